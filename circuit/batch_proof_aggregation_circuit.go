@@ -0,0 +1,204 @@
+package circuit
+
+import (
+	"fmt"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
+	"github.com/consensys/gnark/backend"
+	"github.com/consensys/gnark/backend/groth16"
+	groth16_bn254 "github.com/consensys/gnark/backend/groth16/bn254"
+	"github.com/consensys/gnark/std/algebra/emulated/sw_bn254"
+	"github.com/consensys/gnark/std/commitments/pedersen"
+	poseidon2 "github.com/consensys/gnark/std/hash/poseidon"
+	"github.com/consensys/gnark/std/math/emulated"
+	stdgroth16 "github.com/consensys/gnark/std/recursion/groth16"
+)
+
+type (
+	RecursiveProof        = stdgroth16.Proof[sw_bn254.G1Affine, sw_bn254.G2Affine]
+	RecursiveVerifyingKey = stdgroth16.VerifyingKey[sw_bn254.G1Affine, sw_bn254.G2Affine, sw_bn254.GTEl]
+)
+
+// AggregatedBatch is one inner proof checked by BatchProofAggregationCircuit.
+// The inner proof is either a BatchCreateUserCircuit proof or another
+// aggregation proof, both of them only expose
+// Poseidon(BeforeAccountTreeRoot, AfterAccountTreeRoot, BeforeCEXAssetsCommitment, AfterCEXAssetsCommitment)
+// as their public input.
+type AggregatedBatch struct {
+	Proof                     RecursiveProof
+	BeforeAccountTreeRoot     Variable
+	AfterAccountTreeRoot      Variable
+	BeforeCEXAssetsCommitment Variable
+	AfterCEXAssetsCommitment  Variable
+	// padding batches repeat the last real batch and are excluded from the chain
+	IsPadding Variable
+}
+
+// BatchProofAggregationCircuit verifies len(Batches) groth16 proofs and the
+// chaining of account tree roots and cex assets commitments between them.
+// Its public input has the same shape as BatchCommitment, so aggregation
+// proofs can be aggregated again until one proof is left for the snapshot.
+type BatchProofAggregationCircuit struct {
+	AggregatedCommitment      Variable `gnark:",public"`
+	BeforeAccountTreeRoot     Variable
+	AfterAccountTreeRoot      Variable
+	BeforeCEXAssetsCommitment Variable
+	AfterCEXAssetsCommitment  Variable
+	Batches                   []AggregatedBatch
+	// InnerVerifyingKeys[i] verifies Batches[i], the keys are compiled into
+	// the circuit as constants
+	InnerVerifyingKeys []RecursiveVerifyingKey `gnark:"-"`
+}
+
+// AggregationInput is the native counterpart of AggregatedBatch.
+type AggregationInput struct {
+	Proof                     groth16.Proof
+	BeforeAccountTreeRoot     []byte
+	AfterAccountTreeRoot      []byte
+	BeforeCEXAssetsCommitment []byte
+	AfterCEXAssetsCommitment  []byte
+}
+
+func NewVerifyBatchProofAggregationCircuit(commitment []byte) *BatchProofAggregationCircuit {
+	var v BatchProofAggregationCircuit
+	v.AggregatedCommitment = commitment
+	return &v
+}
+
+// NewBatchProofAggregationCircuit returns the circuit used for compiling,
+// which aggregates one inner proof per verifying key in innerVks.
+func NewBatchProofAggregationCircuit(innerVks []groth16.VerifyingKey) (*BatchProofAggregationCircuit, error) {
+	var circuit BatchProofAggregationCircuit
+	circuit.AggregatedCommitment = 0
+	circuit.BeforeAccountTreeRoot = 0
+	circuit.AfterAccountTreeRoot = 0
+	circuit.BeforeCEXAssetsCommitment = 0
+	circuit.AfterCEXAssetsCommitment = 0
+	circuit.Batches = make([]AggregatedBatch, len(innerVks))
+	circuit.InnerVerifyingKeys = make([]RecursiveVerifyingKey, len(innerVks))
+	for i, vk := range innerVks {
+		var err error
+		circuit.InnerVerifyingKeys[i], err = stdgroth16.ValueOfVerifyingKeyFixed[sw_bn254.G1Affine, sw_bn254.G2Affine, sw_bn254.GTEl](vk)
+		if err != nil {
+			return nil, err
+		}
+		tVk, ok := vk.(*groth16_bn254.VerifyingKey)
+		if !ok {
+			return nil, fmt.Errorf("expected bn254 verifying key, got %T", vk)
+		}
+		// not filled by ValueOfVerifyingKeyFixed, but needed to verify the commitment
+		circuit.InnerVerifyingKeys[i].PublicAndCommitmentCommitted = tVk.PublicAndCommitmentCommitted
+		circuit.Batches[i] = AggregatedBatch{
+			Proof: RecursiveProof{
+				Commitments: make([]pedersen.Commitment[sw_bn254.G1Affine], len(tVk.CommitmentKeys)),
+			},
+			BeforeAccountTreeRoot:     0,
+			AfterAccountTreeRoot:      0,
+			BeforeCEXAssetsCommitment: 0,
+			AfterCEXAssetsCommitment:  0,
+			IsPadding:                 0,
+		}
+	}
+	return &circuit, nil
+}
+
+func (b BatchProofAggregationCircuit) Define(api API) error {
+	if len(b.Batches) == 0 || len(b.Batches) != len(b.InnerVerifyingKeys) {
+		return fmt.Errorf("invalid aggregation circuit: %d batches, %d verifying keys", len(b.Batches), len(b.InnerVerifyingKeys))
+	}
+	actualAggregatedCommitment := poseidon2.Poseidon(api, b.BeforeAccountTreeRoot, b.AfterAccountTreeRoot, b.BeforeCEXAssetsCommitment, b.AfterCEXAssetsCommitment)
+	api.AssertIsEqual(b.AggregatedCommitment, actualAggregatedCommitment)
+
+	verifier, err := stdgroth16.NewVerifier[sw_bn254.ScalarField, sw_bn254.G1Affine, sw_bn254.G2Affine, sw_bn254.GTEl](api)
+	if err != nil {
+		return err
+	}
+	scalarApi, err := emulated.NewField[sw_bn254.ScalarField](api)
+	if err != nil {
+		return err
+	}
+
+	// the first batch is always a real one, and no real batch follows a padding batch
+	api.AssertIsEqual(b.Batches[0].IsPadding, 0)
+	currentAccountTreeRoot := b.BeforeAccountTreeRoot
+	currentCEXAssetsCommitment := b.BeforeCEXAssetsCommitment
+	for i := 0; i < len(b.Batches); i++ {
+		batch := b.Batches[i]
+		api.AssertIsBoolean(batch.IsPadding)
+		if i > 0 {
+			api.AssertIsEqual(api.Mul(b.Batches[i-1].IsPadding, api.Sub(1, batch.IsPadding)), 0)
+		}
+		isRealBatch := api.Sub(1, batch.IsPadding)
+		api.AssertIsEqual(api.Mul(isRealBatch, api.Sub(batch.BeforeAccountTreeRoot, currentAccountTreeRoot)), 0)
+		api.AssertIsEqual(api.Mul(isRealBatch, api.Sub(batch.BeforeCEXAssetsCommitment, currentCEXAssetsCommitment)), 0)
+
+		batchCommitment := poseidon2.Poseidon(api, batch.BeforeAccountTreeRoot, batch.AfterAccountTreeRoot, batch.BeforeCEXAssetsCommitment, batch.AfterCEXAssetsCommitment)
+		innerWitness := stdgroth16.Witness[sw_bn254.ScalarField]{
+			Public: []emulated.Element[sw_bn254.ScalarField]{*scalarApi.FromBits(api.ToBinary(batchCommitment)...)},
+		}
+		err = verifier.AssertProof(b.InnerVerifyingKeys[i], batch.Proof, innerWitness)
+		if err != nil {
+			return err
+		}
+
+		currentAccountTreeRoot = api.Select(batch.IsPadding, currentAccountTreeRoot, batch.AfterAccountTreeRoot)
+		currentCEXAssetsCommitment = api.Select(batch.IsPadding, currentCEXAssetsCommitment, batch.AfterCEXAssetsCommitment)
+	}
+	api.AssertIsEqual(b.AfterAccountTreeRoot, currentAccountTreeRoot)
+	api.AssertIsEqual(b.AfterCEXAssetsCommitment, currentCEXAssetsCommitment)
+	return nil
+}
+
+// SetBatchProofAggregationCircuitWitness assigns the inputs to an aggregation
+// circuit with batchCounts slots, the remaining slots are padded with the last
+// input. It also returns the native aggregated commitment.
+func SetBatchProofAggregationCircuitWitness(inputs []AggregationInput, batchCounts int) (witness *BatchProofAggregationCircuit, aggregatedCommitment []byte, err error) {
+	if len(inputs) == 0 || len(inputs) > batchCounts {
+		return nil, nil, fmt.Errorf("invalid aggregation inputs count %d, batch counts is %d", len(inputs), batchCounts)
+	}
+	first := inputs[0]
+	last := inputs[len(inputs)-1]
+	aggregatedCommitment = poseidon.PoseidonBytes(first.BeforeAccountTreeRoot, last.AfterAccountTreeRoot,
+		first.BeforeCEXAssetsCommitment, last.AfterCEXAssetsCommitment)
+	witness = &BatchProofAggregationCircuit{
+		AggregatedCommitment:      aggregatedCommitment,
+		BeforeAccountTreeRoot:     first.BeforeAccountTreeRoot,
+		AfterAccountTreeRoot:      last.AfterAccountTreeRoot,
+		BeforeCEXAssetsCommitment: first.BeforeCEXAssetsCommitment,
+		AfterCEXAssetsCommitment:  last.AfterCEXAssetsCommitment,
+		Batches:                   make([]AggregatedBatch, batchCounts),
+	}
+	for i := 0; i < batchCounts; i++ {
+		input := last
+		isPadding := 1
+		if i < len(inputs) {
+			input = inputs[i]
+			isPadding = 0
+		}
+		proof, err := stdgroth16.ValueOfProof[sw_bn254.G1Affine, sw_bn254.G2Affine](input.Proof)
+		if err != nil {
+			return nil, nil, err
+		}
+		witness.Batches[i] = AggregatedBatch{
+			Proof:                     proof,
+			BeforeAccountTreeRoot:     input.BeforeAccountTreeRoot,
+			AfterAccountTreeRoot:      input.AfterAccountTreeRoot,
+			BeforeCEXAssetsCommitment: input.BeforeCEXAssetsCommitment,
+			AfterCEXAssetsCommitment:  input.AfterCEXAssetsCommitment,
+			IsPadding:                 isPadding,
+		}
+	}
+	return witness, aggregatedCommitment, nil
+}
+
+// RecursiveProverOptions must be used when generating a groth16 proof which
+// will be verified by BatchProofAggregationCircuit, the matching
+// RecursiveVerifierOptions must then be used to verify it natively.
+func RecursiveProverOptions() backend.ProverOption {
+	return stdgroth16.GetNativeProverOptions(ecc.BN254.ScalarField(), ecc.BN254.ScalarField())
+}
+
+func RecursiveVerifierOptions() backend.VerifierOption {
+	return stdgroth16.GetNativeVerifierOptions(ecc.BN254.ScalarField(), ecc.BN254.ScalarField())
+}
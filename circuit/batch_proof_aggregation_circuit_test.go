@@ -0,0 +1,87 @@
+package circuit
+
+import (
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/frontend"
+	poseidon2 "github.com/consensys/gnark/std/hash/poseidon"
+	"github.com/consensys/gnark/std/rangecheck"
+	"github.com/consensys/gnark/test"
+)
+
+// mockBatchCircuit has the same public input as BatchCreateUserCircuit and
+// uses a range check, so its proofs contain a commitment as well.
+type mockBatchCircuit struct {
+	BatchCommitment           Variable `gnark:",public"`
+	BeforeAccountTreeRoot     Variable
+	AfterAccountTreeRoot      Variable
+	BeforeCEXAssetsCommitment Variable
+	AfterCEXAssetsCommitment  Variable
+	Balance                   Variable
+}
+
+func (c *mockBatchCircuit) Define(api API) error {
+	actualBatchCommitment := poseidon2.Poseidon(api, c.BeforeAccountTreeRoot, c.AfterAccountTreeRoot, c.BeforeCEXAssetsCommitment, c.AfterCEXAssetsCommitment)
+	api.AssertIsEqual(c.BatchCommitment, actualBatchCommitment)
+	r := rangecheck.New(api)
+	r.Check(c.Balance, 64)
+	return nil
+}
+
+func TestBatchProofAggregationCircuit(t *testing.T) {
+	assert := test.NewAssert(t)
+	innerCcs, err := Compile(ProvingSystemGroth16, &mockBatchCircuit{})
+	assert.NoError(err)
+	innerPk, innerVk, err := groth16.Setup(innerCcs)
+	assert.NoError(err)
+
+	roots := [][]byte{{1}, {2}, {3}}
+	cexCommitments := [][]byte{{4}, {5}, {6}}
+	inputs := make([]AggregationInput, 2)
+	for i := range inputs {
+		batchCommitment := poseidon.PoseidonBytes(roots[i], roots[i+1], cexCommitments[i], cexCommitments[i+1])
+		w, err := frontend.NewWitness(&mockBatchCircuit{
+			BatchCommitment:           batchCommitment,
+			BeforeAccountTreeRoot:     roots[i],
+			AfterAccountTreeRoot:      roots[i+1],
+			BeforeCEXAssetsCommitment: cexCommitments[i],
+			AfterCEXAssetsCommitment:  cexCommitments[i+1],
+			Balance:                   i,
+		}, ecc.BN254.ScalarField())
+		assert.NoError(err)
+		proof, err := groth16.Prove(innerCcs, innerPk, w, RecursiveProverOptions())
+		assert.NoError(err)
+		publicWitness, err := w.Public()
+		assert.NoError(err)
+		assert.NoError(groth16.Verify(proof, innerVk, publicWitness, RecursiveVerifierOptions()))
+		inputs[i] = AggregationInput{
+			Proof:                     proof,
+			BeforeAccountTreeRoot:     roots[i],
+			AfterAccountTreeRoot:      roots[i+1],
+			BeforeCEXAssetsCommitment: cexCommitments[i],
+			AfterCEXAssetsCommitment:  cexCommitments[i+1],
+		}
+	}
+
+	// two real batches and one padding batch
+	batchCounts := 3
+	innerVks := []groth16.VerifyingKey{innerVk, innerVk, innerVk}
+	aggregationCircuit, err := NewBatchProofAggregationCircuit(innerVks)
+	assert.NoError(err)
+	assignment, aggregatedCommitment, err := SetBatchProofAggregationCircuitWitness(inputs, batchCounts)
+	assert.NoError(err)
+	expectCommitment := poseidon.PoseidonBytes(roots[0], roots[2], cexCommitments[0], cexCommitments[2])
+	if string(expectCommitment) != string(aggregatedCommitment) {
+		t.Fatalf("aggregated commitment not match: %x:%x", expectCommitment, aggregatedCommitment)
+	}
+	assert.NoError(test.IsSolved(aggregationCircuit, assignment, ecc.BN254.ScalarField()))
+
+	// the batches must be chained
+	inputs[0], inputs[1] = inputs[1], inputs[0]
+	assignment, _, err = SetBatchProofAggregationCircuitWitness(inputs, batchCounts)
+	assert.NoError(err)
+	assert.Error(test.IsSolved(aggregationCircuit, assignment, ecc.BN254.ScalarField()))
+}
@@ -0,0 +1,270 @@
+package circuit
+
+import (
+	"math/big"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/consensys/gnark/std/hash/poseidon"
+
+	"github.com/consensys/gnark/std/lookup/logderivlookup"
+	"github.com/consensys/gnark/std/rangecheck"
+)
+
+// BatchUpdateUserCircuit proves the changes of accounts since the previous
+// snapshot: the account tree starts from the tree of the previous snapshot
+// and the cex assets start from its final cex assets. An operation replaces
+// the leaf of an account and adjusts the cex assets by the difference between
+// the new assets and the old assets of the account.
+type BatchUpdateUserCircuit struct {
+	BatchCommitment           Variable `gnark:",public"`
+	BeforeAccountTreeRoot     Variable
+	AfterAccountTreeRoot      Variable
+	BeforeCEXAssetsCommitment Variable
+	AfterCEXAssetsCommitment  Variable
+	BeforeCexAssets           []CexAssetInfo
+	UpdateUserOps             []UpdateUserOperation
+}
+
+func NewVerifyBatchUpdateUserCircuit(commitment []byte) *BatchUpdateUserCircuit {
+	var v BatchUpdateUserCircuit
+	v.BatchCommitment = commitment
+	return &v
+}
+
+func NewBatchUpdateUserCircuit(userAssetCounts uint32, allAssetCounts uint32, batchCounts uint32) *BatchUpdateUserCircuit {
+	var circuit BatchUpdateUserCircuit
+	circuit.BatchCommitment = 0
+	circuit.BeforeAccountTreeRoot = 0
+	circuit.AfterAccountTreeRoot = 0
+	circuit.BeforeCEXAssetsCommitment = 0
+	circuit.AfterCEXAssetsCommitment = 0
+	circuit.BeforeCexAssets = newEmptyCexAssets(allAssetCounts)
+	circuit.UpdateUserOps = make([]UpdateUserOperation, batchCounts)
+	for i := uint32(0); i < batchCounts; i++ {
+		circuit.UpdateUserOps[i] = UpdateUserOperation{
+			BeforeAccountTreeRoot: 0,
+			AfterAccountTreeRoot:  0,
+			IsOldEmpty:            0,
+			OldTotalEquity:        0,
+			OldTotalDebt:          0,
+			OldTotalCollateral:    0,
+			OldAssetIndexes:       make([]Variable, userAssetCounts),
+			OldAssetsForUpdateCex: make([]UserAssetMeta, allAssetCounts),
+			IsNewEmpty:            0,
+			Assets:                make([]UserAssetInfo, userAssetCounts),
+			AssetsForUpdateCex:    make([]UserAssetMeta, allAssetCounts),
+			AccountIndex:          0,
+			AccountIdHash:         0,
+			AccountProof:          [utils.AccountTreeDepth]Variable{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		}
+		for j := uint32(0); j < allAssetCounts; j++ {
+			circuit.UpdateUserOps[i].OldAssetsForUpdateCex[j] = UserAssetMeta{0, 0, 0, 0, 0}
+			circuit.UpdateUserOps[i].AssetsForUpdateCex[j] = UserAssetMeta{0, 0, 0, 0, 0}
+		}
+		for j := uint32(0); j < userAssetCounts; j++ {
+			circuit.UpdateUserOps[i].OldAssetIndexes[j] = j
+			circuit.UpdateUserOps[i].Assets[j] = UserAssetInfo{
+				AssetIndex:                     j,
+				LoanCollateralIndex:            0,
+				LoanCollateralFlag:             0,
+				MarginCollateralIndex:          0,
+				MarginCollateralFlag:           0,
+				PortfolioMarginCollateralIndex: 0,
+				PortfolioMarginCollateralFlag:  0,
+			}
+		}
+	}
+	return &circuit
+}
+
+func (b BatchUpdateUserCircuit) Define(api API) error {
+	// verify whether BatchCommitment is computed correctly
+	actualBatchCommitment := poseidon.Poseidon(api, b.BeforeAccountTreeRoot, b.AfterAccountTreeRoot, b.BeforeCEXAssetsCommitment, b.AfterCEXAssetsCommitment)
+	api.AssertIsEqual(b.BatchCommitment, actualBatchCommitment)
+	countOfCexAsset := getVariableCountOfCexAsset(b.BeforeCexAssets[0])
+	cexAssets := make([]Variable, len(b.BeforeCexAssets)*countOfCexAsset)
+	afterCexAssets := make([]CexAssetInfo, len(b.BeforeCexAssets))
+
+	r := rangecheck.New(api)
+	// verify whether beforeCexAssetsCommitment is computed correctly
+	assetPriceTable := logderivlookup.New(api)
+	for i := 0; i < len(b.BeforeCexAssets); i++ {
+		r.Check(b.BeforeCexAssets[i].TotalEquity, 64)
+		r.Check(b.BeforeCexAssets[i].TotalDebt, 64)
+		r.Check(b.BeforeCexAssets[i].BasePrice, 64)
+		r.Check(b.BeforeCexAssets[i].LoanCollateral, 64)
+		r.Check(b.BeforeCexAssets[i].MarginCollateral, 64)
+		r.Check(b.BeforeCexAssets[i].PortfolioMarginCollateral, 64)
+
+		fillCexAssetCommitment(api, b.BeforeCexAssets[i], i, cexAssets)
+		generateRapidArithmeticForCollateral(api, r, b.BeforeCexAssets[i].LoanRatios)
+		generateRapidArithmeticForCollateral(api, r, b.BeforeCexAssets[i].MarginRatios)
+		generateRapidArithmeticForCollateral(api, r, b.BeforeCexAssets[i].PortfolioMarginRatios)
+		afterCexAssets[i] = b.BeforeCexAssets[i]
+
+		assetPriceTable.Insert(b.BeforeCexAssets[i].BasePrice)
+	}
+	actualCexAssetsCommitment := poseidon.Poseidon(api, cexAssets...)
+	api.AssertIsEqual(b.BeforeCEXAssetsCommitment, actualCexAssetsCommitment)
+	api.AssertIsEqual(b.BeforeAccountTreeRoot, b.UpdateUserOps[0].BeforeAccountTreeRoot)
+	api.AssertIsEqual(b.AfterAccountTreeRoot, b.UpdateUserOps[len(b.UpdateUserOps)-1].AfterAccountTreeRoot)
+
+	loanTierRatiosTable := constructLoanTierRatiosLookupTable(api, b.BeforeCexAssets)
+	marginTierRatiosTable := constructMarginTierRatiosLookupTable(api, b.BeforeCexAssets)
+	portfolioMarginTierRatiosTable := constructPortfolioTierRatiosLookupTable(api, b.BeforeCexAssets)
+	// the asset id hashes of the new assets are followed by the ones of the old assets
+	userAssetIdHashes := make([]Variable, 2*len(b.UpdateUserOps)+1)
+
+	userAssetsResults := make([][]Variable, len(b.UpdateUserOps))
+	userAssetsQueries := make([][]Variable, len(b.UpdateUserOps))
+	oldUserAssetsResults := make([][]Variable, len(b.UpdateUserOps))
+	oldUserAssetsQueries := make([][]Variable, len(b.UpdateUserOps))
+
+	numOfAssetsFields := 6
+	for i := 0; i < len(b.UpdateUserOps); i++ {
+		op := b.UpdateUserOps[i]
+		api.AssertIsBoolean(op.IsOldEmpty)
+		api.AssertIsBoolean(op.IsNewEmpty)
+		accountIndexHelper := accountIdToMerkleHelper(api, op.AccountIndex)
+
+		// the old assets are zero when the old leaf is empty
+		oldUserAssetsLookupTable := constructUserAssetsLookupTable(api, op.OldAssetsForUpdateCex)
+		checkAssetIndexesIncreasing(api, r, op.OldAssetIndexes)
+		userAssetIdHashes[len(b.UpdateUserOps)+i] = computeAssetIdsHash(api, op.OldAssetIndexes)
+		oldUserAssetsQueries[i] = make([]Variable, len(op.OldAssetIndexes)*5)
+		for j := 0; j < len(op.OldAssetIndexes); j++ {
+			p := api.Mul(op.OldAssetIndexes[j], 5)
+			for k := 0; k < 5; k++ {
+				oldUserAssetsQueries[i][j*5+k] = api.Add(p, k)
+			}
+		}
+		oldUserAssetsResults[i] = oldUserAssetsLookupTable.Lookup(oldUserAssetsQueries[i]...)
+		oldFlattenAssetFieldsForHash := make([]Variable, len(op.OldAssetIndexes)*numOfAssetsFields)
+		for j := 0; j < len(op.OldAssetIndexes); j++ {
+			oldFlattenAssetFieldsForHash[j*numOfAssetsFields] = op.OldAssetIndexes[j]
+			for k := 0; k < 5; k++ {
+				api.AssertIsEqual(api.Mul(op.IsOldEmpty, oldUserAssetsResults[i][j*5+k]), 0)
+				oldFlattenAssetFieldsForHash[j*numOfAssetsFields+1+k] = oldUserAssetsResults[i][j*5+k]
+			}
+		}
+		oldUserAssetsCommitment := computeUserAssetsCommitment(api, oldFlattenAssetFieldsForHash)
+		oldAccountHash := poseidon.Poseidon(api, op.AccountIdHash, op.OldTotalEquity, op.OldTotalDebt, op.OldTotalCollateral, oldUserAssetsCommitment)
+		oldAccountHash = api.Select(op.IsOldEmpty, EmptyAccountLeafNodeHash, oldAccountHash)
+		verifyMerkleProof(api, op.BeforeAccountTreeRoot, oldAccountHash, op.AccountProof[:], accountIndexHelper)
+
+		// the new assets are zero when the new leaf is empty
+		userAssets := checkUserAssets(api, r, b.BeforeCexAssets, assetPriceTable, loanTierRatiosTable, marginTierRatiosTable, portfolioMarginTierRatiosTable,
+			op.Assets, op.AssetsForUpdateCex)
+		for j := 0; j < len(userAssets.results); j++ {
+			api.AssertIsEqual(api.Mul(op.IsNewEmpty, userAssets.results[j]), 0)
+		}
+		userAssetIdHashes[i] = userAssets.assetIdsHash
+		userAssetsQueries[i] = userAssets.queries
+		userAssetsResults[i] = userAssets.results
+
+		for j := 0; j < len(op.AssetsForUpdateCex); j++ {
+			afterCexAssets[j].TotalEquity = api.Sub(api.Add(afterCexAssets[j].TotalEquity, op.AssetsForUpdateCex[j].Equity), op.OldAssetsForUpdateCex[j].Equity)
+			afterCexAssets[j].TotalDebt = api.Sub(api.Add(afterCexAssets[j].TotalDebt, op.AssetsForUpdateCex[j].Debt), op.OldAssetsForUpdateCex[j].Debt)
+			afterCexAssets[j].LoanCollateral = api.Sub(api.Add(afterCexAssets[j].LoanCollateral, op.AssetsForUpdateCex[j].LoanCollateral), op.OldAssetsForUpdateCex[j].LoanCollateral)
+			afterCexAssets[j].MarginCollateral = api.Sub(api.Add(afterCexAssets[j].MarginCollateral, op.AssetsForUpdateCex[j].MarginCollateral), op.OldAssetsForUpdateCex[j].MarginCollateral)
+			afterCexAssets[j].PortfolioMarginCollateral = api.Sub(api.Add(afterCexAssets[j].PortfolioMarginCollateral, op.AssetsForUpdateCex[j].PortfolioMarginCollateral), op.OldAssetsForUpdateCex[j].PortfolioMarginCollateral)
+		}
+
+		// make sure user's total Debt is less or equal than total collateral
+		r.Check(userAssets.totalDebt, 128)
+		r.Check(userAssets.totalCollateralRealValue, 128)
+		assertIsLessOrEqualNOp(api, userAssets.totalDebt, userAssets.totalCollateralRealValue, 128)
+		userAssetsCommitment := computeUserAssetsCommitment(api, userAssets.flattenAssetFields)
+		accountHash := poseidon.Poseidon(api, op.AccountIdHash, userAssets.totalEquity, userAssets.totalDebt, userAssets.totalCollateralRealValue, userAssetsCommitment)
+		accountHash = api.Select(op.IsNewEmpty, EmptyAccountLeafNodeHash, accountHash)
+		actualAccountTreeRoot := updateMerkleProof(api, accountHash, op.AccountProof[:], accountIndexHelper)
+		api.AssertIsEqual(actualAccountTreeRoot, op.AfterAccountTreeRoot)
+	}
+
+	// make sure both the new and the old user assets contain all non-zero assets
+	// of AssetsForUpdateCex and OldAssetsForUpdateCex, the random number is the
+	// poseidon hash of the batch commitment and the hashes of all user asset indexes
+	userAssetIdHashes[2*len(b.UpdateUserOps)] = b.BatchCommitment
+	randomChallenge := poseidon.Poseidon(api, userAssetIdHashes...)
+	powersOfRandomChallenge, powersOfRandomChallengeLookupTable := constructPowersOfRandomChallenge(api, randomChallenge, 5*len(b.BeforeCexAssets))
+
+	for i := 0; i < len(b.UpdateUserOps); i++ {
+		checkRandomLinearCombination(api, powersOfRandomChallengeLookupTable, powersOfRandomChallenge,
+			userAssetsQueries[i], userAssetsResults[i], b.UpdateUserOps[i].AssetsForUpdateCex)
+		checkRandomLinearCombination(api, powersOfRandomChallengeLookupTable, powersOfRandomChallenge,
+			oldUserAssetsQueries[i], oldUserAssetsResults[i], b.UpdateUserOps[i].OldAssetsForUpdateCex)
+	}
+	// the range check also makes sure the cex assets don't underflow
+	tempAfterCexAssets := make([]Variable, len(b.BeforeCexAssets)*countOfCexAsset)
+	for j := 0; j < len(b.BeforeCexAssets); j++ {
+		r.Check(afterCexAssets[j].TotalEquity, 64)
+		r.Check(afterCexAssets[j].TotalDebt, 64)
+		r.Check(afterCexAssets[j].LoanCollateral, 64)
+		r.Check(afterCexAssets[j].MarginCollateral, 64)
+		r.Check(afterCexAssets[j].PortfolioMarginCollateral, 64)
+
+		fillCexAssetCommitment(api, afterCexAssets[j], j, tempAfterCexAssets)
+	}
+
+	// verify AfterCEXAssetsCommitment is computed correctly
+	actualAfterCEXAssetsCommitment := poseidon.Poseidon(api, tempAfterCexAssets...)
+	api.AssertIsEqual(actualAfterCEXAssetsCommitment, b.AfterCEXAssetsCommitment)
+	for i := 0; i < len(b.UpdateUserOps)-1; i++ {
+		api.AssertIsEqual(b.UpdateUserOps[i].AfterAccountTreeRoot, b.UpdateUserOps[i+1].BeforeAccountTreeRoot)
+	}
+	return nil
+}
+
+func SetBatchUpdateUserCircuitWitness(batchWitness *utils.BatchUpdateUserWitness) (witness *BatchUpdateUserCircuit, err error) {
+	witness = &BatchUpdateUserCircuit{
+		BatchCommitment:           batchWitness.BatchCommitment,
+		BeforeAccountTreeRoot:     batchWitness.BeforeAccountTreeRoot,
+		AfterAccountTreeRoot:      batchWitness.AfterAccountTreeRoot,
+		BeforeCEXAssetsCommitment: batchWitness.BeforeCEXAssetsCommitment,
+		AfterCEXAssetsCommitment:  batchWitness.AfterCEXAssetsCommitment,
+		BeforeCexAssets:           make([]CexAssetInfo, len(batchWitness.BeforeCexAssets)),
+		UpdateUserOps:             make([]UpdateUserOperation, len(batchWitness.UpdateUserOps)),
+	}
+
+	setCexAssetsWitness(witness.BeforeCexAssets, batchWitness.BeforeCexAssets)
+
+	targetCounts := batchWitness.AssetsCount
+	for i := 0; i < len(witness.UpdateUserOps); i++ {
+		op := &batchWitness.UpdateUserOps[i]
+		witness.UpdateUserOps[i].BeforeAccountTreeRoot = op.BeforeAccountTreeRoot
+		witness.UpdateUserOps[i].AfterAccountTreeRoot = op.AfterAccountTreeRoot
+		witness.UpdateUserOps[i].IsOldEmpty = boolToVariable(op.IsOldEmpty)
+		witness.UpdateUserOps[i].OldTotalEquity = bigIntOrZero(op.OldTotalEquity)
+		witness.UpdateUserOps[i].OldTotalDebt = bigIntOrZero(op.OldTotalDebt)
+		witness.UpdateUserOps[i].OldTotalCollateral = bigIntOrZero(op.OldTotalCollateral)
+		// the collateral info of the old assets isn't used by the circuit
+		oldUserAssets, oldAssetsForUpdateCex := setUserAssetsWitness(op.OldAssets, targetCounts, batchWitness.BeforeCexAssets)
+		witness.UpdateUserOps[i].OldAssetIndexes = make([]Variable, targetCounts)
+		for j := 0; j < targetCounts; j++ {
+			witness.UpdateUserOps[i].OldAssetIndexes[j] = oldUserAssets[j].AssetIndex
+		}
+		witness.UpdateUserOps[i].OldAssetsForUpdateCex = oldAssetsForUpdateCex
+		witness.UpdateUserOps[i].IsNewEmpty = boolToVariable(op.IsNewEmpty)
+		witness.UpdateUserOps[i].Assets, witness.UpdateUserOps[i].AssetsForUpdateCex = setUserAssetsWitness(op.Assets, targetCounts, batchWitness.BeforeCexAssets)
+		witness.UpdateUserOps[i].AccountIdHash = op.AccountIdHash
+		witness.UpdateUserOps[i].AccountIndex = op.AccountIndex
+		for j := 0; j < len(witness.UpdateUserOps[i].AccountProof); j++ {
+			witness.UpdateUserOps[i].AccountProof[j] = op.AccountProof[j]
+		}
+	}
+	return witness, nil
+}
+
+func boolToVariable(b bool) Variable {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func bigIntOrZero(v *big.Int) Variable {
+	if v == nil {
+		return 0
+	}
+	return v
+}
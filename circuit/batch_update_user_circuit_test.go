@@ -0,0 +1,146 @@
+package circuit
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	bsmt "github.com/bnb-chain/zkbnb-smt"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
+	"github.com/consensys/gnark/constraint/solver"
+	"github.com/consensys/gnark/test"
+)
+
+func constructUpdateUserOp(accountTree bsmt.SparseMerkleTree, cexAssets []utils.CexAssetInfo, accountIndex uint32, oldAccount, newAccount *utils.AccountInfo) utils.UpdateUserOperation {
+	op := utils.UpdateUserOperation{
+		BeforeAccountTreeRoot: accountTree.Root(),
+		AccountIndex:          accountIndex,
+		IsOldEmpty:            oldAccount == nil,
+		IsNewEmpty:            newAccount == nil,
+	}
+	proof, err := accountTree.GetProof(uint64(accountIndex))
+	if err != nil {
+		panic(err.Error())
+	}
+	copy(op.AccountProof[:], proof)
+	leaf := utils.NilAccountHash
+	if oldAccount != nil {
+		op.AccountIdHash = oldAccount.AccountId
+		op.OldTotalEquity = oldAccount.TotalEquity
+		op.OldTotalDebt = oldAccount.TotalDebt
+		op.OldTotalCollateral = oldAccount.TotalCollateral
+		op.OldAssets = oldAccount.Assets
+		for i := range oldAccount.Assets {
+			utils.SubAccountAsset(cexAssets, &oldAccount.Assets[i])
+		}
+	}
+	if newAccount != nil {
+		op.AccountIdHash = newAccount.AccountId
+		op.Assets = newAccount.Assets
+		for i := range newAccount.Assets {
+			utils.AddAccountAsset(cexAssets, &newAccount.Assets[i])
+		}
+		hasher := poseidon.NewPoseidon()
+		leaf = utils.AccountInfoToHash(newAccount, &hasher)
+	}
+	err = accountTree.Set(uint64(accountIndex), leaf)
+	if err != nil {
+		panic(err.Error())
+	}
+	op.AfterAccountTreeRoot = accountTree.Root()
+	return op
+}
+
+func TestBatchUpdateUserCircuit(t *testing.T) {
+	assert := test.NewAssert(t)
+	solver.RegisterHint(IntegerDivision)
+	totalAssetsCount := utils.AssetCounts
+	assetsCount := 50
+	cexAssets := make([]utils.CexAssetInfo, totalAssetsCount)
+	for i := 0; i < totalAssetsCount; i++ {
+		u := utils.CexAssetInfo{
+			BasePrice: uint64(i + 1),
+			Index:     uint32(i),
+		}
+		for j := 0; j < utils.TierCount; j++ {
+			u.LoanRatios[j] = utils.TierRatio{BoundaryValue: big.NewInt(int64(100 * (j + 1))), Ratio: 90, PrecomputedValue: big.NewInt(0)}
+			u.MarginRatios[j] = utils.TierRatio{BoundaryValue: big.NewInt(int64(100 * (j + 1))), Ratio: 80, PrecomputedValue: big.NewInt(0)}
+			u.PortfolioMarginRatios[j] = utils.TierRatio{BoundaryValue: big.NewInt(int64(100 * (j + 1))), Ratio: 70, PrecomputedValue: big.NewInt(0)}
+		}
+		utils.CalculatePrecomputedValue(u.LoanRatios[:])
+		utils.CalculatePrecomputedValue(u.MarginRatios[:])
+		utils.CalculatePrecomputedValue(u.PortfolioMarginRatios[:])
+		cexAssets[i] = u
+	}
+	newAccount := func(id byte, assets []utils.AccountAsset) *utils.AccountInfo {
+		account := &utils.AccountInfo{AccountId: []byte{id}, Assets: assets}
+		utils.RecomputeAccountTotals(account, cexAssets)
+		return account
+	}
+	prevAccounts := []*utils.AccountInfo{
+		newAccount(1, []utils.AccountAsset{{Index: 0, Equity: 100, Debt: 10, Loan: 50}, {Index: 7, Equity: 30}}),
+		newAccount(2, []utils.AccountAsset{{Index: 3, Equity: 20, Margin: 20}}),
+		newAccount(3, []utils.AccountAsset{{Index: 1, Equity: 5, Debt: 1, PortfolioMargin: 3}}),
+	}
+
+	// the tree and the cex assets of the previous snapshot
+	accountTree, err := utils.NewAccountTree("memory", "")
+	assert.NoError(err)
+	for i, account := range prevAccounts {
+		constructUpdateUserOp(accountTree, cexAssets, uint32(i), nil, account)
+	}
+	_, err = accountTree.Commit(nil)
+	assert.NoError(err)
+
+	batchWitness := &utils.BatchUpdateUserWitness{
+		BeforeAccountTreeRoot:     accountTree.Root(),
+		BeforeCEXAssetsCommitment: utils.ComputeCexAssetsCommitment(cexAssets),
+		AssetsCount:               assetsCount,
+		BeforeCexAssets:           make([]utils.CexAssetInfo, totalAssetsCount),
+	}
+	copy(batchWitness.BeforeCexAssets, cexAssets)
+	batchWitness.UpdateUserOps = []utils.UpdateUserOperation{
+		// update
+		constructUpdateUserOp(accountTree, cexAssets, 0, prevAccounts[0],
+			newAccount(1, []utils.AccountAsset{{Index: 0, Equity: 80, Debt: 10, Loan: 50}, {Index: 9, Equity: 1, Margin: 1}})),
+		// delete
+		constructUpdateUserOp(accountTree, cexAssets, 1, prevAccounts[1], nil),
+		// insert
+		constructUpdateUserOp(accountTree, cexAssets, 5, nil,
+			newAccount(4, []utils.AccountAsset{{Index: 2, Equity: 7}, {Index: 499, Equity: 3, Debt: 1, Loan: 3}})),
+		// padding
+		constructUpdateUserOp(accountTree, cexAssets, 6, nil, nil),
+	}
+	batchWitness.AfterAccountTreeRoot = accountTree.Root()
+	batchWitness.AfterCEXAssetsCommitment = utils.ComputeCexAssetsCommitment(cexAssets)
+	batchWitness.BatchCommitment = poseidon.PoseidonBytes(batchWitness.BeforeAccountTreeRoot, batchWitness.AfterAccountTreeRoot,
+		batchWitness.BeforeCEXAssetsCommitment, batchWitness.AfterCEXAssetsCommitment)
+
+	data, err := utils.EncodeBatchWitness(batchWitness)
+	assert.NoError(err)
+	decodedWitness := utils.DecodeBatchUpdateWitness(data)
+	assert.NotNil(decodedWitness)
+	assert.Equal(utils.RecoverAfterCexAssetsFromUpdateWitness(utils.DecodeBatchUpdateWitness(data)), cexAssets)
+	assert.Nil(utils.DecodeBatchWitness(data).CreateUserOps)
+
+	emptyCircuit := NewBatchUpdateUserCircuit(uint32(assetsCount), uint32(totalAssetsCount), uint32(len(batchWitness.UpdateUserOps)))
+	assignment, err := SetBatchUpdateUserCircuitWitness(decodedWitness)
+	assert.NoError(err)
+	assert.NoError(test.IsSolved(emptyCircuit, assignment, ecc.BN254.ScalarField()))
+
+	// the deleted account can't be claimed as an empty leaf
+	decodedWitness.UpdateUserOps[1].IsOldEmpty = true
+	assignment, err = SetBatchUpdateUserCircuitWitness(decodedWitness)
+	assert.NoError(err)
+	assert.Error(test.IsSolved(emptyCircuit, assignment, ecc.BN254.ScalarField()))
+
+	// the assets of the deleted account must be removed from the cex assets
+	decodedWitness = utils.DecodeBatchUpdateWitness(data)
+	for i := range decodedWitness.UpdateUserOps[1].OldAssets {
+		decodedWitness.UpdateUserOps[1].OldAssets[i] = utils.AccountAsset{Index: uint16(i)}
+	}
+	assignment, err = SetBatchUpdateUserCircuitWitness(decodedWitness)
+	assert.NoError(err)
+	assert.Error(test.IsSolved(emptyCircuit, assignment, ecc.BN254.ScalarField()))
+}
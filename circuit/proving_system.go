@@ -0,0 +1,187 @@
+package circuit
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	kzg_bn254 "github.com/consensys/gnark-crypto/ecc/bn254/kzg"
+	"github.com/consensys/gnark-crypto/kzg"
+	"github.com/consensys/gnark/backend"
+	"github.com/consensys/gnark/backend/groth16"
+	groth16_bn254 "github.com/consensys/gnark/backend/groth16/bn254"
+	"github.com/consensys/gnark/backend/plonk"
+	plonk_bn254 "github.com/consensys/gnark/backend/plonk/bn254"
+	"github.com/consensys/gnark/backend/solidity"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/frontend/cs/scs"
+	gnarkio "github.com/consensys/gnark/io"
+)
+
+const (
+	ProvingSystemGroth16 = "groth16"
+	ProvingSystemPlonk   = "plonk"
+)
+
+// ProvingKey, VerifyingKey and Proof are the subsets of the groth16 and plonk
+// interfaces shared by both backends, so that keys and proofs can be passed
+// around without knowing which backend produced them.
+type (
+	ProvingKey interface {
+		io.WriterTo
+		io.ReaderFrom
+		gnarkio.WriterRawTo
+		gnarkio.UnsafeReaderFrom
+	}
+
+	VerifyingKey interface {
+		io.WriterTo
+		io.ReaderFrom
+		gnarkio.WriterRawTo
+		gnarkio.UnsafeReaderFrom
+		solidity.VerifyingKey
+	}
+
+	Proof interface {
+		io.WriterTo
+		io.ReaderFrom
+		gnarkio.WriterRawTo
+	}
+)
+
+// NormalizeProvingSystem validates the proving system name. An empty name
+// means groth16, which keeps configs and proof rows written before plonk
+// support was added valid.
+func NormalizeProvingSystem(provingSystem string) (string, error) {
+	switch provingSystem {
+	case "", ProvingSystemGroth16:
+		return ProvingSystemGroth16, nil
+	case ProvingSystemPlonk:
+		return ProvingSystemPlonk, nil
+	default:
+		return "", fmt.Errorf("unsupported proving system: %s", provingSystem)
+	}
+}
+
+// ConstraintSystemFileSuffix returns the suffix of the serialized constraint
+// system generated by keygen: R1CS for groth16 and SparseR1CS for plonk.
+func ConstraintSystemFileSuffix(provingSystem string) string {
+	if provingSystem == ProvingSystemPlonk {
+		return ".scs"
+	}
+	return ".r1cs"
+}
+
+func NewBuilder(provingSystem string) frontend.NewBuilder {
+	if provingSystem == ProvingSystemPlonk {
+		return scs.NewBuilder
+	}
+	return r1cs.NewBuilder
+}
+
+func Compile(provingSystem string, c frontend.Circuit) (constraint.ConstraintSystem, error) {
+	return frontend.Compile(ecc.BN254.ScalarField(), NewBuilder(provingSystem), c, frontend.IgnoreUnconstrainedInputs())
+}
+
+func NewConstraintSystem(provingSystem string) constraint.ConstraintSystem {
+	if provingSystem == ProvingSystemPlonk {
+		return plonk.NewCS(ecc.BN254)
+	}
+	return groth16.NewCS(ecc.BN254)
+}
+
+func NewProvingKey(provingSystem string) ProvingKey {
+	if provingSystem == ProvingSystemPlonk {
+		return plonk.NewProvingKey(ecc.BN254)
+	}
+	return groth16.NewProvingKey(ecc.BN254)
+}
+
+func NewVerifyingKey(provingSystem string) VerifyingKey {
+	if provingSystem == ProvingSystemPlonk {
+		return plonk.NewVerifyingKey(ecc.BN254)
+	}
+	return groth16.NewVerifyingKey(ecc.BN254)
+}
+
+func NewProof(provingSystem string) Proof {
+	if provingSystem == ProvingSystemPlonk {
+		return plonk.NewProof(ecc.BN254)
+	}
+	return groth16.NewProof(ecc.BN254)
+}
+
+// Setup runs the groth16 circuit-specific setup, or the plonk setup from a
+// KZG SRS. srs and srsLagrange are ignored for groth16.
+func Setup(provingSystem string, cs constraint.ConstraintSystem, srs, srsLagrange kzg.SRS) (ProvingKey, VerifyingKey, error) {
+	if provingSystem == ProvingSystemPlonk {
+		if srs == nil || srsLagrange == nil {
+			return nil, nil, fmt.Errorf("plonk setup needs a kzg srs")
+		}
+		return plonk.Setup(cs, srs, srsLagrange)
+	}
+	return groth16.Setup(cs)
+}
+
+func Prove(provingSystem string, cs constraint.ConstraintSystem, pk ProvingKey, fullWitness witness.Witness, opts ...backend.ProverOption) (Proof, error) {
+	// both backends share the same method sets, so the concrete bn254 types
+	// have to be checked before handing them to gnark
+	if provingSystem == ProvingSystemPlonk {
+		plonkPk, ok := pk.(*plonk_bn254.ProvingKey)
+		if !ok {
+			return nil, fmt.Errorf("proving key is not a plonk proving key")
+		}
+		return plonk.Prove(cs, plonkPk, fullWitness, opts...)
+	}
+	groth16Pk, ok := pk.(*groth16_bn254.ProvingKey)
+	if !ok {
+		return nil, fmt.Errorf("proving key is not a groth16 proving key")
+	}
+	return groth16.Prove(cs, groth16Pk, fullWitness, opts...)
+}
+
+func Verify(provingSystem string, proof Proof, vk VerifyingKey, publicWitness witness.Witness, opts ...backend.VerifierOption) error {
+	if provingSystem == ProvingSystemPlonk {
+		plonkProof, ok := proof.(*plonk_bn254.Proof)
+		if !ok {
+			return fmt.Errorf("proof is not a plonk proof")
+		}
+		plonkVk, ok := vk.(*plonk_bn254.VerifyingKey)
+		if !ok {
+			return fmt.Errorf("verifying key is not a plonk verifying key")
+		}
+		return plonk.Verify(plonkProof, plonkVk, publicWitness, opts...)
+	}
+	groth16Proof, ok := proof.(*groth16_bn254.Proof)
+	if !ok {
+		return fmt.Errorf("proof is not a groth16 proof")
+	}
+	groth16Vk, ok := vk.(*groth16_bn254.VerifyingKey)
+	if !ok {
+		return fmt.Errorf("verifying key is not a groth16 verifying key")
+	}
+	return groth16.Verify(groth16Proof, groth16Vk, publicWitness, opts...)
+}
+
+// NewKzgSRSForCircuit derives the canonical and lagrange SRS needed by
+// plonk.Setup from a canonical bn254 KZG SRS, e.g. the output of a powers of
+// tau ceremony. The SRS must be at least as large as plonk.SRSSize(cs).
+func NewKzgSRSForCircuit(cs constraint.ConstraintSystem, srs *kzg_bn254.SRS) (kzg.SRS, kzg.SRS, error) {
+	sizeCanonical, sizeLagrange := plonk.SRSSize(cs)
+	if len(srs.Pk.G1) < sizeCanonical || len(srs.Pk.G1) < sizeLagrange {
+		return nil, nil, fmt.Errorf("kzg srs too small: got %d points, need %d", len(srs.Pk.G1), sizeCanonical)
+	}
+	canonical := &kzg_bn254.SRS{Vk: srs.Vk}
+	canonical.Pk.G1 = srs.Pk.G1[:sizeCanonical]
+
+	lagrangeG1, err := kzg_bn254.ToLagrangeG1(srs.Pk.G1[:sizeLagrange])
+	if err != nil {
+		return nil, nil, err
+	}
+	lagrange := &kzg_bn254.SRS{Vk: srs.Vk}
+	lagrange.Pk.G1 = lagrangeG1
+	return canonical, lagrange, nil
+}
@@ -0,0 +1,87 @@
+package circuit
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/kzg"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test/unsafekzg"
+)
+
+type cubicCircuit struct {
+	X Variable
+	Y Variable `gnark:",public"`
+}
+
+func (c *cubicCircuit) Define(api API) error {
+	x3 := api.Mul(c.X, c.X, c.X)
+	api.AssertIsEqual(c.Y, api.Add(x3, c.X, 5))
+	return nil
+}
+
+func TestProvingSystems(t *testing.T) {
+	for _, provingSystem := range []string{ProvingSystemGroth16, ProvingSystemPlonk} {
+		cs, err := Compile(provingSystem, &cubicCircuit{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var srs, srsLagrange kzg.SRS
+		if provingSystem == ProvingSystemPlonk {
+			srs, srsLagrange, err = unsafekzg.NewSRS(cs)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		pk, vk, err := Setup(provingSystem, cs, srs, srsLagrange)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fullWitness, err := frontend.NewWitness(&cubicCircuit{X: 3, Y: 35}, ecc.BN254.ScalarField())
+		if err != nil {
+			t.Fatal(err)
+		}
+		publicWitness, err := fullWitness.Public()
+		if err != nil {
+			t.Fatal(err)
+		}
+		proof, err := Prove(provingSystem, cs, pk, fullWitness)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// keys and proofs must survive the serialization used by keygen and prover
+		var buf bytes.Buffer
+		if _, err = vk.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		newVk := NewVerifyingKey(provingSystem)
+		if _, err = newVk.ReadFrom(&buf); err != nil {
+			t.Fatal(err)
+		}
+		buf.Reset()
+		if _, err = proof.WriteRawTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		newProof := NewProof(provingSystem)
+		if _, err = newProof.ReadFrom(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if err = Verify(provingSystem, newProof, newVk, publicWitness); err != nil {
+			t.Fatalf("%s: %s", provingSystem, err.Error())
+		}
+
+		// the other backend must refuse keys and proofs it did not produce
+		other := ProvingSystemPlonk
+		if provingSystem == ProvingSystemPlonk {
+			other = ProvingSystemGroth16
+		}
+		if err = Verify(other, newProof, newVk, publicWitness); err == nil {
+			t.Fatalf("%s proof verified as %s", provingSystem, other)
+		}
+	}
+	if _, err := NormalizeProvingSystem("stark"); err == nil {
+		t.Fatal("unknown proving system accepted")
+	}
+}
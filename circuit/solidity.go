@@ -0,0 +1,114 @@
+package circuit
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend"
+	groth16_bn254 "github.com/consensys/gnark/backend/groth16/bn254"
+	plonk_bn254 "github.com/consensys/gnark/backend/plonk/bn254"
+	"github.com/consensys/gnark/backend/solidity"
+	"golang.org/x/crypto/sha3"
+)
+
+const solidityWordSize = 32
+
+func backendID(provingSystem string) backend.ID {
+	if provingSystem == ProvingSystemPlonk {
+		return backend.PLONK
+	}
+	return backend.GROTH16
+}
+
+// SolidityProverOptions makes the groth16 proof verifiable by the solidity
+// verifier exported by keygen, the solidity verifier hashes the commitments
+// with keccak256 instead of the default hash to field function.
+func SolidityProverOptions(provingSystem string) backend.ProverOption {
+	return solidity.WithProverTargetSolidityVerifier(backendID(provingSystem))
+}
+
+func SolidityVerifierOptions(provingSystem string) backend.VerifierOption {
+	return solidity.WithVerifierTargetSolidityVerifier(backendID(provingSystem))
+}
+
+func solidityWords(publicInputs [][]byte) ([]byte, error) {
+	words := make([]byte, 0, len(publicInputs)*solidityWordSize)
+	for _, input := range publicInputs {
+		v := new(big.Int).SetBytes(input)
+		if v.Cmp(ecc.BN254.ScalarField()) >= 0 {
+			return nil, fmt.Errorf("public input %x is not reduced", input)
+		}
+		words = append(words, v.FillBytes(make([]byte, solidityWordSize))...)
+	}
+	return words, nil
+}
+
+func soliditySelector(signature string) []byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte(signature))
+	return h.Sum(nil)[:4]
+}
+
+func solidityUint(v int) []byte {
+	word := make([]byte, solidityWordSize)
+	binary.BigEndian.PutUint64(word[solidityWordSize-8:], uint64(v))
+	return word
+}
+
+// SolidityCalldata returns the ABI encoded calldata of the exported solidity
+// verifier: verifyProof(uint256[8],[uint256[2n],uint256[2],]uint256[m]) for
+// groth16 and Verify(bytes,uint256[]) for plonk. The proof must be generated
+// with SolidityProverOptions, publicInputs are the big-endian public inputs of
+// the circuit, i.e. the BatchCommitment for the batch circuits.
+func SolidityCalldata(provingSystem string, proof Proof, publicInputs [][]byte) ([]byte, error) {
+	inputs, err := solidityWords(publicInputs)
+	if err != nil {
+		return nil, err
+	}
+	if provingSystem == ProvingSystemPlonk {
+		plonkProof, ok := proof.(*plonk_bn254.Proof)
+		if !ok {
+			return nil, fmt.Errorf("proof is not a plonk proof")
+		}
+		proofBytes := plonkProof.MarshalSolidity()
+		paddedProofLen := (len(proofBytes) + solidityWordSize - 1) / solidityWordSize * solidityWordSize
+		calldata := soliditySelector("Verify(bytes,uint256[])")
+		// head: offsets of the two dynamic arguments
+		calldata = append(calldata, solidityUint(2*solidityWordSize)...)
+		calldata = append(calldata, solidityUint(3*solidityWordSize+paddedProofLen)...)
+		calldata = append(calldata, solidityUint(len(proofBytes))...)
+		calldata = append(calldata, proofBytes...)
+		calldata = append(calldata, make([]byte, paddedProofLen-len(proofBytes))...)
+		calldata = append(calldata, solidityUint(len(publicInputs))...)
+		return append(calldata, inputs...), nil
+	}
+	groth16Proof, ok := proof.(*groth16_bn254.Proof)
+	if !ok {
+		return nil, fmt.Errorf("proof is not a groth16 proof")
+	}
+	// Ar | Bs | Krs in EIP-197 format
+	proofBytes := groth16Proof.MarshalSolidity()[:8*solidityWordSize]
+	signature := "verifyProof(uint256[8],"
+	var commitments []byte
+	if len(groth16Proof.Commitments) > 0 {
+		signature += "uint256[" + strconv.Itoa(2*len(groth16Proof.Commitments)) + "],uint256[2],"
+		for i := range groth16Proof.Commitments {
+			x := groth16Proof.Commitments[i].X.Bytes()
+			y := groth16Proof.Commitments[i].Y.Bytes()
+			commitments = append(commitments, x[:]...)
+			commitments = append(commitments, y[:]...)
+		}
+		x := groth16Proof.CommitmentPok.X.Bytes()
+		y := groth16Proof.CommitmentPok.Y.Bytes()
+		commitments = append(commitments, x[:]...)
+		commitments = append(commitments, y[:]...)
+	}
+	signature += "uint256[" + strconv.Itoa(len(publicInputs)) + "])"
+	calldata := soliditySelector(signature)
+	calldata = append(calldata, proofBytes...)
+	calldata = append(calldata, commitments...)
+	return append(calldata, inputs...), nil
+}
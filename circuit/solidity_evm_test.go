@@ -0,0 +1,232 @@
+// The test runs the exported solidity verifiers on the EVM of go-ethereum, it
+// is skipped when solc 0.8.18 or later is not installed.
+
+package circuit
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/kzg"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test/unsafekzg"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// compileSolidity compiles the contract by solc and returns the creation code
+// of the contract named contractName.
+func compileSolidity(t *testing.T, contract []byte, contractName string) []byte {
+	solc, err := exec.LookPath("solc")
+	if err != nil {
+		t.Skip("solc is not installed")
+	}
+	file := filepath.Join(t.TempDir(), "verifier.sol")
+	if err = os.WriteFile(file, contract, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the test chain config doesn't enable shanghai, so PUSH0 can't be used
+	out, err := exec.Command(solc, "--optimize", "--evm-version", "paris", "--combined-json", "bin", file).Output()
+	if err != nil {
+		t.Fatalf("solc failed: %s", err.Error())
+	}
+	var compiled struct {
+		Contracts map[string]struct {
+			Bin string `json:"bin"`
+		} `json:"contracts"`
+	}
+	if err = json.Unmarshal(out, &compiled); err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range compiled.Contracts {
+		if strings.HasSuffix(name, ":"+contractName) {
+			code, err := hex.DecodeString(c.Bin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return code
+		}
+	}
+	t.Fatalf("contract %s is not found in the solc output", contractName)
+	return nil
+}
+
+// memoryState is the state of the EVM kept in memory. The verifiers only read
+// their code, so snapshots, refunds and access lists are not tracked.
+type memoryState struct {
+	code  map[common.Address][]byte
+	nonce map[common.Address]uint64
+	state map[common.Address]map[common.Hash]common.Hash
+}
+
+func newMemoryState() *memoryState {
+	return &memoryState{
+		code:  make(map[common.Address][]byte),
+		nonce: make(map[common.Address]uint64),
+		state: make(map[common.Address]map[common.Hash]common.Hash),
+	}
+}
+
+func (s *memoryState) CreateAccount(common.Address)           {}
+func (s *memoryState) SubBalance(common.Address, *big.Int)    {}
+func (s *memoryState) AddBalance(common.Address, *big.Int)    {}
+func (s *memoryState) GetBalance(common.Address) *big.Int     { return new(big.Int) }
+func (s *memoryState) GetNonce(addr common.Address) uint64    { return s.nonce[addr] }
+func (s *memoryState) SetNonce(addr common.Address, n uint64) { s.nonce[addr] = n }
+func (s *memoryState) GetCodeHash(addr common.Address) common.Hash {
+	if code, ok := s.code[addr]; ok {
+		return crypto.Keccak256Hash(code)
+	}
+	return common.Hash{}
+}
+func (s *memoryState) GetCode(addr common.Address) []byte       { return s.code[addr] }
+func (s *memoryState) SetCode(addr common.Address, code []byte) { s.code[addr] = code }
+func (s *memoryState) GetCodeSize(addr common.Address) int      { return len(s.code[addr]) }
+func (s *memoryState) AddRefund(uint64)                         {}
+func (s *memoryState) SubRefund(uint64)                         {}
+func (s *memoryState) GetRefund() uint64                        { return 0 }
+func (s *memoryState) GetCommittedState(addr common.Address, key common.Hash) common.Hash {
+	return s.GetState(addr, key)
+}
+func (s *memoryState) GetState(addr common.Address, key common.Hash) common.Hash {
+	return s.state[addr][key]
+}
+func (s *memoryState) SetState(addr common.Address, key, value common.Hash) {
+	if s.state[addr] == nil {
+		s.state[addr] = make(map[common.Hash]common.Hash)
+	}
+	s.state[addr][key] = value
+}
+func (s *memoryState) GetTransientState(common.Address, common.Hash) common.Hash {
+	return common.Hash{}
+}
+func (s *memoryState) SetTransientState(common.Address, common.Hash, common.Hash) {}
+func (s *memoryState) SelfDestruct(common.Address)                                {}
+func (s *memoryState) HasSelfDestructed(common.Address) bool                      { return false }
+func (s *memoryState) Selfdestruct6780(common.Address)                            {}
+func (s *memoryState) Exist(addr common.Address) bool {
+	_, ok := s.code[addr]
+	return ok || s.nonce[addr] != 0
+}
+func (s *memoryState) Empty(addr common.Address) bool          { return !s.Exist(addr) }
+func (s *memoryState) AddressInAccessList(common.Address) bool { return true }
+func (s *memoryState) SlotInAccessList(common.Address, common.Hash) (bool, bool) {
+	return true, true
+}
+func (s *memoryState) AddAddressToAccessList(common.Address)           {}
+func (s *memoryState) AddSlotToAccessList(common.Address, common.Hash) {}
+func (s *memoryState) Prepare(params.Rules, common.Address, common.Address, *common.Address, []common.Address, types.AccessList) {
+}
+func (s *memoryState) RevertToSnapshot(int)            {}
+func (s *memoryState) Snapshot() int                   { return 0 }
+func (s *memoryState) AddLog(*types.Log)               {}
+func (s *memoryState) AddPreimage(common.Hash, []byte) {}
+
+// evmVerifier is the exported solidity verifier deployed on the EVM.
+type evmVerifier struct {
+	evm     *vm.EVM
+	from    common.Address
+	address common.Address
+}
+
+func deployEvmVerifier(t *testing.T, code []byte) *evmVerifier {
+	blockCtx := vm.BlockContext{
+		CanTransfer: func(vm.StateDB, common.Address, *big.Int) bool { return true },
+		Transfer:    func(vm.StateDB, common.Address, common.Address, *big.Int) {},
+		GetHash:     func(uint64) common.Hash { return common.Hash{} },
+		GasLimit:    30_000_000,
+		BlockNumber: big.NewInt(1),
+		Difficulty:  big.NewInt(1),
+		BaseFee:     big.NewInt(0),
+	}
+	evm := vm.NewEVM(blockCtx, vm.TxContext{GasPrice: big.NewInt(0)}, newMemoryState(), params.TestChainConfig, vm.Config{})
+	from := common.HexToAddress("0x1000")
+	_, address, _, err := evm.Create(vm.AccountRef(from), code, 15_000_000, new(big.Int))
+	if err != nil {
+		t.Fatalf("deploy the verifier contract failed: %s", err.Error())
+	}
+	return &evmVerifier{evm: evm, from: from, address: address}
+}
+
+// verify calls the verifier with calldata. The groth16 verifier reverts for
+// an invalid proof and the plonk verifier returns false.
+func (v *evmVerifier) verify(calldata []byte) bool {
+	out, _, err := v.evm.StaticCall(vm.AccountRef(v.from), v.address, calldata, 15_000_000)
+	if err != nil {
+		return false
+	}
+	return len(out) == 0 || (len(out) == solidityWordSize && out[solidityWordSize-1] == 1)
+}
+
+func TestSolidityVerifierOnEVM(t *testing.T) {
+	for _, provingSystem := range []string{ProvingSystemGroth16, ProvingSystemPlonk} {
+		cs, err := Compile(provingSystem, &committedCubicCircuit{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var srs, srsLagrange kzg.SRS
+		contractName := "Verifier"
+		if provingSystem == ProvingSystemPlonk {
+			srs, srsLagrange, err = unsafekzg.NewSRS(cs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			contractName = "PlonkVerifier"
+		}
+		pk, vk, err := Setup(provingSystem, cs, srs, srsLagrange)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var contract bytes.Buffer
+		if err = vk.ExportSolidity(&contract); err != nil {
+			t.Fatal(err)
+		}
+		verifier := deployEvmVerifier(t, compileSolidity(t, contract.Bytes(), contractName))
+
+		fullWitness, err := frontend.NewWitness(&committedCubicCircuit{X: 3, Y: 35}, ecc.BN254.ScalarField())
+		if err != nil {
+			t.Fatal(err)
+		}
+		proof, err := Prove(provingSystem, cs, pk, fullWitness, SolidityProverOptions(provingSystem))
+		if err != nil {
+			t.Fatal(err)
+		}
+		calldata, err := SolidityCalldata(provingSystem, proof, [][]byte{big.NewInt(35).Bytes()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !verifier.verify(calldata) {
+			t.Fatalf("%s: the solidity verifier rejects the proof", provingSystem)
+		}
+
+		// the proof is bound to the public input
+		wrongInput, err := SolidityCalldata(provingSystem, proof, [][]byte{big.NewInt(36).Bytes()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if verifier.verify(wrongInput) {
+			t.Fatalf("%s: the solidity verifier accepts a wrong public input", provingSystem)
+		}
+		// flip a bit of the first proof element
+		tampered := bytes.Clone(calldata)
+		proofStart := 4
+		if provingSystem == ProvingSystemPlonk {
+			proofStart = 4 + 3*solidityWordSize
+		}
+		tampered[proofStart+solidityWordSize-1] ^= 1
+		if verifier.verify(tampered) {
+			t.Fatalf("%s: the solidity verifier accepts a tampered proof", provingSystem)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package circuit
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/kzg"
+	plonk_bn254 "github.com/consensys/gnark/backend/plonk/bn254"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test/unsafekzg"
+)
+
+type committedCubicCircuit struct {
+	X Variable
+	Y Variable `gnark:",public"`
+}
+
+func (c *committedCubicCircuit) Define(api API) error {
+	x3 := api.Mul(c.X, c.X, c.X)
+	api.AssertIsEqual(c.Y, api.Add(x3, c.X, 5))
+	// the batch circuits commit to the lookup tables in the same way
+	commitment, err := api.(frontend.Committer).Commit(c.X)
+	if err != nil {
+		return err
+	}
+	api.AssertIsDifferent(commitment, 0)
+	return nil
+}
+
+func TestSolidityCalldata(t *testing.T) {
+	for _, provingSystem := range []string{ProvingSystemGroth16, ProvingSystemPlonk} {
+		cs, err := Compile(provingSystem, &committedCubicCircuit{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var srs, srsLagrange kzg.SRS
+		if provingSystem == ProvingSystemPlonk {
+			srs, srsLagrange, err = unsafekzg.NewSRS(cs)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		pk, vk, err := Setup(provingSystem, cs, srs, srsLagrange)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var contract bytes.Buffer
+		if err = vk.ExportSolidity(&contract); err != nil {
+			t.Fatal(err)
+		}
+		fullWitness, err := frontend.NewWitness(&committedCubicCircuit{X: 3, Y: 35}, ecc.BN254.ScalarField())
+		if err != nil {
+			t.Fatal(err)
+		}
+		publicWitness, err := fullWitness.Public()
+		if err != nil {
+			t.Fatal(err)
+		}
+		proof, err := Prove(provingSystem, cs, pk, fullWitness, SolidityProverOptions(provingSystem))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = Verify(provingSystem, proof, vk, publicWitness, SolidityVerifierOptions(provingSystem)); err != nil {
+			t.Fatalf("%s: %s", provingSystem, err.Error())
+		}
+
+		publicInput := big.NewInt(35).Bytes()
+		calldata, err := SolidityCalldata(provingSystem, proof, [][]byte{publicInput})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(calldata[len(calldata)-32:], new(big.Int).SetBytes(publicInput).FillBytes(make([]byte, 32))) {
+			t.Fatalf("%s: the public input must be the last word of calldata", provingSystem)
+		}
+		if provingSystem == ProvingSystemPlonk {
+			if !strings.Contains(contract.String(), "function Verify(bytes calldata proof, uint256[] calldata public_inputs)") {
+				t.Fatal("unexpected plonk verifier contract")
+			}
+			proofBytes := proof.(*plonk_bn254.Proof).MarshalSolidity()
+			proofOffset := 4 + new(big.Int).SetBytes(calldata[4:36]).Int64()
+			proofLen := new(big.Int).SetBytes(calldata[proofOffset : proofOffset+32]).Int64()
+			if proofLen != int64(len(proofBytes)) || !bytes.Equal(calldata[proofOffset+32:proofOffset+32+proofLen], proofBytes) {
+				t.Fatal("unexpected plonk proof encoding")
+			}
+			inputsOffset := 4 + new(big.Int).SetBytes(calldata[36:68]).Int64()
+			if new(big.Int).SetBytes(calldata[inputsOffset:inputsOffset+32]).Int64() != 1 || int(inputsOffset)+64 != len(calldata) {
+				t.Fatal("unexpected plonk public inputs encoding")
+			}
+		} else {
+			if !strings.Contains(contract.String(), "uint256[2] calldata commitments") {
+				t.Fatal("unexpected groth16 verifier contract")
+			}
+			// proof, one commitment, commitment pok and one public input
+			if len(calldata) != 4+32*(8+2+2+1) {
+				t.Fatalf("unexpected groth16 calldata length %d", len(calldata))
+			}
+			if !bytes.Equal(calldata[:4], soliditySelector("verifyProof(uint256[8],uint256[2],uint256[2],uint256[1])")) {
+				t.Fatal("unexpected groth16 selector")
+			}
+			// the solidity verifier hashes the commitment by keccak256
+			if err = Verify(provingSystem, proof, vk, publicWitness); err == nil {
+				t.Fatal("the proof for solidity verifier verified with the default options")
+			}
+		}
+	}
+	if _, err := SolidityCalldata(ProvingSystemGroth16, NewProof(ProvingSystemGroth16), [][]byte{ecc.BN254.ScalarField().Bytes()}); err == nil {
+		t.Fatal("unreduced public input accepted")
+	}
+}
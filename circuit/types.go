@@ -62,3 +62,24 @@ type CreateUserOperation struct {
 	AccountIdHash         Variable
 	AccountProof          [utils.AccountTreeDepth]Variable
 }
+
+type UpdateUserOperation struct {
+	BeforeAccountTreeRoot Variable
+	AfterAccountTreeRoot  Variable
+	// IsOldEmpty is 1 when the account is inserted into an empty leaf
+	IsOldEmpty         Variable
+	OldTotalEquity     Variable
+	OldTotalDebt       Variable
+	OldTotalCollateral Variable
+	// the asset indexes of the old account, the old assets are not range checked
+	// because they are bound to the old account leaf
+	OldAssetIndexes       []Variable
+	OldAssetsForUpdateCex []UserAssetMeta
+	// IsNewEmpty is 1 when the account is deleted and its leaf is reset to the empty leaf
+	IsNewEmpty         Variable
+	Assets             []UserAssetInfo
+	AssetsForUpdateCex []UserAssetMeta
+	AccountIndex       Variable
+	AccountIdHash      Variable
+	AccountProof       [utils.AccountTreeDepth]Variable
+}
@@ -0,0 +1,87 @@
+package circuit
+
+import (
+	"fmt"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
+	poseidon2 "github.com/consensys/gnark/std/hash/poseidon"
+)
+
+// UserInclusionCircuit proves that the account leaf with the given assets
+// commitment is in the account tree. The merkle siblings, the account index
+// and the total equity, debt and collateral of the account stay private.
+type UserInclusionCircuit struct {
+	AccountTreeRoot Variable `gnark:",public"`
+	// UserAssetsCommitment is Poseidon(AccountIdHash, AssetsCommitment), so
+	// that two accounts with the same assets can't share one proof
+	UserAssetsCommitment Variable `gnark:",public"`
+	AccountIdHash        Variable
+	TotalEquity          Variable
+	TotalDebt            Variable
+	TotalCollateral      Variable
+	AssetsCommitment     Variable
+	AccountIndex         Variable
+	MerkleProof          [utils.AccountTreeDepth]Variable
+}
+
+// UserInclusionInput is the private data of one user needed to generate the
+// user inclusion proof, it is the same as the data kept by userproof service.
+type UserInclusionInput struct {
+	AccountTreeRoot []byte
+	Account         *utils.AccountInfo
+	MerkleProof     [][]byte
+}
+
+func NewUserInclusionCircuit() *UserInclusionCircuit {
+	var circuit UserInclusionCircuit
+	circuit.AccountTreeRoot = 0
+	circuit.UserAssetsCommitment = 0
+	circuit.AccountIdHash = 0
+	circuit.TotalEquity = 0
+	circuit.TotalDebt = 0
+	circuit.TotalCollateral = 0
+	circuit.AssetsCommitment = 0
+	circuit.AccountIndex = 0
+	for i := 0; i < utils.AccountTreeDepth; i++ {
+		circuit.MerkleProof[i] = 0
+	}
+	return &circuit
+}
+
+func NewVerifyUserInclusionCircuit(accountTreeRoot []byte, userAssetsCommitment []byte) *UserInclusionCircuit {
+	var v UserInclusionCircuit
+	v.AccountTreeRoot = accountTreeRoot
+	v.UserAssetsCommitment = userAssetsCommitment
+	return &v
+}
+
+func (c UserInclusionCircuit) Define(api API) error {
+	actualUserAssetsCommitment := poseidon2.Poseidon(api, c.AccountIdHash, c.AssetsCommitment)
+	api.AssertIsEqual(c.UserAssetsCommitment, actualUserAssetsCommitment)
+	accountHash := poseidon2.Poseidon(api, c.AccountIdHash, c.TotalEquity, c.TotalDebt, c.TotalCollateral, c.AssetsCommitment)
+	verifyMerkleProof(api, c.AccountTreeRoot, accountHash, c.MerkleProof[:], accountIdToMerkleHelper(api, c.AccountIndex))
+	return nil
+}
+
+func SetUserInclusionCircuitWitness(input *UserInclusionInput) (witness *UserInclusionCircuit, err error) {
+	if len(input.MerkleProof) != utils.AccountTreeDepth {
+		return nil, fmt.Errorf("invalid merkle proof length %d", len(input.MerkleProof))
+	}
+	hasher := poseidon.NewPoseidon()
+	assetsCommitment := utils.ComputeUserAssetsCommitment(&hasher, input.Account.Assets)
+	witness = &UserInclusionCircuit{
+		AccountTreeRoot:      input.AccountTreeRoot,
+		UserAssetsCommitment: utils.ComputeUserInclusionCommitment(input.Account.AccountId, assetsCommitment),
+		AccountIdHash:        input.Account.AccountId,
+		TotalEquity:          input.Account.TotalEquity,
+		TotalDebt:            input.Account.TotalDebt,
+		TotalCollateral:      input.Account.TotalCollateral,
+		AssetsCommitment:     assetsCommitment,
+		AccountIndex:         input.Account.AccountIndex,
+	}
+	for i := 0; i < utils.AccountTreeDepth; i++ {
+		witness.MerkleProof[i] = input.MerkleProof[i]
+	}
+	return witness, nil
+}
@@ -0,0 +1,81 @@
+package circuit
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+)
+
+func TestUserInclusionCircuit(t *testing.T) {
+	assert := test.NewAssert(t)
+	accountTree, err := utils.NewAccountTree("memory", "")
+	assert.NoError(err)
+	accounts := []utils.AccountInfo{
+		{
+			AccountIndex:    0,
+			AccountId:       []byte{1},
+			TotalEquity:     big.NewInt(1000),
+			TotalDebt:       big.NewInt(10),
+			TotalCollateral: big.NewInt(100),
+			Assets:          []utils.AccountAsset{{Index: 0, Equity: 10, Debt: 1}},
+		},
+		{
+			AccountIndex:    5,
+			AccountId:       []byte{2},
+			TotalEquity:     big.NewInt(2000),
+			TotalDebt:       big.NewInt(20),
+			TotalCollateral: big.NewInt(200),
+			Assets:          []utils.AccountAsset{{Index: 0, Equity: 20, Debt: 2}, {Index: 3, Equity: 7, Loan: 5}},
+		},
+	}
+	hasher := poseidon.NewPoseidon()
+	for i := range accounts {
+		err = accountTree.Set(uint64(accounts[i].AccountIndex), utils.AccountInfoToHash(&accounts[i], &hasher))
+		assert.NoError(err)
+	}
+	merkleProof, err := accountTree.GetProof(uint64(accounts[1].AccountIndex))
+	assert.NoError(err)
+
+	input := &UserInclusionInput{
+		AccountTreeRoot: accountTree.Root(),
+		Account:         &accounts[1],
+		MerkleProof:     merkleProof,
+	}
+	assignment, err := SetUserInclusionCircuitWitness(input)
+	assert.NoError(err)
+	assert.NoError(test.IsSolved(NewUserInclusionCircuit(), assignment, ecc.BN254.ScalarField()))
+
+	// the verifier only knows the root and its own account id hash and assets
+	ccs, err := Compile(ProvingSystemGroth16, NewUserInclusionCircuit())
+	assert.NoError(err)
+	pk, vk, err := Setup(ProvingSystemGroth16, ccs, nil, nil)
+	assert.NoError(err)
+	w, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField())
+	assert.NoError(err)
+	proof, err := Prove(ProvingSystemGroth16, ccs, pk, w)
+	assert.NoError(err)
+	hasher.Reset()
+	userAssetsCommitment := utils.ComputeUserInclusionCommitment(accounts[1].AccountId, utils.ComputeUserAssetsCommitment(&hasher, accounts[1].Assets))
+	vWitness, err := frontend.NewWitness(NewVerifyUserInclusionCircuit(accountTree.Root(), userAssetsCommitment), ecc.BN254.ScalarField(), frontend.PublicOnly())
+	assert.NoError(err)
+	assert.NoError(Verify(ProvingSystemGroth16, proof, vk, vWitness))
+
+	// the private totals must match the leaf
+	input.Account.TotalEquity = big.NewInt(2001)
+	assignment, err = SetUserInclusionCircuitWitness(input)
+	assert.NoError(err)
+	assert.Error(test.IsSolved(NewUserInclusionCircuit(), assignment, ecc.BN254.ScalarField()))
+
+	// the proof can't be claimed by another account
+	input.Account.TotalEquity = big.NewInt(2000)
+	assignment, err = SetUserInclusionCircuitWitness(input)
+	assert.NoError(err)
+	hasher.Reset()
+	assignment.UserAssetsCommitment = utils.ComputeUserInclusionCommitment(accounts[0].AccountId, utils.ComputeUserAssetsCommitment(&hasher, accounts[1].Assets))
+	assert.Error(test.IsSolved(NewUserInclusionCircuit(), assignment, ecc.BN254.ScalarField()))
+}
@@ -9,6 +9,34 @@ import (
 	"github.com/consensys/gnark/std/lookup/logderivlookup"
 )
 
+// r1csNOpBuilder is implemented by the r1cs builder only, the plonk builder
+// doesn't support the NOp comparisons.
+type r1csNOpBuilder interface {
+	MustBeLessOrEqCstNOp(a frontend.Variable, bound *big.Int, aForDebug frontend.Variable, maxBits int, omitRangeCheck bool)
+}
+
+// assertIsLessOrEqualNOp asserts v <= bound, both of them must be less than 2^maxBits.
+func assertIsLessOrEqualNOp(api API, v, bound Variable, maxBits int) {
+	if _, ok := api.(r1csNOpBuilder); ok {
+		api.AssertIsLessOrEqualNOp(v, bound, maxBits, true)
+		return
+	}
+	// bound - v wraps around the field when v > bound
+	api.ToBinary(api.Sub(bound, v), maxBits)
+}
+
+// cmpNOp returns 1 if a > b, 0 if a = b and -1 if a < b, both of them must be
+// less than 2^maxBits.
+func cmpNOp(api API, a, b Variable, maxBits int) Variable {
+	if _, ok := api.(r1csNOpBuilder); ok {
+		return api.CmpNOp(a, b, maxBits, true)
+	}
+	c := new(big.Int).Lsh(big.NewInt(1), uint(maxBits))
+	isEqual := api.IsZero(api.Sub(a, b))
+	resBits := api.ToBinary(api.Sub(api.Add(a, c), b), maxBits+1)
+	return api.Select(isEqual, 0, api.Select(resBits[maxBits], 1, -1))
+}
+
 func verifyMerkleProof(api API, merkleRoot Variable, node Variable, proofSet, helper []Variable) {
 	for i := 0; i < len(proofSet); i++ {
 		api.AssertIsBoolean(helper[i])
@@ -90,12 +118,12 @@ func fillCexAssetCommitment(api API, asset CexAssetInfo, currentIndex int, commi
 
 func generateRapidArithmeticForCollateral(api API, r frontend.Rangechecker, tierRatios []TierRatio) {
 	tierRatios[0].PrecomputedValue = checkAndGetIntegerDivisionRes(api, r, api.Mul(tierRatios[0].BoundaryValue, tierRatios[0].Ratio))
-	api.AssertIsLessOrEqualNOp(tierRatios[0].Ratio, utils.PercentageMultiplierFr, 8, true)
-	api.AssertIsLessOrEqualNOp(tierRatios[0].BoundaryValue, utils.MaxTierBoundaryValueFr, 128, true)
+	assertIsLessOrEqualNOp(api, tierRatios[0].Ratio, utils.PercentageMultiplierFr, 8)
+	assertIsLessOrEqualNOp(api, tierRatios[0].BoundaryValue, utils.MaxTierBoundaryValueFr, 128)
 	for i := 1; i < len(tierRatios); i++ {
-		api.AssertIsLessOrEqualNOp(tierRatios[i-1].BoundaryValue, tierRatios[i].BoundaryValue, 128, true)
-		api.AssertIsLessOrEqualNOp(tierRatios[i].Ratio, utils.PercentageMultiplierFr, 8, true)
-		api.AssertIsLessOrEqualNOp(tierRatios[i].BoundaryValue, utils.MaxTierBoundaryValueFr, 128, true)
+		assertIsLessOrEqualNOp(api, tierRatios[i-1].BoundaryValue, tierRatios[i].BoundaryValue, 128)
+		assertIsLessOrEqualNOp(api, tierRatios[i].Ratio, utils.PercentageMultiplierFr, 8)
+		assertIsLessOrEqualNOp(api, tierRatios[i].BoundaryValue, utils.MaxTierBoundaryValueFr, 128)
 		diffBoundary := api.Sub(tierRatios[i].BoundaryValue, tierRatios[i-1].BoundaryValue)
 		current := checkAndGetIntegerDivisionRes(api, r, api.Mul(diffBoundary, tierRatios[i].Ratio))
 		tierRatios[i].PrecomputedValue = api.Add(tierRatios[i-1].PrecomputedValue, current)
@@ -134,13 +162,13 @@ func getAndCheckTierRatiosQueryResults(api API, r frontend.Rangechecker, tierRat
 	results := tierRatiosTable.Lookup(queries...)
 	collateralValue := api.Mul(userCollateral, assetPrice)
 	// results[0] is less than 2^128 which is constrainted in the GenerateRapidArithmeticForCollateral
-	cr := api.CmpNOp(collateralValue, results[0], 128, true)
+	cr := cmpNOp(api, collateralValue, results[0], 128)
 	// cr only can be 0 or 1
 	// cr is 0 in the special case that userAssets.LoanCollateral is 0;
 	api.AssertIsEqual(cr, api.Select(api.IsZero(collateralValue), 0, 1))
 	// results[3] is the upper boundary value
 	upperBoundaryValue := api.Select(api.IsZero(collateralFlag), results[3], utils.MaxTierBoundaryValueFr)
-	api.AssertIsLessOrEqualNOp(collateralValue, upperBoundaryValue, 128, true)
+	assertIsLessOrEqualNOp(api, collateralValue, upperBoundaryValue, 128)
 	// results[4] is ratio of upper boundary value
 	// diffValue = (collateralValue - lower boundary value) * ratio
 	diffValue := api.Mul(api.Sub(collateralValue, results[0]), results[4])
@@ -158,7 +186,7 @@ func checkAndGetIntegerDivisionRes(api API, r frontend.Rangechecker, dividend Va
 	}
 	r.Check(quotientRes[0], 128)
 	r.Check(quotientRes[1], 8)
-	api.AssertIsLessOrEqualNOp(quotientRes[1], utils.PercentageMultiplierFr, 8, true)
+	assertIsLessOrEqualNOp(api, quotientRes[1], utils.PercentageMultiplierFr, 8)
 	api.AssertIsEqual(api.Add(api.Mul(quotientRes[0], utils.PercentageMultiplierFr), quotientRes[1]), dividend)
 	return quotientRes[0]
 }
@@ -263,3 +291,178 @@ func calcAndSetCollateralInfo(assetIndex int, ua *UserAssetInfo, um *utils.Accou
 		ua.PortfolioMarginCollateralFlag = 1
 	}
 }
+
+// To check all the user assetIndexes are unique to each other.
+// If the user assetIndex is increasing, Then all the assetIndexes are unique
+func checkAssetIndexesIncreasing(api API, r frontend.Rangechecker, assetIndexes []Variable) {
+	for j := 0; j < len(assetIndexes)-1; j++ {
+		r.Check(assetIndexes[j], 16)
+		cr := cmpNOp(api, assetIndexes[j+1], assetIndexes[j], 16)
+		api.AssertIsEqual(cr, 1)
+	}
+}
+
+func computeAssetIdsHash(api API, assetIndexes []Variable) Variable {
+	// one Variable can store 15 assetIds, one assetId is less than 16 bits
+	assetIdsToVariables := make([]Variable, (len(assetIndexes)+14)/15)
+	for j := 0; j < len(assetIdsToVariables); j++ {
+		var v Variable = 0
+		for p := j * 15; p < (j+1)*15 && p < len(assetIndexes); p++ {
+			v = api.Add(v, api.Mul(assetIndexes[p], utils.PowersOfSixteenBits[p%15]))
+		}
+		assetIdsToVariables[j] = v
+	}
+	return poseidon.Poseidon(api, assetIdsToVariables...)
+}
+
+func constructUserAssetsLookupTable(api API, assetsForUpdateCex []UserAssetMeta) *logderivlookup.Table {
+	t := logderivlookup.New(api)
+	for j := 0; j < len(assetsForUpdateCex); j++ {
+		t.Insert(assetsForUpdateCex[j].Equity)
+		t.Insert(assetsForUpdateCex[j].Debt)
+		t.Insert(assetsForUpdateCex[j].LoanCollateral)
+		t.Insert(assetsForUpdateCex[j].MarginCollateral)
+		t.Insert(assetsForUpdateCex[j].PortfolioMarginCollateral)
+	}
+	return t
+}
+
+type userAssetsValues struct {
+	totalEquity              Variable
+	totalDebt                Variable
+	totalCollateralRealValue Variable
+	assetIdsHash             Variable
+	// the fields of user assets used to compute the user assets commitment
+	flattenAssetFields []Variable
+	// the queries and results of user assets lookup, they are used by random linear combination check
+	queries []Variable
+	results []Variable
+}
+
+// checkUserAssets checks the assets of a user and computes the total equity,
+// total debt and total collateral real value of the user by the prices and
+// tier ratios of the cex assets.
+func checkUserAssets(api API, r frontend.Rangechecker, cexAssets []CexAssetInfo,
+	assetPriceTable, loanTierRatiosTable, marginTierRatiosTable, portfolioMarginTierRatiosTable *logderivlookup.Table,
+	userAssets []UserAssetInfo, assetsForUpdateCex []UserAssetMeta) (res userAssetsValues) {
+	var totalUserEquity Variable = 0
+	var totalUserDebt Variable = 0
+	var totalUserCollateralRealValue Variable = 0
+
+	// construct lookup table for user assets
+	userAssetsLookupTable := constructUserAssetsLookupTable(api, assetsForUpdateCex)
+
+	assetIndexes := make([]Variable, len(userAssets))
+	for j := 0; j < len(userAssets); j++ {
+		assetIndexes[j] = userAssets[j].AssetIndex
+	}
+	checkAssetIndexesIncreasing(api, r, assetIndexes)
+	res.assetIdsHash = computeAssetIdsHash(api, assetIndexes)
+
+	// construct query to get user assets
+	res.queries = make([]Variable, len(userAssets)*5)
+	assetPriceQueries := make([]Variable, len(userAssets))
+	numOfAssetsFields := 6
+	for j := 0; j < len(userAssets); j++ {
+		p := api.Mul(userAssets[j].AssetIndex, 5)
+		for k := 0; k < 5; k++ {
+			res.queries[j*5+k] = api.Add(p, k)
+		}
+		assetPriceQueries[j] = userAssets[j].AssetIndex
+	}
+	res.results = userAssetsLookupTable.Lookup(res.queries...)
+	assetPriceResponses := assetPriceTable.Lookup(assetPriceQueries...)
+
+	res.flattenAssetFields = make([]Variable, len(userAssets)*numOfAssetsFields)
+	for j := 0; j < len(userAssets); j++ {
+		// Equity
+		userEquity := res.results[j*5]
+		r.Check(userEquity, 64)
+		// Debt
+		userDebt := res.results[j*5+1]
+		r.Check(userDebt, 64)
+		// LoanCollateral
+		userLoanCollateral := res.results[j*5+2]
+		r.Check(userLoanCollateral, 64)
+		// MarginCollateral
+		userMarginCollateral := res.results[j*5+3]
+		r.Check(userMarginCollateral, 64)
+		// PortfolioMarginCollateral
+		userPortfolioMarginCollateral := res.results[j*5+4]
+		r.Check(userPortfolioMarginCollateral, 64)
+
+		res.flattenAssetFields[j*numOfAssetsFields] = userAssets[j].AssetIndex
+		res.flattenAssetFields[j*numOfAssetsFields+1] = userEquity
+		res.flattenAssetFields[j*numOfAssetsFields+2] = userDebt
+		res.flattenAssetFields[j*numOfAssetsFields+3] = userLoanCollateral
+		res.flattenAssetFields[j*numOfAssetsFields+4] = userMarginCollateral
+		res.flattenAssetFields[j*numOfAssetsFields+5] = userPortfolioMarginCollateral
+
+		assetTotalCollateral := api.Add(userLoanCollateral, userMarginCollateral, userPortfolioMarginCollateral)
+		r.Check(assetTotalCollateral, 64)
+		assertIsLessOrEqualNOp(api, assetTotalCollateral, userEquity, 64)
+
+		loanRealValue := getAndCheckTierRatiosQueryResults(api, r, loanTierRatiosTable, userAssets[j].AssetIndex,
+			userLoanCollateral,
+			userAssets[j].LoanCollateralIndex,
+			userAssets[j].LoanCollateralFlag,
+			assetPriceResponses[j],
+			3*(len(cexAssets[j].LoanRatios)+1))
+
+		marginRealValue := getAndCheckTierRatiosQueryResults(api, r, marginTierRatiosTable, userAssets[j].AssetIndex,
+			userMarginCollateral,
+			userAssets[j].MarginCollateralIndex,
+			userAssets[j].MarginCollateralFlag,
+			assetPriceResponses[j],
+			3*(len(cexAssets[j].MarginRatios)+1))
+
+		portfolioMarginRealValue := getAndCheckTierRatiosQueryResults(api, r, portfolioMarginTierRatiosTable, userAssets[j].AssetIndex,
+			userPortfolioMarginCollateral,
+			userAssets[j].PortfolioMarginCollateralIndex,
+			userAssets[j].PortfolioMarginCollateralFlag,
+			assetPriceResponses[j],
+			3*(len(cexAssets[j].PortfolioMarginRatios)+1))
+
+		totalUserCollateralRealValue = api.Add(totalUserCollateralRealValue, loanRealValue, marginRealValue, portfolioMarginRealValue)
+
+		totalUserEquity = api.Add(totalUserEquity, api.Mul(userEquity, assetPriceResponses[j]))
+		totalUserDebt = api.Add(totalUserDebt, api.Mul(userDebt, assetPriceResponses[j]))
+	}
+	res.totalEquity = totalUserEquity
+	res.totalDebt = totalUserDebt
+	res.totalCollateralRealValue = totalUserCollateralRealValue
+	return res
+}
+
+func constructPowersOfRandomChallenge(api API, randomChallenge Variable, n int) ([]Variable, *logderivlookup.Table) {
+	powersOfRandomChallenge := make([]Variable, n)
+	powersOfRandomChallenge[0] = randomChallenge
+	powersOfRandomChallengeLookupTable := logderivlookup.New(api)
+	powersOfRandomChallengeLookupTable.Insert(randomChallenge)
+	for i := 1; i < len(powersOfRandomChallenge); i++ {
+		powersOfRandomChallenge[i] = api.Mul(powersOfRandomChallenge[i-1], randomChallenge)
+		powersOfRandomChallengeLookupTable.Insert(powersOfRandomChallenge[i])
+	}
+	return powersOfRandomChallenge, powersOfRandomChallengeLookupTable
+}
+
+// checkRandomLinearCombination makes sure the user assets looked up by the
+// queries contain all non-zero assets of assetsForUpdateCex
+func checkRandomLinearCombination(api API, powersOfRandomChallengeLookupTable *logderivlookup.Table, powersOfRandomChallenge []Variable,
+	userAssetsQueries []Variable, userAssetsResults []Variable, assetsForUpdateCex []UserAssetMeta) {
+	powersOfRCResults := powersOfRandomChallengeLookupTable.Lookup(userAssetsQueries...)
+	var sumA Variable = 0
+	for j := 0; j < len(powersOfRCResults); j++ {
+		sumA = api.Add(sumA, api.Mul(powersOfRCResults[j], userAssetsResults[j]))
+	}
+
+	var sumB Variable = 0
+	for j := 0; j < len(assetsForUpdateCex); j++ {
+		sumB = api.Add(sumB, api.Mul(assetsForUpdateCex[j].Equity, powersOfRandomChallenge[5*j]))
+		sumB = api.Add(sumB, api.Mul(assetsForUpdateCex[j].Debt, powersOfRandomChallenge[5*j+1]))
+		sumB = api.Add(sumB, api.Mul(assetsForUpdateCex[j].LoanCollateral, powersOfRandomChallenge[5*j+2]))
+		sumB = api.Add(sumB, api.Mul(assetsForUpdateCex[j].MarginCollateral, powersOfRandomChallenge[5*j+3]))
+		sumB = api.Add(sumB, api.Mul(assetsForUpdateCex[j].PortfolioMarginCollateral, powersOfRandomChallenge[5*j+4]))
+	}
+	api.AssertIsEqual(sumA, sumB)
+}
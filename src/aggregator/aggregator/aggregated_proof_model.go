@@ -0,0 +1,156 @@
+package aggregator
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+)
+
+const (
+	TableNamePrefix = "aggregated_proof"
+)
+
+// the root proof aggregates the top proofs of all tiers, so it doesn't belong to any tier
+const RootAssetsCount = 0
+
+type (
+	AggregatedProofModel interface {
+		CreateAggregatedProofTable() error
+		DropAggregatedProofTable() error
+		CreateAggregatedProof(row *AggregatedProof) error
+		GetAggregatedProof(assetsCount int, level int, position int) (p *AggregatedProof, err error)
+		GetRootAggregatedProof() (p *AggregatedProof, err error)
+	}
+
+	defaultAggregatedProofModel struct {
+		table string
+		db    *utils.DB
+	}
+
+	// AggregatedProof is the proof of the batches [StartBatchNumber, EndBatchNumber],
+	// Level 1 proofs aggregate batch proofs, level n proofs aggregate level n-1 proofs.
+	AggregatedProof struct {
+		ID                      uint64
+		CreatedAt               time.Time
+		UpdatedAt               time.Time
+		DeletedAt               *time.Time
+		ProofInfo               string
+		CexAssetListCommitments string
+		AccountTreeRoots        string
+		AggregatedCommitment    string
+		AssetsCount             int
+		Level                   int
+		Position                int
+		StartBatchNumber        int64
+		EndBatchNumber          int64
+	}
+)
+
+func NewAggregatedProofModel(db *utils.DB, suffix string) AggregatedProofModel {
+	return &defaultAggregatedProofModel{
+		table: TableNamePrefix + suffix,
+		db:    db,
+	}
+}
+
+func (m *defaultAggregatedProofModel) CreateAggregatedProofTable() error {
+	if m.db.Driver() != utils.DbDriverMysql {
+		return m.createPortableAggregatedProofTable()
+	}
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		deleted_at TIMESTAMP NULL DEFAULT NULL,
+		proof_info LONGTEXT NOT NULL,
+		cex_asset_list_commitments TEXT NOT NULL,
+		account_tree_roots TEXT NOT NULL,
+		aggregated_commitment TEXT NOT NULL,
+		assets_count INT NOT NULL,
+		level INT NOT NULL,
+		position INT NOT NULL,
+		start_batch_number BIGINT NOT NULL,
+		end_batch_number BIGINT NOT NULL,
+		UNIQUE KEY idx_assets_count_level_position (assets_count, level, position)
+	)`, m.table)
+	_, err := m.db.Exec(query)
+	return err
+}
+
+// createPortableAggregatedProofTable creates the table of postgres and sqlite
+func (m *defaultAggregatedProofModel) createPortableAggregatedProofTable() error {
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id %s,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		deleted_at TIMESTAMP NULL DEFAULT NULL,
+		proof_info TEXT NOT NULL,
+		cex_asset_list_commitments TEXT NOT NULL,
+		account_tree_roots TEXT NOT NULL,
+		aggregated_commitment TEXT NOT NULL,
+		assets_count INT NOT NULL,
+		level INT NOT NULL,
+		position INT NOT NULL,
+		start_batch_number BIGINT NOT NULL,
+		end_batch_number BIGINT NOT NULL
+	)`, m.table, m.db.AutoIncrementPrimaryKey())
+	_, err := m.db.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS idx_%s_assets_count_level_position ON %s (assets_count, level, position)", m.table, m.table)
+	_, err = m.db.Exec(query)
+	return err
+}
+
+func (m *defaultAggregatedProofModel) DropAggregatedProofTable() error {
+	query := fmt.Sprintf("DROP TABLE IF EXISTS %s", m.table)
+	_, err := m.db.Exec(query)
+	return err
+}
+
+func (m *defaultAggregatedProofModel) CreateAggregatedProof(row *AggregatedProof) error {
+	query := fmt.Sprintf("INSERT INTO %s (proof_info, cex_asset_list_commitments, account_tree_roots, aggregated_commitment, assets_count, level, position, start_batch_number, end_batch_number, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)", m.table)
+	result, err := m.db.Exec(query, row.ProofInfo, row.CexAssetListCommitments, row.AccountTreeRoots, row.AggregatedCommitment, row.AssetsCount, row.Level, row.Position, row.StartBatchNumber, row.EndBatchNumber)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return utils.DbErrSqlOperation
+	}
+	return nil
+}
+
+func (m *defaultAggregatedProofModel) GetAggregatedProof(assetsCount int, level int, position int) (p *AggregatedProof, err error) {
+	row := &AggregatedProof{}
+	query := fmt.Sprintf("SELECT id, created_at, updated_at, deleted_at, proof_info, cex_asset_list_commitments, account_tree_roots, aggregated_commitment, assets_count, level, position, start_batch_number, end_batch_number FROM %s WHERE assets_count = ? AND level = ? AND position = ? AND deleted_at IS NULL LIMIT 1", m.table)
+	dbRow := m.db.QueryRowWithTimeout(query, assetsCount, level, position)
+	err = dbRow.Scan(&row.ID, &row.CreatedAt, &row.UpdatedAt, &row.DeletedAt, &row.ProofInfo, &row.CexAssetListCommitments, &row.AccountTreeRoots, &row.AggregatedCommitment, &row.AssetsCount, &row.Level, &row.Position, &row.StartBatchNumber, &row.EndBatchNumber)
+	if err == sql.ErrNoRows {
+		return nil, utils.DbErrNotFound
+	}
+	if err != nil {
+		return nil, utils.ConvertSqlErrToDbErr(err)
+	}
+	return row, nil
+}
+
+func (m *defaultAggregatedProofModel) GetRootAggregatedProof() (p *AggregatedProof, err error) {
+	row := &AggregatedProof{}
+	query := fmt.Sprintf("SELECT id, created_at, updated_at, deleted_at, proof_info, cex_asset_list_commitments, account_tree_roots, aggregated_commitment, assets_count, level, position, start_batch_number, end_batch_number FROM %s WHERE assets_count = ? AND deleted_at IS NULL ORDER BY level DESC LIMIT 1", m.table)
+	dbRow := m.db.QueryRowWithTimeout(query, RootAssetsCount)
+	err = dbRow.Scan(&row.ID, &row.CreatedAt, &row.UpdatedAt, &row.DeletedAt, &row.ProofInfo, &row.CexAssetListCommitments, &row.AccountTreeRoots, &row.AggregatedCommitment, &row.AssetsCount, &row.Level, &row.Position, &row.StartBatchNumber, &row.EndBatchNumber)
+	if err == sql.ErrNoRows {
+		return nil, utils.DbErrNotFound
+	}
+	if err != nil {
+		return nil, utils.ConvertSqlErrToDbErr(err)
+	}
+	return row, nil
+}
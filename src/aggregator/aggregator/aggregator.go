@@ -0,0 +1,367 @@
+package aggregator
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/circuit"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/aggregator/config"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/prover/prover"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/witness"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/constraint/solver"
+	"github.com/consensys/gnark/frontend"
+)
+
+type Aggregator struct {
+	witnessModel         witness.WitnessModel
+	proofModel           prover.ProofModel
+	aggregatedProofModel AggregatedProofModel
+
+	ZkKeyName        []string
+	AssetsCountTiers []int
+	RootZkKeyName    string
+	BatchCount       int
+	Levels           int
+
+	R1cs                  constraint.ConstraintSystem
+	ProvingKey            groth16.ProvingKey
+	VerifyingKey          groth16.VerifyingKey
+	CurrentZkKeyNameInUse string
+}
+
+// aggregationItem is a batch proof or an aggregated proof which will be
+// aggregated by the next level.
+type aggregationItem struct {
+	input            circuit.AggregationInput
+	startBatchNumber int64
+	endBatchNumber   int64
+}
+
+func NewAggregator(config *config.Config) *Aggregator {
+	db, err := utils.NewDBWithDriver(config.DbDriver, config.MysqlDataSource)
+	if err != nil {
+		panic(err.Error())
+	}
+	solver.RegisterHint(circuit.IntegerDivision)
+	return &Aggregator{
+		witnessModel:         witness.NewWitnessModel(db, config.DbSuffix),
+		proofModel:           prover.NewProofModel(db, config.DbSuffix),
+		aggregatedProofModel: NewAggregatedProofModel(db, config.DbSuffix),
+		ZkKeyName:            config.ZkKeyName,
+		AssetsCountTiers:     config.AssetsCountTiers,
+		RootZkKeyName:        config.RootZkKeyName,
+		BatchCount:           config.BatchCount,
+		Levels:               config.Levels,
+	}
+}
+
+func (a *Aggregator) Run() {
+	err := a.aggregatedProofModel.CreateAggregatedProofTable()
+	if err != nil {
+		panic(err.Error())
+	}
+	err = a.proofModel.UpgradeProofTable()
+	if err != nil {
+		panic(err.Error())
+	}
+	proofs := a.fetchAllProofs()
+
+	// the witness service generates the batches tier by tier in ascending order
+	// of assets count, so every tier is a contiguous range of batches
+	tierItems := make([][]aggregationItem, len(a.AssetsCountTiers))
+	tierIndex := 0
+	for _, proof := range proofs {
+		for tierIndex < len(a.AssetsCountTiers) && a.AssetsCountTiers[tierIndex] != proof.AssetsCount {
+			tierIndex++
+		}
+		if tierIndex == len(a.AssetsCountTiers) {
+			panic("the batches are not ordered by assets count tiers, or the assets count is not in the config file: " + strconv.Itoa(proof.AssetsCount))
+		}
+		tierItems[tierIndex] = append(tierItems[tierIndex], proofToAggregationItem(proof))
+	}
+
+	tops := make([]aggregationItem, len(a.AssetsCountTiers))
+	for i, items := range tierItems {
+		if len(items) == 0 {
+			panic("there is no batch of assets count tier " + strconv.Itoa(a.AssetsCountTiers[i]) + ", the root proof needs every tier")
+		}
+		for level := 1; level <= a.Levels; level++ {
+			items = a.aggregateLevel(a.AssetsCountTiers[i], level, a.ZkKeyName[i]+"_l"+strconv.Itoa(level), items, a.BatchCount)
+		}
+		if len(items) != 1 {
+			panic(fmt.Sprintf("%d proofs left for assets count tier %d after %d levels, more aggregation levels are needed",
+				len(items), a.AssetsCountTiers[i], a.Levels))
+		}
+		tops[i] = items[0]
+	}
+
+	root := a.aggregateLevel(RootAssetsCount, a.Levels+1, a.RootZkKeyName, tops, len(tops))[0]
+	fmt.Printf("root aggregated proof covers batch %d to %d\n", root.startBatchNumber, root.endBatchNumber)
+	fmt.Printf("account merkle tree root is %x\n", root.input.AfterAccountTreeRoot)
+	fmt.Println("aggregator run finish...")
+}
+
+func (a *Aggregator) fetchAllProofs() []*prover.Proof {
+	var witnessCounts []int64
+	var proofCounts int64
+	var err error
+	for {
+		witnessCounts, err = a.witnessModel.GetRowCounts()
+		if err == utils.DbErrQueryInterrupted || err == utils.DbErrQueryTimeout {
+			fmt.Println("get witness counts timeout, retry...:", err.Error())
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		if err != nil {
+			panic(err.Error())
+		}
+		break
+	}
+	for {
+		proofCounts, err = a.proofModel.GetRowCounts()
+		if err == utils.DbErrQueryInterrupted || err == utils.DbErrQueryTimeout {
+			fmt.Println("get proof counts timeout, retry...:", err.Error())
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		if err != nil {
+			panic(err.Error())
+		}
+		break
+	}
+	if witnessCounts[0] == 0 || proofCounts != witnessCounts[0] {
+		panic(fmt.Sprintf("only %d of %d batches are proved, please wait for the provers", proofCounts, witnessCounts[0]))
+	}
+
+	var proofs []*prover.Proof
+	for {
+		proofs, err = a.proofModel.GetProofsBetween(0, proofCounts-1)
+		if err == utils.DbErrQueryInterrupted || err == utils.DbErrQueryTimeout {
+			fmt.Println("get proofs timeout, retry...:", err.Error())
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		if err != nil {
+			panic(err.Error())
+		}
+		break
+	}
+	for i, proof := range proofs {
+		if proof.BatchNumber != int64(i) {
+			panic("proof of batch " + strconv.Itoa(i) + " not found")
+		}
+		if proof.ProvingSystem != circuit.ProvingSystemGroth16 {
+			panic("only groth16 batch proofs can be aggregated, batch " + strconv.Itoa(i) + " is proved by " + proof.ProvingSystem)
+		}
+	}
+	return proofs
+}
+
+// aggregateLevel aggregates every batchCount items into one proof of the
+// given level. Proofs already in the table are reused, so the aggregator can
+// resume after a crash.
+func (a *Aggregator) aggregateLevel(assetsCount int, level int, zkKeyName string, items []aggregationItem, batchCount int) []aggregationItem {
+	nextItems := make([]aggregationItem, 0, (len(items)+batchCount-1)/batchCount)
+	for position := 0; position*batchCount < len(items); position++ {
+		end := (position + 1) * batchCount
+		if end > len(items) {
+			end = len(items)
+		}
+		chunk := items[position*batchCount : end]
+
+		var row *AggregatedProof
+		var err error
+		for {
+			row, err = a.aggregatedProofModel.GetAggregatedProof(assetsCount, level, position)
+			if err == utils.DbErrQueryInterrupted || err == utils.DbErrQueryTimeout {
+				fmt.Println("get aggregated proof timeout, retry...:", err.Error())
+				time.Sleep(1 * time.Second)
+				continue
+			}
+			break
+		}
+		if err == nil {
+			fmt.Printf("aggregated proof of tier %d level %d position %d exists\n", assetsCount, level, position)
+			nextItems = append(nextItems, aggregatedProofToAggregationItem(row))
+			continue
+		}
+		if err != utils.DbErrNotFound {
+			panic(err.Error())
+		}
+
+		a.LoadSnarkParamsOnce(zkKeyName)
+		inputs := make([]circuit.AggregationInput, len(chunk))
+		for i := range chunk {
+			inputs[i] = chunk[i].input
+		}
+		proof, aggregatedCommitment, err := a.GenerateAndVerifyProof(inputs, batchCount)
+		if err != nil {
+			panic(fmt.Sprintf("generate aggregated proof of tier %d level %d position %d failed: %s", assetsCount, level, position, err.Error()))
+		}
+		item := aggregationItem{
+			input: circuit.AggregationInput{
+				Proof:                     proof,
+				BeforeAccountTreeRoot:     inputs[0].BeforeAccountTreeRoot,
+				AfterAccountTreeRoot:      inputs[len(inputs)-1].AfterAccountTreeRoot,
+				BeforeCEXAssetsCommitment: inputs[0].BeforeCEXAssetsCommitment,
+				AfterCEXAssetsCommitment:  inputs[len(inputs)-1].AfterCEXAssetsCommitment,
+			},
+			startBatchNumber: chunk[0].startBatchNumber,
+			endBatchNumber:   chunk[len(chunk)-1].endBatchNumber,
+		}
+
+		var buf bytes.Buffer
+		_, err = proof.WriteRawTo(&buf)
+		if err != nil {
+			panic("proof serialize failed: " + err.Error())
+		}
+		cexAssetListCommitmentsSerial, _ := json.Marshal([][]byte{item.input.BeforeCEXAssetsCommitment, item.input.AfterCEXAssetsCommitment})
+		accountTreeRootsSerial, _ := json.Marshal([][]byte{item.input.BeforeAccountTreeRoot, item.input.AfterAccountTreeRoot})
+		row = &AggregatedProof{
+			ProofInfo:               base64.StdEncoding.EncodeToString(buf.Bytes()),
+			CexAssetListCommitments: string(cexAssetListCommitmentsSerial),
+			AccountTreeRoots:        string(accountTreeRootsSerial),
+			AggregatedCommitment:    base64.StdEncoding.EncodeToString(aggregatedCommitment),
+			AssetsCount:             assetsCount,
+			Level:                   level,
+			Position:                position,
+			StartBatchNumber:        item.startBatchNumber,
+			EndBatchNumber:          item.endBatchNumber,
+		}
+		err = a.aggregatedProofModel.CreateAggregatedProof(row)
+		if err != nil {
+			panic(fmt.Sprintf("create aggregated proof of tier %d level %d position %d failed: %s", assetsCount, level, position, err.Error()))
+		}
+		nextItems = append(nextItems, item)
+	}
+	return nextItems
+}
+
+func (a *Aggregator) GenerateAndVerifyProof(inputs []circuit.AggregationInput, batchCount int) (proof groth16.Proof, aggregatedCommitment []byte, err error) {
+	startTime := time.Now().UnixMilli()
+	circuitWitness, aggregatedCommitment, err := circuit.SetBatchProofAggregationCircuitWitness(inputs, batchCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	witness, err := frontend.NewWitness(circuitWitness, ecc.BN254.ScalarField())
+	if err != nil {
+		return nil, nil, err
+	}
+	vWitness, err := frontend.NewWitness(circuit.NewVerifyBatchProofAggregationCircuit(aggregatedCommitment), ecc.BN254.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		return nil, nil, err
+	}
+	// aggregated proofs are aggregated again by the next level
+	proof, err = groth16.Prove(a.R1cs, a.ProvingKey, witness, circuit.RecursiveProverOptions())
+	if err != nil {
+		return nil, nil, err
+	}
+	endTime := time.Now().UnixMilli()
+	fmt.Println("aggregated proof generation cost ", endTime-startTime, " ms")
+	err = groth16.Verify(proof, a.VerifyingKey, vWitness, circuit.RecursiveVerifierOptions())
+	if err != nil {
+		return nil, nil, err
+	}
+	return proof, aggregatedCommitment, nil
+}
+
+func (a *Aggregator) LoadSnarkParamsOnce(zkKeyName string) {
+	if zkKeyName == a.CurrentZkKeyNameInUse {
+		return
+	}
+	s := time.Now()
+	fmt.Println("begin loading aggregation keys ", zkKeyName)
+	a.R1cs, a.ProvingKey, a.VerifyingKey = nil, nil, nil
+	runtime.GC()
+	err := utils.CheckKeyParams(zkKeyName+".pk", utils.KeyCircuitAggregation)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	a.R1cs = groth16.NewCS(ecc.BN254)
+	_, err = utils.LoadKeyFile(zkKeyName, ".r1cs", nil, a.R1cs.ReadFrom)
+	if err != nil {
+		panic("r1cs read error..." + err.Error())
+	}
+	a.ProvingKey = groth16.NewProvingKey(ecc.BN254)
+	_, err = utils.LoadKeyFile(zkKeyName, ".pk", nil, a.ProvingKey.UnsafeReadFrom)
+	if err != nil {
+		panic("provingKey loading error:" + err.Error())
+	}
+	a.VerifyingKey = groth16.NewVerifyingKey(ecc.BN254)
+	_, err = utils.LoadKeyFile(zkKeyName, ".vk", nil, a.VerifyingKey.ReadFrom)
+	if err != nil {
+		panic("verifyingKey loading error:" + err.Error())
+	}
+	runtime.GC()
+	fmt.Println("finish loading aggregation keys.... the time cost is ", time.Since(s))
+	a.CurrentZkKeyNameInUse = zkKeyName
+}
+
+func proofToAggregationItem(row *prover.Proof) aggregationItem {
+	return aggregationItem{
+		input:            decodeAggregationInput(row.ProofInfo, row.AccountTreeRoots, row.CexAssetListCommitments),
+		startBatchNumber: row.BatchNumber,
+		endBatchNumber:   row.BatchNumber,
+	}
+}
+
+func aggregatedProofToAggregationItem(row *AggregatedProof) aggregationItem {
+	return aggregationItem{
+		input:            decodeAggregationInput(row.ProofInfo, row.AccountTreeRoots, row.CexAssetListCommitments),
+		startBatchNumber: row.StartBatchNumber,
+		endBatchNumber:   row.EndBatchNumber,
+	}
+}
+
+func decodeAggregationInput(proofInfo string, accountTreeRootsSerial string, cexAssetListCommitmentsSerial string) circuit.AggregationInput {
+	proofBytes, err := base64.StdEncoding.DecodeString(proofInfo)
+	if err != nil {
+		panic("decode proof failed: " + err.Error())
+	}
+	proof := groth16.NewProof(ecc.BN254)
+	_, err = proof.ReadFrom(bytes.NewBuffer(proofBytes))
+	if err != nil {
+		panic("deserialize proof failed: " + err.Error())
+	}
+	var accountTreeRoots, cexAssetListCommitments [][]byte
+	err = json.Unmarshal([]byte(accountTreeRootsSerial), &accountTreeRoots)
+	if err != nil || len(accountTreeRoots) != 2 {
+		panic("decode account tree roots failed")
+	}
+	err = json.Unmarshal([]byte(cexAssetListCommitmentsSerial), &cexAssetListCommitments)
+	if err != nil || len(cexAssetListCommitments) != 2 {
+		panic("decode cex asset list commitments failed")
+	}
+	return circuit.AggregationInput{
+		Proof:                     proof,
+		BeforeAccountTreeRoot:     accountTreeRoots[0],
+		AfterAccountTreeRoot:      accountTreeRoots[1],
+		BeforeCEXAssetsCommitment: cexAssetListCommitments[0],
+		AfterCEXAssetsCommitment:  cexAssetListCommitments[1],
+	}
+}
+
+// CheckConfig checks that the tiers, key names and aggregation shape of the
+// config are consistent.
+func CheckConfig(aggregatorConfig *config.Config) {
+	if len(aggregatorConfig.AssetsCountTiers) != len(aggregatorConfig.ZkKeyName) {
+		panic("asset tiers and asset tier names should have the same length")
+	}
+	if aggregatorConfig.BatchCount <= 0 || aggregatorConfig.Levels <= 0 {
+		panic("batch count and levels should be positive")
+	}
+	err := utils.CheckAssetsCountTiers(aggregatorConfig.AssetsCountTiers)
+	if err != nil {
+		panic(err.Error())
+	}
+}
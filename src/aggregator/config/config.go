@@ -0,0 +1,17 @@
+package config
+
+type Config struct {
+	// DbDriver is mysql, postgres or sqlite3, it defaults to mysql. MysqlDataSource
+	// is the data source of the driver
+	DbDriver        string
+	MysqlDataSource string
+	DbSuffix        string
+	// ZkKeyName[i] is the key name prefix of the aggregation keys of
+	// AssetsCountTiers[i], the key of level l is ZkKeyName[i] + "_l" + l
+	ZkKeyName        []string
+	AssetsCountTiers []int
+	RootZkKeyName    string
+	// BatchCount is the number of proofs aggregated by one aggregation proof
+	BatchCount int
+	Levels     int
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/zkpor/zkpor"
+)
+
+// aggregator runs zkpor aggregate, the config is config/config.json by default
+func main() {
+	p := &zkpor.Program{Name: "aggregator", Groups: []string{"aggregate"}, DefaultConfig: "config/config.json"}
+	p.Main(os.Args[1:])
+}
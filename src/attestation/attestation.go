@@ -0,0 +1,177 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+)
+
+const (
+	// Version is the version of the statement layout
+	Version          = 1
+	AlgorithmEd25519 = "ed25519"
+)
+
+// Statement is what the exchange commits to for a snapshot, the hashes and
+// roots are hex encoded.
+type Statement struct {
+	Version             int
+	SnapshotTime        time.Time
+	BatchCount          int
+	AccountTreeRoot     string
+	CexAssetsCommitment string
+	PrevAccountTreeRoot string
+	AssetRegistryHash   string
+	VerifyingKeys       []VerifyingKey
+	CexAssetsInfo       []utils.CexAssetInfo
+}
+
+// VerifyingKey is the SHA-256 of the verifying key of a tier.
+type VerifyingKey struct {
+	AssetsCount   int
+	ProvingSystem string
+	Sha256        string
+}
+
+// Attestation is the signed statement, the signature is over the compact
+// json of Statement as it is, so that it doesn't depend on the json encoder
+// of the verifier.
+type Attestation struct {
+	Statement json.RawMessage
+	Algorithm string
+	PublicKey string
+	Signature string
+}
+
+// GenerateKey writes a new ed25519 key pair, the private key file holds the
+// hex encoded seed and the public key file the hex encoded public key.
+func GenerateKey(privateKeyFile string, publicKeyFile string) error {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(privateKeyFile, []byte(hex.EncodeToString(privateKey.Seed())+"\n"), 0600)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(publicKeyFile, []byte(hex.EncodeToString(publicKey)+"\n"), 0644)
+}
+
+func readHexFile(name string, size int) ([]byte, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %s", name, err.Error())
+	}
+	if len(key) != size {
+		return nil, fmt.Errorf("invalid key file %s: the key should be %d bytes", name, size)
+	}
+	return key, nil
+}
+
+func LoadPrivateKey(name string) (ed25519.PrivateKey, error) {
+	seed, err := readHexFile(name, ed25519.SeedSize)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func LoadPublicKey(name string) (ed25519.PublicKey, error) {
+	key, err := readHexFile(name, ed25519.PublicKeySize)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+func Sign(statement *Statement, privateKey ed25519.PrivateKey) (*Attestation, error) {
+	content, err := json.Marshal(statement)
+	if err != nil {
+		return nil, err
+	}
+	return &Attestation{
+		Statement: content,
+		Algorithm: AlgorithmEd25519,
+		PublicKey: hex.EncodeToString(privateKey.Public().(ed25519.PublicKey)),
+		Signature: hex.EncodeToString(ed25519.Sign(privateKey, content)),
+	}, nil
+}
+
+// Verify checks that the attestation is signed by the public key, which must
+// be obtained from the exchange rather than from the attestation itself.
+func (a *Attestation) Verify(publicKey ed25519.PublicKey) (*Statement, error) {
+	if a.Algorithm != AlgorithmEd25519 {
+		return nil, fmt.Errorf("unsupported signature algorithm %s", a.Algorithm)
+	}
+	if a.PublicKey != hex.EncodeToString(publicKey) {
+		return nil, errors.New("the attestation is signed by another key")
+	}
+	signature, err := hex.DecodeString(a.Signature)
+	if err != nil {
+		return nil, err
+	}
+	var content bytes.Buffer
+	err = json.Compact(&content, a.Statement)
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(publicKey, content.Bytes(), signature) {
+		return nil, errors.New("invalid signature")
+	}
+	statement := &Statement{}
+	err = json.Unmarshal(content.Bytes(), statement)
+	if err != nil {
+		return nil, err
+	}
+	if statement.Version != Version {
+		return nil, fmt.Errorf("unsupported statement version %d, expected %d", statement.Version, Version)
+	}
+	return statement, nil
+}
+
+// Matches reports whether the statement commits to the same snapshot as
+// other, SnapshotTime is compared as an instant.
+func (s *Statement) Matches(other *Statement) bool {
+	if !s.SnapshotTime.Equal(other.SnapshotTime) {
+		return false
+	}
+	a, b := *s, *other
+	a.SnapshotTime, b.SnapshotTime = time.Time{}, time.Time{}
+	contentA, errA := json.Marshal(a)
+	contentB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(contentA, contentB)
+}
+
+func Write(name string, a *Attestation) error {
+	content, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, content, 0644)
+}
+
+func Read(name string) (*Attestation, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	a := &Attestation{}
+	err = json.Unmarshal(content, a)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
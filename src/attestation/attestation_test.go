@@ -0,0 +1,83 @@
+package attestation
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	dir := t.TempDir()
+	privateKeyFile, publicKeyFile := filepath.Join(dir, "attestation.key"), filepath.Join(dir, "attestation.pub")
+	if err := GenerateKey(privateKeyFile, publicKeyFile); err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := LoadPrivateKey(privateKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := LoadPublicKey(publicKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statement := &Statement{
+		Version:         Version,
+		SnapshotTime:    time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC),
+		BatchCount:      2,
+		AccountTreeRoot: "0102",
+		VerifyingKeys:   []VerifyingKey{{AssetsCount: 50, ProvingSystem: "groth16", Sha256: "aa"}},
+		CexAssetsInfo:   []utils.CexAssetInfo{{Symbol: "btc", TotalEquity: 10, TotalDebt: 1}},
+	}
+	a, err := Sign(statement, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "attestation.json")
+	if err = Write(name, a); err != nil {
+		t.Fatal(err)
+	}
+	a, err = Read(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := a.Verify(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !signed.Matches(statement) {
+		t.Fatalf("unexpected statement %v", signed)
+	}
+	other := *statement
+	other.AccountTreeRoot = "0103"
+	if signed.Matches(&other) {
+		t.Fatal("the statements of different roots should not match")
+	}
+
+	// a changed root breaks the signature
+	content, _ := os.ReadFile(name)
+	if err = os.WriteFile(name, bytes.Replace(content, []byte("0102"), []byte("0103"), 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a, err = Read(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.Verify(publicKey); err == nil {
+		t.Fatal("the tampered attestation should not verify")
+	}
+
+	// the attestation must be signed by the pinned key
+	otherPublicKeyFile := filepath.Join(dir, "other.pub")
+	if err = GenerateKey(filepath.Join(dir, "other.key"), otherPublicKeyFile); err != nil {
+		t.Fatal(err)
+	}
+	otherPublicKey, _ := LoadPublicKey(otherPublicKeyFile)
+	a, _ = Sign(statement, privateKey)
+	if _, err = a.Verify(otherPublicKey); err == nil {
+		t.Fatal("the attestation should not verify with another key")
+	}
+}
@@ -0,0 +1,395 @@
+package bundle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/circuit"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/prover/prover"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	verifierConfig "github.com/binance/zkmerkle-proof-of-solvency/src/verifier/config"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/witness"
+)
+
+const (
+	// Version is the version of the bundle layout, it is increased when the
+	// layout is changed incompatibly
+	Version = 1
+
+	ManifestFile          = "manifest.json"
+	ProofTableFile        = "proof.csv"
+	CexAssetsInfoFile     = "cex_assets_info.json"
+	PrevCexAssetsInfoFile = "prev_cex_assets_info.json"
+	AssetRegistryFile     = "asset_registry.json"
+	CircuitParamsFile     = "circuit_params.json"
+	KeysDir               = "keys"
+
+	// ArchiveSuffix is the suffix of the bundle written as a gzipped tar
+	ArchiveSuffix = ".tar.gz"
+)
+
+// Manifest describes the files of the bundle, the paths are relative to the
+// bundle directory.
+type Manifest struct {
+	Version         int
+	CreatedAt       time.Time
+	BatchCount      int
+	AccountTreeRoot string
+	// VerifyingKeys are the verifying key files of AssetsCountTiers
+	AssetsCountTiers  []int
+	ProvingSystems    []string
+	VerifyingKeys     []string
+	RecursiveProof    bool
+	SolidityProof     bool
+	AssetRegistryHash string
+	// PrevAccountTreeRoot is set when the bundle is of an incremental snapshot
+	PrevAccountTreeRoot string
+	// Files is the hex encoded SHA-256 of every file except the manifest
+	Files map[string]string
+}
+
+// Content is the content of the bundle of a snapshot.
+type Content struct {
+	Proofs              []*prover.Proof
+	AccountTreeRoot     []byte
+	CexAssetsInfo       []utils.CexAssetInfo
+	AssetRegistryHash   string
+	PrevAccountTreeRoot []byte
+	PrevCexAssetsInfo   []utils.CexAssetInfo
+	// ZkKeyName, AssetsCountTiers and ProvingSystems are the batch keys of
+	// the snapshot, only the verifying keys are copied into the bundle
+	ZkKeyName        []string
+	AssetsCountTiers []int
+	ProvingSystems   []string
+	RecursiveProof   bool
+	SolidityProof    bool
+	// AssetRegistry is the asset registry file, it is optional
+	AssetRegistry string
+}
+
+// Collect reads the proofs and the final state of the snapshot from the db,
+// all batches of the snapshot must be proved.
+func Collect(db *utils.DB, dbSuffix string, prevDbSuffix string) (*Content, error) {
+	witnessModel := witness.NewWitnessModel(db, dbSuffix)
+	proofModel := prover.NewProofModel(db, dbSuffix)
+	err := proofModel.UpgradeProofTable()
+	if err != nil {
+		return nil, err
+	}
+	latestWitness, err := witnessModel.GetLatestBatchWitness()
+	if err != nil {
+		return nil, err
+	}
+	c := &Content{}
+	c.CexAssetsInfo, c.AccountTreeRoot = witness.RecoverAfterState(latestWitness)
+	c.AssetRegistryHash = witness.RecoverAssetRegistryHash(latestWitness)
+	limit := int64(1024)
+	for start := int64(0); start <= latestWitness.Height; start += limit {
+		proofs, err := proofModel.GetProofsBetween(start, start+limit-1)
+		if err == utils.DbErrQueryInterrupted || err == utils.DbErrQueryTimeout {
+			fmt.Println("get proofs timeout, retry...:", err.Error())
+			time.Sleep(1 * time.Second)
+			start -= limit
+			continue
+		}
+		if err == utils.DbErrNotFound {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.Proofs = append(c.Proofs, proofs...)
+	}
+	if int64(len(c.Proofs)) != latestWitness.Height+1 {
+		return nil, fmt.Errorf("%d batches are not proved", latestWitness.Height+1-int64(len(c.Proofs)))
+	}
+	if prevDbSuffix != "" {
+		// the batches of the incremental snapshot start from the final
+		// state of the previous snapshot
+		prevWitness, err := witness.NewWitnessModel(db, prevDbSuffix).GetLatestBatchWitness()
+		if err != nil {
+			return nil, err
+		}
+		c.PrevCexAssetsInfo, c.PrevAccountTreeRoot = witness.RecoverAfterState(prevWitness)
+	}
+	return c, nil
+}
+
+// Export writes the bundle to the directory, or to a gzipped tar when the
+// name ends with ArchiveSuffix.
+func Export(name string, c *Content) (*Manifest, error) {
+	if !strings.HasSuffix(name, ArchiveSuffix) {
+		return Write(name, c)
+	}
+	dir, err := os.MkdirTemp("", "zkpor_bundle")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	manifest, err := Write(dir, c)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, Archive(dir, name)
+}
+
+// Write writes the bundle to the directory.
+func Write(dir string, c *Content) (*Manifest, error) {
+	if len(c.ZkKeyName) != len(c.AssetsCountTiers) {
+		return nil, errors.New("asset tiers and key names should have the same length")
+	}
+	if len(c.ProvingSystems) != 0 && len(c.ProvingSystems) != len(c.AssetsCountTiers) {
+		return nil, errors.New("asset tiers and proving systems should have the same length")
+	}
+	err := os.MkdirAll(filepath.Join(dir, KeysDir), 0755)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{
+		Version:           Version,
+		CreatedAt:         time.Now().UTC(),
+		BatchCount:        len(c.Proofs),
+		AccountTreeRoot:   hex.EncodeToString(c.AccountTreeRoot),
+		AssetsCountTiers:  c.AssetsCountTiers,
+		ProvingSystems:    make([]string, len(c.AssetsCountTiers)),
+		VerifyingKeys:     make([]string, len(c.AssetsCountTiers)),
+		RecursiveProof:    c.RecursiveProof,
+		SolidityProof:     c.SolidityProof,
+		AssetRegistryHash: c.AssetRegistryHash,
+	}
+	files := []string{ProofTableFile, CexAssetsInfoFile, CircuitParamsFile}
+	err = prover.WriteProofTable(filepath.Join(dir, ProofTableFile), c.Proofs)
+	if err != nil {
+		return nil, err
+	}
+	// the proofs are verified with the circuit params they are generated with
+	err = writeJson(filepath.Join(dir, CircuitParamsFile), utils.CurrentCircuitParams())
+	if err != nil {
+		return nil, err
+	}
+	err = writeJson(filepath.Join(dir, CexAssetsInfoFile), c.CexAssetsInfo)
+	if err != nil {
+		return nil, err
+	}
+	if c.PrevAccountTreeRoot != nil {
+		manifest.PrevAccountTreeRoot = hex.EncodeToString(c.PrevAccountTreeRoot)
+		files = append(files, PrevCexAssetsInfoFile)
+		err = writeJson(filepath.Join(dir, PrevCexAssetsInfoFile), c.PrevCexAssetsInfo)
+		if err != nil {
+			return nil, err
+		}
+	}
+	copied := make(map[string]string)
+	keyManifest, err := bundleKeyManifest(c.ZkKeyName)
+	if err != nil {
+		return nil, err
+	}
+	if keyManifest != nil {
+		files = append(files, filepath.ToSlash(filepath.Join(KeysDir, utils.KeyManifestFile)))
+		err = keyManifest.Write(filepath.Join(dir, KeysDir))
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, keyName := range c.ZkKeyName {
+		provingSystem := ""
+		if len(c.ProvingSystems) != 0 {
+			provingSystem = c.ProvingSystems[i]
+		}
+		manifest.ProvingSystems[i], err = circuit.NormalizeProvingSystem(provingSystem)
+		if err != nil {
+			return nil, err
+		}
+		// the tiers may share the same key
+		vkFile := filepath.ToSlash(filepath.Join(KeysDir, filepath.Base(keyName)+".vk"))
+		if src, ok := copied[vkFile]; ok && src != keyName {
+			return nil, fmt.Errorf("the keys %s and %s have the same file name", src, keyName)
+		}
+		manifest.VerifyingKeys[i] = vkFile
+		if _, ok := copied[vkFile]; ok {
+			continue
+		}
+		copied[vkFile] = keyName
+		files = append(files, vkFile)
+		err = copyFile(keyName+".vk", filepath.Join(dir, vkFile))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.AssetRegistry != "" {
+		files = append(files, AssetRegistryFile)
+		err = copyFile(c.AssetRegistry, filepath.Join(dir, AssetRegistryFile))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	manifest.Files = make(map[string]string, len(files))
+	for _, file := range files {
+		manifest.Files[file], err = hashFile(filepath.Join(dir, file))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return manifest, writeJson(filepath.Join(dir, ManifestFile), manifest)
+}
+
+// bundleKeyManifest returns the key manifest entries of the keys, it returns
+// nil when a key is not in a key manifest, so that the verifier doesn't
+// refuse the key without entry.
+func bundleKeyManifest(zkKeyNames []string) (*utils.KeyManifest, error) {
+	keyManifest := &utils.KeyManifest{}
+	for _, keyName := range zkKeyNames {
+		m, err := utils.ReadKeyManifest(filepath.Dir(keyName))
+		if err != nil {
+			return nil, err
+		}
+		if m == nil || m.Entry(filepath.Base(keyName)) == nil {
+			return nil, nil
+		}
+		keyManifest.Put(m.Entry(filepath.Base(keyName)))
+	}
+	return keyManifest, nil
+}
+
+// Load checks the files of the bundle directory against the manifest and
+// returns the verifier config of the bundle.
+func Load(dir string) (*Manifest, *verifierConfig.Config, error) {
+	content, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, nil, err
+	}
+	manifest := &Manifest{}
+	err = json.Unmarshal(content, manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+	if manifest.Version != Version {
+		return nil, nil, fmt.Errorf("unsupported bundle version %d, expected %d", manifest.Version, Version)
+	}
+	for file, expectHash := range manifest.Files {
+		if !filepath.IsLocal(filepath.FromSlash(file)) {
+			return nil, nil, fmt.Errorf("invalid file %s in the manifest", file)
+		}
+		hash, err := hashFile(filepath.Join(dir, file))
+		if err != nil {
+			return nil, nil, err
+		}
+		if hash != expectHash {
+			return nil, nil, fmt.Errorf("sha256 of %s is %s, but the manifest records %s", file, hash, expectHash)
+		}
+	}
+	// the files used by the verifier must be covered by the manifest
+	path := func(file string) (string, error) {
+		if _, ok := manifest.Files[file]; !ok {
+			return "", fmt.Errorf("%s is not in the manifest", file)
+		}
+		return filepath.Join(dir, filepath.FromSlash(file)), nil
+	}
+	if len(manifest.VerifyingKeys) != len(manifest.AssetsCountTiers) || len(manifest.ProvingSystems) != len(manifest.AssetsCountTiers) {
+		return nil, nil, errors.New("asset tiers, proving systems and verifying keys should have the same length")
+	}
+	c := &verifierConfig.Config{
+		AssetsCountTiers:    manifest.AssetsCountTiers,
+		ProvingSystems:      manifest.ProvingSystems,
+		ZkKeyName:           make([]string, len(manifest.VerifyingKeys)),
+		RecursiveProof:      manifest.RecursiveProof,
+		SolidityProof:       manifest.SolidityProof,
+		AccountTreeRoot:     manifest.AccountTreeRoot,
+		PrevAccountTreeRoot: manifest.PrevAccountTreeRoot,
+		AssetRegistryHash:   manifest.AssetRegistryHash,
+	}
+	for i, vkFile := range manifest.VerifyingKeys {
+		if !strings.HasSuffix(vkFile, ".vk") {
+			return nil, nil, fmt.Errorf("invalid verifying key %s", vkFile)
+		}
+		keyFile, err := path(vkFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		// the verifier appends .vk to the key name
+		c.ZkKeyName[i] = strings.TrimSuffix(keyFile, ".vk")
+	}
+	if c.ProofTable, err = path(ProofTableFile); err != nil {
+		return nil, nil, err
+	}
+	cexAssetsInfoFile, err := path(CexAssetsInfoFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = readJson(cexAssetsInfoFile, &c.CexAssetsInfo); err != nil {
+		return nil, nil, err
+	}
+	if manifest.PrevAccountTreeRoot != "" {
+		prevCexAssetsInfoFile, err := path(PrevCexAssetsInfoFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err = readJson(prevCexAssetsInfoFile, &c.PrevCexAssetsInfo); err != nil {
+			return nil, nil, err
+		}
+	}
+	if _, ok := manifest.Files[AssetRegistryFile]; ok {
+		c.AssetRegistry = filepath.Join(dir, AssetRegistryFile)
+	}
+	// the bundles exported before the circuit params were configurable use
+	// the built-in params
+	if _, ok := manifest.Files[CircuitParamsFile]; ok {
+		c.CircuitParams = filepath.Join(dir, CircuitParamsFile)
+	}
+	return manifest, c, nil
+}
+
+func hashFile(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err = io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func writeJson(name string, v interface{}) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, content, 0644)
+}
+
+func readJson(name string, v interface{}) error {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, v)
+}
@@ -0,0 +1,71 @@
+package bundle
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/prover/prover"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+)
+
+func TestWriteAndLoad(t *testing.T) {
+	keyDir := t.TempDir()
+	for _, name := range []string{"zkpor50_700.vk", "zkpor500_92.vk"} {
+		if err := os.WriteFile(filepath.Join(keyDir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the key manifest entries of the keys are copied into the bundle
+	if err := utils.AddKeyManifestEntry(filepath.Join(keyDir, "zkpor50_700"), utils.NewBatchKeyParams(50, false), "groth16", 1, []string{".vk"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.AddKeyManifestEntry(filepath.Join(keyDir, "zkpor500_92"), utils.NewBatchKeyParams(500, false), "plonk", 1, []string{".vk"}); err != nil {
+		t.Fatal(err)
+	}
+	content := &Content{
+		Proofs:              []*prover.Proof{{BatchNumber: 0, ProofInfo: "proof", AssetsCount: 50}},
+		AccountTreeRoot:     []byte{1, 2},
+		CexAssetsInfo:       []utils.CexAssetInfo{{Symbol: "btc", Index: 0, TotalEquity: 10}},
+		PrevAccountTreeRoot: []byte{3, 4},
+		PrevCexAssetsInfo:   []utils.CexAssetInfo{{Symbol: "btc", Index: 0, TotalEquity: 9}},
+		ZkKeyName:           []string{filepath.Join(keyDir, "zkpor50_700"), filepath.Join(keyDir, "zkpor500_92")},
+		AssetsCountTiers:    []int{50, 500},
+		ProvingSystems:      []string{"", "plonk"},
+	}
+	archive := filepath.Join(t.TempDir(), "bundle"+ArchiveSuffix)
+	if _, err := Export(archive, content); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := Extract(archive, dir); err != nil {
+		t.Fatal(err)
+	}
+	manifest, c, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.BatchCount != 1 || manifest.AccountTreeRoot != "0102" || len(manifest.Files) != 7 {
+		t.Fatalf("unexpected manifest %v", manifest)
+	}
+	if c.ZkKeyName[1] != filepath.Join(dir, KeysDir, "zkpor500_92") || c.ProvingSystems[0] != "groth16" ||
+		c.ProofTable != filepath.Join(dir, ProofTableFile) || c.PrevAccountTreeRoot != "0304" ||
+		c.PrevCexAssetsInfo[0].TotalEquity != 9 || c.CexAssetsInfo[0].TotalEquity != 10 || c.AssetRegistry != "" ||
+		c.CircuitParams != filepath.Join(dir, CircuitParamsFile) {
+		t.Fatalf("unexpected verifier config %v", c)
+	}
+
+	keyManifest, err := utils.ReadKeyManifest(filepath.Join(dir, KeysDir))
+	if err != nil || keyManifest == nil || len(keyManifest.Keys) != 2 || keyManifest.Entry("zkpor500_92").ProvingSystem != "plonk" {
+		t.Fatalf("unexpected key manifest %v %v", keyManifest, err)
+	}
+
+	// a tampered verifying key is rejected
+	if err = os.WriteFile(filepath.Join(dir, KeysDir, "zkpor50_700.vk"), []byte("forged"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = Load(dir); err == nil || !strings.Contains(err.Error(), "zkpor50_700.vk") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	aggregatorConfig "github.com/binance/zkmerkle-proof-of-solvency/src/aggregator/config"
+	dbtoolConfig "github.com/binance/zkmerkle-proof-of-solvency/src/dbtool/config"
+	localConfig "github.com/binance/zkmerkle-proof-of-solvency/src/local/config"
+	proofApiConfig "github.com/binance/zkmerkle-proof-of-solvency/src/proofapi/config"
+	proverConfig "github.com/binance/zkmerkle-proof-of-solvency/src/prover/config"
+	userProofConfig "github.com/binance/zkmerkle-proof-of-solvency/src/userproof/config"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	verifierConfig "github.com/binance/zkmerkle-proof-of-solvency/src/verifier/config"
+	witnessConfig "github.com/binance/zkmerkle-proof-of-solvency/src/witness/config"
+)
+
+// Config is the config shared by the subcommands of zkpor, every service
+// takes the fields it needs from it. The fields have the same meaning as the
+// config of the service, the relative paths are resolved against the
+// directory of the config file by Load.
+type Config struct {
+	// DbDriver is mysql, postgres or sqlite3, it defaults to mysql. MysqlDataSource
+	// is the data source of the driver
+	DbDriver        string
+	MysqlDataSource string
+	DbSuffix        string
+	PrevDbSuffix    string
+	UserDataFile    string
+	SpillDir        string
+	TreeDB          struct {
+		Driver string
+		Option struct {
+			Addr string
+		}
+	}
+	Redis struct {
+		Host     string
+		Password string
+	}
+	MetricsAddr string
+	// CircuitParams is the circuit params file shared by all services, the
+	// built-in params are used when it is empty
+	CircuitParams string
+
+	// ZkKeyName, AssetsCountTiers and ProvingSystems are the batch keys used
+	// by the prover and the verifier
+	ZkKeyName        []string
+	AssetsCountTiers []int
+	ProvingSystems   []string
+	RecursiveProof   bool
+	SolidityProof    bool
+	TaskLeaseSeconds int
+	KeyCacheSize     int
+
+	UserInclusionZkKeyName     string
+	UserInclusionProvingSystem string
+	StaticProofDir             string
+	StaticProofWriters         int
+
+	// Aggregation is the config of the aggregator service, ZkKeyName is
+	// parallel to AssetsCountTiers
+	Aggregation struct {
+		ZkKeyName     []string
+		RootZkKeyName string
+		BatchCount    int
+		Levels        int
+	}
+
+	// ProofApi is the config of the user proof http api
+	ProofApi struct {
+		ListenAddr        string
+		RateLimit         float64
+		RateBurst         int
+		TrustForwardedFor bool
+		AuthTokens        []string
+	}
+
+	// ProofTable and the following fields are only used by the verifier,
+	// AggregationZkKeyName defaults to Aggregation.RootZkKeyName
+	ProofTable           string
+	CexAssetsInfo        []utils.CexAssetInfo
+	AggregatedProofTable string
+	AggregationZkKeyName string
+	AccountTreeRoot      string
+	PrevAccountTreeRoot  string
+	PrevCexAssetsInfo    []utils.CexAssetInfo
+	AssetRegistry        string
+	AssetRegistryHash    string
+
+	// DataDir is only used by the local pipeline, OutputDir is used by the
+	// local pipeline and the orchestrator
+	DataDir   string
+	OutputDir string
+}
+
+// Load reads the config file and resolves the relative paths of the config
+// against the directory of the file.
+func Load(name string) (*Config, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	err = json.Unmarshal(content, c)
+	if err != nil {
+		return nil, err
+	}
+	c.resolvePaths(filepath.Dir(name))
+	return c, nil
+}
+
+func (c *Config) resolvePaths(dir string) {
+	resolve := func(path *string) {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+	for _, path := range []*string{&c.UserDataFile, &c.SpillDir, &c.UserInclusionZkKeyName,
+		&c.Aggregation.RootZkKeyName, &c.ProofTable, &c.AggregatedProofTable, &c.AggregationZkKeyName,
+		&c.AssetRegistry, &c.DataDir, &c.OutputDir, &c.StaticProofDir, &c.CircuitParams} {
+		resolve(path)
+	}
+	for i := range c.ZkKeyName {
+		resolve(&c.ZkKeyName[i])
+	}
+	for i := range c.Aggregation.ZkKeyName {
+		resolve(&c.Aggregation.ZkKeyName[i])
+	}
+	// the address of the other tree drivers is a host
+	if c.TreeDB.Driver == "leveldb" {
+		resolve(&c.TreeDB.Option.Addr)
+	}
+}
+
+// SetMysqlSource replaces the password of MysqlDataSource with the one
+// fetched from aws secretsmanager.
+func (c *Config) SetMysqlSource(remotePasswdConfig string) error {
+	s, err := utils.GetMysqlSource(c.MysqlDataSource, remotePasswdConfig)
+	if err != nil {
+		return err
+	}
+	c.MysqlDataSource = s
+	return nil
+}
+
+func (c *Config) WitnessConfig() *witnessConfig.Config {
+	return &witnessConfig.Config{
+		DbDriver:        c.DbDriver,
+		MysqlDataSource: c.MysqlDataSource,
+		UserDataFile:    c.UserDataFile,
+		DbSuffix:        c.DbSuffix,
+		PrevDbSuffix:    c.PrevDbSuffix,
+		SpillDir:        c.SpillDir,
+		TreeDB:          c.TreeDB,
+		MetricsAddr:     c.MetricsAddr,
+	}
+}
+
+func (c *Config) ProverConfig() *proverConfig.Config {
+	return &proverConfig.Config{
+		DbDriver:         c.DbDriver,
+		MysqlDataSource:  c.MysqlDataSource,
+		DbSuffix:         c.DbSuffix,
+		Redis:            c.Redis,
+		ZkKeyName:        c.ZkKeyName,
+		AssetsCountTiers: c.AssetsCountTiers,
+		ProvingSystems:   c.ProvingSystems,
+		RecursiveProof:   c.RecursiveProof,
+		SolidityProof:    c.SolidityProof,
+		TaskLeaseSeconds: c.TaskLeaseSeconds,
+		KeyCacheSize:     c.KeyCacheSize,
+		MetricsAddr:      c.MetricsAddr,
+	}
+}
+
+func (c *Config) AggregatorConfig() *aggregatorConfig.Config {
+	return &aggregatorConfig.Config{
+		DbDriver:         c.DbDriver,
+		MysqlDataSource:  c.MysqlDataSource,
+		DbSuffix:         c.DbSuffix,
+		ZkKeyName:        c.Aggregation.ZkKeyName,
+		AssetsCountTiers: c.AssetsCountTiers,
+		RootZkKeyName:    c.Aggregation.RootZkKeyName,
+		BatchCount:       c.Aggregation.BatchCount,
+		Levels:           c.Aggregation.Levels,
+	}
+}
+
+func (c *Config) UserProofConfig() *userProofConfig.Config {
+	return &userProofConfig.Config{
+		DbDriver:                   c.DbDriver,
+		MysqlDataSource:            c.MysqlDataSource,
+		UserDataFile:               c.UserDataFile,
+		DbSuffix:                   c.DbSuffix,
+		PrevDbSuffix:               c.PrevDbSuffix,
+		SpillDir:                   c.SpillDir,
+		TreeDB:                     c.TreeDB,
+		UserInclusionZkKeyName:     c.UserInclusionZkKeyName,
+		UserInclusionProvingSystem: c.UserInclusionProvingSystem,
+		StaticProofDir:             c.StaticProofDir,
+		StaticProofWriters:         c.StaticProofWriters,
+		MetricsAddr:                c.MetricsAddr,
+	}
+}
+
+func (c *Config) ProofApiConfig() *proofApiConfig.Config {
+	return &proofApiConfig.Config{
+		DbDriver:          c.DbDriver,
+		MysqlDataSource:   c.MysqlDataSource,
+		DbSuffix:          c.DbSuffix,
+		ListenAddr:        c.ProofApi.ListenAddr,
+		RateLimit:         c.ProofApi.RateLimit,
+		RateBurst:         c.ProofApi.RateBurst,
+		TrustForwardedFor: c.ProofApi.TrustForwardedFor,
+		AuthTokens:        c.ProofApi.AuthTokens,
+		MetricsAddr:       c.MetricsAddr,
+	}
+}
+
+func (c *Config) VerifierConfig() *verifierConfig.Config {
+	aggregationZkKeyName := c.AggregationZkKeyName
+	if aggregationZkKeyName == "" {
+		aggregationZkKeyName = c.Aggregation.RootZkKeyName
+	}
+	return &verifierConfig.Config{
+		ProofTable:           c.ProofTable,
+		ZkKeyName:            c.ZkKeyName,
+		AssetsCountTiers:     c.AssetsCountTiers,
+		ProvingSystems:       c.ProvingSystems,
+		RecursiveProof:       c.RecursiveProof,
+		SolidityProof:        c.SolidityProof,
+		CexAssetsInfo:        c.CexAssetsInfo,
+		AggregatedProofTable: c.AggregatedProofTable,
+		AggregationZkKeyName: aggregationZkKeyName,
+		AccountTreeRoot:      c.AccountTreeRoot,
+		PrevAccountTreeRoot:  c.PrevAccountTreeRoot,
+		PrevCexAssetsInfo:    c.PrevCexAssetsInfo,
+		AssetRegistry:        c.AssetRegistry,
+		AssetRegistryHash:    c.AssetRegistryHash,
+	}
+}
+
+func (c *Config) DbToolConfig() *dbtoolConfig.Config {
+	return &dbtoolConfig.Config{
+		DbDriver:         c.DbDriver,
+		MysqlDataSource:  c.MysqlDataSource,
+		DbSuffix:         c.DbSuffix,
+		TreeDB:           c.TreeDB,
+		Redis:            c.Redis,
+		ZkKeyName:        c.ZkKeyName,
+		AssetsCountTiers: c.AssetsCountTiers,
+		ProvingSystems:   c.ProvingSystems,
+		RecursiveProof:   c.RecursiveProof,
+		SolidityProof:    c.SolidityProof,
+		PrevDbSuffix:     c.PrevDbSuffix,
+		UserDataFile:     c.UserDataFile,
+	}
+}
+
+func (c *Config) LocalConfig() *localConfig.Config {
+	return &localConfig.Config{
+		UserDataFile:     c.UserDataFile,
+		DbSuffix:         c.DbSuffix,
+		DataDir:          c.DataDir,
+		ZkKeyName:        c.ZkKeyName,
+		AssetsCountTiers: c.AssetsCountTiers,
+		ProvingSystems:   c.ProvingSystems,
+		OutputDir:        c.OutputDir,
+	}
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "config.json")
+	content := `{
+  "DbSuffix": "1",
+  "UserDataFile": "users",
+  "TreeDB": {"Driver": "leveldb", "Option": {"Addr": "tree"}},
+  "Redis": {"Host": "127.0.0.1:6379"},
+  "ZkKeyName": ["/keys/zkpor50_700", "keys/zkpor500_92"],
+  "AssetsCountTiers": [50, 500],
+  "Aggregation": {"ZkKeyName": ["keys/zkpor_agg50", "keys/zkpor_agg500"], "RootZkKeyName": "keys/zkpor_agg_root", "BatchCount": 8, "Levels": 3}
+}`
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.UserDataFile != filepath.Join(dir, "users") || c.TreeDB.Option.Addr != filepath.Join(dir, "tree") ||
+		c.ZkKeyName[0] != "/keys/zkpor50_700" || c.ZkKeyName[1] != filepath.Join(dir, "keys/zkpor500_92") || c.SpillDir != "" {
+		t.Fatalf("unexpected paths %v", c)
+	}
+
+	aggregatorConfig := c.AggregatorConfig()
+	if aggregatorConfig.DbSuffix != "1" || aggregatorConfig.ZkKeyName[1] != filepath.Join(dir, "keys/zkpor_agg500") ||
+		aggregatorConfig.AssetsCountTiers[1] != 500 || aggregatorConfig.BatchCount != 8 || aggregatorConfig.Levels != 3 {
+		t.Fatalf("unexpected aggregator config %v", aggregatorConfig)
+	}
+	if c.VerifierConfig().AggregationZkKeyName != filepath.Join(dir, "keys/zkpor_agg_root") {
+		t.Fatal("the aggregation key of the verifier should default to the root key of the aggregator")
+	}
+	if witnessConfig := c.WitnessConfig(); witnessConfig.TreeDB.Driver != "leveldb" || witnessConfig.UserDataFile != c.UserDataFile {
+		t.Fatalf("unexpected witness config %v", witnessConfig)
+	}
+	if c.DbToolConfig().Redis.Host != "127.0.0.1:6379" {
+		t.Fatal("unexpected redis host")
+	}
+
+	// the address of the redis tree is not a path
+	c.TreeDB.Driver, c.TreeDB.Option.Addr = "redis", "127.0.0.1:6666"
+	c.resolvePaths(dir)
+	if c.TreeDB.Option.Addr != "127.0.0.1:6666" {
+		t.Fatal("the redis address should not be resolved")
+	}
+}
+
+// the service binaries read the shared config, so their example configs must
+// only have the fields of Config
+func TestServiceConfigs(t *testing.T) {
+	names, err := filepath.Glob("../*/config/config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) == 0 {
+		t.Fatal("no service config is found")
+	}
+	for _, name := range names {
+		content, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(&Config{}); err != nil {
+			t.Fatalf("%s: %s", name, err.Error())
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package config
 
 type Config struct {
+	// DbDriver is mysql, postgres or sqlite3, it defaults to mysql. MysqlDataSource
+	// is the data source of the driver
+	DbDriver        string
 	MysqlDataSource string
 	DbSuffix        string
 	TreeDB          struct {
@@ -13,4 +16,14 @@ type Config struct {
 		Host     string
 		Password string
 	}
+	// ZkKeyName, AssetsCountTiers, ProvingSystems and the following fields
+	// are only used by export-bundle, the asset registry is taken from the
+	// UserDataFile directory when it exists
+	ZkKeyName        []string
+	AssetsCountTiers []int
+	ProvingSystems   []string
+	RecursiveProof   bool
+	SolidityProof    bool
+	PrevDbSuffix     string
+	UserDataFile     string
 }
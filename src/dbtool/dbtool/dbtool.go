@@ -0,0 +1,420 @@
+package dbtool
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/circuit"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/aggregator/aggregator"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/bundle"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/dbtool/config"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/orchestrator"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/prover/prover"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/witness"
+	"github.com/redis/go-redis/v9"
+)
+
+// DropTables drops the witness, proof, userproof, aggregated proof and
+// pipeline stage tables of DbSuffix and flushes the redis of the task queue.
+func DropTables(dbtoolConfig *config.Config) {
+	db, err := utils.NewDBWithDriver(dbtoolConfig.DbDriver, dbtoolConfig.MysqlDataSource)
+	if err != nil {
+		panic(err.Error())
+	}
+	witnessModel := witness.NewWitnessModel(db, dbtoolConfig.DbSuffix)
+	err = witnessModel.DropBatchWitnessTable()
+	if err != nil {
+		fmt.Println("drop witness table failed")
+		panic(err.Error())
+	}
+	fmt.Println("drop witness table successfully")
+
+	proofModel := prover.NewProofModel(db, dbtoolConfig.DbSuffix)
+	err = proofModel.DropProofTable()
+	if err != nil {
+		fmt.Println("drop proof table failed")
+		panic(err.Error())
+	}
+	fmt.Println("drop proof table successfully")
+
+	userProofModel := model.NewUserProofModel(db, dbtoolConfig.DbSuffix)
+	err = userProofModel.DropUserProofTable()
+	if err != nil {
+		fmt.Println("drop userproof table failed")
+		panic(err.Error())
+	}
+	fmt.Println("drop userproof table successfully")
+
+	aggregatedProofModel := aggregator.NewAggregatedProofModel(db, dbtoolConfig.DbSuffix)
+	err = aggregatedProofModel.DropAggregatedProofTable()
+	if err != nil {
+		fmt.Println("drop aggregated proof table failed")
+		panic(err.Error())
+	}
+	fmt.Println("drop aggregated proof table successfully")
+
+	stageModel := orchestrator.NewStageModel(db, dbtoolConfig.DbSuffix)
+	err = stageModel.DropStageTable()
+	if err != nil {
+		fmt.Println("drop pipeline stage table failed")
+		panic(err.Error())
+	}
+	fmt.Println("drop pipeline stage table successfully")
+
+	// clear redis data
+	client := redis.NewClient(&redis.Options{
+		Addr:     dbtoolConfig.Redis.Host,
+		Password: dbtoolConfig.Redis.Password,
+	})
+	client.FlushAll(context.Background())
+	fmt.Println("redis data drop successfully")
+}
+
+// FlushTreeDB deletes the account tree of TreeDB.
+func FlushTreeDB(dbtoolConfig *config.Config) {
+	if dbtoolConfig.TreeDB.Driver == "leveldb" {
+		err := removeLevelDB(dbtoolConfig.TreeDB.Option.Addr)
+		if err != nil {
+			panic(err.Error())
+		}
+		fmt.Println("leveldb tree data drop successfully")
+	} else {
+		client := redis.NewClient(&redis.Options{
+			Addr:            dbtoolConfig.TreeDB.Option.Addr,
+			PoolSize:        500,
+			MaxRetries:      5,
+			MinRetryBackoff: 8 * time.Millisecond,
+			MaxRetryBackoff: 512 * time.Millisecond,
+			DialTimeout:     10 * time.Second,
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    10 * time.Second,
+			PoolTimeout:     15 * time.Second,
+		})
+		client.FlushAll(context.Background())
+		fmt.Println("kvrocks data drop successfully")
+	}
+}
+
+// removeLevelDB deletes the leveldb directory of the account tree. A
+// misconfigured Addr must not wipe an arbitrary directory, so the empty, dot
+// and root paths are refused and the directory must contain the CURRENT or
+// LOCK file of leveldb.
+func removeLevelDB(dir string) error {
+	if strings.TrimSpace(dir) == "" {
+		return fmt.Errorf("the leveldb path is empty")
+	}
+	clean := filepath.Clean(dir)
+	if clean == "." || clean == ".." {
+		return fmt.Errorf("refuse to delete the leveldb path %s", dir)
+	}
+	abs, err := filepath.Abs(clean)
+	if err != nil {
+		return err
+	}
+	if abs == filepath.VolumeName(abs)+string(filepath.Separator) {
+		return fmt.Errorf("refuse to delete the leveldb path %s", dir)
+	}
+	if home, err := os.UserHomeDir(); err == nil && abs == filepath.Clean(home) {
+		return fmt.Errorf("refuse to delete the leveldb path %s", dir)
+	}
+	info, err := os.Stat(abs)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("the leveldb path %s is not a directory", dir)
+	}
+	isLevelDB := false
+	for _, name := range []string{"CURRENT", "LOCK"} {
+		if _, err := os.Stat(filepath.Join(abs, name)); err == nil {
+			isLevelDB = true
+		}
+	}
+	if !isLevelDB {
+		return fmt.Errorf("the leveldb path %s has no CURRENT or LOCK file, it is not a leveldb store", dir)
+	}
+	return os.RemoveAll(abs)
+}
+
+// CheckProverStatus prints the witness counts of every status and the number
+// of batches which are not proved yet.
+func CheckProverStatus(dbtoolConfig *config.Config) {
+	db, err := utils.NewDBWithDriver(dbtoolConfig.DbDriver, dbtoolConfig.MysqlDataSource)
+	if err != nil {
+		panic(err.Error())
+	}
+	witnessModel := witness.NewWitnessModel(db, dbtoolConfig.DbSuffix)
+	proofModel := prover.NewProofModel(db, dbtoolConfig.DbSuffix)
+
+	var witnessCounts []int64
+	var proofCounts int64
+	for {
+		witnessCounts, err = witnessModel.GetRowCounts()
+		if err == utils.DbErrQueryInterrupted || err == utils.DbErrQueryTimeout {
+			fmt.Println("get witness counts timeout, retry...:", err.Error())
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		if err != nil {
+			panic(err.Error())
+		}
+		break
+	}
+	for {
+		proofCounts, err = proofModel.GetRowCounts()
+		if err == utils.DbErrQueryInterrupted || err == utils.DbErrQueryTimeout {
+			fmt.Println("get proof counts timeout, retry...:", err.Error())
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		if err == utils.DbErrTableNotFound {
+			fmt.Println("proof table not found")
+			proofCounts = 0
+			break
+		}
+		if err != nil {
+			panic(err.Error())
+		}
+		break
+	}
+
+	fmt.Printf("Total witness item %d, Published item %d, Pending item %d, Finished item %d\n", witnessCounts[0], witnessCounts[1], witnessCounts[2], witnessCounts[3])
+	fmt.Println(witnessCounts[0] - proofCounts)
+}
+
+// QueryCexAssets prints the cex assets and the asset registry hash recorded
+// by the latest witness.
+func QueryCexAssets(dbtoolConfig *config.Config) {
+	db, err := utils.NewDBWithDriver(dbtoolConfig.DbDriver, dbtoolConfig.MysqlDataSource)
+	if err != nil {
+		panic(err.Error())
+	}
+	witnessModel := witness.NewWitnessModel(db, dbtoolConfig.DbSuffix)
+	latestWitness, err := witnessModel.GetLatestBatchWitness()
+	if err != nil {
+		panic(err.Error())
+	}
+	cexAssetsInfo, _ := witness.RecoverAfterState(latestWitness)
+	var newAssetsInfo []utils.CexAssetInfo
+	for i := 0; i < len(cexAssetsInfo); i++ {
+		if cexAssetsInfo[i].BasePrice != 0 {
+			newAssetsInfo = append(newAssetsInfo, cexAssetsInfo[i])
+		}
+	}
+	cexAssetsInfoBytes, _ := json.Marshal(newAssetsInfo)
+	fmt.Println(string(cexAssetsInfoBytes))
+	fmt.Println("asset registry hash:", witness.RecoverAssetRegistryHash(latestWitness))
+}
+
+// QueryWitnessData prints the witness data of the batch height.
+func QueryWitnessData(dbtoolConfig *config.Config, height int64) {
+	db, err := utils.NewDBWithDriver(dbtoolConfig.DbDriver, dbtoolConfig.MysqlDataSource)
+	if err != nil {
+		panic(err.Error())
+	}
+	witnessModel := witness.NewWitnessModel(db, dbtoolConfig.DbSuffix)
+
+	w, err := witnessModel.GetBatchWitnessByHeight(height)
+	if err != nil {
+		panic(err.Error())
+	}
+	fmt.Printf("%x", w.WitnessData)
+}
+
+// QueryAccountData prints the user config of the account index.
+func QueryAccountData(dbtoolConfig *config.Config, index int) {
+	db, err := utils.NewDBWithDriver(dbtoolConfig.DbDriver, dbtoolConfig.MysqlDataSource)
+	if err != nil {
+		panic(err.Error())
+	}
+	userProofModel := model.NewUserProofModel(db, dbtoolConfig.DbSuffix)
+
+	u, err := userProofModel.GetUserProofByIndex(uint32(index))
+	if err != nil {
+		panic(err.Error())
+	}
+	fmt.Println(u.Config)
+}
+
+// PushTasksToRedis pushes the published witness heights back to the task
+// queues of the provers, every height is pushed to the queue of its assets
+// count tier.
+func PushTasksToRedis(dbtoolConfig *config.Config) {
+	db, err := utils.NewDBWithDriver(dbtoolConfig.DbDriver, dbtoolConfig.MysqlDataSource)
+	if err != nil {
+		panic(err.Error())
+	}
+	witnessModel := witness.NewWitnessModel(db, dbtoolConfig.DbSuffix)
+	err = witnessModel.UpgradeBatchWitnessTable()
+	if err != nil {
+		panic(err.Error())
+	}
+	limit := 1024
+	offset := 0
+	witessStatusList := []int64{witness.StatusPublished}
+	taskQueueName := prover.TaskQueueNamePrefix + dbtoolConfig.DbSuffix
+	ctx := context.Background()
+	redisCli := redis.NewClient(&redis.Options{
+		Addr:     dbtoolConfig.Redis.Host,
+		Password: dbtoolConfig.Redis.Password,
+	})
+	tierCounts := make(map[int64]int)
+	for _, status := range witessStatusList {
+		offset = 0
+		for {
+			tasks, err := witnessModel.GetAllBatchTasksByStatus(status, limit, offset)
+			if err == utils.DbErrQueryInterrupted || err == utils.DbErrQueryTimeout {
+				fmt.Println("get witness heights timeout, retry...:", err.Error())
+				time.Sleep(1 * time.Second)
+				continue
+			}
+			if err == utils.DbErrNotFound {
+				fmt.Printf("no more witness data with status %d\n", status)
+				break
+			}
+			if err != nil {
+				panic(err.Error())
+			}
+
+			redisPipe := redisCli.Pipeline()
+			for _, task := range tasks {
+				redisPipe.LPush(ctx, prover.TaskQueueName(taskQueueName, int(task.AssetsCount)), task.Height)
+				tierCounts[task.AssetsCount]++
+			}
+			_, err = redisPipe.Exec(ctx)
+			if err != nil {
+				panic(err.Error())
+			} else {
+				fmt.Printf("push %d task to redis, offset: %d\n", len(tasks), offset)
+			}
+			offset += len(tasks)
+		}
+	}
+	for tier, count := range tierCounts {
+		fmt.Printf("push %d task to %s\n", count, prover.TaskQueueName(taskQueueName, int(tier)))
+	}
+	fmt.Println("push task to redis successfully")
+}
+
+// ExportCalldata writes the solidity verifier calldata of all batch proofs to
+// outputFile. The proofs must be generated with SolidityProof, the solidity
+// verifier rejects the other proofs.
+func ExportCalldata(dbtoolConfig *config.Config, outputFile string) {
+	if !dbtoolConfig.SolidityProof {
+		panic("SolidityProof must be set: only the proofs generated with SolidityProof can be verified by the solidity verifier")
+	}
+	db, err := utils.NewDBWithDriver(dbtoolConfig.DbDriver, dbtoolConfig.MysqlDataSource)
+	if err != nil {
+		panic(err.Error())
+	}
+	proofModel := prover.NewProofModel(db, dbtoolConfig.DbSuffix)
+	err = proofModel.UpgradeProofTable()
+	if err != nil {
+		panic(err.Error())
+	}
+	type ProofCalldata struct {
+		BatchNumber     int64
+		AssetsCount     int
+		ProvingSystem   string
+		BatchCommitment string
+		Calldata        string
+	}
+	calldatas := make([]ProofCalldata, 0)
+	limit := int64(1024)
+	for start := int64(0); ; start += limit {
+		proofs, err := proofModel.GetProofsBetween(start, start+limit-1)
+		if err == utils.DbErrQueryInterrupted || err == utils.DbErrQueryTimeout {
+			fmt.Println("get proofs timeout, retry...:", err.Error())
+			time.Sleep(1 * time.Second)
+			start -= limit
+			continue
+		}
+		if err == utils.DbErrNotFound {
+			break
+		}
+		if err != nil {
+			panic(err.Error())
+		}
+		for _, p := range proofs {
+			provingSystem, err := circuit.NormalizeProvingSystem(p.ProvingSystem)
+			if err != nil {
+				panic(err.Error())
+			}
+			proofBytes, err := base64.StdEncoding.DecodeString(p.ProofInfo)
+			if err != nil {
+				panic(err.Error())
+			}
+			proof := circuit.NewProof(provingSystem)
+			_, err = proof.ReadFrom(bytes.NewBuffer(proofBytes))
+			if err != nil {
+				panic(err.Error())
+			}
+			batchCommitment, err := base64.StdEncoding.DecodeString(p.BatchCommitment)
+			if err != nil {
+				panic(err.Error())
+			}
+			calldata, err := circuit.SolidityCalldata(provingSystem, proof, [][]byte{batchCommitment})
+			if err != nil {
+				panic(err.Error())
+			}
+			calldatas = append(calldatas, ProofCalldata{
+				BatchNumber:     p.BatchNumber,
+				AssetsCount:     p.AssetsCount,
+				ProvingSystem:   provingSystem,
+				BatchCommitment: "0x" + hex.EncodeToString(batchCommitment),
+				Calldata:        "0x" + hex.EncodeToString(calldata),
+			})
+		}
+	}
+	calldatasBytes, err := json.Marshal(calldatas)
+	if err != nil {
+		panic(err.Error())
+	}
+	err = os.WriteFile(outputFile, calldatasBytes, 0644)
+	if err != nil {
+		panic(err.Error())
+	}
+	fmt.Println("export calldata of ", len(calldatas), " proofs successfully")
+}
+
+// ExportBundle exports the audit bundle of the snapshot, which contains
+// everything the verifier needs, to the directory or the gzipped tar.
+func ExportBundle(dbtoolConfig *config.Config, output string) {
+	db, err := utils.NewDBWithDriver(dbtoolConfig.DbDriver, dbtoolConfig.MysqlDataSource)
+	if err != nil {
+		panic(err.Error())
+	}
+	content, err := bundle.Collect(db, dbtoolConfig.DbSuffix, dbtoolConfig.PrevDbSuffix)
+	if err != nil {
+		panic(err.Error())
+	}
+	content.ZkKeyName = dbtoolConfig.ZkKeyName
+	content.AssetsCountTiers = dbtoolConfig.AssetsCountTiers
+	content.ProvingSystems = dbtoolConfig.ProvingSystems
+	content.RecursiveProof = dbtoolConfig.RecursiveProof
+	content.SolidityProof = dbtoolConfig.SolidityProof
+	if dbtoolConfig.UserDataFile != "" {
+		assetRegistryFile := filepath.Join(dbtoolConfig.UserDataFile, utils.AssetRegistryFile)
+		if _, err := os.Stat(assetRegistryFile); err == nil {
+			content.AssetRegistry = assetRegistryFile
+		}
+	}
+	manifest, err := bundle.Export(output, content)
+	if err != nil {
+		panic(err.Error())
+	}
+	fmt.Printf("export bundle of %d batches to %s, account tree root %s\n", manifest.BatchCount, output, manifest.AccountTreeRoot)
+}
@@ -0,0 +1,57 @@
+package dbtool
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/dbtool/config"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestRemoveLevelDB(t *testing.T) {
+	for _, dir := range []string{"", " ", ".", "./", "..", "/", "//"} {
+		if err := removeLevelDB(dir); err == nil {
+			t.Fatalf("expect an error for the path %q", dir)
+		}
+	}
+
+	// a directory which is not a leveldb store is kept
+	dir := t.TempDir()
+	file := filepath.Join(dir, "data.csv")
+	if err := os.WriteFile(file, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeLevelDB(dir); err == nil {
+		t.Fatal("expect an error for the directory which is not a leveldb store")
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Fatal("the directory which is not a leveldb store is deleted")
+	}
+
+	treeDir := filepath.Join(dir, "tree")
+	db, err := leveldb.OpenFile(treeDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if err = removeLevelDB(treeDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(treeDir); !os.IsNotExist(err) {
+		t.Fatal("the leveldb store is not deleted")
+	}
+	// the missing store has nothing to delete
+	if err = removeLevelDB(treeDir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportCalldataWithoutSolidityProof(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect a panic when SolidityProof is not set")
+		}
+	}()
+	ExportCalldata(&config.Config{}, filepath.Join(t.TempDir(), "calldata.json"))
+}
@@ -0,0 +1,498 @@
+package keygen
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/circuit"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	curve "github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/fft"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/pedersen"
+	"github.com/consensys/gnark/backend/groth16/bn254/mpcsetup"
+	"github.com/consensys/gnark/constraint"
+	cs_bn254 "github.com/consensys/gnark/constraint/bn254"
+)
+
+// The multi-party setup of the groth16 keys runs in two phases on top of
+// gnark mpcsetup. Phase 1 (powers of tau) is shared by the circuits of the
+// same domain size, phase 2 is specific to a circuit. Every participant
+// reads the file of the previous participant and writes its contribution to
+// a new file, the keys are safe as long as one participant discards its
+// randomness.
+//
+// The batch circuits use a pedersen commitment for their range checks and
+// lookups, which mpcsetup doesn't handle, so the phase 2 of such a circuit
+// has a second mpcsetup phase for the commitment key: its L holds the
+// commitment basis scaled by 1/σ and its G2 delta is σ⋅g₂, where σ is the
+// product of the contributions.
+
+// ceremonyPhase2 is the phase 2 file of a circuit, Commitment is nil when the
+// circuit has no commitment.
+type ceremonyPhase2 struct {
+	Delta      mpcsetup.Phase2
+	Commitment *mpcsetup.Phase2
+}
+
+func (p *ceremonyPhase2) WriteTo(w io.Writer) (int64, error) {
+	flag := []byte{0}
+	if p.Commitment != nil {
+		flag[0] = 1
+	}
+	n, err := w.Write(flag)
+	if err != nil {
+		return int64(n), err
+	}
+	total := int64(n)
+	m, err := p.Delta.WriteTo(w)
+	total += m
+	if err != nil || p.Commitment == nil {
+		return total, err
+	}
+	m, err = p.Commitment.WriteTo(w)
+	return total + m, err
+}
+
+func (p *ceremonyPhase2) ReadFrom(r io.Reader) (int64, error) {
+	flag := make([]byte, 1)
+	n, err := r.Read(flag)
+	if err != nil {
+		return int64(n), err
+	}
+	total := int64(n)
+	m, err := p.Delta.ReadFrom(r)
+	total += m
+	if err != nil || flag[0] == 0 {
+		return total, err
+	}
+	p.Commitment = &mpcsetup.Phase2{}
+	m, err = p.Commitment.ReadFrom(r)
+	return total + m, err
+}
+
+// Hash identifies the contribution, it is published by the participant.
+func (p *ceremonyPhase2) Hash() []byte {
+	h := sha256.New()
+	h.Write(p.Delta.Hash)
+	if p.Commitment != nil {
+		h.Write(p.Commitment.Hash)
+	}
+	return h.Sum(nil)
+}
+
+// ceremonyEvaluations are the parts of the keys which don't depend on the
+// phase 2 contributions, they are computed once by CeremonyInit.
+type ceremonyEvaluations struct {
+	mpcsetup.Phase2Evaluations
+	Alpha           curve.G1Affine
+	Beta            curve.G1Affine
+	BetaG2          curve.G2Affine
+	CommitmentBasis []curve.G1Affine
+}
+
+func (e *ceremonyEvaluations) WriteTo(w io.Writer) (int64, error) {
+	n, err := e.Phase2Evaluations.WriteTo(w)
+	if err != nil {
+		return n, err
+	}
+	enc := curve.NewEncoder(w)
+	for _, v := range []interface{}{e.G1.VKK, &e.Alpha, &e.Beta, &e.BetaG2, e.CommitmentBasis} {
+		if err = enc.Encode(v); err != nil {
+			break
+		}
+	}
+	return n + enc.BytesWritten(), err
+}
+
+func (e *ceremonyEvaluations) ReadFrom(r io.Reader) (int64, error) {
+	n, err := e.Phase2Evaluations.ReadFrom(r)
+	if err != nil {
+		return n, err
+	}
+	dec := curve.NewDecoder(r)
+	for _, v := range []interface{}{&e.G1.VKK, &e.Alpha, &e.Beta, &e.BetaG2, &e.CommitmentBasis} {
+		if err = dec.Decode(v); err != nil {
+			break
+		}
+	}
+	return n + dec.BytesRead(), err
+}
+
+// fullReader fills the buffer of every Read, mpcsetup reads the hash of a
+// phase with a single Read which may be short on a bufio.Reader.
+type fullReader struct {
+	r io.Reader
+}
+
+func (f fullReader) Read(p []byte) (int, error) {
+	return io.ReadFull(f.r, p)
+}
+
+func writeCeremonyFile(name string, v io.WriterTo) {
+	f, err := os.Create(name)
+	if err != nil {
+		panic(err)
+	}
+	w := bufio.NewWriter(f)
+	_, err = v.WriteTo(w)
+	if err != nil {
+		panic(err)
+	}
+	err = w.Flush()
+	if err != nil {
+		panic(err)
+	}
+	err = f.Close()
+	if err != nil {
+		panic(err)
+	}
+}
+
+func readCeremonyFile(name string, v io.ReaderFrom) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = v.ReadFrom(fullReader{bufio.NewReader(f)})
+	if err != nil {
+		return fmt.Errorf("read %s failed: %s", name, err.Error())
+	}
+	return nil
+}
+
+// phaseHash is the hash mpcsetup computes for a phase 2, the public key of
+// the initial phase is random so two initial phases are compared by
+// parametersHash instead.
+func phaseHash(p *mpcsetup.Phase2) []byte {
+	c := *p
+	c.Hash = nil
+	h := sha256.New()
+	c.WriteTo(h)
+	return h.Sum(nil)
+}
+
+func parametersHash(p mpcsetup.Phase2) []byte {
+	p.PublicKey = mpcsetup.PublicKey{}
+	return phaseHash(&p)
+}
+
+func phase1ParametersHash(p mpcsetup.Phase1) []byte {
+	p.PublicKeys.Tau = mpcsetup.PublicKey{}
+	p.PublicKeys.Alpha = mpcsetup.PublicKey{}
+	p.PublicKeys.Beta = mpcsetup.PublicKey{}
+	p.Hash = nil
+	h := sha256.New()
+	p.WriteTo(h)
+	return h.Sum(nil)
+}
+
+func evaluationsHash(e *ceremonyEvaluations) []byte {
+	h := sha256.New()
+	e.WriteTo(h)
+	return h.Sum(nil)
+}
+
+// CeremonyPhase1Init writes the initial phase 1 for the circuits of a domain
+// of 2^power, that is the circuits of more than 2^(power-1) and at most
+// 2^power constraints.
+func CeremonyPhase1Init(power int, output string) {
+	if power <= 0 || power > 28 {
+		panic("phase 1 power should be in [1, 28]")
+	}
+	phase1 := mpcsetup.InitPhase1(power)
+	writeCeremonyFile(output, &phase1)
+	fmt.Println("write the initial phase 1 of power", power, "to", output)
+}
+
+// CeremonyPhase1Contribute adds a random contribution to the phase 1 of
+// input and writes it to output.
+func CeremonyPhase1Contribute(input string, output string) {
+	var phase1 mpcsetup.Phase1
+	err := readCeremonyFile(input, &phase1)
+	if err != nil {
+		panic(err.Error())
+	}
+	phase1.Contribute()
+	writeCeremonyFile(output, &phase1)
+	fmt.Printf("write the phase 1 contribution %x to %s\n", phase1.Hash, output)
+}
+
+// CeremonyPhase1Verify checks that files are the initial phase 1 followed
+// by its contributions in order.
+func CeremonyPhase1Verify(files []string) error {
+	if len(files) < 2 {
+		return errors.New("the initial phase 1 and at least one contribution are needed")
+	}
+	var prev mpcsetup.Phase1
+	err := readCeremonyFile(files[0], &prev)
+	if err != nil {
+		return err
+	}
+	power := 0
+	for 1<<power < len(prev.Parameters.G2.Tau) {
+		power++
+	}
+	initial := mpcsetup.InitPhase1(power)
+	if string(phase1ParametersHash(initial)) != string(phase1ParametersHash(prev)) {
+		return fmt.Errorf("%s is not the initial phase 1 of power %d", files[0], power)
+	}
+	for _, name := range files[1:] {
+		var next mpcsetup.Phase1
+		err = readCeremonyFile(name, &next)
+		if err != nil {
+			return err
+		}
+		if len(next.Parameters.G1.Tau) != len(prev.Parameters.G1.Tau) || len(next.Parameters.G2.Tau) != len(prev.Parameters.G2.Tau) {
+			return fmt.Errorf("the contribution %s has another size", name)
+		}
+		err = mpcsetup.VerifyPhase1(&prev, &next)
+		if err != nil {
+			return fmt.Errorf("the contribution %s is invalid: %s", name, err.Error())
+		}
+		fmt.Printf("verify the phase 1 contribution %x of %s\n", next.Hash, name)
+		prev = next
+	}
+	return nil
+}
+
+// readR1CS reads the groth16 constraint system generated by keygen.
+func readR1CS(name string) (*cs_bn254.R1CS, error) {
+	cs := circuit.NewConstraintSystem(circuit.ProvingSystemGroth16)
+	err := readCeremonyFile(name, cs)
+	if err != nil {
+		return nil, err
+	}
+	return cs.(*cs_bn254.R1CS), nil
+}
+
+// initCeremonyPhase2 computes the initial phase 2 of the circuit from the
+// last phase 1 contribution. It follows the layout of groth16.Setup: the
+// commitment wires go to the verifying key with the public wires, and the
+// private wires of the commitment to the commitment basis.
+func initCeremonyPhase2(r1cs *cs_bn254.R1CS, phase1 *mpcsetup.Phase1) (*ceremonyPhase2, *ceremonyEvaluations, error) {
+	domain := fft.NewDomain(uint64(r1cs.GetNbConstraints()))
+	if uint64(len(phase1.Parameters.G2.Tau)) != domain.Cardinality {
+		return nil, nil, fmt.Errorf("the circuit of %d constraints needs the phase 1 of power %d", r1cs.GetNbConstraints(), bits.TrailingZeros64(domain.Cardinality))
+	}
+	commitmentInfo := r1cs.CommitmentInfo.(constraint.Groth16Commitments)
+	if len(commitmentInfo) > 1 {
+		return nil, nil, fmt.Errorf("the circuit has %d commitments, at most one is supported", len(commitmentInfo))
+	}
+	delta, evals := mpcsetup.InitPhase2(r1cs, phase1)
+	p := &ceremonyPhase2{Delta: delta}
+	e := &ceremonyEvaluations{
+		Phase2Evaluations: evals,
+		Alpha:             phase1.Parameters.G1.AlphaTau[0],
+		Beta:              phase1.Parameters.G1.BetaTau[0],
+		BetaG2:            phase1.Parameters.G2.Beta,
+		CommitmentBasis:   []curve.G1Affine{},
+	}
+	if len(commitmentInfo) == 1 {
+		commitmentWires := make(map[int]bool)
+		for _, i := range commitmentInfo.CommitmentIndexes() {
+			commitmentWires[i] = true
+		}
+		committed := make(map[int]bool)
+		for _, i := range commitmentInfo.GetPrivateCommitted()[0] {
+			committed[i] = true
+		}
+		nbPublic := r1cs.GetNbPublicVariables()
+		l := make([]curve.G1Affine, 0, len(delta.Parameters.G1.L))
+		for i, point := range delta.Parameters.G1.L {
+			wire := nbPublic + i
+			if commitmentWires[wire] {
+				e.G1.VKK = append(e.G1.VKK, point)
+			} else if committed[wire] {
+				e.CommitmentBasis = append(e.CommitmentBasis, point)
+			} else {
+				l = append(l, point)
+			}
+		}
+		p.Delta.Parameters.G1.L = l
+		p.Delta.Hash = phaseHash(&p.Delta)
+
+		_, _, g1, g2 := curve.Generators()
+		p.Commitment = &mpcsetup.Phase2{}
+		p.Commitment.Parameters.G1.Delta = g1
+		p.Commitment.Parameters.G2.Delta = g2
+		p.Commitment.Parameters.G1.L = append([]curve.G1Affine{}, e.CommitmentBasis...)
+		p.Commitment.Parameters.G1.Z = []curve.G1Affine{}
+		p.Commitment.Hash = phaseHash(p.Commitment)
+	}
+	return p, e, nil
+}
+
+// CeremonyInit writes the initial phase 2 of the groth16 constraint system
+// r1csFile from the last phase 1 contribution, and the evaluations which
+// CeremonyExtract needs with the last phase 2 contribution.
+func CeremonyInit(r1csFile string, phase1File string, output string, evaluationsFile string) {
+	r1cs, err := readR1CS(r1csFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	var phase1 mpcsetup.Phase1
+	err = readCeremonyFile(phase1File, &phase1)
+	if err != nil {
+		panic(err.Error())
+	}
+	p, e, err := initCeremonyPhase2(r1cs, &phase1)
+	if err != nil {
+		panic(err.Error())
+	}
+	writeCeremonyFile(output, p)
+	writeCeremonyFile(evaluationsFile, e)
+	fmt.Println("write the initial phase 2 of", r1csFile, "to", output, "and its evaluations to", evaluationsFile)
+}
+
+// CeremonyContribute adds a random contribution to the phase 2 of input and
+// writes it to output.
+func CeremonyContribute(input string, output string) {
+	p := &ceremonyPhase2{}
+	err := readCeremonyFile(input, p)
+	if err != nil {
+		panic(err.Error())
+	}
+	p.Delta.Contribute()
+	if p.Commitment != nil {
+		p.Commitment.Contribute()
+	}
+	writeCeremonyFile(output, p)
+	fmt.Printf("write the phase 2 contribution %x to %s\n", p.Hash(), output)
+}
+
+func verifyPhase2Contribution(prev *mpcsetup.Phase2, next *mpcsetup.Phase2) error {
+	if len(next.Parameters.G1.L) != len(prev.Parameters.G1.L) || len(next.Parameters.G1.Z) != len(prev.Parameters.G1.Z) {
+		return errors.New("the contribution has another size")
+	}
+	return mpcsetup.VerifyPhase2(prev, next)
+}
+
+// CeremonyVerify recomputes the initial phase 2 of the constraint system
+// from the last phase 1 contribution, checks that files start with it and
+// are followed by its contributions in order, and that evaluationsFile is
+// the one of the circuit.
+func CeremonyVerify(r1csFile string, phase1File string, evaluationsFile string, files []string) error {
+	if len(files) < 2 {
+		return errors.New("the initial phase 2 and at least one contribution are needed")
+	}
+	r1cs, err := readR1CS(r1csFile)
+	if err != nil {
+		return err
+	}
+	var phase1 mpcsetup.Phase1
+	err = readCeremonyFile(phase1File, &phase1)
+	if err != nil {
+		return err
+	}
+	initial, initialEvals, err := initCeremonyPhase2(r1cs, &phase1)
+	if err != nil {
+		return err
+	}
+	evals := &ceremonyEvaluations{}
+	err = readCeremonyFile(evaluationsFile, evals)
+	if err != nil {
+		return err
+	}
+	if string(evaluationsHash(evals)) != string(evaluationsHash(initialEvals)) {
+		return fmt.Errorf("%s are not the evaluations of %s", evaluationsFile, r1csFile)
+	}
+	prev := &ceremonyPhase2{}
+	err = readCeremonyFile(files[0], prev)
+	if err != nil {
+		return err
+	}
+	if (prev.Commitment == nil) != (initial.Commitment == nil) ||
+		string(parametersHash(prev.Delta)) != string(parametersHash(initial.Delta)) ||
+		string(phaseHash(&prev.Delta)) != string(prev.Delta.Hash) ||
+		prev.Commitment != nil && (string(parametersHash(*prev.Commitment)) != string(parametersHash(*initial.Commitment)) ||
+			string(phaseHash(prev.Commitment)) != string(prev.Commitment.Hash)) {
+		return fmt.Errorf("%s is not the initial phase 2 of %s", files[0], r1csFile)
+	}
+	for _, name := range files[1:] {
+		next := &ceremonyPhase2{}
+		err = readCeremonyFile(name, next)
+		if err != nil {
+			return err
+		}
+		if (next.Commitment == nil) != (prev.Commitment == nil) {
+			return fmt.Errorf("the contribution %s has another commitment", name)
+		}
+		err = verifyPhase2Contribution(&prev.Delta, &next.Delta)
+		if err == nil && next.Commitment != nil {
+			err = verifyPhase2Contribution(prev.Commitment, next.Commitment)
+		}
+		if err != nil {
+			return fmt.Errorf("the contribution %s is invalid: %s", name, err.Error())
+		}
+		fmt.Printf("verify the phase 2 contribution %x of %s\n", next.Hash(), name)
+		prev = next
+	}
+	return nil
+}
+
+// CeremonyExtract writes the groth16 keys of the constraint system r1csFile
+// from the last phase 2 contribution to dir, with the constraint system and
+// its circuit params embedded by keygen. The contributions are expected to
+// be checked by CeremonyVerify.
+func CeremonyExtract(r1csFile string, evaluationsFile string, input string, dir string) {
+	params, err := utils.ReadKeyParams(r1csFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	if params == nil {
+		panic("the constraint system " + r1csFile + " has no circuit params, please generate it with keygen")
+	}
+	if params.Circuit == utils.KeyCircuitBatchCreate || params.Circuit == utils.KeyCircuitBatchUpdate {
+		err = utils.CheckBatchKeyParams(r1csFile, params.AssetsCountTier)
+	} else {
+		err = utils.CheckKeyParams(r1csFile, params.Circuit)
+	}
+	if err != nil {
+		panic(err.Error())
+	}
+	r1cs, err := readR1CS(r1csFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	evals := &ceremonyEvaluations{}
+	err = readCeremonyFile(evaluationsFile, evals)
+	if err != nil {
+		panic(err.Error())
+	}
+	p := &ceremonyPhase2{}
+	err = readCeremonyFile(input, p)
+	if err != nil {
+		panic(err.Error())
+	}
+	commitmentInfo := r1cs.CommitmentInfo.(constraint.Groth16Commitments)
+	if (p.Commitment != nil) != (len(commitmentInfo) == 1) {
+		panic(input + " is not a phase 2 of " + r1csFile)
+	}
+
+	// only the first elements of phase 1 are in the keys
+	var phase1 mpcsetup.Phase1
+	phase1.Parameters.G1.AlphaTau = []curve.G1Affine{evals.Alpha}
+	phase1.Parameters.G1.BetaTau = []curve.G1Affine{evals.Beta}
+	phase1.Parameters.G2.Beta = evals.BetaG2
+	pk, vk := mpcsetup.ExtractKeys(&phase1, &p.Delta, &evals.Phase2Evaluations, r1cs.GetNbConstraints())
+	if p.Commitment != nil {
+		// the pedersen key of σ: Basis⋅σ = L and -G⋅σ = -g₂
+		_, _, _, g2 := curve.Generators()
+		var gSigma curve.G2Affine
+		gSigma.Neg(&g2)
+		pk.CommitmentKeys = []pedersen.ProvingKey{{Basis: evals.CommitmentBasis, BasisExpSigma: p.Commitment.Parameters.G1.L}}
+		vk.CommitmentKeys = []pedersen.VerifyingKey{{G: p.Commitment.Parameters.G2.Delta, GSigma: gSigma}}
+		vk.PublicAndCommitmentCommitted = commitmentInfo.GetPublicAndCommitmentCommitted(commitmentInfo.CommitmentIndexes(), r1cs.GetNbPublicVariables())
+	}
+	zkKeyName := filepath.Join(dir, strings.TrimSuffix(filepath.Base(r1csFile), circuit.ConstraintSystemFileSuffix(circuit.ProvingSystemGroth16)))
+	writeZkKeys(zkKeyName, circuit.ProvingSystemGroth16, &pk, &vk, r1cs, params)
+	fmt.Println("extract the keys of the phase 2 contribution", fmt.Sprintf("%x", p.Hash()), "to", zkKeyName)
+}
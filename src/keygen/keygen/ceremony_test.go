@@ -0,0 +1,113 @@
+package keygen
+
+import (
+	"math/bits"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/circuit"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/rangecheck"
+)
+
+// rangeCheckCircuit uses a range check, so its groth16 keys have a
+// commitment key like the batch circuits.
+type rangeCheckCircuit struct {
+	X frontend.Variable
+	Y frontend.Variable `gnark:",public"`
+}
+
+func (c *rangeCheckCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(api.Mul(c.X, c.X), c.Y)
+	rangecheck.New(api).Check(c.X, 32)
+	return nil
+}
+
+// TestCeremony runs the ceremony of two participants in both phases, and
+// proves with the extracted keys.
+func TestCeremony(t *testing.T) {
+	for _, c := range []frontend.Circuit{&squareCircuit{}, &rangeCheckCircuit{}} {
+		dir := t.TempDir()
+		file := func(name string) string { return filepath.Join(dir, name) }
+		cs, err := circuit.Compile(circuit.ProvingSystemGroth16, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Create(file("zkpor50_700.r1cs"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs.WriteTo(f)
+		f.Close()
+		if err = utils.AppendKeyParams(file("zkpor50_700.r1cs"), utils.NewBatchKeyParams(50, false)); err != nil {
+			t.Fatal(err)
+		}
+
+		power := bits.Len(uint(cs.GetNbConstraints() - 1))
+		CeremonyPhase1Init(power, file("phase1_0"))
+		CeremonyPhase1Contribute(file("phase1_0"), file("phase1_1"))
+		CeremonyPhase1Contribute(file("phase1_1"), file("phase1_2"))
+		phase1 := []string{file("phase1_0"), file("phase1_1"), file("phase1_2")}
+		if err = CeremonyPhase1Verify(phase1); err != nil {
+			t.Fatal(err)
+		}
+		if err = CeremonyPhase1Verify([]string{file("phase1_0"), file("phase1_2")}); err == nil {
+			t.Fatal("the contribution which skips a participant should be refused")
+		}
+
+		CeremonyInit(file("zkpor50_700.r1cs"), file("phase1_2"), file("phase2_0"), file("evaluations"))
+		CeremonyContribute(file("phase2_0"), file("phase2_1"))
+		CeremonyContribute(file("phase2_1"), file("phase2_2"))
+		phase2 := []string{file("phase2_0"), file("phase2_1"), file("phase2_2")}
+		if err = CeremonyVerify(file("zkpor50_700.r1cs"), file("phase1_2"), file("evaluations"), phase2); err != nil {
+			t.Fatal(err)
+		}
+		if err = CeremonyVerify(file("zkpor50_700.r1cs"), file("phase1_1"), file("evaluations"), phase2); err == nil {
+			t.Fatal("the phase 2 of another phase 1 should be refused")
+		}
+		if err = CeremonyVerify(file("zkpor50_700.r1cs"), file("phase1_2"), file("evaluations"), []string{file("phase2_1"), file("phase2_2")}); err == nil {
+			t.Fatal("the phase 2 which doesn't start with the initial one should be refused")
+		}
+
+		keyDir := t.TempDir()
+		CeremonyExtract(file("zkpor50_700.r1cs"), file("evaluations"), file("phase2_2"), keyDir)
+		zkKeyName := filepath.Join(keyDir, "zkpor50_700")
+		if _, err = utils.CheckBatchKeyManifest(zkKeyName, 50, circuit.ProvingSystemGroth16, ".pk", ".vk", ".r1cs"); err != nil {
+			t.Fatal(err)
+		}
+		pk := circuit.NewProvingKey(circuit.ProvingSystemGroth16)
+		f, _ = os.Open(zkKeyName + ".pk")
+		_, err = pk.UnsafeReadFrom(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		vk := circuit.NewVerifyingKey(circuit.ProvingSystemGroth16)
+		f, _ = os.Open(zkKeyName + ".vk")
+		_, err = vk.ReadFrom(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var assignment frontend.Circuit = &squareCircuit{X: 3, Y: 9}
+		if _, ok := c.(*rangeCheckCircuit); ok {
+			assignment = &rangeCheckCircuit{X: 3, Y: 9}
+		}
+		w, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField())
+		if err != nil {
+			t.Fatal(err)
+		}
+		proof, err := circuit.Prove(circuit.ProvingSystemGroth16, cs, pk, w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		publicWitness, _ := w.Public()
+		if err = circuit.Verify(circuit.ProvingSystemGroth16, proof, vk, publicWitness); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package keygen
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/circuit"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/consensys/gnark-crypto/ecc"
+	kzg_bn254 "github.com/consensys/gnark-crypto/ecc/bn254/kzg"
+	"github.com/consensys/gnark-crypto/kzg"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test/unsafekzg"
+)
+
+// writeZkKeys writes the keys and the constraint system, every file embeds
+// the circuit params of the keys, and records them in the key manifest.
+func writeZkKeys(zkKeyName string, provingSystem string, pk circuit.ProvingKey, vk circuit.VerifyingKey, cs constraint.ConstraintSystem, params *utils.KeyParams) {
+	pkFile, err := os.Create(zkKeyName + ".pk")
+	if err != nil {
+		panic(err)
+	}
+	n, err := pk.WriteTo(pkFile)
+	if err != nil {
+		panic(err)
+	}
+	pkFile.Close()
+	fmt.Println("pk size is ", n)
+	vkFile, err := os.Create(zkKeyName + ".vk")
+	if err != nil {
+		panic(err)
+	}
+	n, err = vk.WriteTo(vkFile)
+	if err != nil {
+		panic(err)
+	}
+	vkFile.Close()
+	fmt.Println("vk size is ", n)
+
+	csFile, err := os.Create(zkKeyName + circuit.ConstraintSystemFileSuffix(provingSystem))
+	if err != nil {
+		panic(err)
+	}
+	n, err = cs.WriteTo(csFile)
+	if err != nil {
+		panic(err)
+	}
+	csFile.Close()
+	fmt.Println("constraint system size is ", n)
+
+	suffixes := []string{".pk", ".vk", circuit.ConstraintSystemFileSuffix(provingSystem)}
+	for _, suffix := range suffixes {
+		err = utils.AppendKeyParams(zkKeyName+suffix, params)
+		if err != nil {
+			panic(err)
+		}
+	}
+	err = utils.AddKeyManifestEntry(zkKeyName, params, provingSystem, cs.GetNbConstraints(), suffixes)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("add", filepath.Base(zkKeyName), "to", filepath.Join(filepath.Dir(zkKeyName), utils.KeyManifestFile))
+}
+
+func setupAggregationKeys(zkKeyName string, innerVks []groth16.VerifyingKey, assetsCountTier int) groth16.VerifyingKey {
+	aggregationCircuit, err := circuit.NewBatchProofAggregationCircuit(innerVks)
+	if err != nil {
+		panic(err)
+	}
+	startTime := time.Now()
+	oCs, err := circuit.Compile(circuit.ProvingSystemGroth16, aggregationCircuit)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("constraint system generation time is ", time.Since(startTime))
+	fmt.Println("aggregation constraints number is ", oCs.GetNbConstraints())
+	pk, vk, err := groth16.Setup(oCs)
+	if err != nil {
+		panic(err)
+	}
+	writeZkKeys(zkKeyName, circuit.ProvingSystemGroth16, pk, vk, oCs, utils.NewKeyParams(utils.KeyCircuitAggregation, assetsCountTier))
+	return vk
+}
+
+// GenerateAggregationKeys generates the keys of the aggregation circuits on
+// top of the groth16 batch keys in dir. For every tier,
+// level 1 aggregates batchCount batch proofs and level l aggregates batchCount
+// level l-1 proofs. The root circuit aggregates the level proofs of all tiers
+// in ascending order of assets count.
+func GenerateAggregationKeys(dir string, batchCount int, levels int) {
+	if batchCount <= 0 || levels <= 0 {
+		panic("aggregation batch count and levels should be positive")
+	}
+	rootInnerVks := make([]groth16.VerifyingKey, 0, len(utils.AssetCountsTiers))
+	for _, k := range utils.AssetCountsTiers {
+		v := utils.BatchCreateUserOpsCountsTiers[k]
+		batchZkKeyName := "zkpor" + strconv.FormatInt(int64(k), 10) + "_" + strconv.FormatInt(int64(v), 10)
+		vkFromFile, err := os.ReadFile(filepath.Join(dir, batchZkKeyName+".vk"))
+		if err != nil {
+			panic("batch verifying key load error, please generate the groth16 batch keys first: " + err.Error())
+		}
+		err = utils.CheckBatchKeyParams(filepath.Join(dir, batchZkKeyName+".vk"), k)
+		if err != nil {
+			panic(err.Error())
+		}
+		innerVk := groth16.NewVerifyingKey(ecc.BN254)
+		_, err = innerVk.ReadFrom(bytes.NewBuffer(vkFromFile))
+		if err != nil {
+			panic(err)
+		}
+		for level := 1; level <= levels; level++ {
+			innerVks := make([]groth16.VerifyingKey, batchCount)
+			for i := range innerVks {
+				innerVks[i] = innerVk
+			}
+			zkKeyName := "zkpor_agg" + strconv.FormatInt(int64(k), 10) + "_l" + strconv.Itoa(level)
+			fmt.Println("generating aggregation keys ", zkKeyName)
+			innerVk = setupAggregationKeys(filepath.Join(dir, zkKeyName), innerVks, k)
+		}
+		rootInnerVks = append(rootInnerVks, innerVk)
+	}
+	fmt.Println("generating aggregation keys zkpor_agg_root")
+	setupAggregationKeys(filepath.Join(dir, "zkpor_agg_root"), rootInnerVks, 0)
+}
+
+// ExportSolidityVerifiers writes a solidity verifier contract for the batch
+// verifying key of every tier in dir, the keys of the batch update user
+// circuits are used when incremental is set.
+func ExportSolidityVerifiers(dir string, provingSystem string, incremental bool) {
+	zkKeyPrefix, opsCountsTiers := batchKeyPrefix(incremental)
+	for k, v := range opsCountsTiers {
+		zkKeyName := filepath.Join(dir, zkKeyPrefix+strconv.FormatInt(int64(k), 10)+"_"+strconv.FormatInt(int64(v), 10))
+		if provingSystem == circuit.ProvingSystemPlonk {
+			zkKeyName += "_" + circuit.ProvingSystemPlonk
+		}
+		vkFromFile, err := os.ReadFile(zkKeyName + ".vk")
+		if err != nil {
+			panic("verifying key load error, please generate the batch keys first: " + err.Error())
+		}
+		err = utils.CheckBatchKeyParams(zkKeyName+".vk", k)
+		if err != nil {
+			panic(err.Error())
+		}
+		vk := circuit.NewVerifyingKey(provingSystem)
+		_, err = vk.ReadFrom(bytes.NewBuffer(vkFromFile))
+		if err != nil {
+			panic(err)
+		}
+		solFile, err := os.Create(zkKeyName + ".sol")
+		if err != nil {
+			panic(err)
+		}
+		err = vk.ExportSolidity(solFile)
+		if err != nil {
+			panic(err)
+		}
+		solFile.Close()
+		fmt.Println("export solidity verifier ", zkKeyName+".sol")
+	}
+}
+
+func loadKzgSRS(cs constraint.ConstraintSystem, srsFile string, unsafeSRS bool) (kzg.SRS, kzg.SRS) {
+	if unsafeSRS {
+		fmt.Println("WARNING: generating an unsafe kzg srs, the keys must only be used for testing")
+		srs, srsLagrange, err := unsafekzg.NewSRS(cs)
+		if err != nil {
+			panic(err)
+		}
+		return srs, srsLagrange
+	}
+	if srsFile == "" {
+		panic("plonk setup needs a kzg srs file, please specify it by -kzg_srs")
+	}
+	f, err := os.Open(srsFile)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	srs := &kzg_bn254.SRS{}
+	_, err = srs.ReadFrom(bufio.NewReaderSize(f, 1<<20))
+	if err != nil {
+		panic(err)
+	}
+	canonical, lagrange, err := circuit.NewKzgSRSForCircuit(cs, srs)
+	if err != nil {
+		panic(err)
+	}
+	return canonical, lagrange
+}
+
+func batchKeyPrefix(incremental bool) (string, map[int]int) {
+	if incremental {
+		return "zkpor_update", utils.BatchUpdateUserOpsCountsTiers
+	}
+	return "zkpor", utils.BatchCreateUserOpsCountsTiers
+}
+
+// GenerateUserInclusionKeys generates the keys of the zero-knowledge user
+// inclusion circuit in dir.
+func GenerateUserInclusionKeys(dir string, provingSystem string, kzgSrsFile string, unsafeKzgSrs bool) {
+	oCs, err := circuit.Compile(provingSystem, circuit.NewUserInclusionCircuit())
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("user inclusion constraints number is ", oCs.GetNbConstraints())
+	zkKeyName := filepath.Join(dir, "zkpor_user_inclusion")
+	var srs, srsLagrange kzg.SRS
+	if provingSystem == circuit.ProvingSystemPlonk {
+		zkKeyName += "_" + circuit.ProvingSystemPlonk
+		srs, srsLagrange = loadKzgSRS(oCs, kzgSrsFile, unsafeKzgSrs)
+	}
+	pk, vk, err := circuit.Setup(provingSystem, oCs, srs, srsLagrange)
+	if err != nil {
+		panic(err)
+	}
+	writeZkKeys(zkKeyName, provingSystem, pk, vk, oCs, utils.NewKeyParams(utils.KeyCircuitUserInclusion, 0))
+}
+
+// GenerateBatchKeys generates the keys of the batch create user circuits of
+// every tier in dir, or the keys of the batch update user circuits used by
+// incremental snapshots when incremental is set.
+func GenerateBatchKeys(dir string, provingSystem string, incremental bool, kzgSrsFile string, unsafeKzgSrs bool) {
+	zkKeyPrefix, opsCountsTiers := batchKeyPrefix(incremental)
+	for k, v := range opsCountsTiers {
+		var batchCircuit frontend.Circuit
+		if incremental {
+			batchCircuit = circuit.NewBatchUpdateUserCircuit(uint32(k), uint32(utils.AssetCounts), uint32(v))
+		} else {
+			batchCircuit = circuit.NewBatchCreateUserCircuit(uint32(k), uint32(utils.AssetCounts), uint32(v))
+		}
+		startTime := time.Now()
+		oCs, err := circuit.Compile(provingSystem, batchCircuit)
+		if err != nil {
+			panic(err)
+		}
+		endTime := time.Now()
+		fmt.Println("constraint system generation time is ", endTime.Sub(startTime))
+		fmt.Println("batch user constraints number is ", oCs.GetNbConstraints())
+		zkKeyName := filepath.Join(dir, zkKeyPrefix+strconv.FormatInt(int64(k), 10)+"_"+strconv.FormatInt(int64(v), 10))
+		var srs, srsLagrange kzg.SRS
+		if provingSystem == circuit.ProvingSystemPlonk {
+			// groth16 and plonk keys of the same tier can live in the same directory
+			zkKeyName += "_" + circuit.ProvingSystemPlonk
+			srs, srsLagrange = loadKzgSRS(oCs, kzgSrsFile, unsafeKzgSrs)
+		}
+		pk, vk, err := circuit.Setup(provingSystem, oCs, srs, srsLagrange)
+		if err != nil {
+			panic(err)
+		}
+		writeZkKeys(zkKeyName, provingSystem, pk, vk, oCs, utils.NewBatchKeyParams(k, incremental))
+	}
+}
+
+// StartPeriodicGC runs the garbage collector every 10 seconds, the setup of
+// the large circuits keeps a lot of garbage otherwise.
+func StartPeriodicGC() {
+	go func() {
+		for {
+			time.Sleep(time.Second * 10)
+			runtime.GC()
+		}
+	}()
+}
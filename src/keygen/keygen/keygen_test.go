@@ -0,0 +1,83 @@
+package keygen
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/circuit"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/consensys/gnark-crypto/kzg"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test/unsafekzg"
+)
+
+type squareCircuit struct {
+	X frontend.Variable
+	Y frontend.Variable `gnark:",public"`
+}
+
+func (c *squareCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(api.Mul(c.X, c.X), c.Y)
+	return nil
+}
+
+// TestWriteZkKeys checks that gnark still reads the key files which embed
+// the circuit params.
+func TestWriteZkKeys(t *testing.T) {
+	for _, provingSystem := range []string{circuit.ProvingSystemGroth16, circuit.ProvingSystemPlonk} {
+		cs, err := circuit.Compile(provingSystem, &squareCircuit{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var srs, srsLagrange kzg.SRS
+		if provingSystem == circuit.ProvingSystemPlonk {
+			srs, srsLagrange, err = unsafekzg.NewSRS(cs)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		pk, vk, err := circuit.Setup(provingSystem, cs, srs, srsLagrange)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zkKeyName := filepath.Join(t.TempDir(), "zkpor50_700")
+		params := utils.NewBatchKeyParams(50, false)
+		writeZkKeys(zkKeyName, provingSystem, pk, vk, cs, params)
+
+		f, _ := os.Open(zkKeyName + ".pk")
+		if _, err = circuit.NewProvingKey(provingSystem).UnsafeReadFrom(f); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		f, _ = os.Open(zkKeyName + ".vk")
+		if _, err = circuit.NewVerifyingKey(provingSystem).ReadFrom(f); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		f, _ = os.Open(zkKeyName + circuit.ConstraintSystemFileSuffix(provingSystem))
+		if _, err = circuit.NewConstraintSystem(provingSystem).ReadFrom(f); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		for _, suffix := range []string{".pk", ".vk", circuit.ConstraintSystemFileSuffix(provingSystem)} {
+			if err = utils.CheckBatchKeyParams(zkKeyName+suffix, 50); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = utils.CheckBatchKeyParams(zkKeyName+".vk", 500); err == nil {
+			t.Fatal("the key of tier 50 should not be accepted for tier 500")
+		}
+		if err = utils.CheckKeyParams(zkKeyName+".vk", utils.KeyCircuitUserInclusion); err == nil {
+			t.Fatal("the batch key should not be accepted for the user inclusion circuit")
+		}
+		entry, err := utils.CheckBatchKeyManifest(zkKeyName, 50, provingSystem, ".pk", ".vk", circuit.ConstraintSystemFileSuffix(provingSystem))
+		if err != nil || entry == nil {
+			t.Fatalf("the key should be in the key manifest %v", err)
+		}
+		if err = entry.CheckFile(zkKeyName, ".vk"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
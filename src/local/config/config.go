@@ -0,0 +1,17 @@
+package config
+
+type Config struct {
+	UserDataFile string
+	DbSuffix     string
+	// DataDir keeps the tables and the account tree, the pipeline resumes
+	// from it when it is restarted
+	DataDir          string
+	ZkKeyName        []string
+	AssetsCountTiers []int
+	// ProvingSystems is parallel to AssetsCountTiers, every tier defaults
+	// to groth16 when it is empty
+	ProvingSystems []string
+	// OutputDir receives the proof table and the config of the verifier
+	// service, nothing is written when it is empty
+	OutputDir string
+}
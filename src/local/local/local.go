@@ -0,0 +1,278 @@
+package local
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/circuit"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/local/config"
+	proverConfig "github.com/binance/zkmerkle-proof-of-solvency/src/prover/config"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/prover/prover"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	verifierConfig "github.com/binance/zkmerkle-proof-of-solvency/src/verifier/config"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/verifier/verifier"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/witness"
+	bsmt "github.com/bnb-chain/zkbnb-smt"
+)
+
+const userProofsPerWrite = 100
+
+// runWitness generates the batch witness of all accounts, it resumes from the
+// latest witness in the table like the witness service.
+func runWitness(accountTree bsmt.SparseMerkleTree, accounts map[int][]utils.AccountInfo,
+	cexAssets []utils.CexAssetInfo, assetRegistryHash string, witnessModel witness.WitnessModel) {
+	// the witness service pads the accounts of every tier
+	ops := make(map[int][]utils.AccountInfo, len(accounts))
+	totalAccountNum := 0
+	for k, v := range accounts {
+		ops[k] = v
+		totalAccountNum += len(v)
+		fmt.Println("the asset counts of user is ", k, "total ops number is ", len(v))
+	}
+	witnessCexAssets := make([]utils.CexAssetInfo, len(cexAssets))
+	copy(witnessCexAssets, cexAssets)
+	witness.NewWitnessWithModel(accountTree, uint32(totalAccountNum), utils.NewMemoryAccountSource(ops), witnessCexAssets, assetRegistryHash, witnessModel).Run()
+}
+
+// runProver proves the published witnesses through the in-process task queue,
+// then the witnesses received before a crash are proved by the rerun mode.
+func runProver(localConfig *config.Config, witnessModel witness.WitnessModel, proofModel prover.ProofModel) {
+	taskQueue := prover.NewLocalTaskQueue()
+	for offset := 0; ; {
+		heights, err := witnessModel.GetAllBatchHeightsByStatus(witness.StatusPublished, 1024, offset)
+		if err == utils.DbErrNotFound {
+			break
+		}
+		if err != nil {
+			panic(err.Error())
+		}
+		for _, height := range heights {
+			taskQueue.PushTask(int(height))
+		}
+		offset += len(heights)
+	}
+	p := prover.NewProverWithModels(&proverConfig.Config{
+		ZkKeyName:        localConfig.ZkKeyName,
+		AssetsCountTiers: localConfig.AssetsCountTiers,
+		ProvingSystems:   localConfig.ProvingSystems,
+	}, witnessModel, proofModel, taskQueue)
+	p.Run(false)
+	p.Run(true)
+}
+
+// runUserProof writes the merkle proofs of all accounts, it resumes from the
+// number of accounts in the table like the userproof service.
+func runUserProof(accountTree bsmt.SparseMerkleTree, accounts map[int][]utils.AccountInfo, assetRegistryHash string, userProofModel model.UserProofModel) {
+	currentAccountCounts, err := userProofModel.GetUserCounts()
+	if err != nil {
+		panic(err.Error())
+	}
+	keys := make([]int, 0, len(accounts))
+	for k := range accounts {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	root := hex.EncodeToString(accountTree.Root())
+	rows := make([]model.UserProof, 0, userProofsPerWrite)
+	prevAccountCounts := 0
+	for _, k := range keys {
+		for i := range accounts[k] {
+			if prevAccountCounts+i < currentAccountCounts {
+				continue
+			}
+			account := &accounts[k][i]
+			leaf, err := accountTree.Get(uint64(account.AccountIndex), nil)
+			if err != nil {
+				panic(err.Error())
+			}
+			proof, err := accountTree.GetProof(uint64(account.AccountIndex))
+			if err != nil {
+				panic(err.Error())
+			}
+			rows = append(rows, *model.ConvertAccount(account, leaf, proof, root, assetRegistryHash))
+			if len(rows) == userProofsPerWrite {
+				err = userProofModel.CreateUserProofs(rows)
+				if err != nil {
+					panic(err.Error())
+				}
+				rows = rows[:0]
+			}
+		}
+		prevAccountCounts += len(accounts[k])
+	}
+	err = userProofModel.CreateUserProofs(rows)
+	if err != nil {
+		panic(err.Error())
+	}
+	fmt.Println("total write ", prevAccountCounts-currentAccountCounts, " user proofs")
+}
+
+// verifyProofs writes the proof table and verifies it by the verifier
+// service, the proofs must be chained from the empty account tree to
+// finalAccountTreeRoot and to the cex assets computed from the accounts.
+func verifyProofs(localConfig *config.Config, outputDir string, proofs []*prover.Proof, cexAssets []utils.CexAssetInfo,
+	finalAccountTreeRoot []byte, assetRegistryHash string) *verifierConfig.Config {
+	proofTable := filepath.Join(outputDir, "proof.csv")
+	err := prover.WriteProofTable(proofTable, proofs)
+	if err != nil {
+		panic(err.Error())
+	}
+	verifierCfg := &verifierConfig.Config{
+		ProofTable:        proofTable,
+		ZkKeyName:         localConfig.ZkKeyName,
+		AssetsCountTiers:  localConfig.AssetsCountTiers,
+		ProvingSystems:    localConfig.ProvingSystems,
+		CexAssetsInfo:     cexAssets,
+		AccountTreeRoot:   hex.EncodeToString(finalAccountTreeRoot),
+		AssetRegistryHash: assetRegistryHash,
+	}
+	// the verifier checks the hash of the registry file, there is no file
+	// to check when the default registry is used
+	assetRegistryFile := filepath.Join(localConfig.UserDataFile, utils.AssetRegistryFile)
+	if _, err := os.Stat(assetRegistryFile); err == nil {
+		verifierCfg.AssetRegistry = assetRegistryFile
+	}
+	verifier.PrepareConfig(verifierCfg)
+	if !verifier.VerifyBatchProofs(verifierCfg) {
+		panic("the batch proofs verify failed")
+	}
+	return verifierCfg
+}
+
+// writeVerifierConfig writes the config which verifies the proof table in
+// OutputDir by the verifier service.
+func writeVerifierConfig(localConfig *config.Config, verifierCfg *verifierConfig.Config) {
+	content, err := json.MarshalIndent(verifierCfg, "", "  ")
+	if err != nil {
+		panic(err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(localConfig.OutputDir, "verifier_config.json"), content, 0644)
+	if err != nil {
+		panic(err.Error())
+	}
+	fmt.Println("write the proof table and the verifier config to ", localConfig.OutputDir)
+}
+
+// Run generates the witness, the batch proofs and the user proofs of the
+// user data in one process, verifies the batch proofs and writes the output
+// for the verifier when OutputDir is set.
+func Run(localConfig *config.Config) {
+	var err error
+	if len(localConfig.AssetsCountTiers) != len(localConfig.ZkKeyName) {
+		panic("asset tiers and asset tier names should have the same length")
+	}
+	if len(localConfig.ProvingSystems) == 0 {
+		localConfig.ProvingSystems = make([]string, len(localConfig.AssetsCountTiers))
+	}
+	if len(localConfig.ProvingSystems) != len(localConfig.AssetsCountTiers) {
+		panic("asset tiers and proving systems should have the same length")
+	}
+	for i := range localConfig.ProvingSystems {
+		localConfig.ProvingSystems[i], err = circuit.NormalizeProvingSystem(localConfig.ProvingSystems[i])
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	err = utils.CheckAssetsCountTiers(localConfig.AssetsCountTiers)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	db, err := utils.NewEmbeddedDB(filepath.Join(localConfig.DataDir, "db"))
+	if err != nil {
+		panic(err.Error())
+	}
+	defer db.Close()
+	accountTree, err := utils.NewAccountTree("leveldb", filepath.Join(localConfig.DataDir, "accounttree"))
+	if err != nil {
+		panic(err.Error())
+	}
+	fmt.Println("account tree init height is ", accountTree.LatestVersion())
+	witnessModel := witness.NewEmbeddedWitnessModel(db, localConfig.DbSuffix)
+	proofModel := prover.NewEmbeddedProofModel(db, localConfig.DbSuffix)
+	userProofModel := model.NewEmbeddedUserProofModel(db, localConfig.DbSuffix)
+
+	accounts, cexAssets, err := utils.ParseUserDataSet(localConfig.UserDataFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	assetRegistry, err := utils.LoadAssetRegistryFromDir(localConfig.UserDataFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	// the expected cex assets are computed from the accounts directly
+	expectedCexAssets := make([]utils.CexAssetInfo, len(cexAssets))
+	copy(expectedCexAssets, cexAssets)
+	for i := range expectedCexAssets {
+		expectedCexAssets[i].TotalEquity = 0
+		expectedCexAssets[i].TotalDebt = 0
+		expectedCexAssets[i].LoanCollateral = 0
+		expectedCexAssets[i].MarginCollateral = 0
+		expectedCexAssets[i].PortfolioMarginCollateral = 0
+	}
+	totalAccountNum := 0
+	for _, v := range accounts {
+		totalAccountNum += len(v)
+		for i := range v {
+			for j := range v[i].Assets {
+				utils.AddAccountAsset(expectedCexAssets, &v[i].Assets[j])
+			}
+		}
+	}
+
+	fmt.Println("begin to generate witness...")
+	runWitness(accountTree, accounts, cexAssets, assetRegistry.Hash, witnessModel)
+	latestWitness, err := witnessModel.GetLatestBatchWitness()
+	if err != nil {
+		panic(err.Error())
+	}
+	_, finalAccountTreeRoot := witness.RecoverAfterState(latestWitness)
+
+	fmt.Println("begin to generate proof...")
+	runProver(localConfig, witnessModel, proofModel)
+
+	fmt.Println("begin to generate user proof...")
+	runUserProof(accountTree, accounts, assetRegistry.Hash, userProofModel)
+	userCounts, err := userProofModel.GetUserCounts()
+	if err != nil {
+		panic(err.Error())
+	}
+	if userCounts != totalAccountNum {
+		fmt.Println("user proof counts actual:expected", userCounts, totalAccountNum)
+		panic("mismatch num")
+	}
+
+	fmt.Println("begin to verify proof...")
+	proofs, err := proofModel.GetProofsBetween(0, latestWitness.Height)
+	if err != nil {
+		panic(err.Error())
+	}
+	if int64(len(proofs)) != latestWitness.Height+1 {
+		fmt.Println("proof counts actual:expected", len(proofs), latestWitness.Height+1)
+		panic("some batches are not proved")
+	}
+	outputDir := localConfig.OutputDir
+	if outputDir == "" {
+		outputDir, err = os.MkdirTemp("", "zkpor_local")
+		if err != nil {
+			panic(err.Error())
+		}
+		defer os.RemoveAll(outputDir)
+	} else {
+		err = os.MkdirAll(outputDir, 0755)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	verifierCfg := verifyProofs(localConfig, outputDir, proofs, expectedCexAssets, finalAccountTreeRoot, assetRegistry.Hash)
+	if localConfig.OutputDir != "" {
+		writeVerifierConfig(localConfig, verifierCfg)
+	}
+	fmt.Printf("local pipeline run finished, the account tree root is %x\n", finalAccountTreeRoot)
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/zkpor/zkpor"
+)
+
+// local runs zkpor local, the config is config/config.json by default
+func main() {
+	p := &zkpor.Program{Name: "local", Groups: []string{"local"}, DefaultConfig: "config/config.json"}
+	p.Main(os.Args[1:])
+}
@@ -0,0 +1,335 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/bundle"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/config"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/prover/prover"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/userproof"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/verifier/verifier"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/witness"
+)
+
+const (
+	StageWitness   = "witness"
+	StageProve     = "prove"
+	StageUserProof = "userproof"
+	StageVerify    = "verify"
+)
+
+const (
+	StageStatusFinished = "finished"
+	StageStatusSkipped  = "skipped"
+	StageStatusFailed   = "failed"
+)
+
+// StageResult is the result of a stage in this run, the stages finished by
+// the previous runs are skipped
+type StageResult struct {
+	Name     string
+	Status   string
+	Duration time.Duration
+	Detail   string
+}
+
+type Summary struct {
+	Stages []StageResult
+	Err    error
+}
+
+func (s *Summary) Print() {
+	fmt.Println("pipeline summary:")
+	for _, stage := range s.Stages {
+		fmt.Printf("  %-10s %-9s %-12s %s\n", stage.Name, stage.Status, stage.Duration.Round(time.Second), stage.Detail)
+	}
+	if s.Err != nil {
+		fmt.Println("pipeline failed:", s.Err.Error())
+	} else {
+		fmt.Println("pipeline finished")
+	}
+}
+
+// Orchestrator drives the pipeline of a snapshot: it generates the witness,
+// pushes the batches to the task queue, waits for the provers and proves the
+// stuck batches itself, then generates the user proofs and verifies the
+// batch proofs. The finished stages are recorded in the stage table.
+type Orchestrator struct {
+	config         *config.Config
+	db             *utils.DB
+	witnessModel   witness.WitnessModel
+	userProofModel model.UserProofModel
+	stageModel     StageModel
+	taskQueue      prover.ManagedTaskQueue
+	// PollInterval is the interval of checking the witness counts
+	PollInterval time.Duration
+	// StallTimeout is the time without new proofs and live leases after
+	// which the provers are regarded as gone
+	StallTimeout time.Duration
+	// MaxRecoveries is the number of times the stuck batches are proved in
+	// this process before the orchestrator gives up
+	MaxRecoveries int
+	// proveStuckBatches proves the tasks of the task queue until it is empty
+	proveStuckBatches func()
+	stuckProver       *prover.Prover
+}
+
+func NewOrchestrator(c *config.Config) *Orchestrator {
+	db, err := utils.NewDBWithDriver(c.DbDriver, c.MysqlDataSource)
+	if err != nil {
+		panic(err.Error())
+	}
+	o := &Orchestrator{
+		config:         c,
+		db:             db,
+		witnessModel:   witness.NewWitnessModel(db, c.DbSuffix),
+		userProofModel: model.NewUserProofModel(db, c.DbSuffix),
+		stageModel:     NewStageModel(db, c.DbSuffix),
+		taskQueue:      prover.NewTaskQueue(c.ProverConfig()),
+		PollInterval:   30 * time.Second,
+		StallTimeout:   8 * time.Minute,
+		MaxRecoveries:  3,
+	}
+	o.proveStuckBatches = func() {
+		// the prover is kept, so the keys are loaded only once
+		if o.stuckProver == nil {
+			proverConfig := o.config.ProverConfig()
+			proverConfig.MetricsAddr = ""
+			prover.CheckConfig(proverConfig)
+			o.stuckProver = prover.NewProver(proverConfig)
+		}
+		o.stuckProver.Run(false)
+	}
+	return o
+}
+
+// Run runs the stages which are not finished yet, it stops at the first
+// failed stage.
+func (o *Orchestrator) Run() *Summary {
+	summary := &Summary{}
+	err := o.stageModel.CreateStageTable()
+	if err != nil {
+		summary.Err = err
+		return summary
+	}
+	utils.StartMetricsServer(o.config.MetricsAddr)
+	stages := []struct {
+		name string
+		run  func() (string, error)
+	}{
+		{StageWitness, o.generateWitness},
+		{StageProve, o.waitForProofs},
+		{StageUserProof, o.generateUserProofs},
+		{StageVerify, o.verifyProofs},
+	}
+	for _, stage := range stages {
+		finished, err := o.stageModel.GetStage(stage.name)
+		if err == nil {
+			summary.Stages = append(summary.Stages, StageResult{Name: stage.name, Status: StageStatusSkipped, Detail: finished.Detail})
+			continue
+		}
+		if err != utils.DbErrNotFound {
+			summary.Err = err
+			return summary
+		}
+		fmt.Println("begin to run stage", stage.name)
+		startTime := time.Now()
+		detail, err := runStage(stage.run)
+		result := StageResult{Name: stage.name, Status: StageStatusFinished, Duration: time.Since(startTime), Detail: detail}
+		if err == nil {
+			err = o.stageModel.FinishStage(stage.name, detail)
+		}
+		if err != nil {
+			result.Status = StageStatusFailed
+			result.Detail = err.Error()
+			summary.Stages = append(summary.Stages, result)
+			summary.Err = fmt.Errorf("stage %s: %w", stage.name, err)
+			return summary
+		}
+		summary.Stages = append(summary.Stages, result)
+	}
+	return summary
+}
+
+// runStage converts the panic of the services to the error of the stage.
+func runStage(run func() (string, error)) (detail string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return run()
+}
+
+func (o *Orchestrator) getRowCounts() ([]int64, error) {
+	for {
+		counts, err := o.witnessModel.GetRowCounts()
+		if err == utils.DbErrQueryInterrupted || err == utils.DbErrQueryTimeout {
+			fmt.Println("get witness counts timeout, retry...:", err.Error())
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		return counts, err
+	}
+}
+
+func (o *Orchestrator) generateWitness() (string, error) {
+	witnessConfig := o.config.WitnessConfig()
+	witnessConfig.MetricsAddr = ""
+	witness.RunService(witnessConfig)
+	counts, err := o.getRowCounts()
+	if err != nil {
+		return "", err
+	}
+	if counts[0] == 0 {
+		return "", errors.New("no witness is generated")
+	}
+	return fmt.Sprintf("%d batches", counts[0]), nil
+}
+
+// pushMissingTasks pushes the batches which are not finished and are neither
+// in the task queues nor leased to the queues of their tiers.
+func (o *Orchestrator) pushMissingTasks() error {
+	err := o.witnessModel.UpgradeBatchWitnessTable()
+	if err != nil {
+		return err
+	}
+	pending, err := o.taskQueue.PendingTasks()
+	if err != nil {
+		return err
+	}
+	missing := make(map[int][]int)
+	missingCount := 0
+	for _, status := range []int64{witness.StatusPublished, witness.StatusReceived} {
+		for offset := 0; ; {
+			tasks, err := o.witnessModel.GetAllBatchTasksByStatus(status, 1024, offset)
+			if err == utils.DbErrQueryInterrupted || err == utils.DbErrQueryTimeout {
+				fmt.Println("get witness heights timeout, retry...:", err.Error())
+				time.Sleep(1 * time.Second)
+				continue
+			}
+			if err == utils.DbErrNotFound {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			for _, task := range tasks {
+				if !pending[int(task.Height)] {
+					tier := int(task.AssetsCount)
+					missing[tier] = append(missing[tier], int(task.Height))
+					missingCount++
+				}
+			}
+			offset += len(tasks)
+		}
+	}
+	if missingCount > 0 {
+		fmt.Printf("push %d tasks to the task queue\n", missingCount)
+	}
+	for tier, heights := range missing {
+		err = o.taskQueue.PushTasks(tier, heights)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForProofs waits until all batches are finished. When no proof is
+// generated within StallTimeout and no prover holds a lease, the provers are
+// gone and the batches left are proved in this process.
+func (o *Orchestrator) waitForProofs() (string, error) {
+	err := o.pushMissingTasks()
+	if err != nil {
+		return "", err
+	}
+	recoveries := 0
+	lastFinished := int64(-1)
+	lastProgress := time.Now()
+	for {
+		counts, err := o.getRowCounts()
+		if err != nil {
+			return "", err
+		}
+		fmt.Printf("total witness item %d, published item %d, pending item %d, finished item %d\n", counts[0], counts[1], counts[2], counts[3])
+		if counts[3] == counts[0] {
+			return fmt.Sprintf("%d batches proved, %d recoveries", counts[0], recoveries), nil
+		}
+		liveLeases, err := o.taskQueue.LiveLeases()
+		if err != nil {
+			return "", err
+		}
+		if counts[3] != lastFinished || liveLeases > 0 {
+			lastFinished = counts[3]
+			lastProgress = time.Now()
+		} else if time.Since(lastProgress) >= o.StallTimeout {
+			if recoveries == o.MaxRecoveries {
+				return "", fmt.Errorf("%d batches are not proved after %d recoveries", counts[0]-counts[3], recoveries)
+			}
+			recoveries++
+			fmt.Printf("no proof is generated in %s and no prover holds a lease, prove the batches left in process\n", o.StallTimeout)
+			err = o.pushMissingTasks()
+			if err != nil {
+				return "", err
+			}
+			o.proveStuckBatches()
+			lastProgress = time.Now()
+			continue
+		}
+		time.Sleep(o.PollInterval)
+	}
+}
+
+func (o *Orchestrator) generateUserProofs() (string, error) {
+	userProofConfig := o.config.UserProofConfig()
+	userProofConfig.MetricsAddr = ""
+	userproof.Run(userProofConfig)
+	userCounts, err := o.userProofModel.GetUserCounts()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d accounts", userCounts), nil
+}
+
+// verifyProofs exports the audit bundle to OutputDir and verifies it in the
+// same way as the third-party verifiers.
+func (o *Orchestrator) verifyProofs() (string, error) {
+	content, err := bundle.Collect(o.db, o.config.DbSuffix, o.config.PrevDbSuffix)
+	if err != nil {
+		return "", err
+	}
+	content.ZkKeyName = o.config.ZkKeyName
+	content.AssetsCountTiers = o.config.AssetsCountTiers
+	content.ProvingSystems = o.config.ProvingSystems
+	content.RecursiveProof = o.config.RecursiveProof
+	content.SolidityProof = o.config.SolidityProof
+	assetRegistryFile := filepath.Join(o.config.UserDataFile, utils.AssetRegistryFile)
+	if _, err := os.Stat(assetRegistryFile); err == nil {
+		content.AssetRegistry = assetRegistryFile
+	}
+
+	outputDir := o.config.OutputDir
+	if outputDir == "" {
+		outputDir, err = os.MkdirTemp("", "zkpor")
+		if err != nil {
+			return "", err
+		}
+		defer os.RemoveAll(outputDir)
+	}
+	bundleDir := filepath.Join(outputDir, "bundle")
+	manifest, err := bundle.Write(bundleDir, content)
+	if err != nil {
+		return "", err
+	}
+	if !verifier.VerifyBundle(bundleDir) {
+		return "", errors.New("the batch proofs verify failed")
+	}
+	return fmt.Sprintf("account tree root %s", manifest.AccountTreeRoot), nil
+}
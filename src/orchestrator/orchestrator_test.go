@@ -0,0 +1,108 @@
+package orchestrator
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/prover/prover"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/witness"
+)
+
+func TestWaitForProofs(t *testing.T) {
+	db, err := utils.NewEmbeddedDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	witnessModel := witness.NewEmbeddedWitnessModel(db, "test")
+	witnesses := make([]witness.BatchWitness, 5)
+	for i := range witnesses {
+		witnesses[i] = witness.BatchWitness{Height: int64(i), WitnessData: "data", Status: witness.StatusPublished}
+	}
+	if err = witnessModel.CreateBatchWitness(witnesses); err != nil {
+		t.Fatal(err)
+	}
+	// the prover of batch 1 is gone after receiving it, batch 4 is still
+	// in the queue
+	if _, err = witnessModel.GetAndUpdateBatchesWitnessByHeight(1, witness.StatusPublished, witness.StatusReceived); err != nil {
+		t.Fatal(err)
+	}
+	taskQueue := prover.NewLocalTaskQueue()
+	taskQueue.PushTask(4)
+
+	calls := 0
+	o := &Orchestrator{
+		witnessModel:  witnessModel,
+		taskQueue:     taskQueue,
+		PollInterval:  time.Millisecond,
+		StallTimeout:  10 * time.Millisecond,
+		MaxRecoveries: 3,
+	}
+	o.proveStuckBatches = func() {
+		calls++
+		pending, _ := taskQueue.PendingTasks()
+		if len(pending) != 5 {
+			t.Errorf("unexpected tasks %v", pending)
+		}
+		// the first recovery fails and leaves the tasks in the queue
+		if calls == 1 {
+			return
+		}
+		for {
+			height, err := taskQueue.PopTask()
+			if err != nil {
+				return
+			}
+			w, _ := witnessModel.GetBatchWitnessByHeight(int64(height))
+			witnessModel.UpdateBatchWitnessStatus(w, witness.StatusFinished)
+		}
+	}
+	detail, err := o.waitForProofs()
+	if err != nil || calls != 2 || detail != "5 batches proved, 2 recoveries" {
+		t.Fatalf("unexpected result %s %v %d", detail, err, calls)
+	}
+
+	// give up when the batches are never proved
+	if err = witnessModel.CreateBatchWitness([]witness.BatchWitness{{Height: 5, WitnessData: "data", Status: witness.StatusPublished}}); err != nil {
+		t.Fatal(err)
+	}
+	calls = 0
+	o.proveStuckBatches = func() { calls++ }
+	o.MaxRecoveries = 1
+	if _, err = o.waitForProofs(); err == nil || calls != 1 {
+		t.Fatalf("unexpected result %v %d", err, calls)
+	}
+}
+
+func TestStageModel(t *testing.T) {
+	if !utils.SqliteEnabled() {
+		t.Skip("the sqlite driver is built with -tags sqlite")
+	}
+	db, err := utils.NewDBWithDriver(utils.DbDriverSqlite, filepath.Join(t.TempDir(), "zkpor.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stageModel := NewStageModel(db, "test")
+	if err = stageModel.CreateStageTable(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stageModel.GetStage(StageWitness); err != utils.DbErrNotFound {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err = stageModel.FinishStage(StageWitness, "5 batches"); err != nil {
+		t.Fatal(err)
+	}
+	stage, err := stageModel.GetStage(StageWitness)
+	if err != nil || stage.Detail != "5 batches" {
+		t.Fatalf("unexpected stage %v %v", stage, err)
+	}
+	// a stage is finished only once
+	if err = stageModel.FinishStage(StageWitness, "5 batches"); err == nil {
+		t.Fatal("the stage should not be finished twice")
+	}
+	if err = stageModel.DropStageTable(); err != nil {
+		t.Fatal(err)
+	}
+}
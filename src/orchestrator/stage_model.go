@@ -0,0 +1,84 @@
+package orchestrator
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+)
+
+const (
+	StageTableNamePrefix = "pipeline_stage"
+)
+
+type (
+	// StageModel records the finished stages of the pipeline, so that the
+	// orchestrator skips them when it is restarted
+	StageModel interface {
+		CreateStageTable() error
+		DropStageTable() error
+		GetStage(name string) (stage *Stage, err error)
+		FinishStage(name string, detail string) error
+	}
+
+	defaultStageModel struct {
+		table string
+		db    *utils.DB
+	}
+
+	Stage struct {
+		ID        uint64
+		CreatedAt time.Time
+		Name      string
+		Detail    string
+	}
+)
+
+func NewStageModel(db *utils.DB, suffix string) StageModel {
+	return &defaultStageModel{
+		table: StageTableNamePrefix + suffix,
+		db:    db,
+	}
+}
+
+func (m *defaultStageModel) CreateStageTable() error {
+	primaryKey := "BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY"
+	if m.db.Driver() != utils.DbDriverMysql {
+		primaryKey = m.db.AutoIncrementPrimaryKey()
+	}
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id %s,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		name VARCHAR(32) NOT NULL UNIQUE,
+		detail TEXT NOT NULL
+	)`, m.table, primaryKey)
+	_, err := m.db.Exec(query)
+	return err
+}
+
+func (m *defaultStageModel) DropStageTable() error {
+	query := fmt.Sprintf("DROP TABLE IF EXISTS %s", m.table)
+	_, err := m.db.Exec(query)
+	return err
+}
+
+func (m *defaultStageModel) GetStage(name string) (stage *Stage, err error) {
+	query := fmt.Sprintf("SELECT id, created_at, name, detail FROM %s WHERE name = ?", m.table)
+	row := m.db.QueryRowWithTimeout(query, name)
+	stage = &Stage{}
+	err = row.Scan(&stage.ID, &stage.CreatedAt, &stage.Name, &stage.Detail)
+	if err == sql.ErrNoRows {
+		return nil, utils.DbErrNotFound
+	}
+	if err != nil {
+		return nil, utils.ConvertSqlErrToDbErr(err)
+	}
+	return stage, nil
+}
+
+func (m *defaultStageModel) FinishStage(name string, detail string) error {
+	query := fmt.Sprintf("INSERT INTO %s (name, detail) VALUES (?, ?)", m.table)
+	_, err := m.db.Exec(query, name, detail)
+	return err
+}
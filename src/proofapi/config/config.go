@@ -0,0 +1,26 @@
+package config
+
+type Config struct {
+	// DbDriver is mysql, postgres or sqlite3, it defaults to mysql. MysqlDataSource
+	// is the data source of the driver
+	DbDriver        string
+	MysqlDataSource string
+	DbSuffix        string
+	// ListenAddr is the listen address of the http api, such as ":8080"
+	ListenAddr string
+	// RateLimit is the number of requests per second allowed for a client,
+	// RateBurst is the number of requests a client can make at once. The
+	// rate is not limited when RateLimit is 0
+	RateLimit float64
+	RateBurst int
+	// TrustForwardedFor takes the client address from the rightmost entry of
+	// the X-Forwarded-For header, it must only be set behind a trusted proxy
+	// which appends the address of its client
+	TrustForwardedFor bool
+	// AuthTokens is optional, the requests must carry one of the tokens in
+	// the "Authorization: Bearer <token>" header when it is set
+	AuthTokens []string
+	// MetricsAddr is the listen address of the prometheus /metrics endpoint,
+	// such as ":9100". The endpoint is disabled when it is empty
+	MetricsAddr string
+}
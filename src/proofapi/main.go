@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/zkpor/zkpor"
+)
+
+// proofapi runs zkpor userproof serve, the config is config/config.json by default
+func main() {
+	p := &zkpor.Program{Name: "proofapi", Groups: []string{"userproof serve"}, DefaultConfig: "config/config.json"}
+	p.Main(os.Args[1:])
+}
@@ -0,0 +1,79 @@
+package proofapi
+
+import (
+	"sync"
+	"time"
+)
+
+// minSweepClients is the number of clients tracked before the idle clients
+// are removed
+const minSweepClients = 1 << 16
+
+// rateLimiter is a token bucket for every client, a bucket is refilled with
+// rate tokens per second up to burst tokens.
+type rateLimiter struct {
+	sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*bucket
+	nextSweep int
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   make(map[string]*bucket),
+		nextSweep: minSweepClients,
+		now:       time.Now,
+	}
+}
+
+// allow takes a token from the bucket of the client, it returns the time
+// until the next token when the bucket is empty.
+func (l *rateLimiter) allow(client string) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	now := l.now()
+	b, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= l.nextSweep {
+			l.sweep(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens -= 1
+	return true, 0
+}
+
+// sweep removes the buckets which are full again, they are the same as the
+// buckets of new clients.
+func (l *rateLimiter) sweep(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+	l.nextSweep = 2 * len(l.buckets)
+	if l.nextSweep < minSweepClients {
+		l.nextSweep = minSweepClients
+	}
+}
@@ -0,0 +1,263 @@
+package proofapi
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/proofapi/config"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+)
+
+// snapshotCacheSeconds is how long the snapshot metadata is cached, counting
+// the users of a large table is slow
+const snapshotCacheSeconds = 60
+
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Snapshot is the metadata of the snapshot which the user proofs belong to.
+type Snapshot struct {
+	Root              string
+	AssetRegistryHash string
+	AccountCount      int
+}
+
+// UserProofResponse is the user config consumed by `verifier user`, the
+// verifier ignores the snapshot metadata.
+type UserProofResponse struct {
+	model.UserConfig
+	Snapshot  *Snapshot
+	CreatedAt time.Time
+}
+
+type errorResponse struct {
+	Error string
+}
+
+// Server is the read-only http api of the user proofs.
+type Server struct {
+	userProofModel    model.UserProofModel
+	limiter           *rateLimiter
+	trustForwardedFor bool
+	// Authorize is called before every request, the request is rejected
+	// with 401 when it returns an error. No authorization is needed when it
+	// is nil
+	Authorize func(r *http.Request) error
+
+	snapshotLock      sync.Mutex
+	snapshot          *Snapshot
+	snapshotUpdatedAt time.Time
+}
+
+func NewServer(proofApiConfig *config.Config) *Server {
+	db, err := utils.NewDBWithDriver(proofApiConfig.DbDriver, proofApiConfig.MysqlDataSource)
+	if err != nil {
+		panic(err.Error())
+	}
+	s := newServer(model.NewUserProofModel(db, proofApiConfig.DbSuffix), proofApiConfig)
+	if len(proofApiConfig.AuthTokens) != 0 {
+		s.Authorize = TokenAuthorizer(proofApiConfig.AuthTokens)
+	}
+	return s
+}
+
+func newServer(userProofModel model.UserProofModel, proofApiConfig *config.Config) *Server {
+	s := &Server{
+		userProofModel:    userProofModel,
+		trustForwardedFor: proofApiConfig.TrustForwardedFor,
+	}
+	if proofApiConfig.RateLimit > 0 {
+		s.limiter = newRateLimiter(proofApiConfig.RateLimit, proofApiConfig.RateBurst)
+	}
+	return s
+}
+
+// TokenAuthorizer accepts the requests with one of the bearer tokens.
+func TokenAuthorizer(tokens []string) func(r *http.Request) error {
+	return func(r *http.Request) error {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			return ErrUnauthorized
+		}
+		for _, t := range tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+				return nil
+			}
+		}
+		return ErrUnauthorized
+	}
+}
+
+// Handler serves
+//
+//	GET /v1/snapshot
+//	GET /v1/userproofs/{accountIdHash}
+//	GET /v1/userproofs/index/{accountIndex}
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/snapshot", s.wrap("snapshot", s.getSnapshot))
+	mux.HandleFunc("GET /v1/userproofs/{accountIdHash}", s.wrap("userproof_by_id", s.getUserProofById))
+	mux.HandleFunc("GET /v1/userproofs/index/{accountIndex}", s.wrap("userproof_by_index", s.getUserProofByIndex))
+	return mux
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+	fmt.Println("serve user proofs on", addr)
+	return server.ListenAndServe()
+}
+
+// wrap applies the rate limit and the auth hook to the handler, the handler
+// returns the status code and the response.
+func (s *Server) wrap(endpoint string, handler func(r *http.Request) (int, interface{})) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, resp := s.serve(w, r, handler)
+		utils.ProofApiRequests.WithLabelValues(endpoint, strconv.Itoa(code)).Inc()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// serve limits the rate of the client address before the authorization, so
+// the requests with a wrong token are limited as well.
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, handler func(r *http.Request) (int, interface{})) (int, interface{}) {
+	if s.limiter != nil {
+		if ok, wait := s.limiter.allow(s.clientAddr(r)); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			return http.StatusTooManyRequests, errorResponse{"too many requests"}
+		}
+	}
+	if s.Authorize != nil {
+		if err := s.Authorize(r); err != nil {
+			return http.StatusUnauthorized, errorResponse{err.Error()}
+		}
+	}
+	return handler(r)
+}
+
+// clientAddr is the key of the rate limit. Behind a trusted proxy it is the
+// rightmost X-Forwarded-For entry, which is appended by the proxy, the entries
+// before it are sent by the client and can be anything.
+func (s *Server) clientAddr(r *http.Request) string {
+	if s.trustForwardedFor {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) != 0 {
+			last := forwarded[len(forwarded)-1]
+			if i := strings.LastIndex(last, ","); i >= 0 {
+				last = last[i+1:]
+			}
+			if client := strings.TrimSpace(last); client != "" {
+				return client
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func dbErrorResponse(err error) (int, interface{}) {
+	if err == utils.DbErrNotFound {
+		return http.StatusNotFound, errorResponse{"user proof not found"}
+	}
+	fmt.Println("query user proof failed:", err.Error())
+	utils.ServiceErrors.WithLabelValues("proofapi", "query").Inc()
+	return http.StatusInternalServerError, errorResponse{"internal error"}
+}
+
+// getSnapshot returns the snapshot metadata, it is taken from the first user
+// proof and cached for snapshotCacheSeconds.
+func (s *Server) getSnapshot(r *http.Request) (int, interface{}) {
+	snapshot, err := s.cachedSnapshot()
+	if err != nil {
+		return dbErrorResponse(err)
+	}
+	return http.StatusOK, snapshot
+}
+
+func (s *Server) cachedSnapshot() (*Snapshot, error) {
+	s.snapshotLock.Lock()
+	defer s.snapshotLock.Unlock()
+	if s.snapshot != nil && time.Since(s.snapshotUpdatedAt) < snapshotCacheSeconds*time.Second {
+		return s.snapshot, nil
+	}
+	userProof, err := s.userProofModel.GetUserProofByIndex(0)
+	if err != nil {
+		return nil, err
+	}
+	var userConfig model.UserConfig
+	err = json.Unmarshal([]byte(userProof.Config), &userConfig)
+	if err != nil {
+		return nil, err
+	}
+	accountCount, err := s.userProofModel.GetUserCounts()
+	if err != nil {
+		return nil, err
+	}
+	s.snapshot = &Snapshot{
+		Root:              userConfig.Root,
+		AssetRegistryHash: userConfig.AssetRegistryHash,
+		AccountCount:      accountCount,
+	}
+	s.snapshotUpdatedAt = time.Now()
+	return s.snapshot, nil
+}
+
+func (s *Server) userProofResponse(userProof *model.UserProof, err error) (int, interface{}) {
+	if err != nil {
+		return dbErrorResponse(err)
+	}
+	resp := &UserProofResponse{CreatedAt: userProof.CreatedAt}
+	err = json.Unmarshal([]byte(userProof.Config), &resp.UserConfig)
+	if err != nil {
+		return dbErrorResponse(err)
+	}
+	resp.Snapshot, err = s.cachedSnapshot()
+	if err != nil {
+		return dbErrorResponse(err)
+	}
+	return http.StatusOK, resp
+}
+
+func (s *Server) getUserProofById(r *http.Request) (int, interface{}) {
+	// the account id hash is stored in lower case hex
+	accountIdHash := strings.ToLower(r.PathValue("accountIdHash"))
+	if b, err := hex.DecodeString(accountIdHash); err != nil || len(b) != 32 {
+		return http.StatusBadRequest, errorResponse{"the account id hash should be 32 bytes in hex"}
+	}
+	return s.userProofResponse(s.userProofModel.GetUserProofById(accountIdHash))
+}
+
+func (s *Server) getUserProofByIndex(r *http.Request) (int, interface{}) {
+	accountIndex, err := strconv.ParseUint(r.PathValue("accountIndex"), 10, 32)
+	if err != nil {
+		return http.StatusBadRequest, errorResponse{"invalid account index"}
+	}
+	return s.userProofResponse(s.userProofModel.GetUserProofByIndex(uint32(accountIndex)))
+}
+
+// Run serves the user proofs on ListenAddr until the server fails.
+func Run(proofApiConfig *config.Config) {
+	utils.StartMetricsServer(proofApiConfig.MetricsAddr)
+	err := NewServer(proofApiConfig).ListenAndServe(proofApiConfig.ListenAddr)
+	if err != nil {
+		panic(err.Error())
+	}
+}
@@ -0,0 +1,127 @@
+package proofapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/proofapi/config"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	verifierConfig "github.com/binance/zkmerkle-proof-of-solvency/src/verifier/config"
+)
+
+func TestServer(t *testing.T) {
+	db, err := utils.NewEmbeddedDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	userProofModel := model.NewEmbeddedUserProofModel(db, "test")
+	rows := make([]model.UserProof, 2)
+	for i := range rows {
+		account := &utils.AccountInfo{
+			AccountIndex:    uint32(i),
+			AccountId:       bytes.Repeat([]byte{byte(i + 1)}, 32),
+			TotalEquity:     big.NewInt(100),
+			TotalDebt:       big.NewInt(10),
+			TotalCollateral: big.NewInt(0),
+			Assets:          []utils.AccountAsset{{Index: 1, Equity: 100, Debt: 10}},
+		}
+		rows[i] = *model.ConvertAccount(account, []byte{1}, [][]byte{{2}, {3}}, "0a0b", "registry")
+	}
+	if err = userProofModel.CreateUserProofs(rows); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newServer(userProofModel, &config.Config{RateLimit: 1, RateBurst: 2})
+	s.Authorize = TokenAuthorizer([]string{"secret"})
+	s.limiter.now = func() time.Time { return time.Unix(0, 0) }
+	get := func(path string, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	for _, token := range []string{"wrong", ""} {
+		if w := get("/v1/userproofs/index/1", token); w.Code != http.StatusUnauthorized {
+			t.Fatalf("unexpected code %d", w.Code)
+		}
+	}
+	// the requests without a valid token are limited as well
+	if w := get("/v1/userproofs/index/1", "wrong"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("unexpected code %d", w.Code)
+	}
+	s.limiter.now = func() time.Time { return time.Unix(2, 0) }
+	w := get("/v1/userproofs/"+"0202020202020202020202020202020202020202020202020202020202020202", "secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected code %d %s", w.Code, w.Body.String())
+	}
+	// the response is the user config of the verifier
+	var userConfig verifierConfig.UserConfig
+	var resp UserProofResponse
+	if err = json.Unmarshal(w.Body.Bytes(), &userConfig); err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if userConfig.AccountIndex != 1 || userConfig.Root != "0a0b" || len(userConfig.Proof) != 2 || userConfig.TotalEquity.Int64() != 100 ||
+		resp.Snapshot.AccountCount != 2 || resp.Snapshot.Root != "0a0b" || resp.Snapshot.AssetRegistryHash != "registry" {
+		t.Fatalf("unexpected response %s", w.Body.String())
+	}
+	if w = get("/v1/userproofs/index/9", "secret"); w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected code %d", w.Code)
+	}
+	// the burst of 2 requests is used up
+	if w = get("/v1/snapshot", "secret"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("unexpected code %d", w.Code)
+	}
+	s.limiter.now = func() time.Time { return time.Unix(3, 0) }
+	if w = get("/v1/userproofs/invalid", "secret"); w.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected code %d", w.Code)
+	}
+}
+
+func TestServerForwardedFor(t *testing.T) {
+	db, err := utils.NewEmbeddedDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := newServer(model.NewEmbeddedUserProofModel(db, "test"), &config.Config{RateLimit: 1, RateBurst: 2, TrustForwardedFor: true})
+	s.Authorize = TokenAuthorizer([]string{"secret"})
+	s.limiter.now = func() time.Time { return time.Unix(0, 0) }
+	get := func(forwardedFor ...string) int {
+		r := httptest.NewRequest(http.MethodGet, "/v1/snapshot", nil)
+		r.RemoteAddr = "10.0.0.1:443"
+		for _, v := range forwardedFor {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		return w.Code
+	}
+	// the client sends another leftmost entry every time, the proxy appends
+	// the real address
+	for i, forwarded := range []string{"1.1.1.1, 203.0.113.7", "2.2.2.2,203.0.113.7"} {
+		if code := get(forwarded); code != http.StatusUnauthorized {
+			t.Fatalf("unexpected code %d of request %d", code, i)
+		}
+	}
+	if code := get("3.3.3.3", "203.0.113.7"); code != http.StatusTooManyRequests {
+		t.Fatalf("the spoofed X-Forwarded-For escapes the limit: %d", code)
+	}
+	// another client behind the proxy has its own limit
+	if code := get("203.0.113.7, 198.51.100.2"); code != http.StatusUnauthorized {
+		t.Fatalf("unexpected code %d", code)
+	}
+}
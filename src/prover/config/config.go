@@ -1,12 +1,42 @@
 package config
 
 type Config struct {
+	// DbDriver is mysql, postgres or sqlite3, it defaults to mysql. MysqlDataSource
+	// is the data source of the driver
+	DbDriver        string
 	MysqlDataSource string
 	DbSuffix        string
 	Redis           struct {
 		Host     string
 		Password string
 	}
+	// ZkKeyName is parallel to AssetsCountTiers, it should be the keys of
+	// the batch update user circuits for the incremental snapshot
 	ZkKeyName        []string
 	AssetsCountTiers []int
+	// ProvingSystems is parallel to AssetsCountTiers, every tier defaults
+	// to groth16 when it is empty
+	ProvingSystems []string
+	// RecursiveProof generates groth16 proofs which can be aggregated by the
+	// aggregator service
+	RecursiveProof bool
+	// SolidityProof generates proofs which can be verified by the solidity
+	// verifiers exported by keygen, it can't be used with RecursiveProof
+	SolidityProof bool
+	// TaskLeaseSeconds is the lease of the task popped from the task queue,
+	// the task is popped again by the other provers when the prover doesn't
+	// renew its lease in time. It defaults to 120
+	TaskLeaseSeconds int
+	// TaskTiers pins the prover to the task queues of these assets count
+	// tiers, which must be in AssetsCountTiers. The prover pops the tasks of
+	// all tiers when it is empty
+	TaskTiers []int
+	// KeyCacheSize is the number of tiers whose constraint system and keys
+	// are kept in memory, the least recently used tier is dropped to load
+	// another one. It defaults to 1, a prover of several tiers loads the keys
+	// of every tier only once when it is the number of tiers
+	KeyCacheSize int
+	// MetricsAddr is the listen address of the prometheus /metrics endpoint,
+	// such as ":9100". The endpoint is disabled when it is empty
+	MetricsAddr string
 }
@@ -1,38 +1,13 @@
 package main
 
 import (
-	"encoding/json"
-	"flag"
-	"io/ioutil"
+	"os"
 
-	"github.com/binance/zkmerkle-proof-of-solvency/src/prover/config"
-	"github.com/binance/zkmerkle-proof-of-solvency/src/prover/prover"
-	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/zkpor/zkpor"
 )
 
+// prover runs zkpor prove, the config is config/config.json by default
 func main() {
-	proverConfig := &config.Config{}
-	content, err := ioutil.ReadFile("config/config.json")
-	if err != nil {
-		panic(err.Error())
-	}
-	err = json.Unmarshal(content, proverConfig)
-	if err != nil {
-		panic(err.Error())
-	}
-	if len(proverConfig.AssetsCountTiers) != len(proverConfig.ZkKeyName) {
-		panic("asset tiers and asset tier names should have the same length")
-	}
-	remotePasswdConfig := flag.String("remote_password_config", "", "fetch password from aws secretsmanager")
-	rerun := flag.Bool("rerun", false, "flag which indicates rerun proof generation")
-	flag.Parse()
-	if *remotePasswdConfig != "" {
-		s, err := utils.GetMysqlSource(proverConfig.MysqlDataSource, *remotePasswdConfig)
-		if err != nil {
-			panic(err.Error())
-		}
-		proverConfig.MysqlDataSource = s
-	}
-	prover := prover.NewProver(proverConfig)
-	prover.Run(*rerun)
+	p := &zkpor.Program{Name: "prover", Groups: []string{"prove"}, DefaultConfig: "config/config.json"}
+	p.Main(os.Args[1:])
 }
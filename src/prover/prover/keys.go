@@ -0,0 +1,152 @@
+package prover
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/circuit"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/consensys/gnark/constraint"
+)
+
+// snarkParams are the constraint system and the keys of an assets count tier.
+type snarkParams struct {
+	r1cs          constraint.ConstraintSystem
+	provingKey    circuit.ProvingKey
+	verifyingKey  circuit.VerifyingKey
+	provingSystem string
+}
+
+// loadSnarkParams streams the constraint system and the proving key of the
+// tier from their files concurrently, every file is checked against the key
+// manifest while it is read.
+func (p *Prover) loadSnarkParams(index int) (*snarkParams, error) {
+	zkKeyName := p.SessionName[index]
+	provingSystem := p.ProvingSystems[index]
+	entry := p.keyManifest[index]
+	err := utils.CheckBatchKeyParams(zkKeyName+".pk", p.AssetsCountTiers[index])
+	if err != nil {
+		return nil, err
+	}
+	params := &snarkParams{
+		r1cs:          circuit.NewConstraintSystem(provingSystem),
+		provingKey:    circuit.NewProvingKey(provingSystem),
+		verifyingKey:  circuit.NewVerifyingKey(provingSystem),
+		provingSystem: provingSystem,
+	}
+
+	var wg sync.WaitGroup
+	var r1csErr, pkErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s := time.Now()
+		n, err := utils.LoadKeyFile(zkKeyName, circuit.ConstraintSystemFileSuffix(provingSystem), entry, params.r1cs.ReadFrom)
+		if err != nil {
+			r1csErr = fmt.Errorf("r1cs read error: %s", err.Error())
+			return
+		}
+		fmt.Println("finish loading r1cs of size", n, "the time cost is", time.Since(s))
+	}()
+	go func() {
+		defer wg.Done()
+		s := time.Now()
+		n, err := utils.LoadKeyFile(zkKeyName, ".pk", entry, params.provingKey.UnsafeReadFrom)
+		if err != nil {
+			pkErr = fmt.Errorf("provingKey loading error: %s", err.Error())
+			return
+		}
+		fmt.Println("finish loading proving key of size", n, "the time cost is", time.Since(s))
+	}()
+	wg.Wait()
+	if r1csErr != nil {
+		return nil, r1csErr
+	}
+	if pkErr != nil {
+		return nil, pkErr
+	}
+	_, err = utils.LoadKeyFile(zkKeyName, ".vk", entry, params.verifyingKey.ReadFrom)
+	if err != nil {
+		return nil, fmt.Errorf("verifyingKey loading error: %s", err.Error())
+	}
+	return params, nil
+}
+
+// evictSnarkParams drops the least recently used tiers until there is room
+// for one more tier in the cache.
+func (p *Prover) evictSnarkParams() {
+	keyCacheSize := max(p.KeyCacheSize, 1)
+	evicted := false
+	for len(p.snarkParamsUse) >= keyCacheSize {
+		k := p.snarkParamsUse[0]
+		p.snarkParamsUse = p.snarkParamsUse[1:]
+		delete(p.snarkParamsCache, k)
+		fmt.Println("evict the keys of", k, "assets")
+		if k == p.CurrentSnarkParamsInUse {
+			p.R1cs, p.ProvingKey, p.VerifyingKey = nil, nil, nil
+			p.CurrentSnarkParamsInUse = 0
+		}
+		evicted = true
+	}
+	if evicted {
+		runtime.GC()
+		debug.FreeOSMemory()
+	}
+}
+
+// LoadSnarkParamsOnce makes the keys of the tier of targerAssetsCount the
+// keys in use. The keys of the last KeyCacheSize tiers are kept in memory,
+// so a prover of several tiers only loads the keys of a tier once when
+// KeyCacheSize is the number of its tiers.
+func (p *Prover) LoadSnarkParamsOnce(targerAssetsCount int) {
+	if targerAssetsCount == p.CurrentSnarkParamsInUse {
+		return
+	}
+
+	index := -1
+	for i, v := range p.AssetsCountTiers {
+		if targerAssetsCount == v {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		panic("the assets count is not in the config file")
+	}
+	if p.snarkParamsCache == nil {
+		p.snarkParamsCache = make(map[int]*snarkParams)
+	}
+	params, ok := p.snarkParamsCache[targerAssetsCount]
+	if ok {
+		fmt.Println("use the cached keys of", targerAssetsCount, "assets")
+		for i, k := range p.snarkParamsUse {
+			if k == targerAssetsCount {
+				p.snarkParamsUse = append(p.snarkParamsUse[:i], p.snarkParamsUse[i+1:]...)
+				break
+			}
+		}
+	} else {
+		p.evictSnarkParams()
+		fmt.Println("begin loading the keys of", targerAssetsCount, "assets")
+		s := time.Now()
+		var err error
+		params, err = p.loadSnarkParams(index)
+		if err != nil {
+			panic(err.Error())
+		}
+		fmt.Println("finish loading the keys of", targerAssetsCount, "assets, the time cost is", time.Since(s))
+		utils.KeyLoadingSeconds.WithLabelValues(strconv.Itoa(targerAssetsCount)).Set(time.Since(s).Seconds())
+		p.snarkParamsCache[targerAssetsCount] = params
+	}
+	p.snarkParamsUse = append(p.snarkParamsUse, targerAssetsCount)
+
+	p.R1cs = params.r1cs
+	p.ProvingKey = params.provingKey
+	p.VerifyingKey = params.verifyingKey
+	p.CurrentSnarkParamsInUse = targerAssetsCount
+	p.CurrentProvingSystem = params.provingSystem
+}
@@ -0,0 +1,104 @@
+package prover
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/circuit"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/consensys/gnark/frontend"
+)
+
+type squareCircuit struct {
+	X frontend.Variable
+	Y frontend.Variable `gnark:",public"`
+}
+
+func (c *squareCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(api.Mul(c.X, c.X), c.Y)
+	return nil
+}
+
+func writeTestKeys(t *testing.T, zkKeyName string, assetsCountTier int) {
+	cs, err := circuit.Compile(circuit.ProvingSystemGroth16, &squareCircuit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, vk, err := circuit.Setup(circuit.ProvingSystemGroth16, cs, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(suffix string, writeTo func(f *os.File) error) {
+		f, err := os.Create(zkKeyName + suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = writeTo(f); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		if err = utils.AppendKeyParams(zkKeyName+suffix, utils.NewBatchKeyParams(assetsCountTier, false)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".r1cs", func(f *os.File) error { _, err := cs.WriteTo(f); return err })
+	write(".pk", func(f *os.File) error { _, err := pk.WriteTo(f); return err })
+	write(".vk", func(f *os.File) error { _, err := vk.WriteTo(f); return err })
+	err = utils.AddKeyManifestEntry(zkKeyName, utils.NewBatchKeyParams(assetsCountTier, false), circuit.ProvingSystemGroth16, cs.GetNbConstraints(), []string{".r1cs", ".pk", ".vk"})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadSnarkParamsOnce(t *testing.T) {
+	dir := t.TempDir()
+	newProver := func(keyCacheSize int) *Prover {
+		p := &Prover{
+			SessionName:      []string{filepath.Join(dir, "zkpor50_700"), filepath.Join(dir, "zkpor500_92")},
+			AssetsCountTiers: []int{50, 500},
+			ProvingSystems:   []string{circuit.ProvingSystemGroth16, circuit.ProvingSystemGroth16},
+			KeyCacheSize:     keyCacheSize,
+		}
+		p.checkKeyManifest()
+		return p
+	}
+	writeTestKeys(t, filepath.Join(dir, "zkpor50_700"), 50)
+	writeTestKeys(t, filepath.Join(dir, "zkpor500_92"), 500)
+	p := newProver(2)
+
+	p.LoadSnarkParamsOnce(50)
+	pk50 := p.ProvingKey
+	p.LoadSnarkParamsOnce(500)
+	if p.CurrentSnarkParamsInUse != 500 || p.ProvingKey == pk50 || p.R1cs == nil || p.VerifyingKey == nil {
+		t.Fatal("the keys of tier 500 should be in use")
+	}
+	// both tiers are in the cache
+	p.LoadSnarkParamsOnce(50)
+	if p.ProvingKey != pk50 {
+		t.Fatal("the keys of tier 50 should be cached")
+	}
+
+	// the least recently used tier is evicted
+	p = newProver(1)
+	p.LoadSnarkParamsOnce(50)
+	pk50 = p.ProvingKey
+	p.LoadSnarkParamsOnce(500)
+	p.LoadSnarkParamsOnce(50)
+	if p.ProvingKey == pk50 || len(p.snarkParamsCache) != 1 {
+		t.Fatal("the keys of tier 50 should be loaded again")
+	}
+
+	// a key which doesn't match its fingerprint is refused
+	content, err := os.ReadFile(p.SessionName[1] + ".pk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[len(content)/2] ^= 1
+	if err = os.WriteFile(p.SessionName[1]+".pk", content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.loadSnarkParams(1); err == nil {
+		t.Fatal("the tampered proving key should be refused")
+	}
+}
@@ -0,0 +1,100 @@
+package prover
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+)
+
+type embeddedProofModel struct {
+	table string
+	db    *utils.EmbeddedDB
+}
+
+// NewEmbeddedProofModel returns the proof table of the local pipeline mode.
+func NewEmbeddedProofModel(db *utils.EmbeddedDB, suffix string) ProofModel {
+	return &embeddedProofModel{
+		table: TableNamePrefix + suffix,
+		db:    db,
+	}
+}
+
+func (m *embeddedProofModel) CreateProofTable() error {
+	return nil
+}
+
+func (m *embeddedProofModel) UpgradeProofTable() error {
+	return nil
+}
+
+func (m *embeddedProofModel) DropProofTable() error {
+	return m.db.Drop(m.table)
+}
+
+func (m *embeddedProofModel) CreateProof(row *Proof) error {
+	m.db.Lock()
+	defer m.db.Unlock()
+	key := utils.EmbeddedUint64Key(uint64(row.BatchNumber))
+	// batch_number is unique
+	err := m.db.Get(m.table, key, &Proof{})
+	if err == nil {
+		return utils.DbErrSqlOperation
+	}
+	if err != utils.DbErrNotFound {
+		return err
+	}
+	proof := *row
+	proof.ID = uint64(row.BatchNumber) + 1
+	proof.CreatedAt = time.Now()
+	proof.UpdatedAt = proof.CreatedAt
+	return m.db.Put(m.table, key, &proof)
+}
+
+func (m *embeddedProofModel) GetProofsBetween(start int64, end int64) (proofs []*Proof, err error) {
+	err = m.db.Scan(m.table, utils.EmbeddedUint64Key(uint64(start)), func(value []byte) (bool, error) {
+		proof := &Proof{}
+		err := json.Unmarshal(value, proof)
+		if err != nil {
+			return false, err
+		}
+		if proof.BatchNumber > end {
+			return false, nil
+		}
+		proofs = append(proofs, proof)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(proofs) == 0 {
+		return nil, utils.DbErrNotFound
+	}
+	return proofs, nil
+}
+
+func (m *embeddedProofModel) GetLatestProof() (p *Proof, err error) {
+	p = &Proof{}
+	err = m.db.Last(m.table, p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (m *embeddedProofModel) GetLatestConfirmedProof() (p *Proof, err error) {
+	return m.GetLatestProof()
+}
+
+func (m *embeddedProofModel) GetProofByBatchNumber(num int64) (p *Proof, err error) {
+	p = &Proof{}
+	err = m.db.Get(m.table, utils.EmbeddedUint64Key(uint64(num)), p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (m *embeddedProofModel) GetRowCounts() (count int64, err error) {
+	return m.db.Count(m.table)
+}
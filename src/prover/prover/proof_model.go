@@ -3,9 +3,11 @@ package prover
 import (
 	"database/sql"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/gocarina/gocsv"
 )
 
 const (
@@ -15,6 +17,9 @@ const (
 type (
 	ProofModel interface {
 		CreateProofTable() error
+		// UpgradeProofTable adds the columns of the newer versions to the
+		// table created by an older version
+		UpgradeProofTable() error
 		DropProofTable() error
 		CreateProof(row *Proof) error
 		GetProofsBetween(start int64, end int64) (proofs []*Proof, err error)
@@ -40,6 +45,7 @@ type (
 		BatchCommitment         string
 		AssetsCount             int
 		BatchNumber             int64
+		ProvingSystem           string
 	}
 )
 
@@ -55,6 +61,13 @@ func NewProofModel(db *utils.DB, suffix string) ProofModel {
 }
 
 func (m *defaultProofModel) CreateProofTable() error {
+	if m.db.Driver() != utils.DbDriverMysql {
+		err := m.createPortableProofTable()
+		if err != nil {
+			return err
+		}
+		return m.UpgradeProofTable()
+	}
 	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
 		id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
@@ -65,9 +78,38 @@ func (m *defaultProofModel) CreateProofTable() error {
 		account_tree_roots TEXT NOT NULL,
 		batch_commitment TEXT NOT NULL,
 		assets_count INT NOT NULL,
-		batch_number BIGINT NOT NULL UNIQUE
+		batch_number BIGINT NOT NULL UNIQUE,
+		proving_system VARCHAR(16) NOT NULL DEFAULT 'groth16'
 	)`, m.table)
 	_, err := m.db.Exec(query)
+	if err != nil {
+		return err
+	}
+	return m.UpgradeProofTable()
+}
+
+// UpgradeProofTable adds proving_system to the table of the versions before
+// the plonk backend, all proofs of such a table are groth16 proofs.
+func (m *defaultProofModel) UpgradeProofTable() error {
+	return m.db.AddColumn(m.table, "proving_system", "VARCHAR(16) NOT NULL DEFAULT 'groth16'")
+}
+
+// createPortableProofTable creates the table of postgres and sqlite
+func (m *defaultProofModel) createPortableProofTable() error {
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id %s,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		deleted_at TIMESTAMP NULL DEFAULT NULL,
+		proof_info TEXT NOT NULL,
+		cex_asset_list_commitments TEXT NOT NULL,
+		account_tree_roots TEXT NOT NULL,
+		batch_commitment TEXT NOT NULL,
+		assets_count INT NOT NULL,
+		batch_number BIGINT NOT NULL UNIQUE,
+		proving_system VARCHAR(16) NOT NULL DEFAULT 'groth16'
+	)`, m.table, m.db.AutoIncrementPrimaryKey())
+	_, err := m.db.Exec(query)
 	return err
 }
 
@@ -78,8 +120,8 @@ func (m *defaultProofModel) DropProofTable() error {
 }
 
 func (m *defaultProofModel) CreateProof(row *Proof) error {
-	query := fmt.Sprintf("INSERT INTO %s (proof_info, cex_asset_list_commitments, account_tree_roots, batch_commitment, assets_count, batch_number, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, NOW(), NOW())", m.table)
-	result, err := m.db.Exec(query, row.ProofInfo, row.CexAssetListCommitments, row.AccountTreeRoots, row.BatchCommitment, row.AssetsCount, row.BatchNumber)
+	query := fmt.Sprintf("INSERT INTO %s (proof_info, cex_asset_list_commitments, account_tree_roots, batch_commitment, assets_count, batch_number, proving_system, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)", m.table)
+	result, err := m.db.Exec(query, row.ProofInfo, row.CexAssetListCommitments, row.AccountTreeRoots, row.BatchCommitment, row.AssetsCount, row.BatchNumber, row.ProvingSystem)
 	if err != nil {
 		return err
 	}
@@ -94,16 +136,16 @@ func (m *defaultProofModel) CreateProof(row *Proof) error {
 }
 
 func (m *defaultProofModel) GetProofsBetween(start int64, end int64) (proofs []*Proof, err error) {
-	query := fmt.Sprintf("SELECT id, created_at, updated_at, deleted_at, proof_info, cex_asset_list_commitments, account_tree_roots, batch_commitment, assets_count, batch_number FROM %s WHERE batch_number >= ? AND batch_number <= ? AND deleted_at IS NULL ORDER BY batch_number", m.table)
+	query := fmt.Sprintf("SELECT id, created_at, updated_at, deleted_at, proof_info, cex_asset_list_commitments, account_tree_roots, batch_commitment, assets_count, batch_number, proving_system FROM %s WHERE batch_number >= ? AND batch_number <= ? AND deleted_at IS NULL ORDER BY batch_number", m.table)
 	rows, err := m.db.QueryWithTimeout(query, start, end)
 	if err != nil {
-		return nil, utils.ConvertMysqlErrToDbErr(err)
+		return nil, utils.ConvertSqlErrToDbErr(err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		proof := &Proof{}
-		err = rows.Scan(&proof.ID, &proof.CreatedAt, &proof.UpdatedAt, &proof.DeletedAt, &proof.ProofInfo, &proof.CexAssetListCommitments, &proof.AccountTreeRoots, &proof.BatchCommitment, &proof.AssetsCount, &proof.BatchNumber)
+		err = rows.Scan(&proof.ID, &proof.CreatedAt, &proof.UpdatedAt, &proof.DeletedAt, &proof.ProofInfo, &proof.CexAssetListCommitments, &proof.AccountTreeRoots, &proof.BatchCommitment, &proof.AssetsCount, &proof.BatchNumber, &proof.ProvingSystem)
 		if err != nil {
 			return nil, err
 		}
@@ -118,42 +160,42 @@ func (m *defaultProofModel) GetProofsBetween(start int64, end int64) (proofs []*
 
 func (m *defaultProofModel) GetLatestProof() (p *Proof, err error) {
 	row := &Proof{}
-	query := fmt.Sprintf("SELECT id, created_at, updated_at, deleted_at, proof_info, cex_asset_list_commitments, account_tree_roots, batch_commitment, assets_count, batch_number FROM %s WHERE deleted_at IS NULL ORDER BY batch_number DESC LIMIT 1", m.table)
+	query := fmt.Sprintf("SELECT id, created_at, updated_at, deleted_at, proof_info, cex_asset_list_commitments, account_tree_roots, batch_commitment, assets_count, batch_number, proving_system FROM %s WHERE deleted_at IS NULL ORDER BY batch_number DESC LIMIT 1", m.table)
 	dbRow := m.db.QueryRowWithTimeout(query)
-	err = dbRow.Scan(&row.ID, &row.CreatedAt, &row.UpdatedAt, &row.DeletedAt, &row.ProofInfo, &row.CexAssetListCommitments, &row.AccountTreeRoots, &row.BatchCommitment, &row.AssetsCount, &row.BatchNumber)
+	err = dbRow.Scan(&row.ID, &row.CreatedAt, &row.UpdatedAt, &row.DeletedAt, &row.ProofInfo, &row.CexAssetListCommitments, &row.AccountTreeRoots, &row.BatchCommitment, &row.AssetsCount, &row.BatchNumber, &row.ProvingSystem)
 	if err == sql.ErrNoRows {
 		return nil, utils.DbErrNotFound
 	}
 	if err != nil {
-		return nil, utils.ConvertMysqlErrToDbErr(err)
+		return nil, utils.ConvertSqlErrToDbErr(err)
 	}
 	return row, nil
 }
 
 func (m *defaultProofModel) GetLatestConfirmedProof() (p *Proof, err error) {
 	row := &Proof{}
-	query := fmt.Sprintf("SELECT id, created_at, updated_at, deleted_at, proof_info, cex_asset_list_commitments, account_tree_roots, batch_commitment, assets_count, batch_number FROM %s WHERE deleted_at IS NULL ORDER BY batch_number DESC LIMIT 1", m.table)
+	query := fmt.Sprintf("SELECT id, created_at, updated_at, deleted_at, proof_info, cex_asset_list_commitments, account_tree_roots, batch_commitment, assets_count, batch_number, proving_system FROM %s WHERE deleted_at IS NULL ORDER BY batch_number DESC LIMIT 1", m.table)
 	dbRow := m.db.QueryRowWithTimeout(query)
-	err = dbRow.Scan(&row.ID, &row.CreatedAt, &row.UpdatedAt, &row.DeletedAt, &row.ProofInfo, &row.CexAssetListCommitments, &row.AccountTreeRoots, &row.BatchCommitment, &row.AssetsCount, &row.BatchNumber)
+	err = dbRow.Scan(&row.ID, &row.CreatedAt, &row.UpdatedAt, &row.DeletedAt, &row.ProofInfo, &row.CexAssetListCommitments, &row.AccountTreeRoots, &row.BatchCommitment, &row.AssetsCount, &row.BatchNumber, &row.ProvingSystem)
 	if err == sql.ErrNoRows {
 		return nil, utils.DbErrNotFound
 	}
 	if err != nil {
-		return nil, utils.ConvertMysqlErrToDbErr(err)
+		return nil, utils.ConvertSqlErrToDbErr(err)
 	}
 	return row, nil
 }
 
 func (m *defaultProofModel) GetProofByBatchNumber(num int64) (p *Proof, err error) {
 	row := &Proof{}
-	query := fmt.Sprintf("SELECT id, created_at, updated_at, deleted_at, proof_info, cex_asset_list_commitments, account_tree_roots, batch_commitment, assets_count, batch_number FROM %s WHERE batch_number = ? AND deleted_at IS NULL LIMIT 1", m.table)
+	query := fmt.Sprintf("SELECT id, created_at, updated_at, deleted_at, proof_info, cex_asset_list_commitments, account_tree_roots, batch_commitment, assets_count, batch_number, proving_system FROM %s WHERE batch_number = ? AND deleted_at IS NULL LIMIT 1", m.table)
 	dbRow := m.db.QueryRowWithTimeout(query, num)
-	err = dbRow.Scan(&row.ID, &row.CreatedAt, &row.UpdatedAt, &row.DeletedAt, &row.ProofInfo, &row.CexAssetListCommitments, &row.AccountTreeRoots, &row.BatchCommitment, &row.AssetsCount, &row.BatchNumber)
+	err = dbRow.Scan(&row.ID, &row.CreatedAt, &row.UpdatedAt, &row.DeletedAt, &row.ProofInfo, &row.CexAssetListCommitments, &row.AccountTreeRoots, &row.BatchCommitment, &row.AssetsCount, &row.BatchNumber, &row.ProvingSystem)
 	if err == sql.ErrNoRows {
 		return nil, utils.DbErrNotFound
 	}
 	if err != nil {
-		return nil, utils.ConvertMysqlErrToDbErr(err)
+		return nil, utils.ConvertSqlErrToDbErr(err)
 	}
 	return row, nil
 }
@@ -163,7 +205,43 @@ func (m *defaultProofModel) GetRowCounts() (count int64, err error) {
 	row := m.db.QueryRowWithTimeout(query)
 	err = row.Scan(&count)
 	if err != nil {
-		return 0, utils.ConvertMysqlErrToDbErr(err)
+		return 0, utils.ConvertSqlErrToDbErr(err)
 	}
 	return count, nil
 }
+
+// WriteProofTable writes the proofs to the csv file read by the verifier
+// service.
+func WriteProofTable(name string, proofs []*Proof) error {
+	type ProofRow struct {
+		BatchNumber        int64  `csv:"batch_number"`
+		ZkProof            string `csv:"proof_info"`
+		CexAssetCommitment string `csv:"cex_asset_list_commitments"`
+		AccountTreeRoots   string `csv:"account_tree_roots"`
+		BatchCommitment    string `csv:"batch_commitment"`
+		AssetsCount        int    `csv:"assets_count"`
+		ProvingSystem      string `csv:"proving_system"`
+	}
+	rows := make([]*ProofRow, len(proofs))
+	for i, p := range proofs {
+		rows[i] = &ProofRow{
+			BatchNumber:        p.BatchNumber,
+			ZkProof:            p.ProofInfo,
+			CexAssetCommitment: p.CexAssetListCommitments,
+			AccountTreeRoots:   p.AccountTreeRoots,
+			BatchCommitment:    p.BatchCommitment,
+			AssetsCount:        p.AssetsCount,
+			ProvingSystem:      p.ProvingSystem,
+		}
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	err = gocsv.MarshalFile(&rows, f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
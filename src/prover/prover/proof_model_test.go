@@ -0,0 +1,60 @@
+package prover
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/circuit"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+)
+
+func TestUpgradeProofTable(t *testing.T) {
+	if !utils.SqliteEnabled() {
+		t.Skip("the sqlite driver is built with -tags sqlite")
+	}
+	db, err := utils.NewDBWithDriver(utils.DbDriverSqlite, filepath.Join(t.TempDir(), "zkpos.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// the proof table of the versions before the plonk backend
+	_, err = db.Exec(fmt.Sprintf(`CREATE TABLE proof0 (
+		id %s,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		deleted_at TIMESTAMP NULL DEFAULT NULL,
+		proof_info TEXT NOT NULL,
+		cex_asset_list_commitments TEXT NOT NULL,
+		account_tree_roots TEXT NOT NULL,
+		batch_commitment TEXT NOT NULL,
+		assets_count INT NOT NULL,
+		batch_number BIGINT NOT NULL UNIQUE
+	)`, db.AutoIncrementPrimaryKey()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("INSERT INTO proof0 (proof_info, cex_asset_list_commitments, account_tree_roots, batch_commitment, assets_count, batch_number) VALUES ('proof', '[]', '[]', 'c', 50, 0)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proofModel := NewProofModel(db, "0")
+	// the column is added once when the prover restarts
+	for i := 0; i < 2; i++ {
+		if err = proofModel.CreateProofTable(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := proofModel.GetProofByBatchNumber(0)
+	if err != nil || p.ProvingSystem != circuit.ProvingSystemGroth16 {
+		t.Fatalf("unexpected proof %v %v", p, err)
+	}
+	err = proofModel.CreateProof(&Proof{ProofInfo: "proof", CexAssetListCommitments: "[]", AccountTreeRoots: "[]", BatchCommitment: "c", AssetsCount: 50, BatchNumber: 1, ProvingSystem: circuit.ProvingSystemPlonk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = proofModel.GetProofByBatchNumber(1)
+	if err != nil || p.ProvingSystem != circuit.ProvingSystemPlonk {
+		t.Fatalf("unexpected proof %v %v", p, err)
+	}
+}
@@ -2,13 +2,11 @@ package prover
 
 import (
 	"bytes"
-	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
-	"runtime"
+	"slices"
 	"strconv"
 	"time"
 
@@ -17,7 +15,7 @@ import (
 	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
 	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/witness"
 	"github.com/consensys/gnark-crypto/ecc"
-	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend"
 	"github.com/consensys/gnark/constraint"
 	"github.com/consensys/gnark/constraint/solver"
 	"github.com/consensys/gnark/frontend"
@@ -27,70 +25,141 @@ import (
 type Prover struct {
 	witnessModel witness.WitnessModel
 	proofModel   ProofModel
-	redisCli     *redis.Client
+	taskQueue    TaskQueue
+	// the lease of the popped task is renewed every taskLease / 3
+	taskLease time.Duration
 
-	VerifyingKey     groth16.VerifyingKey
-	ProvingKey       groth16.ProvingKey
+	VerifyingKey     circuit.VerifyingKey
+	ProvingKey       circuit.ProvingKey
 	SessionName      []string
 	AssetsCountTiers []int
+	ProvingSystems   []string
+	RecursiveProof   bool
+	SolidityProof    bool
 	R1cs             constraint.ConstraintSystem
 
 	CurrentSnarkParamsInUse int
+	CurrentProvingSystem    string
 	TaskQueueName           string
+	// keyManifest is parallel to AssetsCountTiers, the key files are checked
+	// against their fingerprints when they are loaded
+	keyManifest []*utils.KeyManifestEntry
+
+	// KeyCacheSize is the number of tiers whose keys are kept in memory,
+	// snarkParamsUse holds the cached tiers from the least recently used
+	KeyCacheSize     int
+	snarkParamsCache map[int]*snarkParams
+	snarkParamsUse   []int
 }
 
 func NewProver(config *config.Config) *Prover {
-	db, err := utils.NewDB(config.MysqlDataSource)
+	db, err := utils.NewDBWithDriver(config.DbDriver, config.MysqlDataSource)
 	if err != nil {
 		panic(err.Error())
 	}
-	// Set up the redis client.
+	taskQueue := NewTaskQueue(config)
+	prover := NewProverWithModels(config, witness.NewWitnessModel(db, config.DbSuffix), NewProofModel(db, config.DbSuffix), taskQueue)
+	prover.taskLease = taskQueue.lease
+	prover.TaskQueueName = taskQueue.name
+	return prover
+}
+
+// NewTaskQueue returns the redis task queues of DbSuffix, they are the queues
+// of TaskTiers when it is set, or the queues of all AssetsCountTiers and the
+// queue shared by all tiers.
+func NewTaskQueue(config *config.Config) *TierTaskQueue {
 	redisCli := redis.NewClient(&redis.Options{
 		Addr:     config.Redis.Host,
 		Password: config.Redis.Password,
 	})
-	taskQueueName := "por_batch_task_queue_" + config.DbSuffix
+	taskLease := time.Duration(config.TaskLeaseSeconds) * time.Second
+	if taskLease == 0 {
+		taskLease = DefaultTaskLeaseSeconds * time.Second
+	}
+	tiers := config.TaskTiers
+	if len(tiers) == 0 {
+		tiers = append(append([]int{}, config.AssetsCountTiers...), 0)
+	}
+	return NewTierTaskQueue(redisCli, TaskQueueNamePrefix+config.DbSuffix, taskLease, tiers)
+}
 
+// NewProverWithModels fetches the batch heights from taskQueue, the mysql and
+// redis settings of config are not used.
+func NewProverWithModels(config *config.Config, witnessModel witness.WitnessModel, proofModel ProofModel, taskQueue TaskQueue) *Prover {
 	prover := Prover{
-		witnessModel:            witness.NewWitnessModel(db, config.DbSuffix),
-		proofModel:              NewProofModel(db, config.DbSuffix),
-		redisCli:                redisCli,
+		witnessModel:            witnessModel,
+		proofModel:              proofModel,
+		taskQueue:               taskQueue,
+		taskLease:               DefaultTaskLeaseSeconds * time.Second,
 		SessionName:             config.ZkKeyName,
 		AssetsCountTiers:        config.AssetsCountTiers,
+		ProvingSystems:          config.ProvingSystems,
+		RecursiveProof:          config.RecursiveProof,
+		SolidityProof:           config.SolidityProof,
+		KeyCacheSize:            config.KeyCacheSize,
 		CurrentSnarkParamsInUse: 0,
-		TaskQueueName:           taskQueueName,
 	}
 
+	prover.checkKeyManifest()
+
 	// std.RegisterHints()
 	solver.RegisterHint(circuit.IntegerDivision)
 	return &prover
 }
 
-func (p *Prover) fetchTasksByRedis() (int, error) {
-	var ctx = context.Background()
-	batchHeightStr, err := p.redisCli.BRPop(ctx, 10*time.Second, p.TaskQueueName).Result()
-	if err != nil {
-		return -1, err
-	}
-
-	batchHeight, err := strconv.Atoi(batchHeightStr[1])
-	if err != nil {
-		return -1, err
+// checkKeyManifest checks the keys of every tier against the key manifest
+// written by keygen, so that a swapped or truncated key is found before a
+// batch is proved.
+func (p *Prover) checkKeyManifest() {
+	p.keyManifest = make([]*utils.KeyManifestEntry, len(p.AssetsCountTiers))
+	for i, k := range p.AssetsCountTiers {
+		provingSystem, err := circuit.NormalizeProvingSystem(p.ProvingSystems[i])
+		if err != nil {
+			panic(err.Error())
+		}
+		p.keyManifest[i], err = utils.CheckBatchKeyManifest(p.SessionName[i], k, provingSystem,
+			circuit.ConstraintSystemFileSuffix(provingSystem), ".pk", ".vk")
+		if err != nil {
+			panic(err.Error())
+		}
 	}
-	return batchHeight, nil
 }
 
 func (p *Prover) FetchBatchWitness() ([]*witness.BatchWitness, error) {
-	batchHeight, err := p.fetchTasksByRedis()
-	if err != nil {
-		return nil, err
+	for {
+		batchHeight, err := p.taskQueue.PopTask()
+		if err != nil {
+			return nil, err
+		}
+
+		blockWitnesses, err := p.fetchBatchWitnessByHeight(batchHeight, witness.StatusPublished)
+		if err == utils.DbErrNotFound {
+			// the lease of the task expired, the prover which received the
+			// witness crashed or stopped renewing the lease
+			blockWitnesses, err = p.fetchBatchWitnessByHeight(batchHeight, witness.StatusReceived)
+		}
+		if err == utils.DbErrNotFound {
+			// the prover of the expired lease saved the proof at last
+			fmt.Printf("witness of height %d is finished, skip the task\n", batchHeight)
+			err = p.taskQueue.AckTask(batchHeight)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return blockWitnesses, nil
 	}
+}
 
-	// Fetch unproved block witness.
+func (p *Prover) fetchBatchWitnessByHeight(batchHeight int, beforeStatus int64) ([]*witness.BatchWitness, error) {
 	for {
-		blockWitnesses, err := p.witnessModel.GetAndUpdateBatchesWitnessByHeight(batchHeight, witness.StatusPublished, witness.StatusReceived)
+		blockWitnesses, err := p.witnessModel.GetAndUpdateBatchesWitnessByHeight(batchHeight, beforeStatus, witness.StatusReceived)
 		if err == utils.DbErrQueryInterrupted || err == utils.DbErrQueryTimeout {
 			fmt.Println("get batch witness timeout, retry...:", err.Error())
+			utils.ServiceErrors.WithLabelValues("prover", "db").Inc()
 			time.Sleep(1 * time.Second)
 			continue
 		}
@@ -101,6 +170,36 @@ func (p *Prover) FetchBatchWitness() ([]*witness.BatchWitness, error) {
 	}
 }
 
+// keepTaskAlive renews the lease of the task until the returned function is
+// called.
+func (p *Prover) keepTaskAlive(batchHeight int64) (stop func()) {
+	quit := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(p.taskLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				renewed, err := p.taskQueue.RenewTask(int(batchHeight))
+				if err != nil {
+					fmt.Println("renew task lease failed: ", err.Error())
+					utils.ServiceErrors.WithLabelValues("prover", "lease").Inc()
+					continue
+				}
+				if !renewed {
+					// the proof is still saved, the prover which pops the
+					// task again finds it
+					fmt.Printf("the lease of task %d expired\n", batchHeight)
+					return
+				}
+			}
+		}
+	}()
+	return func() { close(quit) }
+}
+
 func (p *Prover) FetchBatchWitnessForRerun() ([]*witness.BatchWitness, error) {
 	var blockWitness *witness.BatchWitness
 	var err error
@@ -108,6 +207,7 @@ func (p *Prover) FetchBatchWitnessForRerun() ([]*witness.BatchWitness, error) {
 		blockWitness, err = p.witnessModel.GetLatestBatchWitnessByStatus(witness.StatusReceived)
 		if err == utils.DbErrQueryInterrupted || err == utils.DbErrQueryTimeout {
 			fmt.Println("get latest batch witness by status timeout, retry...:", err.Error())
+			utils.ServiceErrors.WithLabelValues("prover", "db").Inc()
 			time.Sleep(1 * time.Second)
 			continue
 		}
@@ -119,6 +219,7 @@ func (p *Prover) FetchBatchWitnessForRerun() ([]*witness.BatchWitness, error) {
 			blockWitness, err = p.witnessModel.GetLatestBatchWitnessByStatus(witness.StatusPublished)
 			if err == utils.DbErrQueryInterrupted || err == utils.DbErrQueryTimeout {
 				fmt.Println("get latest batch witness by status timeout, retry...:", err.Error())
+				utils.ServiceErrors.WithLabelValues("prover", "db").Inc()
 				time.Sleep(1 * time.Second)
 				continue
 			}
@@ -134,28 +235,31 @@ func (p *Prover) FetchBatchWitnessForRerun() ([]*witness.BatchWitness, error) {
 }
 
 func (p *Prover) Run(flag bool) {
-	p.proofModel.CreateProofTable()
+	err := p.proofModel.CreateProofTable()
+	if err != nil {
+		panic(err.Error())
+	}
 	for {
 		var batchWitnesses []*witness.BatchWitness
 		var err error
 		if !flag {
-			// when the task is removed from redis queue,
-			// 1. if prover crash before updating witness status to pending, or
-			// 2. if prover crash before generating proof,
-			// then the offline rerun mechanism will be triggered to handle this situation.
+			// the task is leased to the prover, if the prover crashes before
+			// saving the proof, the task is pushed back to the task queue
+			// when its lease expires and is popped by the other provers.
 			batchWitnesses, err = p.FetchBatchWitness()
 			if errors.Is(err, utils.DbErrNotFound) {
 				fmt.Println("there is no published status witness in db, so quit")
 				fmt.Println("prover run finish...")
 				return
 			}
-			if errors.Is(err, redis.Nil) {
+			if errors.Is(err, ErrTaskQueueEmpty) {
 				fmt.Println("There is no task left in task queue")
 				fmt.Println("prover run finish...")
 				return
 			}
 			if err != nil {
 				fmt.Println("get batch witness failed: ", err.Error())
+				utils.ServiceErrors.WithLabelValues("prover", "fetch").Inc()
 				time.Sleep(10 * time.Second)
 				continue
 			}
@@ -173,72 +277,112 @@ func (p *Prover) Run(flag bool) {
 		}
 
 		for _, batchWitness := range batchWitnesses {
-			witnessForCircuit := utils.DecodeBatchWitness(batchWitness.WitnessData)
-			cexAssetListCommitments := make([][]byte, 2)
-			cexAssetListCommitments[0] = witnessForCircuit.BeforeCEXAssetsCommitment
-			cexAssetListCommitments[1] = witnessForCircuit.AfterCEXAssetsCommitment
-			accountTreeRoots := make([][]byte, 2)
-			accountTreeRoots[0] = witnessForCircuit.BeforeAccountTreeRoot
-			accountTreeRoots[1] = witnessForCircuit.AfterAccountTreeRoot
-			cexAssetListCommitmentsSerial, err := json.Marshal(cexAssetListCommitments)
-			if err != nil {
-				fmt.Println("marshal cex asset list failed: ", err.Error())
-				return
+			stop := func() {}
+			if !flag {
+				stop = p.keepTaskAlive(batchWitness.Height)
 			}
-			accountTreeRootsSerial, err := json.Marshal(accountTreeRoots)
+			err = p.proveBatchWitness(batchWitness, !flag)
+			stop()
 			if err != nil {
-				fmt.Println("marshal account tree root failed: ", err.Error())
 				return
 			}
-			proof, assetsCount, err := p.GenerateAndVerifyProof(witnessForCircuit, batchWitness.Height)
-			if err != nil {
-				fmt.Println("generate and verify proof error:", err.Error())
-				return
-			}
-			var buf bytes.Buffer
-			_, err = proof.WriteRawTo(&buf)
-			if err != nil {
-				fmt.Println("proof serialize failed")
-				return
-			}
-			proofBytes := buf.Bytes()
+		}
+	}
+}
 
-			// Check the existence of block proof.
-			for {
-				_, err = p.proofModel.GetProofByBatchNumber(batchWitness.Height)
-				if err == utils.DbErrQueryInterrupted || err == utils.DbErrQueryTimeout {
-					fmt.Println("get proof by batch number timeout, retry...:", err.Error())
-					time.Sleep(1 * time.Second)
-					continue
-				}
-				break
-			}
-			if err == nil {
-				fmt.Printf("blockProof of height %d exists\n", batchWitness.Height)
-				err = p.witnessModel.UpdateBatchWitnessStatus(batchWitness, witness.StatusFinished)
-				if err != nil {
-					fmt.Println("update witness error:", err.Error())
-				}
-				continue
-			}
+// proveBatchWitness saves the proof of the witness, the task of the witness
+// is acknowledged when it is leased from the task queue.
+func (p *Prover) proveBatchWitness(batchWitness *witness.BatchWitness, leased bool) error {
+	witnessForCircuit := utils.DecodeBatchWitness(batchWitness.WitnessData)
+	cexAssetListCommitments := make([][]byte, 2)
+	cexAssetListCommitments[0] = witnessForCircuit.BeforeCEXAssetsCommitment
+	cexAssetListCommitments[1] = witnessForCircuit.AfterCEXAssetsCommitment
+	accountTreeRoots := make([][]byte, 2)
+	accountTreeRoots[0] = witnessForCircuit.BeforeAccountTreeRoot
+	accountTreeRoots[1] = witnessForCircuit.AfterAccountTreeRoot
+	cexAssetListCommitmentsSerial, err := json.Marshal(cexAssetListCommitments)
+	if err != nil {
+		fmt.Println("marshal cex asset list failed: ", err.Error())
+		return err
+	}
+	accountTreeRootsSerial, err := json.Marshal(accountTreeRoots)
+	if err != nil {
+		fmt.Println("marshal account tree root failed: ", err.Error())
+		return err
+	}
+	var proof circuit.Proof
+	var assetsCount int
+	if len(witnessForCircuit.CreateUserOps) == 0 {
+		// the batch of an incremental snapshot, the roots and the
+		// commitments above are shared by both kinds of witness
+		proof, assetsCount, err = p.GenerateAndVerifyUpdateProof(utils.DecodeBatchUpdateWitness(batchWitness.WitnessData), batchWitness.Height)
+	} else {
+		proof, assetsCount, err = p.GenerateAndVerifyProof(witnessForCircuit, batchWitness.Height)
+	}
+	if err != nil {
+		fmt.Println("generate and verify proof error:", err.Error())
+		utils.ServiceErrors.WithLabelValues("prover", "prove").Inc()
+		return err
+	}
+	var buf bytes.Buffer
+	_, err = proof.WriteRawTo(&buf)
+	if err != nil {
+		fmt.Println("proof serialize failed")
+		return err
+	}
+	proofBytes := buf.Bytes()
 
-			var row = &Proof{
-				ProofInfo:               base64.StdEncoding.EncodeToString(proofBytes),
-				BatchNumber:             batchWitness.Height,
-				CexAssetListCommitments: string(cexAssetListCommitmentsSerial),
-				AccountTreeRoots:        string(accountTreeRootsSerial),
-				BatchCommitment:         base64.StdEncoding.EncodeToString(witnessForCircuit.BatchCommitment),
-				AssetsCount:             assetsCount,
-			}
-			err = p.proofModel.CreateProof(row)
-			if err != nil {
-				fmt.Printf("create blockProof of height %d failed\n", batchWitness.Height)
-				return
-			}
-			err = p.witnessModel.UpdateBatchWitnessStatus(batchWitness, witness.StatusFinished)
-			if err != nil {
-				fmt.Println("update witness error:", err.Error())
-			}
+	// Check the existence of block proof.
+	for {
+		_, err = p.proofModel.GetProofByBatchNumber(batchWitness.Height)
+		if err == utils.DbErrQueryInterrupted || err == utils.DbErrQueryTimeout {
+			fmt.Println("get proof by batch number timeout, retry...:", err.Error())
+			utils.ServiceErrors.WithLabelValues("prover", "db").Inc()
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		break
+	}
+	if err == nil {
+		fmt.Printf("blockProof of height %d exists\n", batchWitness.Height)
+		p.finishBatchWitness(batchWitness, leased)
+		return nil
+	}
+
+	var row = &Proof{
+		ProofInfo:               base64.StdEncoding.EncodeToString(proofBytes),
+		BatchNumber:             batchWitness.Height,
+		CexAssetListCommitments: string(cexAssetListCommitmentsSerial),
+		AccountTreeRoots:        string(accountTreeRootsSerial),
+		BatchCommitment:         base64.StdEncoding.EncodeToString(witnessForCircuit.BatchCommitment),
+		AssetsCount:             assetsCount,
+		ProvingSystem:           p.CurrentProvingSystem,
+	}
+	err = p.proofModel.CreateProof(row)
+	if err != nil {
+		fmt.Printf("create blockProof of height %d failed\n", batchWitness.Height)
+		utils.ServiceErrors.WithLabelValues("prover", "save").Inc()
+		return err
+	}
+	utils.ProverBatchesProved.WithLabelValues(strconv.Itoa(assetsCount)).Inc()
+	p.finishBatchWitness(batchWitness, leased)
+	return nil
+}
+
+// finishBatchWitness marks the witness finished, the task is acknowledged
+// only after that, otherwise it is popped again when the lease expires.
+func (p *Prover) finishBatchWitness(batchWitness *witness.BatchWitness, leased bool) {
+	err := p.witnessModel.UpdateBatchWitnessStatus(batchWitness, witness.StatusFinished)
+	if err != nil {
+		fmt.Println("update witness error:", err.Error())
+		utils.ServiceErrors.WithLabelValues("prover", "finish").Inc()
+		return
+	}
+	if leased {
+		err = p.taskQueue.AckTask(int(batchWitness.Height))
+		if err != nil {
+			fmt.Println("ack task error:", err.Error())
+			utils.ServiceErrors.WithLabelValues("prover", "finish").Inc()
 		}
 	}
 }
@@ -246,118 +390,122 @@ func (p *Prover) Run(flag bool) {
 func (p *Prover) GenerateAndVerifyProof(
 	batchWitness *utils.BatchCreateUserWitness,
 	batchNumber int64,
-) (proof groth16.Proof, assetsCount int, err error) {
-	startTime := time.Now().UnixMilli()
+) (proof circuit.Proof, assetsCount int, err error) {
 	fmt.Println("begin to generate proof for batch: ", batchNumber)
 	circuitWitness, _ := circuit.SetBatchCreateUserCircuitWitness(batchWitness)
-	// Lazy load r1cs, proving key and verifying key.
-	p.LoadSnarkParamsOnce(len(circuitWitness.CreateUserOps[0].Assets))
+	assetsCount = len(circuitWitness.CreateUserOps[0].Assets)
 	verifyWitness := circuit.NewVerifyBatchCreateUserCircuit(batchWitness.BatchCommitment)
-	witness, err := frontend.NewWitness(circuitWitness, ecc.BN254.ScalarField())
+	proof, err = p.proveAndVerify(circuitWitness, verifyWitness, assetsCount)
 	if err != nil {
 		return proof, 0, err
 	}
+	return proof, assetsCount, nil
+}
 
-	vWitness, err := frontend.NewWitness(verifyWitness, ecc.BN254.ScalarField(), frontend.PublicOnly())
-	if err != nil {
-		return proof, 0, err
+// GenerateAndVerifyUpdateProof proves the batch of an incremental snapshot,
+// ZkKeyName must be the keys of the batch update user circuit.
+func (p *Prover) GenerateAndVerifyUpdateProof(
+	batchWitness *utils.BatchUpdateUserWitness,
+	batchNumber int64,
+) (proof circuit.Proof, assetsCount int, err error) {
+	if batchWitness == nil {
+		return proof, 0, errors.New("decode invalid witness data")
 	}
-	proof, err = groth16.Prove(p.R1cs, p.ProvingKey, witness)
+	fmt.Println("begin to generate update proof for batch: ", batchNumber)
+	circuitWitness, err := circuit.SetBatchUpdateUserCircuitWitness(batchWitness)
 	if err != nil {
 		return proof, 0, err
 	}
-	endTime := time.Now().UnixMilli()
-	fmt.Println("proof generation cost ", endTime-startTime, " ms")
-
-	err = groth16.Verify(proof, p.VerifyingKey, vWitness)
+	assetsCount = batchWitness.AssetsCount
+	verifyWitness := circuit.NewVerifyBatchUpdateUserCircuit(batchWitness.BatchCommitment)
+	proof, err = p.proveAndVerify(circuitWitness, verifyWitness, assetsCount)
 	if err != nil {
 		return proof, 0, err
 	}
-	endTime2 := time.Now().UnixMilli()
-	fmt.Println("proof verification cost ", endTime2-endTime, " ms")
-	return proof, len(circuitWitness.CreateUserOps[0].Assets), nil
+	return proof, assetsCount, nil
 }
 
-func (p *Prover) LoadSnarkParamsOnce(targerAssetsCount int) {
-	if targerAssetsCount == p.CurrentSnarkParamsInUse {
-		return
+func (p *Prover) proveAndVerify(circuitWitness frontend.Circuit, verifyWitness frontend.Circuit, assetsCount int) (proof circuit.Proof, err error) {
+	startTime := time.Now().UnixMilli()
+	// Lazy load r1cs, proving key and verifying key.
+	p.LoadSnarkParamsOnce(assetsCount)
+	witness, err := frontend.NewWitness(circuitWitness, ecc.BN254.ScalarField())
+	if err != nil {
+		return proof, err
 	}
 
-	index := -1
-	for i, v := range p.AssetsCountTiers {
-		if targerAssetsCount == v {
-			index = i
-			break
-		}
+	vWitness, err := frontend.NewWitness(verifyWitness, ecc.BN254.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		return proof, err
 	}
-	if index == -1 {
-		panic("the assets count is not in the config file")
+	var proverOpts []backend.ProverOption
+	var verifierOpts []backend.VerifierOption
+	if p.RecursiveProof {
+		// the proof will be verified by the aggregation circuit
+		proverOpts = append(proverOpts, circuit.RecursiveProverOptions())
+		verifierOpts = append(verifierOpts, circuit.RecursiveVerifierOptions())
 	}
-	// Load r1cs, proving key and verifying key.
-	s := time.Now()
-	fmt.Println("begin loading r1cs of ", targerAssetsCount, " assets")
-	loadR1csChan := make(chan bool)
-	go func() {
-		for {
-
-			select {
-			case <-loadR1csChan:
-				fmt.Println("load r1cs finished...... quit")
-				return
-			case <-time.After(time.Second * 10):
-				runtime.GC()
-			}
-		}
-	}()
-
-	p.R1cs = groth16.NewCS(ecc.BN254)
-
-	r1csFromFile, err := os.ReadFile(p.SessionName[index] + ".r1cs")
-	if err != nil {
-		panic("r1cs file load error..." + err.Error())
+	if p.SolidityProof {
+		proverOpts = append(proverOpts, circuit.SolidityProverOptions(p.CurrentProvingSystem))
+		verifierOpts = append(verifierOpts, circuit.SolidityVerifierOptions(p.CurrentProvingSystem))
 	}
-	buf := bytes.NewBuffer(r1csFromFile)
-	n, err := p.R1cs.ReadFrom(buf)
+	// startTime includes the key loading
+	proveStartTime := time.Now()
+	proof, err = circuit.Prove(p.CurrentProvingSystem, p.R1cs, p.ProvingKey, witness, proverOpts...)
 	if err != nil {
-		panic("r1cs read error..." + err.Error())
+		return proof, err
 	}
-	fmt.Println("r1cs read size is ", n)
-	loadR1csChan <- true
-	runtime.GC()
-	et := time.Now()
-	fmt.Println("finish loading r1cs.... the time cost is ", et.Sub(s))
+	endTime := time.Now().UnixMilli()
+	fmt.Println("proof generation cost ", endTime-startTime, " ms")
+	assetsCountLabel := strconv.Itoa(assetsCount)
+	utils.ProofGenerationSeconds.WithLabelValues(assetsCountLabel).Observe(time.Since(proveStartTime).Seconds())
 
-	// read proving and verifying keys
-	fmt.Println("begin loading proving key of ", targerAssetsCount, " assets")
-	s = time.Now()
-	pkFromFile, err := os.ReadFile(p.SessionName[index] + ".pk")
+	err = circuit.Verify(p.CurrentProvingSystem, proof, p.VerifyingKey, vWitness, verifierOpts...)
 	if err != nil {
-		panic("provingKey file load error:" + err.Error())
+		return proof, err
 	}
-	buf = bytes.NewBuffer(pkFromFile)
-	p.ProvingKey = groth16.NewProvingKey(ecc.BN254)
-	n, err = p.ProvingKey.UnsafeReadFrom(buf)
-	if err != nil {
-		panic("provingKey loading error:" + err.Error())
-	}
-	fmt.Println("proving key read size is ", n)
-	et = time.Now()
-	fmt.Println("finish loading proving key... the time cost is ", et.Sub(s))
+	endTime2 := time.Now().UnixMilli()
+	fmt.Println("proof verification cost ", endTime2-endTime, " ms")
+	utils.ProofVerificationSeconds.WithLabelValues(assetsCountLabel).Observe(float64(endTime2-endTime) / 1000)
+	return proof, nil
+}
 
-	fmt.Println("begin loading verifying key of ", targerAssetsCount, " assets")
-	s = time.Now()
-	vkFromFile, err := os.ReadFile(p.SessionName[index] + ".vk")
-	if err != nil {
-		panic("verifyingKey file load error:" + err.Error())
+// CheckConfig normalizes the proving systems of the config and checks that the
+// tiers, key names and proof options are consistent.
+func CheckConfig(proverConfig *config.Config) {
+	var err error
+	if len(proverConfig.AssetsCountTiers) != len(proverConfig.ZkKeyName) {
+		panic("asset tiers and asset tier names should have the same length")
+	}
+	if len(proverConfig.ProvingSystems) == 0 {
+		proverConfig.ProvingSystems = make([]string, len(proverConfig.AssetsCountTiers))
 	}
-	buf = bytes.NewBuffer(vkFromFile)
-	p.VerifyingKey = groth16.NewVerifyingKey(ecc.BN254)
-	n, err = p.VerifyingKey.ReadFrom(buf)
+	if len(proverConfig.ProvingSystems) != len(proverConfig.AssetsCountTiers) {
+		panic("asset tiers and proving systems should have the same length")
+	}
+	err = utils.CheckAssetsCountTiers(proverConfig.AssetsCountTiers)
 	if err != nil {
-		panic("verifyingKey loading error:" + err.Error())
+		panic(err.Error())
+	}
+	for _, k := range proverConfig.TaskTiers {
+		if !slices.Contains(proverConfig.AssetsCountTiers, k) {
+			panic(fmt.Sprintf("the task tier %d is not in the asset tiers", k))
+		}
+	}
+	for i := range proverConfig.ProvingSystems {
+		proverConfig.ProvingSystems[i], err = circuit.NormalizeProvingSystem(proverConfig.ProvingSystems[i])
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	if proverConfig.RecursiveProof {
+		for _, provingSystem := range proverConfig.ProvingSystems {
+			if provingSystem != circuit.ProvingSystemGroth16 {
+				panic("recursive proof is only supported by groth16")
+			}
+		}
+		if proverConfig.SolidityProof {
+			panic("recursive proof can't be verified by the solidity verifier")
+		}
 	}
-	fmt.Println("verifying key read size is ", n)
-	et = time.Now()
-	fmt.Println("finish loading verifying key.. the time cost is ", et.Sub(s))
-	p.CurrentSnarkParamsInUse = targerAssetsCount
 }
@@ -126,7 +126,7 @@ func TestMockProver(t *testing.T) {
 					fmt.Println("prover run finish...")
 					return
 				}
-				if errors.Is(err, redis.Nil) {
+				if errors.Is(err, ErrTaskQueueEmpty) {
 					fmt.Println("There is no task left in task queue")
 					fmt.Println("prover run finish...")
 					return
@@ -156,6 +156,10 @@ func TestMockProver(t *testing.T) {
 						fmt.Println("update witness error:", err.Error())
 						panic(err.Error())
 					}
+					err = prover.taskQueue.AckTask(int(batchWitness.Height))
+					if err != nil {
+						panic(err.Error())
+					}
 				}
 			}
 		}(i)
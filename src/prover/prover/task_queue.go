@@ -0,0 +1,439 @@
+package prover
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrTaskQueueEmpty = errors.New("there is no task left in task queue")
+
+// TaskQueue is the queue of the batch heights to be proved.
+type TaskQueue interface {
+	// PopTask returns ErrTaskQueueEmpty when there is no task left, the
+	// popped task is leased to the caller until it is acknowledged
+	PopTask() (int, error)
+	// RenewTask extends the lease of the task, it returns false when the
+	// lease has expired and the task was pushed back to the queue
+	RenewTask(height int) (bool, error)
+	// AckTask removes the task after its proof is saved
+	AckTask(height int) error
+}
+
+// ManagedTaskQueue is the task queue which can be refilled and inspected by
+// the orchestrator.
+type ManagedTaskQueue interface {
+	TaskQueue
+	// PushTasks pushes the tasks of the assets count tier, the tier is 0
+	// for the witness generated before the tiers are recorded
+	PushTasks(assetsCountTier int, heights []int) error
+	// PendingTasks returns the tasks in the queue and the leased tasks,
+	// including the tasks of the expired leases
+	PendingTasks() (map[int]bool, error)
+	// LiveLeases returns the number of the leases which haven't expired,
+	// which are held by the running provers
+	LiveLeases() (int, error)
+}
+
+const (
+	// TaskQueueNamePrefix is followed by the db suffix, see TaskQueueName
+	TaskQueueNamePrefix     = "por_batch_task_queue_"
+	DefaultTaskLeaseSeconds = 120
+	taskQueueWaitTime       = 10 * time.Second
+	taskQueuePollInterval   = 5 * time.Second
+)
+
+// popTaskScript pushes the tasks of the expired leases back to the queue and
+// leases the next task to the owner ARGV[2], the time of redis is used so that
+// the clocks of the provers don't matter. It returns the task or "", the count
+// of leases and the length of the queue.
+var popTaskScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local expired = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', now)
+for _, height in ipairs(expired) do
+	redis.call('ZREM', KEYS[2], height)
+	redis.call('HDEL', KEYS[3], height)
+	redis.call('RPUSH', KEYS[1], height)
+end
+local height = redis.call('RPOP', KEYS[1])
+if height then
+	redis.call('ZADD', KEYS[2], now + tonumber(ARGV[1]), height)
+	redis.call('HSET', KEYS[3], height, ARGV[2])
+else
+	height = ''
+end
+return {height, redis.call('ZCARD', KEYS[2]), redis.call('LLEN', KEYS[1])}
+`)
+
+// renewTaskScript only renews the lease held by the owner ARGV[3], the lease
+// which expired and was popped by another prover isn't renewed.
+var renewTaskScript = redis.NewScript(`
+if not redis.call('ZSCORE', KEYS[1], ARGV[1]) or redis.call('HGET', KEYS[2], ARGV[1]) ~= ARGV[3] then
+	return 0
+end
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call('ZADD', KEYS[1], now + tonumber(ARGV[2]), ARGV[1])
+return 1
+`)
+
+// ackTaskScript removes the lease held by the owner ARGV[2].
+var ackTaskScript = redis.NewScript(`
+if redis.call('HGET', KEYS[2], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+return 1
+`)
+
+type RedisTaskQueue struct {
+	redisCli *redis.Client
+	name     string
+	// the sorted set of the leased tasks scored by the lease expiry
+	leaseName string
+	// the hash of the leased tasks to their owners
+	ownerName string
+	owner     string
+	lease     time.Duration
+}
+
+// NewRedisTaskQueue returns the queue filled by zkpor queue push.
+// The task whose lease isn't renewed within lease is popped again by the
+// other provers. Every queue is a different lease owner.
+func NewRedisTaskQueue(redisCli *redis.Client, name string, lease time.Duration) *RedisTaskQueue {
+	owner := make([]byte, 16)
+	if _, err := rand.Read(owner); err != nil {
+		panic(err.Error())
+	}
+	return &RedisTaskQueue{
+		redisCli:  redisCli,
+		name:      name,
+		leaseName: name + "_lease",
+		ownerName: name + "_lease_owner",
+		owner:     hex.EncodeToString(owner),
+		lease:     lease,
+	}
+}
+
+func (q *RedisTaskQueue) PopTask() (int, error) {
+	deadline := time.Now().Add(taskQueueWaitTime)
+	for {
+		height, leases, depth, err := q.tryPopTask()
+		if err != nil {
+			return -1, err
+		}
+		utils.TaskQueueLeases.Set(float64(leases))
+		utils.TaskQueueDepth.Set(float64(depth))
+		if height >= 0 {
+			return height, nil
+		}
+		// wait for the leased tasks, they are popped again if their
+		// provers crash
+		if leases == 0 && time.Now().After(deadline) {
+			return -1, ErrTaskQueueEmpty
+		}
+		time.Sleep(taskQueuePollInterval)
+	}
+}
+
+// tryPopTask leases the next task without waiting, the height is -1 when the
+// queue is empty. It also returns the count of leases and the length of the
+// queue.
+func (q *RedisTaskQueue) tryPopTask() (height int, leases int64, depth int64, err error) {
+	result, err := popTaskScript.Run(context.Background(), q.redisCli, []string{q.name, q.leaseName, q.ownerName}, q.lease.Milliseconds(), q.owner).Slice()
+	if err != nil {
+		return -1, 0, 0, err
+	}
+	leases, _ = result[1].(int64)
+	depth, _ = result[2].(int64)
+	if batchHeightStr, _ := result[0].(string); batchHeightStr != "" {
+		height, err = strconv.Atoi(batchHeightStr)
+		return height, leases, depth, err
+	}
+	return -1, leases, depth, nil
+}
+
+func (q *RedisTaskQueue) RenewTask(height int) (bool, error) {
+	renewed, err := renewTaskScript.Run(context.Background(), q.redisCli, []string{q.leaseName, q.ownerName}, height, q.lease.Milliseconds(), q.owner).Int()
+	if err != nil {
+		return false, err
+	}
+	return renewed == 1, nil
+}
+
+// AckTask is a no-op when the lease has expired and the task was popped by
+// another prover, the other prover finds the saved proof.
+func (q *RedisTaskQueue) AckTask(height int) error {
+	return ackTaskScript.Run(context.Background(), q.redisCli, []string{q.leaseName, q.ownerName}, height, q.owner).Err()
+}
+
+// PushTasks pushes the tasks in the same order as zkpor queue push.
+func (q *RedisTaskQueue) PushTasks(heights []int) error {
+	if len(heights) == 0 {
+		return nil
+	}
+	values := make([]interface{}, len(heights))
+	for i, height := range heights {
+		values[i] = height
+	}
+	return q.redisCli.LPush(context.Background(), q.name, values...).Err()
+}
+
+func (q *RedisTaskQueue) PendingTasks() (map[int]bool, error) {
+	ctx := context.Background()
+	queued, err := q.redisCli.LRange(ctx, q.name, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	leased, err := q.redisCli.ZRange(ctx, q.leaseName, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	tasks := make(map[int]bool, len(queued)+len(leased))
+	for _, heightStr := range append(queued, leased...) {
+		height, err := strconv.Atoi(heightStr)
+		if err != nil {
+			return nil, err
+		}
+		tasks[height] = true
+	}
+	return tasks, nil
+}
+
+func (q *RedisTaskQueue) LiveLeases() (int, error) {
+	ctx := context.Background()
+	now, err := q.redisCli.Time(ctx).Result()
+	if err != nil {
+		return 0, err
+	}
+	count, err := q.redisCli.ZCount(ctx, q.leaseName, "("+strconv.FormatInt(now.UnixMilli(), 10), "+inf").Result()
+	return int(count), err
+}
+
+// TaskQueueName returns the queue of the tasks of the assets count tier, the
+// tasks of tier 0 are in the queue of name, which is shared by all tiers.
+func TaskQueueName(name string, assetsCountTier int) string {
+	if assetsCountTier == 0 {
+		return name
+	}
+	return name + "_" + strconv.Itoa(assetsCountTier)
+}
+
+// TierTaskQueue is the task queues of the assets count tiers of a prover.
+// The queue of the tier of the last popped task is popped first, so the
+// prover keeps proving the batches whose keys are loaded and only loads the
+// keys of another tier when the queue of its tier is empty.
+type TierTaskQueue struct {
+	sync.Mutex
+	redisCli *redis.Client
+	name     string
+	lease    time.Duration
+	// tiers are the tiers whose queues are popped, in the order of their
+	// queues in queues
+	tiers    []int
+	queues   []*RedisTaskQueue
+	lastTier int
+	// leased maps the height of the popped task to the index of its queue
+	leased map[int]int
+}
+
+// NewTierTaskQueue returns the queues of the tiers of name, tier 0 is the
+// queue shared by all tiers.
+func NewTierTaskQueue(redisCli *redis.Client, name string, lease time.Duration, tiers []int) *TierTaskQueue {
+	q := &TierTaskQueue{
+		redisCli: redisCli,
+		name:     name,
+		lease:    lease,
+		tiers:    tiers,
+		queues:   make([]*RedisTaskQueue, len(tiers)),
+		lastTier: -1,
+		leased:   make(map[int]int),
+	}
+	for i, k := range tiers {
+		q.queues[i] = NewRedisTaskQueue(redisCli, TaskQueueName(name, k), lease)
+	}
+	return q
+}
+
+// popOrder returns the indexes of the queues, starting from the queue of the
+// last popped task.
+func (q *TierTaskQueue) popOrder() []int {
+	order := make([]int, 0, len(q.tiers))
+	for i, k := range q.tiers {
+		if k == q.lastTier {
+			order = append(order, i)
+		}
+	}
+	for i, k := range q.tiers {
+		if k != q.lastTier {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+func (q *TierTaskQueue) PopTask() (int, error) {
+	deadline := time.Now().Add(taskQueueWaitTime)
+	for {
+		var totalLeases, totalDepth int64
+		for _, i := range q.popOrder() {
+			height, leases, depth, err := q.queues[i].tryPopTask()
+			if err != nil {
+				return -1, err
+			}
+			totalLeases += leases
+			totalDepth += depth
+			if height >= 0 {
+				if q.tiers[i] != q.lastTier {
+					fmt.Println("pop the tasks of the queue", q.queues[i].name)
+				}
+				q.Lock()
+				q.leased[height] = i
+				q.Unlock()
+				q.lastTier = q.tiers[i]
+				return height, nil
+			}
+		}
+		utils.TaskQueueLeases.Set(float64(totalLeases))
+		utils.TaskQueueDepth.Set(float64(totalDepth))
+		if totalLeases == 0 && time.Now().After(deadline) {
+			return -1, ErrTaskQueueEmpty
+		}
+		time.Sleep(taskQueuePollInterval)
+	}
+}
+
+// leasedQueue returns the queue which the task is popped from.
+func (q *TierTaskQueue) leasedQueue(height int) (*RedisTaskQueue, error) {
+	q.Lock()
+	defer q.Unlock()
+	i, ok := q.leased[height]
+	if !ok {
+		return nil, fmt.Errorf("task %d is not popped from the task queue", height)
+	}
+	return q.queues[i], nil
+}
+
+func (q *TierTaskQueue) RenewTask(height int) (bool, error) {
+	queue, err := q.leasedQueue(height)
+	if err != nil {
+		return false, err
+	}
+	return queue.RenewTask(height)
+}
+
+func (q *TierTaskQueue) AckTask(height int) error {
+	queue, err := q.leasedQueue(height)
+	if err != nil {
+		return err
+	}
+	err = queue.AckTask(height)
+	if err != nil {
+		return err
+	}
+	q.Lock()
+	delete(q.leased, height)
+	q.Unlock()
+	return nil
+}
+
+// PushTasks pushes the tasks to the queue of the tier, which needn't be one
+// of the tiers popped by q.
+func (q *TierTaskQueue) PushTasks(assetsCountTier int, heights []int) error {
+	return NewRedisTaskQueue(q.redisCli, TaskQueueName(q.name, assetsCountTier), q.lease).PushTasks(heights)
+}
+
+func (q *TierTaskQueue) PendingTasks() (map[int]bool, error) {
+	tasks := make(map[int]bool)
+	for _, queue := range q.queues {
+		pending, err := queue.PendingTasks()
+		if err != nil {
+			return nil, err
+		}
+		for height := range pending {
+			tasks[height] = true
+		}
+	}
+	return tasks, nil
+}
+
+func (q *TierTaskQueue) LiveLeases() (int, error) {
+	total := 0
+	for _, queue := range q.queues {
+		count, err := queue.LiveLeases()
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// LocalTaskQueue is the in-process task queue of the local pipeline mode, the
+// tasks are popped in the order they are pushed. The tasks are not leased as
+// they are lost with the process anyway.
+type LocalTaskQueue struct {
+	sync.Mutex
+	heights []int
+}
+
+func NewLocalTaskQueue() *LocalTaskQueue {
+	return &LocalTaskQueue{}
+}
+
+func (q *LocalTaskQueue) PushTask(height int) {
+	q.Lock()
+	defer q.Unlock()
+	q.heights = append(q.heights, height)
+}
+
+func (q *LocalTaskQueue) PopTask() (int, error) {
+	q.Lock()
+	defer q.Unlock()
+	if len(q.heights) == 0 {
+		return -1, ErrTaskQueueEmpty
+	}
+	height := q.heights[0]
+	q.heights = q.heights[1:]
+	return height, nil
+}
+
+func (q *LocalTaskQueue) RenewTask(height int) (bool, error) {
+	return true, nil
+}
+
+func (q *LocalTaskQueue) AckTask(height int) error {
+	return nil
+}
+
+// PushTasks pushes the tasks of all tiers to the same queue.
+func (q *LocalTaskQueue) PushTasks(assetsCountTier int, heights []int) error {
+	for _, height := range heights {
+		q.PushTask(height)
+	}
+	return nil
+}
+
+func (q *LocalTaskQueue) PendingTasks() (map[int]bool, error) {
+	q.Lock()
+	defer q.Unlock()
+	tasks := make(map[int]bool, len(q.heights))
+	for _, height := range q.heights {
+		tasks[height] = true
+	}
+	return tasks, nil
+}
+
+func (q *LocalTaskQueue) LiveLeases() (int, error) {
+	return 0, nil
+}
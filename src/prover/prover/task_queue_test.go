@@ -0,0 +1,155 @@
+package prover
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/prover/config"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/witness"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestFetchBatchWitnessOfExpiredLease(t *testing.T) {
+	db, err := utils.NewEmbeddedDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	witnessModel := witness.NewEmbeddedWitnessModel(db, "test")
+	err = witnessModel.CreateBatchWitness([]witness.BatchWitness{
+		{Height: 0, WitnessData: "data", Status: witness.StatusFinished},
+		// received by a prover which crashed
+		{Height: 1, WitnessData: "data", Status: witness.StatusReceived},
+		{Height: 2, WitnessData: "data", Status: witness.StatusPublished},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	taskQueue := NewLocalTaskQueue()
+	// the tasks of the expired leases are pushed back to the queue
+	for _, height := range []int{0, 1, 2} {
+		taskQueue.PushTask(height)
+	}
+	p := NewProverWithModels(&config.Config{}, witnessModel, NewEmbeddedProofModel(db, "test"), taskQueue)
+
+	for _, height := range []int64{1, 2} {
+		batchWitnesses, err := p.FetchBatchWitness()
+		if err != nil || len(batchWitnesses) != 1 || batchWitnesses[0].Height != height {
+			t.Fatalf("unexpected witnesses %v %v", batchWitnesses, err)
+		}
+		if batchWitnesses[0].Status != witness.StatusReceived {
+			t.Fatalf("unexpected status %d", batchWitnesses[0].Status)
+		}
+	}
+	if _, err = p.FetchBatchWitness(); err != ErrTaskQueueEmpty {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestTierTaskQueuePopOrder(t *testing.T) {
+	q := NewTierTaskQueue(nil, TaskQueueNamePrefix+"test", time.Minute, []int{50, 500, 0})
+	if q.queues[1].name != "por_batch_task_queue_test_500" || q.queues[2].name != "por_batch_task_queue_test" {
+		t.Fatalf("unexpected queue names %s %s", q.queues[1].name, q.queues[2].name)
+	}
+	if order := q.popOrder(); !slices.Equal(order, []int{0, 1, 2}) {
+		t.Fatalf("unexpected order %v", order)
+	}
+	// the queue of the tier whose keys are loaded is popped first
+	q.lastTier = 500
+	if order := q.popOrder(); !slices.Equal(order, []int{1, 0, 2}) {
+		t.Fatalf("unexpected order %v", order)
+	}
+	if _, err := q.RenewTask(3); err == nil {
+		t.Fatal("the task which isn't popped can't be renewed")
+	}
+}
+
+func TestRedisTaskQueueLease(t *testing.T) {
+	server := miniredis.RunT(t)
+	// the leases expire by the time of redis
+	now := time.Unix(1700000000, 0)
+	server.SetTime(now)
+	redisCli := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer redisCli.Close()
+	q1 := NewRedisTaskQueue(redisCli, TaskQueueNamePrefix+"test", time.Minute)
+	q2 := NewRedisTaskQueue(redisCli, TaskQueueNamePrefix+"test", time.Minute)
+	if err := q1.PushTasks([]int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	checkPending := func(expected ...int) {
+		t.Helper()
+		tasks, err := q1.PendingTasks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tasks) != len(expected) {
+			t.Fatalf("unexpected pending tasks %v", tasks)
+		}
+		for _, height := range expected {
+			if !tasks[height] {
+				t.Fatalf("unexpected pending tasks %v", tasks)
+			}
+		}
+	}
+
+	height, err := q1.PopTask()
+	if err != nil || height != 1 {
+		t.Fatalf("unexpected task %d %v", height, err)
+	}
+	if leases, err := q1.LiveLeases(); err != nil || leases != 1 {
+		t.Fatalf("unexpected leases %d %v", leases, err)
+	}
+	// only the owner renews and acknowledges the lease
+	if renewed, err := q2.RenewTask(1); err != nil || renewed {
+		t.Fatalf("the lease is renewed by a non-owner %v", err)
+	}
+	if err = q2.AckTask(1); err != nil {
+		t.Fatal(err)
+	}
+	checkPending(1, 2)
+	if renewed, err := q1.RenewTask(1); err != nil || !renewed {
+		t.Fatalf("the lease isn't renewed by its owner %v", err)
+	}
+
+	// the expired lease is popped again by the other prover
+	server.SetTime(now.Add(2 * time.Minute))
+	if leases, err := q1.LiveLeases(); err != nil || leases != 0 {
+		t.Fatalf("unexpected leases %d %v", leases, err)
+	}
+	height, leases, depth, err := q2.tryPopTask()
+	if err != nil || height != 1 || leases != 1 || depth != 1 {
+		t.Fatalf("unexpected task %d %d %d %v", height, leases, depth, err)
+	}
+	if renewed, err := q1.RenewTask(1); err != nil || renewed {
+		t.Fatalf("the expired lease is renewed %v", err)
+	}
+	if err = q1.AckTask(1); err != nil {
+		t.Fatal(err)
+	}
+	checkPending(1, 2)
+	if renewed, err := q2.RenewTask(1); err != nil || !renewed {
+		t.Fatalf("the lease isn't renewed by its owner %v", err)
+	}
+	if err = q2.AckTask(1); err != nil {
+		t.Fatal(err)
+	}
+	checkPending(2)
+
+	height, err = q1.PopTask()
+	if err != nil || height != 2 {
+		t.Fatalf("unexpected task %d %v", height, err)
+	}
+	if err = q1.AckTask(2); err != nil {
+		t.Fatal(err)
+	}
+	checkPending()
+	// the acknowledged task isn't popped again after its lease expires
+	server.SetTime(now.Add(10 * time.Minute))
+	height, leases, depth, err = q2.tryPopTask()
+	if err != nil || height != -1 || leases != 0 || depth != 0 {
+		t.Fatalf("unexpected task %d %d %d %v", height, leases, depth, err)
+	}
+}
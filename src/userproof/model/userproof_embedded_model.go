@@ -0,0 +1,106 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+)
+
+type embeddedUserProofModel struct {
+	table string
+	// maps the account id to the account index
+	idTable string
+	db      *utils.EmbeddedDB
+}
+
+// NewEmbeddedUserProofModel returns the userproof table of the local pipeline
+// mode.
+func NewEmbeddedUserProofModel(db *utils.EmbeddedDB, suffix string) UserProofModel {
+	return &embeddedUserProofModel{
+		table:   TableNamePreifx + suffix,
+		idTable: TableNamePreifx + suffix + "_account_id",
+		db:      db,
+	}
+}
+
+func (m *embeddedUserProofModel) CreateUserProofTable() error {
+	return nil
+}
+
+func (m *embeddedUserProofModel) DropUserProofTable() error {
+	err := m.db.Drop(m.table)
+	if err != nil {
+		return err
+	}
+	return m.db.Drop(m.idTable)
+}
+
+func (m *embeddedUserProofModel) CreateUserProofs(rows []UserProof) error {
+	var batch utils.EmbeddedBatch
+	now := time.Now()
+	for _, row := range rows {
+		row.CreatedAt = now
+		row.UpdatedAt = now
+		err := batch.Put(m.table, utils.EmbeddedUint64Key(uint64(row.AccountIndex)), &row)
+		if err != nil {
+			return err
+		}
+		err = batch.Put(m.idTable, []byte(row.AccountId), row.AccountIndex)
+		if err != nil {
+			return err
+		}
+	}
+	return m.db.Write(&batch)
+}
+
+func (m *embeddedUserProofModel) GetUserProofByIndex(id uint32) (*UserProof, error) {
+	userproof := &UserProof{}
+	err := m.db.Get(m.table, utils.EmbeddedUint64Key(uint64(id)), userproof)
+	if err != nil {
+		return nil, err
+	}
+	return userproof, nil
+}
+
+func (m *embeddedUserProofModel) GetUserProofById(id string) (*UserProof, error) {
+	var index uint32
+	err := m.db.Get(m.idTable, []byte(id), &index)
+	if err != nil {
+		return nil, err
+	}
+	return m.GetUserProofByIndex(index)
+}
+
+func (m *embeddedUserProofModel) GetLatestAccountIndex() (uint32, error) {
+	userproof := &UserProof{}
+	err := m.db.Last(m.table, userproof)
+	if err != nil {
+		return 0, err
+	}
+	return userproof.AccountIndex, nil
+}
+
+func (m *embeddedUserProofModel) GetUserCounts() (int, error) {
+	count, err := m.db.Count(m.idTable)
+	return int(count), err
+}
+
+func (m *embeddedUserProofModel) GetUserAssetsFromIndex(startIndex uint32, limit int) ([]UserProof, error) {
+	userproofs := make([]UserProof, 0, limit)
+	err := m.db.Scan(m.table, utils.EmbeddedUint64Key(uint64(startIndex)), func(value []byte) (bool, error) {
+		var userproof UserProof
+		err := json.Unmarshal(value, &userproof)
+		if err != nil {
+			return false, err
+		}
+		userproof.Proof = ""
+		userproof.Config = ""
+		userproofs = append(userproofs, userproof)
+		return len(userproofs) < limit, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return userproofs, nil
+}
@@ -2,6 +2,8 @@ package model
 
 import (
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"math/big"
 	"time"
@@ -20,6 +22,7 @@ type (
 		GetUserProofById(id string) (*UserProof, error)
 		GetLatestAccountIndex() (uint32, error)
 		GetUserCounts() (int, error)
+		GetUserAssetsFromIndex(startIndex uint32, limit int) ([]UserProof, error)
 	}
 
 	defaultUserProofModel struct {
@@ -50,6 +53,19 @@ type (
 		Assets          []utils.AccountAsset
 		Root            string
 		Proof           [][]byte
+		// AssetRegistryHash is the hash of the asset registry which scales
+		// the balances of Assets
+		AssetRegistryHash string
+	}
+
+	// ZkUserConfig is given to the user instead of UserConfig when the merkle
+	// siblings and the account totals must not be revealed
+	ZkUserConfig struct {
+		AccountIdHash string
+		Assets        []utils.AccountAsset
+		Root          string
+		ProvingSystem string
+		Proof         string
 	}
 )
 
@@ -65,6 +81,9 @@ func NewUserProofModel(db *utils.DB, suffix string) UserProofModel {
 }
 
 func (m *defaultUserProofModel) CreateUserProofTable() error {
+	if m.db.Driver() != utils.DbDriverMysql {
+		return m.createPortableUserProofTable()
+	}
 	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
 		account_index INT UNSIGNED NOT NULL UNIQUE,
 		account_id VARCHAR(255) NOT NULL UNIQUE,
@@ -84,6 +103,26 @@ func (m *defaultUserProofModel) CreateUserProofTable() error {
 	return err
 }
 
+// createPortableUserProofTable creates the table of postgres and sqlite, the
+// unique constraints index account_index and account_id
+func (m *defaultUserProofModel) createPortableUserProofTable() error {
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		account_index BIGINT NOT NULL UNIQUE,
+		account_id VARCHAR(255) NOT NULL UNIQUE,
+		account_leaf_hash TEXT NOT NULL,
+		total_equity VARCHAR(255) NOT NULL,
+		total_debt VARCHAR(255) NOT NULL,
+		total_collateral VARCHAR(255) NOT NULL,
+		assets TEXT NOT NULL,
+		proof TEXT NOT NULL,
+		config TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`, m.table)
+	_, err := m.db.Exec(query)
+	return err
+}
+
 func (m *defaultUserProofModel) DropUserProofTable() error {
 	query := fmt.Sprintf("DROP TABLE IF EXISTS %s", m.table)
 	_, err := m.db.Exec(query)
@@ -95,7 +134,7 @@ func (m *defaultUserProofModel) CreateUserProofs(rows []UserProof) error {
 		return nil
 	}
 
-	query := fmt.Sprintf("INSERT INTO %s (account_index, account_id, account_leaf_hash, total_equity, total_debt, total_collateral, assets, proof, config, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, NOW(), NOW())", m.table)
+	query := fmt.Sprintf("INSERT INTO %s (account_index, account_id, account_leaf_hash, total_equity, total_debt, total_collateral, assets, proof, config, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)", m.table)
 	for _, row := range rows {
 		_, err := m.db.Exec(query, row.AccountIndex, row.AccountId, row.AccountLeafHash, row.TotalEquity, row.TotalDebt, row.TotalCollateral, row.Assets, row.Proof, row.Config)
 		if err != nil {
@@ -114,7 +153,7 @@ func (m *defaultUserProofModel) GetUserProofByIndex(id uint32) (userproof *UserP
 		return nil, utils.DbErrNotFound
 	}
 	if err != nil {
-		return nil, utils.ConvertMysqlErrToDbErr(err)
+		return nil, utils.ConvertSqlErrToDbErr(err)
 	}
 	return userproof, nil
 }
@@ -128,7 +167,7 @@ func (m *defaultUserProofModel) GetUserProofById(id string) (userproof *UserProo
 		return nil, utils.DbErrNotFound
 	}
 	if err != nil {
-		return nil, utils.ConvertMysqlErrToDbErr(err)
+		return nil, utils.ConvertSqlErrToDbErr(err)
 	}
 	return userproof, nil
 }
@@ -142,7 +181,7 @@ func (m *defaultUserProofModel) GetLatestAccountIndex() (uint32, error) {
 		return 0, utils.DbErrNotFound
 	}
 	if err != nil {
-		return 0, utils.ConvertMysqlErrToDbErr(err)
+		return 0, utils.ConvertSqlErrToDbErr(err)
 	}
 	return index, nil
 }
@@ -153,7 +192,68 @@ func (m *defaultUserProofModel) GetUserCounts() (int, error) {
 	row := m.db.QueryRowWithTimeout(query)
 	err := row.Scan(&count)
 	if err != nil {
-		return 0, utils.ConvertMysqlErrToDbErr(err)
+		return 0, utils.ConvertSqlErrToDbErr(err)
 	}
 	return int(count), nil
 }
+
+// GetUserAssetsFromIndex returns at most limit accounts whose account index is
+// not less than startIndex in ascending order of account index, the proof and
+// config columns are not selected.
+func (m *defaultUserProofModel) GetUserAssetsFromIndex(startIndex uint32, limit int) ([]UserProof, error) {
+	query := fmt.Sprintf("SELECT account_index, account_id, account_leaf_hash, total_equity, total_debt, total_collateral, assets FROM %s WHERE account_index >= ? ORDER BY account_index ASC LIMIT ?", m.table)
+	rows, err := m.db.QueryWithTimeout(query, startIndex, limit)
+	if err != nil {
+		return nil, utils.ConvertSqlErrToDbErr(err)
+	}
+	defer rows.Close()
+	userproofs := make([]UserProof, 0, limit)
+	for rows.Next() {
+		var userproof UserProof
+		err = rows.Scan(&userproof.AccountIndex, &userproof.AccountId, &userproof.AccountLeafHash, &userproof.TotalEquity, &userproof.TotalDebt, &userproof.TotalCollateral, &userproof.Assets)
+		if err != nil {
+			return nil, utils.ConvertSqlErrToDbErr(err)
+		}
+		userproofs = append(userproofs, userproof)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, utils.ConvertSqlErrToDbErr(err)
+	}
+	return userproofs, nil
+}
+
+// ConvertAccount returns the userproof row of the account, root is the hex
+// encoded account tree root.
+func ConvertAccount(account *utils.AccountInfo, leafHash []byte, proof [][]byte, root string, assetRegistryHash string) *UserProof {
+	var userProof UserProof
+	var userConfig UserConfig
+	userProof.AccountIndex = account.AccountIndex
+	userProof.AccountId = hex.EncodeToString(account.AccountId)
+	userProof.AccountLeafHash = hex.EncodeToString(leafHash)
+	proofSerial, err := json.Marshal(proof)
+	userProof.Proof = string(proofSerial)
+	assets, err := json.Marshal(account.Assets)
+	if err != nil {
+		panic(err.Error())
+	}
+	userProof.Assets = string(assets)
+	userProof.TotalDebt = account.TotalDebt.String()
+	userProof.TotalEquity = account.TotalEquity.String()
+	userProof.TotalCollateral = account.TotalCollateral.String()
+
+	userConfig.AccountIndex = account.AccountIndex
+	userConfig.AccountIdHash = hex.EncodeToString(account.AccountId)
+	userConfig.Proof = proof
+	userConfig.Root = root
+	userConfig.Assets = account.Assets
+	userConfig.TotalDebt = account.TotalDebt
+	userConfig.TotalEquity = account.TotalEquity
+	userConfig.TotalCollateral = account.TotalCollateral
+	userConfig.AssetRegistryHash = assetRegistryHash
+	configSerial, err := json.Marshal(userConfig)
+	if err != nil {
+		panic(err.Error())
+	}
+	userProof.Config = string(configSerial)
+	return &userProof
+}
@@ -0,0 +1,39 @@
+package userproof
+
+import (
+	"fmt"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/config"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/witness"
+)
+
+// AssignIncrementalAccountIndexes assigns the account indexes and the account
+// totals of the incremental snapshot in the same way as the witness service,
+// the prices and tier ratios come from the first batch witness.
+func AssignIncrementalAccountIndexes(userProofConfig *config.Config, accountsMap map[int][]utils.AccountInfo) {
+	db, err := utils.NewDBWithDriver(userProofConfig.DbDriver, userProofConfig.MysqlDataSource)
+	if err != nil {
+		panic(err.Error())
+	}
+	firstWitness, err := witness.NewWitnessModel(db, userProofConfig.DbSuffix).GetBatchWitnessByHeight(0)
+	if err != nil {
+		panic("get the first witness of the incremental snapshot failed: " + err.Error())
+	}
+	batchWitness := utils.DecodeBatchUpdateWitness(firstWitness.WitnessData)
+	if batchWitness == nil || len(batchWitness.UpdateUserOps) == 0 {
+		panic("decode invalid witness data")
+	}
+	prevAccounts, err := witness.LoadPrevAccounts(model.NewUserProofModel(db, userProofConfig.PrevDbSuffix))
+	if err != nil {
+		panic(err.Error())
+	}
+	updates, _, err := witness.ComputeAccountUpdates(accountsMap, prevAccounts, batchWitness.BeforeCexAssets, batchWitness.InsertStartAccountIndex)
+	if err != nil {
+		panic(err.Error())
+	}
+	for k, v := range updates {
+		fmt.Println("the asset counts of user is ", k, "update ops number is ", len(v))
+	}
+}
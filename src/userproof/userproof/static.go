@@ -0,0 +1,193 @@
+package userproof
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
+)
+
+const (
+	// StaticManifestFile is written to the static proof directory after all
+	// user files are written
+	StaticManifestFile = "manifest.json"
+	// staticProgressFile records the number of accounts whose files are
+	// written, in the order of the accounts of the userproof table
+	staticProgressFile = "progress.json"
+	// staticChunkSize is the number of accounts between two progress updates
+	staticChunkSize = 10000
+	// StaticProofLayout is the path of the file of an account id hash in
+	// hex, relative to the static proof directory
+	StaticProofLayout = "<accountIdHash[0:2]>/<accountIdHash[2:4]>/<accountIdHash>.json"
+)
+
+type StaticManifest struct {
+	Root              string
+	AssetRegistryHash string
+	AccountCount      int
+	Layout            string
+	CreatedAt         time.Time
+}
+
+type staticProgress struct {
+	Root  string
+	Count int
+}
+
+// StaticProofPath returns the file of the hex encoded account id hash in the
+// static proof directory, the files are sharded by the first two bytes of
+// the hash so that a directory holds about 1500 files of 100M users.
+func StaticProofPath(dir string, accountIdHash string) string {
+	return filepath.Join(dir, accountIdHash[0:2], accountIdHash[2:4], accountIdHash+".json")
+}
+
+type staticJob struct {
+	seq   int
+	proof *model.UserProof
+}
+
+// staticProofWriter writes the user config of every account to its own file
+// with parallel writers. The accounts are numbered in the order they are
+// generated, the progress is advanced when all files of a chunk of accounts
+// are written, so a restarted writer only rewrites the files after it.
+type staticProofWriter struct {
+	dir   string
+	root  string
+	start int
+	jobs  chan staticJob
+	wg    sync.WaitGroup
+
+	lock       sync.Mutex
+	chunks     map[int]int
+	nextChunk  int
+	writeError error
+}
+
+func newStaticProofWriter(dir string, root string, writers int) (*staticProofWriter, error) {
+	w := &staticProofWriter{
+		dir:    dir,
+		root:   root,
+		jobs:   make(chan staticJob, 1000),
+		chunks: make(map[int]int),
+	}
+	content, err := os.ReadFile(filepath.Join(dir, staticProgressFile))
+	resumed := err == nil
+	if resumed {
+		var progress staticProgress
+		err = json.Unmarshal(content, &progress)
+		if err != nil {
+			return nil, err
+		}
+		if progress.Root != root {
+			return nil, fmt.Errorf("the static proofs in %s are of the account tree root %s", dir, progress.Root)
+		}
+		w.start = progress.Count
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if !resumed {
+		// the progress is saved after the shard directories are created,
+		// so the directories exist when the writer is resumed
+		for i := 0; i < 256*256; i++ {
+			err = os.MkdirAll(filepath.Join(dir, fmt.Sprintf("%02x", i>>8), fmt.Sprintf("%02x", i&0xff)), 0755)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = w.saveProgress(w.start)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if writers <= 0 {
+		writers = 16
+	}
+	for i := 0; i < writers; i++ {
+		w.wg.Add(1)
+		go w.run()
+	}
+	return w, nil
+}
+
+// Start is the number of accounts whose files are written by the previous
+// runs.
+func (w *staticProofWriter) Start() int {
+	return w.start
+}
+
+func (w *staticProofWriter) Write(seq int, proof *model.UserProof) {
+	w.jobs <- staticJob{seq: seq, proof: proof}
+}
+
+func (w *staticProofWriter) run() {
+	defer w.wg.Done()
+	for job := range w.jobs {
+		err := os.WriteFile(StaticProofPath(w.dir, job.proof.AccountId), []byte(job.proof.Config), 0644)
+		w.finish(job.seq, err)
+	}
+}
+
+// finish counts the written file, and saves the progress when the files of
+// the lowest chunk are all written.
+func (w *staticProofWriter) finish(seq int, err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if err != nil {
+		if w.writeError == nil {
+			w.writeError = err
+		}
+		return
+	}
+	chunk := (seq - w.start) / staticChunkSize
+	w.chunks[chunk]++
+	advanced := false
+	for w.chunks[w.nextChunk] == staticChunkSize {
+		delete(w.chunks, w.nextChunk)
+		w.nextChunk++
+		advanced = true
+	}
+	if advanced && w.writeError == nil {
+		w.writeError = w.saveProgress(w.start + w.nextChunk*staticChunkSize)
+	}
+}
+
+func (w *staticProofWriter) saveProgress(count int) error {
+	content, err := json.Marshal(staticProgress{Root: w.root, Count: count})
+	if err != nil {
+		return err
+	}
+	// the progress is replaced at once, so it is never seen half written
+	tmp := filepath.Join(w.dir, staticProgressFile+".tmp")
+	err = os.WriteFile(tmp, content, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(w.dir, staticProgressFile))
+}
+
+// Close waits for the writers, then writes the manifest of all accounts.
+func (w *staticProofWriter) Close(manifest *StaticManifest) error {
+	close(w.jobs)
+	w.wg.Wait()
+	if w.writeError != nil {
+		return w.writeError
+	}
+	err := w.saveProgress(manifest.AccountCount)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(w.dir, StaticManifestFile), content, 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Println("write static proofs of", manifest.AccountCount, "accounts to", w.dir)
+	return nil
+}
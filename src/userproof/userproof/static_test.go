@@ -0,0 +1,56 @@
+package userproof
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
+)
+
+func TestStaticProofWriter(t *testing.T) {
+	dir := t.TempDir()
+	proof := func(seq int) *model.UserProof {
+		return &model.UserProof{AccountId: fmt.Sprintf("%064x", seq), Config: fmt.Sprintf(`{"AccountIndex":%d}`, seq)}
+	}
+	w, err := newStaticProofWriter(dir, "root", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the writer stops after the first chunk and a half
+	for seq := 0; seq < staticChunkSize*3/2; seq++ {
+		w.Write(seq, proof(seq))
+	}
+	close(w.jobs)
+	w.wg.Wait()
+
+	if _, err = newStaticProofWriter(dir, "another root", 4); err == nil {
+		t.Fatal("the directory of another account tree should not be resumed")
+	}
+	w, err = newStaticProofWriter(dir, "root", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Start() != staticChunkSize {
+		t.Fatalf("unexpected start %d", w.Start())
+	}
+	total := staticChunkSize*2 + 10
+	for seq := w.Start(); seq < total; seq++ {
+		w.Write(seq, proof(seq))
+	}
+	if err = w.Close(&StaticManifest{Root: "root", AccountCount: total, Layout: StaticProofLayout}); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(StaticProofPath(dir, fmt.Sprintf("%064x", total-1)))
+	if err != nil || string(content) != fmt.Sprintf(`{"AccountIndex":%d}`, total-1) {
+		t.Fatalf("unexpected file %s %v", content, err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, StaticManifestFile)); err != nil {
+		t.Fatal(err)
+	}
+	w, err = newStaticProofWriter(dir, "root", 4)
+	if err != nil || w.Start() != total {
+		t.Fatalf("unexpected start %d %v", w.Start(), err)
+	}
+}
@@ -0,0 +1,328 @@
+package userproof
+
+import (
+	"encoding/hex"
+	"fmt"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/config"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	bsmt "github.com/bnb-chain/zkbnb-smt"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
+)
+
+func HandleUserData(userProofConfig *config.Config) utils.AccountSource {
+	startTime := time.Now().UnixMilli()
+	accounts, _, err := utils.ParseUserDataSource(userProofConfig.UserDataFile, userProofConfig.SpillDir)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	endTime := time.Now().UnixMilli()
+	fmt.Println("handle user data cost ", endTime-startTime, " ms")
+	return accounts
+}
+
+type AccountLeave struct {
+	hash  []byte
+	index uint32
+}
+
+func ComputeAccountRootHash(userProofConfig *config.Config) {
+	accountTree, err := utils.NewAccountTree("memory", "")
+	fmt.Printf("empty accountTree root is %x\n", accountTree.Root())
+	if err != nil {
+		panic(err.Error())
+	}
+	accounts, _, err := utils.ParseUserDataSet(userProofConfig.UserDataFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	startTime := time.Now().UnixMilli()
+	totalAccountCount := 0
+	for _, account := range accounts {
+		totalAccountCount += len(account)
+	}
+	paddingStartIndex := totalAccountCount
+	keys := make([]int, 0)
+	for k := range accounts {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	for _, key := range keys {
+		account := accounts[key]
+		paddingStartIndex, account = utils.PaddingAccounts(account, key, paddingStartIndex)
+		totalOpsNumber := len(account)
+		fmt.Println("the asset counts of user is ", key, "total ops number is ", totalOpsNumber)
+		chs := make(chan AccountLeave, 1000)
+		cpuCores := runtime.NumCPU()
+		workers := 1
+		if cpuCores > 2 {
+			workers = cpuCores - 2
+		}
+		results := make(chan bool, workers)
+		averageAccounts := (totalOpsNumber + workers - 1) / workers
+		actualWorkers := 0
+
+		for i := 0; i < workers; i++ {
+			srcAccountIndex := i * averageAccounts
+			destAccountIndex := (i + 1) * averageAccounts
+			if destAccountIndex > totalOpsNumber {
+				destAccountIndex = totalOpsNumber
+			}
+			go CalculateAccountHash(account[srcAccountIndex:destAccountIndex], chs, results)
+			if destAccountIndex == totalOpsNumber {
+				actualWorkers = i + 1
+				break
+			}
+		}
+		fmt.Println("actual workers is ", actualWorkers)
+		quit := make(chan bool, 1)
+		go CalculateAccountTreeRoot(chs, &accountTree, quit)
+
+		for i := 0; i < actualWorkers; i++ {
+			<-results
+		}
+		close(chs)
+		<-quit
+	}
+	endTime := time.Now().UnixMilli()
+	fmt.Println("user account tree generation cost ", endTime-startTime, " ms")
+	fmt.Printf("account tree root %x\n", accountTree.Root())
+}
+
+func CalculateAccountHash(accounts []utils.AccountInfo, chs chan<- AccountLeave, res chan<- bool) {
+	poseidonHasher := poseidon.NewPoseidon()
+	for i := 0; i < len(accounts); i++ {
+		chs <- AccountLeave{
+			hash:  utils.AccountInfoToHash(&accounts[i], &poseidonHasher),
+			index: accounts[i].AccountIndex,
+		}
+	}
+	res <- true
+}
+
+func CalculateAccountTreeRoot(accountLeaves <-chan AccountLeave, accountTree *bsmt.SparseMerkleTree, quit chan<- bool) {
+	num := 0
+	for accountLeaf := range accountLeaves {
+		(*accountTree).Set(uint64(accountLeaf.index), accountLeaf.hash)
+		num++
+		if num%100000 == 0 {
+			fmt.Println("for now, already set ", num, " accounts in tree")
+		}
+	}
+	quit <- true
+}
+
+// Run generates the merkle proofs of all users in the account tree of the
+// witness service and writes them to the userproof table, and to a file per
+// user in StaticProofDir when it is set. It resumes from the users already in
+// the table and the directory.
+func Run(userProofConfig *config.Config) {
+	utils.StartMetricsServer(userProofConfig.MetricsAddr)
+	accountTree, err := utils.NewAccountTree(userProofConfig.TreeDB.Driver, userProofConfig.TreeDB.Option.Addr)
+	if err != nil {
+		panic(err.Error())
+	}
+	accountSource := HandleUserData(userProofConfig)
+	defer func() { accountSource.Close() }()
+	assetRegistry, err := utils.LoadAssetRegistryFromDir(userProofConfig.UserDataFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	if userProofConfig.PrevDbSuffix != "" {
+		// the account indexes of the incremental snapshot are assigned in memory
+		accountsMap, err := utils.ReadAllAccounts(accountSource)
+		if err != nil {
+			panic(err.Error())
+		}
+		accountSource.Close()
+		AssignIncrementalAccountIndexes(userProofConfig, accountsMap)
+		accountSource = utils.NewMemoryAccountSource(accountsMap)
+	}
+	accountCounts := accountSource.Counts()
+	totalAccountCounts := 0
+	for k, count := range accountCounts {
+		totalAccountCounts += count
+		fmt.Println("the asset counts of user is ", k, "total ops number is ", count)
+	}
+	accountAssetKeys := utils.SortedTiers(accountSource)
+	fmt.Println("total accounts num", totalAccountCounts)
+	userProofModel := OpenUserProofTable(userProofConfig)
+	var currentAccountCounts int
+	for {
+		currentAccountCounts, err = userProofModel.GetUserCounts()
+		if err == utils.DbErrQueryInterrupted || err == utils.DbErrQueryTimeout {
+			fmt.Println("get user counts timeout, retry...:", err.Error())
+			utils.ServiceErrors.WithLabelValues("userproof", "db").Inc()
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		break
+	}
+
+	if err != nil && err != utils.DbErrNotFound {
+		panic(err.Error())
+	}
+	totalCounts := currentAccountCounts
+	accountTreeRoot := hex.EncodeToString(accountTree.Root())
+	// the accounts before skip are in the userproof table and the static
+	// proof directory already
+	skip := currentAccountCounts
+	var staticWriter *staticProofWriter
+	if userProofConfig.StaticProofDir != "" {
+		staticWriter, err = newStaticProofWriter(userProofConfig.StaticProofDir, accountTreeRoot, userProofConfig.StaticProofWriters)
+		if err != nil {
+			panic(err.Error())
+		}
+		fmt.Println("static proofs of", staticWriter.Start(), "accounts are written")
+		if staticWriter.Start() < skip {
+			skip = staticWriter.Start()
+		}
+	}
+	jobs := make(chan Job, 1000)
+	nums := make(chan int, 1)
+	results := make(chan *model.UserProof, 1000)
+	for i := 0; i < 1; i++ {
+		go worker(jobs, results, nums, accountTreeRoot, assetRegistry.Hash, currentAccountCounts, staticWriter)
+	}
+	quit := make(chan int, 1)
+	for i := 0; i < 1; i++ {
+		go WriteDB(results, userProofModel, quit, currentAccountCounts)
+	}
+	prevAccountCounts := 0
+	for _, k := range accountAssetKeys {
+		if skip >= accountCounts[k]+prevAccountCounts {
+			prevAccountCounts = accountCounts[k] + prevAccountCounts
+			continue
+		}
+		it, err := accountSource.Iterator(k)
+		if err != nil {
+			panic(err.Error())
+		}
+		for i := 0; i < accountCounts[k]; i++ {
+			account, err := it.Next()
+			if err != nil {
+				panic(err.Error())
+			}
+			if i < skip-prevAccountCounts {
+				continue
+			}
+			leaf, err := accountTree.Get(uint64(account.AccountIndex), nil)
+			if err != nil {
+				panic(err.Error())
+			}
+			proof, err := accountTree.GetProof(uint64(account.AccountIndex))
+			if err != nil {
+				panic(err.Error())
+			}
+			jobs <- Job{
+				seq:     prevAccountCounts + i,
+				account: &account,
+				proof:   proof,
+				leaf:    leaf,
+			}
+		}
+		it.Close()
+		prevAccountCounts += accountCounts[k]
+		skip = prevAccountCounts
+	}
+
+	close(jobs)
+	for i := 0; i < 1; i++ {
+		num := <-nums
+		totalCounts += num
+		fmt.Println("totalCounts", totalCounts)
+	}
+
+	if totalCounts != totalAccountCounts {
+		fmt.Println("totalCounts actual:expected", totalCounts, totalAccountCounts)
+		panic("mismatch num")
+	}
+	close(results)
+	for i := 0; i < 1; i++ {
+		<-quit
+	}
+	if staticWriter != nil {
+		err = staticWriter.Close(&StaticManifest{
+			Root:              accountTreeRoot,
+			AssetRegistryHash: assetRegistry.Hash,
+			AccountCount:      totalAccountCounts,
+			Layout:            StaticProofLayout,
+			CreatedAt:         time.Now().UTC(),
+		})
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	fmt.Println("userproof service run finished...")
+}
+
+func WriteDB(results <-chan *model.UserProof, userProofModel model.UserProofModel, quit chan<- int, currentAccountCounts int) {
+	index := 0
+	proofs := make([]model.UserProof, 100)
+	num := int(currentAccountCounts)
+	for proof := range results {
+		proofs[index] = *proof
+		index += 1
+		if index%100 == 0 {
+			error := userProofModel.CreateUserProofs(proofs)
+			if error != nil {
+				panic(error.Error())
+			}
+			num += 100
+			utils.UserProofsWritten.Add(100)
+			if num%100000 == 0 {
+				fmt.Println("write ", num, "proof to db")
+			}
+			index = 0
+		}
+	}
+	proofs = proofs[:index]
+	if index > 0 {
+		fmt.Println("write ", len(proofs), "proofs to db")
+		userProofModel.CreateUserProofs(proofs)
+		num += index
+		utils.UserProofsWritten.Add(float64(index))
+	}
+	fmt.Println("total write ", num)
+	quit <- 0
+}
+
+type Job struct {
+	// seq is the position of the account in the userproof table
+	seq     int
+	account *utils.AccountInfo
+	proof   [][]byte
+	leaf    []byte
+}
+
+// worker converts the accounts to user proofs, the accounts before dbStart
+// are only written to the static proof directory.
+func worker(jobs <-chan Job, results chan<- *model.UserProof, nums chan<- int, root string, assetRegistryHash string, dbStart int, staticWriter *staticProofWriter) {
+	num := 0
+	for job := range jobs {
+		userProof := model.ConvertAccount(job.account, job.leaf, job.proof, root, assetRegistryHash)
+		if staticWriter != nil && job.seq >= staticWriter.Start() {
+			staticWriter.Write(job.seq, userProof)
+		}
+		if job.seq >= dbStart {
+			results <- userProof
+			num += 1
+		}
+	}
+	nums <- num
+}
+
+func OpenUserProofTable(userConfig *config.Config) model.UserProofModel {
+	db, err := utils.NewDBWithDriver(userConfig.DbDriver, userConfig.MysqlDataSource)
+	if err != nil {
+		panic(err.Error())
+	}
+	userProofTable := model.NewUserProofModel(db, userConfig.DbSuffix)
+	userProofTable.CreateUserProofTable()
+	return userProofTable
+}
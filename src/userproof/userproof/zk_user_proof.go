@@ -0,0 +1,132 @@
+package userproof
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/circuit"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/config"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+)
+
+// GenerateZkUserProof generates the user inclusion proof of the account from
+// the userproof table, and writes the config file which can be verified by
+// `verifier zk-user`.
+func GenerateZkUserProof(userProofConfig *config.Config, accountId string, outputFile string) {
+	provingSystem, err := circuit.NormalizeProvingSystem(userProofConfig.UserInclusionProvingSystem)
+	if err != nil {
+		panic(err.Error())
+	}
+	userProofModel := OpenUserProofTable(userProofConfig)
+	userProof, err := userProofModel.GetUserProofById(accountId)
+	if err != nil {
+		panic("get user proof failed: " + err.Error())
+	}
+	var userConfig model.UserConfig
+	err = json.Unmarshal([]byte(userProof.Config), &userConfig)
+	if err != nil {
+		panic(err.Error())
+	}
+	accountIdHash, err := hex.DecodeString(userConfig.AccountIdHash)
+	if err != nil {
+		panic("the AccountIdHash is invalid")
+	}
+	root, err := hex.DecodeString(userConfig.Root)
+	if err != nil {
+		panic("invalid account tree root")
+	}
+	input := &circuit.UserInclusionInput{
+		AccountTreeRoot: root,
+		Account: &utils.AccountInfo{
+			AccountIndex:    userConfig.AccountIndex,
+			AccountId:       accountIdHash,
+			TotalEquity:     userConfig.TotalEquity,
+			TotalDebt:       userConfig.TotalDebt,
+			TotalCollateral: userConfig.TotalCollateral,
+			Assets:          userConfig.Assets,
+		},
+		MerkleProof: userConfig.Proof,
+	}
+	circuitWitness, err := circuit.SetUserInclusionCircuitWitness(input)
+	if err != nil {
+		panic(err.Error())
+	}
+	witness, err := frontend.NewWitness(circuitWitness, ecc.BN254.ScalarField())
+	if err != nil {
+		panic(err.Error())
+	}
+	vWitness, err := witness.Public()
+	if err != nil {
+		panic(err.Error())
+	}
+
+	zkKeyName := userProofConfig.UserInclusionZkKeyName
+	err = utils.CheckKeyParams(zkKeyName+".pk", utils.KeyCircuitUserInclusion)
+	if err != nil {
+		panic(err.Error())
+	}
+	csFromFile, err := os.ReadFile(zkKeyName + circuit.ConstraintSystemFileSuffix(provingSystem))
+	if err != nil {
+		panic("constraint system file load error..." + err.Error())
+	}
+	cs := circuit.NewConstraintSystem(provingSystem)
+	_, err = cs.ReadFrom(bytes.NewBuffer(csFromFile))
+	if err != nil {
+		panic("constraint system read error..." + err.Error())
+	}
+	pkFromFile, err := os.ReadFile(zkKeyName + ".pk")
+	if err != nil {
+		panic("provingKey file load error:" + err.Error())
+	}
+	pk := circuit.NewProvingKey(provingSystem)
+	_, err = pk.UnsafeReadFrom(bytes.NewBuffer(pkFromFile))
+	if err != nil {
+		panic("provingKey loading error:" + err.Error())
+	}
+	vkFromFile, err := os.ReadFile(zkKeyName + ".vk")
+	if err != nil {
+		panic("verifyingKey file load error:" + err.Error())
+	}
+	vk := circuit.NewVerifyingKey(provingSystem)
+	_, err = vk.ReadFrom(bytes.NewBuffer(vkFromFile))
+	if err != nil {
+		panic("verifyingKey loading error:" + err.Error())
+	}
+
+	proof, err := circuit.Prove(provingSystem, cs, pk, witness)
+	if err != nil {
+		panic("generate user inclusion proof failed: " + err.Error())
+	}
+	err = circuit.Verify(provingSystem, proof, vk, vWitness)
+	if err != nil {
+		panic("verify user inclusion proof failed: " + err.Error())
+	}
+	var buf bytes.Buffer
+	_, err = proof.WriteRawTo(&buf)
+	if err != nil {
+		panic(err.Error())
+	}
+	zkUserConfig := model.ZkUserConfig{
+		AccountIdHash: userConfig.AccountIdHash,
+		Assets:        userConfig.Assets,
+		Root:          userConfig.Root,
+		ProvingSystem: provingSystem,
+		Proof:         base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}
+	configSerial, err := json.Marshal(zkUserConfig)
+	if err != nil {
+		panic(err.Error())
+	}
+	err = os.WriteFile(outputFile, configSerial, 0644)
+	if err != nil {
+		panic(err.Error())
+	}
+	fmt.Println("user inclusion proof is written to ", outputFile)
+}
@@ -2,12 +2,14 @@ package utils
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"hash"
 	"time"
 
 	bsmt "github.com/bnb-chain/zkbnb-smt"
 	"github.com/bnb-chain/zkbnb-smt/database"
+	"github.com/bnb-chain/zkbnb-smt/database/leveldb"
 	"github.com/bnb-chain/zkbnb-smt/database/memory"
 	"github.com/bnb-chain/zkbnb-smt/database/redis"
 	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
@@ -17,13 +19,34 @@ var (
 	NilAccountHash []byte
 )
 
+const (
+	// in megabytes
+	leveldbCacheSize = 512
+	leveldbHandles   = 256
+)
+
 func NewAccountTree(driver string, addr string) (accountTree bsmt.SparseMerkleTree, err error) {
 
 	hasher := bsmt.NewHasherPool(func() hash.Hash {
 		return poseidon.NewPoseidon()
 	})
 
-	var db database.TreeDB
+	db, err := newTreeDB(driver, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	accountTree, err = bsmt.NewBNBSparseMerkleTree(hasher, db, AccountTreeDepth, NilAccountHash)
+	if err != nil {
+		return nil, err
+	}
+	return accountTree, nil
+}
+
+// newTreeDB opens the storage of the account tree, addr is the address of
+// kvrocks for the redis driver and the directory of the database for the
+// leveldb driver
+func newTreeDB(driver string, addr string) (db database.TreeDB, err error) {
 	if driver == "memory" {
 		db = memory.NewMemoryDB()
 	} else if driver == "redis" {
@@ -42,13 +65,18 @@ func NewAccountTree(driver string, addr string) (accountTree bsmt.SparseMerkleTr
 		if err != nil {
 			return nil, err
 		}
+	} else if driver == "leveldb" {
+		if addr == "" {
+			return nil, errors.New("the directory of the leveldb tree db is empty")
+		}
+		db, err = leveldb.New(addr, leveldbCacheSize, leveldbHandles, false)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, fmt.Errorf("unknown tree db driver %s", driver)
 	}
-
-	accountTree, err = bsmt.NewBNBSparseMerkleTree(hasher, db, AccountTreeDepth, NilAccountHash)
-	if err != nil {
-		return nil, err
-	}
-	return accountTree, nil
+	return db, nil
 }
 
 func VerifyMerkleProof(root []byte, accountIndex uint32, proof [][]byte, node []byte) bool {
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"bytes"
+	"hash"
+	"testing"
+
+	bsmt "github.com/bnb-chain/zkbnb-smt"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
+)
+
+func TestLeveldbAccountTree(t *testing.T) {
+	dir := t.TempDir()
+	hasher := bsmt.NewHasherPool(func() hash.Hash {
+		return poseidon.NewPoseidon()
+	})
+	// the tree can't close its db, reopen the db to simulate a restart
+	openTree := func() (bsmt.SparseMerkleTree, func()) {
+		db, err := newTreeDB("leveldb", dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := bsmt.NewBNBSparseMerkleTree(hasher, db, AccountTreeDepth, NilAccountHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tree, func() { db.Close() }
+	}
+	leaf := func(i byte) []byte {
+		h := poseidon.NewPoseidon()
+		h.Write([]byte{i})
+		return h.Sum(nil)
+	}
+
+	tree, closeTree := openTree()
+	for i := 0; i < 3; i++ {
+		if err := tree.Set(uint64(i), leaf(byte(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tree.Commit(nil); err != nil {
+		t.Fatal(err)
+	}
+	root1 := tree.Root()
+	if err := tree.Set(3, leaf(3)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Commit(nil); err != nil {
+		t.Fatal(err)
+	}
+	root2 := tree.Root()
+	closeTree()
+
+	tree, closeTree = openTree()
+	if tree.LatestVersion() != 2 || !bytes.Equal(tree.Root(), root2) {
+		t.Fatalf("unexpected version %d after reopen", tree.LatestVersion())
+	}
+	if err := tree.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	closeTree()
+
+	tree, closeTree = openTree()
+	defer closeTree()
+	if tree.LatestVersion() != 1 || !bytes.Equal(tree.Root(), root1) {
+		t.Fatalf("unexpected version %d after rollback", tree.LatestVersion())
+	}
+
+	if _, err := NewAccountTree("pebble", dir); err == nil {
+		t.Fatal("unknown driver should fail")
+	}
+}
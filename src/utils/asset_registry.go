@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// AssetRegistryFile is the asset registry in the user data directory, it
+	// is versioned alongside cex_assets_info.csv
+	AssetRegistryFile = "asset_registry.json"
+	// ValueDecimals is the decimals of the asset value, which is the balance
+	// multiplied by the price. The tier boundaries are scaled by it as well.
+	ValueDecimals = 16
+)
+
+// defaultAssetRegistry is used when the user data directory has no asset
+// registry, it is the same as sampledata/asset_registry.json
+const defaultAssetRegistry = `{
+  "Default": {"BalanceDecimals": 8, "PriceDecimals": 8},
+  "Assets": {
+    "bttc": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "shib": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "lunc": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "xec": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "win": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "bidr": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "spell": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "hot": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "doge": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "pepe": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "floki": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "idrt": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "dogs": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "bonk": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "1000sats": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "neiro": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "1000pepper": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "not": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "nft": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "bome": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "1mbabydoge": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "hmstr": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "wlfi": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "pump": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "monky": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "1000cheems": {"BalanceDecimals": 2, "PriceDecimals": 14},
+    "idr": {"BalanceDecimals": 2, "PriceDecimals": 14}
+  }
+}
+`
+
+// AssetDecimals is the scaling of an asset, the balances are multiplied by
+// 10^BalanceDecimals and the price by 10^PriceDecimals. Their sum must be
+// ValueDecimals.
+type AssetDecimals struct {
+	BalanceDecimals int
+	PriceDecimals   int
+}
+
+// AssetRegistry declares the decimals of every asset, the asset which isn't
+// in Assets uses Default.
+type AssetRegistry struct {
+	Default AssetDecimals
+	Assets  map[string]AssetDecimals
+	// Hash is the hex encoded sha256 of the registry file, it is recorded
+	// with the snapshot
+	Hash string `json:"-"`
+}
+
+// NewAssetRegistry parses the content of the asset registry file, the
+// symbols are case insensitive.
+func NewAssetRegistry(content []byte) (*AssetRegistry, error) {
+	var r AssetRegistry
+	if err := json.Unmarshal(content, &r); err != nil {
+		return nil, err
+	}
+	if err := r.Default.validate(); err != nil {
+		return nil, fmt.Errorf("default asset decimals: %s", err.Error())
+	}
+	assets := make(map[string]AssetDecimals, len(r.Assets))
+	for symbol, decimals := range r.Assets {
+		if err := decimals.validate(); err != nil {
+			return nil, fmt.Errorf("asset %s decimals: %s", symbol, err.Error())
+		}
+		lowerSymbol := strings.ToLower(symbol)
+		if _, ok := assets[lowerSymbol]; ok {
+			return nil, fmt.Errorf("asset %s is duplicated in asset registry", symbol)
+		}
+		assets[lowerSymbol] = decimals
+	}
+	r.Assets = assets
+	hash := sha256.Sum256(content)
+	r.Hash = hex.EncodeToString(hash[:])
+	return &r, nil
+}
+
+// LoadAssetRegistry loads the asset registry file
+func LoadAssetRegistry(name string) (*AssetRegistry, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewAssetRegistry(content)
+}
+
+// LoadAssetRegistryFromDir loads AssetRegistryFile of the user data directory,
+// the default registry is returned when the directory has no registry.
+func LoadAssetRegistryFromDir(dirname string) (*AssetRegistry, error) {
+	r, err := LoadAssetRegistry(filepath.Join(dirname, AssetRegistryFile))
+	if os.IsNotExist(err) {
+		fmt.Println("there is no", AssetRegistryFile, "in", dirname, ", the default asset registry is used")
+		return NewAssetRegistry([]byte(defaultAssetRegistry))
+	}
+	return r, err
+}
+
+func (d AssetDecimals) validate() error {
+	if d.BalanceDecimals < 0 || d.PriceDecimals < 0 || d.BalanceDecimals+d.PriceDecimals != ValueDecimals {
+		return fmt.Errorf("the sum of balance decimals %d and price decimals %d must be %d",
+			d.BalanceDecimals, d.PriceDecimals, ValueDecimals)
+	}
+	return nil
+}
+
+func (r *AssetRegistry) Decimals(symbol string) AssetDecimals {
+	if d, ok := r.Assets[strings.ToLower(symbol)]; ok {
+		return d
+	}
+	return r.Default
+}
+
+func (r *AssetRegistry) BalanceMultiplier(symbol string) int64 {
+	return pow10(r.Decimals(symbol).BalanceDecimals)
+}
+
+func (r *AssetRegistry) PriceMultiplier(symbol string) int64 {
+	return pow10(r.Decimals(symbol).PriceDecimals)
+}
+
+// FormatPrice converts the scaled price back to the decimal string
+func (r *AssetRegistry) FormatPrice(symbol string, price uint64) string {
+	return formatDecimal(price, r.Decimals(symbol).PriceDecimals)
+}
+
+// FormatBalance converts the scaled balance back to the decimal string
+func (r *AssetRegistry) FormatBalance(symbol string, balance uint64) string {
+	return formatDecimal(balance, r.Decimals(symbol).BalanceDecimals)
+}
+
+func pow10(n int) int64 {
+	m := int64(1)
+	for i := 0; i < n; i++ {
+		m *= 10
+	}
+	return m
+}
+
+func formatDecimal(v uint64, decimals int) string {
+	s := strconv.FormatUint(v, 10)
+	if decimals == 0 {
+		return s
+	}
+	if len(s) <= decimals {
+		s = strings.Repeat("0", decimals-len(s)+1) + s
+	}
+	s = s[:len(s)-decimals] + "." + s[len(s)-decimals:]
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
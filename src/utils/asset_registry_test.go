@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestAssetRegistry(t *testing.T) {
+	// the sample registry is the default one, so their hashes are the same
+	sampleRegistry, err := LoadAssetRegistryFromDir("../sampledata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultRegistry, err := LoadAssetRegistryFromDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sampleRegistry.Hash != defaultRegistry.Hash {
+		t.Fatalf("unexpected hash %s %s", sampleRegistry.Hash, defaultRegistry.Hash)
+	}
+	if sampleRegistry.BalanceMultiplier("BTC") != 100000000 || sampleRegistry.PriceMultiplier("btc") != 100000000 {
+		t.Fatal("unexpected multiplier of btc")
+	}
+	if sampleRegistry.BalanceMultiplier("SHIB") != 100 || sampleRegistry.PriceMultiplier("shib") != 100000000000000 {
+		t.Fatal("unexpected multiplier of shib")
+	}
+	if s := sampleRegistry.FormatPrice("shib", 1234000000); s != "0.00001234" {
+		t.Fatalf("unexpected price %s", s)
+	}
+	if s := sampleRegistry.FormatBalance("btc", 250000000); s != "2.5" {
+		t.Fatalf("unexpected balance %s", s)
+	}
+
+	invalidRegistries := []string{
+		`{"Default": {"BalanceDecimals": 8, "PriceDecimals": 7}}`,
+		`{"Default": {"BalanceDecimals": 8, "PriceDecimals": 8}, "Assets": {"new": {"BalanceDecimals": 18, "PriceDecimals": -2}}}`,
+		`{"Default": {"BalanceDecimals": 8, "PriceDecimals": 8}, "Assets": {"NEW": {"BalanceDecimals": 2, "PriceDecimals": 14}, "new": {"BalanceDecimals": 2, "PriceDecimals": 14}}}`,
+	}
+	for _, content := range invalidRegistries {
+		if _, err := NewAssetRegistry([]byte(content)); err == nil {
+			t.Fatalf("registry %s should be invalid", content)
+		}
+	}
+}
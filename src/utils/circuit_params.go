@@ -0,0 +1,300 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	KeyCircuitBatchCreate   = "batch_create"
+	KeyCircuitBatchUpdate   = "batch_update"
+	KeyCircuitUserInclusion = "user_inclusion"
+	KeyCircuitAggregation   = "aggregation"
+
+	// keyParamsMagic ends the key file which embeds its parameters, the
+	// parameters are appended after the gnark encoding of the key, so the
+	// key is still read by gnark as is
+	keyParamsMagic = "zkporprm"
+)
+
+// CircuitParams are the parameters the batch circuits are compiled with, the
+// keys, the witness and the user proofs of a snapshot must use the same ones.
+type CircuitParams struct {
+	// AccountTreeDepth is fixed by the account proof layout of the circuits,
+	// it is only checked
+	AccountTreeDepth int
+	AssetCounts      int
+	// the key is the assets count tier, the value is the number of users of a
+	// batch of the tier. BatchUpdateUserOpsCountsTiers defaults to half of
+	// BatchCreateUserOpsCountsTiers
+	BatchCreateUserOpsCountsTiers map[int]int
+	BatchUpdateUserOpsCountsTiers map[int]int
+}
+
+// KeyParams are the parameters embedded in a key file generated by keygen,
+// the user inclusion circuit only depends on AccountTreeDepth.
+type KeyParams struct {
+	Circuit          string
+	AccountTreeDepth int
+	AssetCounts      int `json:",omitempty"`
+	AssetsCountTier  int `json:",omitempty"`
+	UserOpsCount     int `json:",omitempty"`
+}
+
+// CurrentCircuitParams returns the parameters in use, they are the built-in
+// ones unless SetCircuitParams is called.
+func CurrentCircuitParams() *CircuitParams {
+	p := &CircuitParams{
+		AccountTreeDepth:              AccountTreeDepth,
+		AssetCounts:                   AssetCounts,
+		BatchCreateUserOpsCountsTiers: make(map[int]int),
+		BatchUpdateUserOpsCountsTiers: make(map[int]int),
+	}
+	for k, v := range BatchCreateUserOpsCountsTiers {
+		p.BatchCreateUserOpsCountsTiers[k] = v
+	}
+	for k, v := range BatchUpdateUserOpsCountsTiers {
+		p.BatchUpdateUserOpsCountsTiers[k] = v
+	}
+	return p
+}
+
+// Validate fills the default batch update ops counts and checks that every
+// account of at most AssetCounts assets belongs to a tier.
+func (p *CircuitParams) Validate() error {
+	if p.AccountTreeDepth != AccountTreeDepth {
+		return fmt.Errorf("account tree depth %d is not supported, the circuits are built with depth %d", p.AccountTreeDepth, AccountTreeDepth)
+	}
+	if p.AssetCounts <= 0 {
+		return errors.New("AssetCounts should be positive")
+	}
+	if len(p.BatchCreateUserOpsCountsTiers) == 0 {
+		return errors.New("BatchCreateUserOpsCountsTiers is empty")
+	}
+	if p.BatchUpdateUserOpsCountsTiers == nil {
+		p.BatchUpdateUserOpsCountsTiers = make(map[int]int)
+		for k, v := range p.BatchCreateUserOpsCountsTiers {
+			p.BatchUpdateUserOpsCountsTiers[k] = (v + 1) / 2
+		}
+	}
+	maxTier := 0
+	for k, v := range p.BatchCreateUserOpsCountsTiers {
+		if k <= 0 || k > p.AssetCounts {
+			return fmt.Errorf("assets count tier %d should be in [1, %d]", k, p.AssetCounts)
+		}
+		if v <= 0 {
+			return fmt.Errorf("batch create user ops count of tier %d should be positive", k)
+		}
+		if p.BatchUpdateUserOpsCountsTiers[k] <= 0 {
+			return fmt.Errorf("batch update user ops count of tier %d should be positive", k)
+		}
+		maxTier = max(maxTier, k)
+	}
+	if len(p.BatchUpdateUserOpsCountsTiers) != len(p.BatchCreateUserOpsCountsTiers) {
+		return errors.New("BatchUpdateUserOpsCountsTiers should have the same tiers as BatchCreateUserOpsCountsTiers")
+	}
+	if maxTier != p.AssetCounts {
+		return fmt.Errorf("the largest assets count tier %d should be AssetCounts %d, the users of more assets have no tier", maxTier, p.AssetCounts)
+	}
+	return nil
+}
+
+// Tiers returns the assets count tiers in ascending order.
+func (p *CircuitParams) Tiers() []int {
+	tiers := make([]int, 0, len(p.BatchCreateUserOpsCountsTiers))
+	for k := range p.BatchCreateUserOpsCountsTiers {
+		tiers = append(tiers, k)
+	}
+	sort.Ints(tiers)
+	return tiers
+}
+
+// SetCircuitParams validates the parameters and replaces the ones in use.
+func SetCircuitParams(p *CircuitParams) error {
+	err := p.Validate()
+	if err != nil {
+		return err
+	}
+	AssetCounts = p.AssetCounts
+	BatchCreateUserOpsCountsTiers = p.BatchCreateUserOpsCountsTiers
+	BatchUpdateUserOpsCountsTiers = p.BatchUpdateUserOpsCountsTiers
+	AssetCountsTiers = p.Tiers()
+	return nil
+}
+
+// LoadCircuitParams reads the circuit parameters file and uses its
+// parameters, the built-in ones are kept when name is empty.
+func LoadCircuitParams(name string) error {
+	if name == "" {
+		return nil
+	}
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	p := &CircuitParams{}
+	err = json.Unmarshal(content, p)
+	if err != nil {
+		return fmt.Errorf("parse circuit params %s failed: %s", name, err.Error())
+	}
+	err = SetCircuitParams(p)
+	if err != nil {
+		return fmt.Errorf("invalid circuit params %s: %s", name, err.Error())
+	}
+	fmt.Println("use circuit params", name, "assets count tiers", AssetCountsTiers)
+	return nil
+}
+
+// CheckAssetsCountTiers checks that the tiers of a service config are tiers
+// of the circuit parameters.
+func CheckAssetsCountTiers(tiers []int) error {
+	for _, k := range tiers {
+		if _, ok := BatchCreateUserOpsCountsTiers[k]; !ok {
+			return fmt.Errorf("assets count tier %d is not in the circuit params %v", k, AssetCountsTiers)
+		}
+	}
+	return nil
+}
+
+// NewBatchKeyParams returns the parameters of the batch create user circuit
+// of the tier, or of the batch update user circuit when update is set.
+func NewBatchKeyParams(assetsCountTier int, update bool) *KeyParams {
+	p := &KeyParams{
+		Circuit:          KeyCircuitBatchCreate,
+		AccountTreeDepth: AccountTreeDepth,
+		AssetCounts:      AssetCounts,
+		AssetsCountTier:  assetsCountTier,
+		UserOpsCount:     BatchCreateUserOpsCountsTiers[assetsCountTier],
+	}
+	if update {
+		p.Circuit = KeyCircuitBatchUpdate
+		p.UserOpsCount = BatchUpdateUserOpsCountsTiers[assetsCountTier]
+	}
+	return p
+}
+
+// NewKeyParams returns the parameters of the user inclusion circuit, or of
+// the aggregation circuit on top of the batch keys of the tier.
+func NewKeyParams(keyCircuit string, assetsCountTier int) *KeyParams {
+	if keyCircuit == KeyCircuitUserInclusion {
+		return &KeyParams{Circuit: keyCircuit, AccountTreeDepth: AccountTreeDepth}
+	}
+	return &KeyParams{
+		Circuit:          keyCircuit,
+		AccountTreeDepth: AccountTreeDepth,
+		AssetCounts:      AssetCounts,
+		AssetsCountTier:  assetsCountTier,
+	}
+}
+
+// AppendKeyParams embeds the parameters at the end of the key file, the
+// layout is the json of the parameters, its length in 8 bytes big endian
+// and keyParamsMagic.
+func AppendKeyParams(name string, params *KeyParams) error {
+	content, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	content = binary.BigEndian.AppendUint64(content, uint64(len(content)))
+	content = append(content, keyParamsMagic...)
+	_, err = f.Write(content)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// ReadKeyParams returns the parameters embedded in the key file, it returns
+// nil for the keys generated before the parameters were embedded.
+func ReadKeyParams(name string) (*KeyParams, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	trailerSize := int64(8 + len(keyParamsMagic))
+	if info.Size() < trailerSize {
+		return nil, nil
+	}
+	trailer := make([]byte, trailerSize)
+	_, err = f.ReadAt(trailer, info.Size()-trailerSize)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(trailer[8:], []byte(keyParamsMagic)) {
+		return nil, nil
+	}
+	size := int64(binary.BigEndian.Uint64(trailer[:8]))
+	if size > info.Size()-trailerSize {
+		return nil, fmt.Errorf("invalid key params of %s", name)
+	}
+	content := make([]byte, size)
+	_, err = f.ReadAt(content, info.Size()-trailerSize-size)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	params := &KeyParams{}
+	err = json.Unmarshal(content, params)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key params of %s: %s", name, err.Error())
+	}
+	return params, nil
+}
+
+// CheckBatchKeyParams checks that the batch key file of the assets count
+// tier is generated with the circuit params in use. The batch create and
+// update keys of a tier are used under the same name, so the key is checked
+// against the params of the circuit it is generated for.
+func CheckBatchKeyParams(name string, assetsCountTier int) error {
+	params, err := readKeyParamsOrWarn(name)
+	if params == nil || err != nil {
+		return err
+	}
+	return compareKeyParams(name, params, NewBatchKeyParams(assetsCountTier, params.Circuit == KeyCircuitBatchUpdate))
+}
+
+// CheckKeyParams checks that the user inclusion or aggregation key file is
+// generated with the circuit params in use, the tier of an aggregation key
+// is not checked.
+func CheckKeyParams(name string, keyCircuit string) error {
+	params, err := readKeyParamsOrWarn(name)
+	if params == nil || err != nil {
+		return err
+	}
+	want := NewKeyParams(keyCircuit, 0)
+	if keyCircuit == KeyCircuitAggregation {
+		want.AssetsCountTier = params.AssetsCountTier
+	}
+	return compareKeyParams(name, params, want)
+}
+
+// readKeyParamsOrWarn accepts the keys without params with a warning.
+func readKeyParamsOrWarn(name string) (*KeyParams, error) {
+	params, err := ReadKeyParams(name)
+	if err == nil && params == nil {
+		fmt.Println("WARNING: the key", name, "has no circuit params, it can't be checked")
+	}
+	return params, err
+}
+
+func compareKeyParams(name string, params *KeyParams, want *KeyParams) error {
+	if *params != *want {
+		return fmt.Errorf("the key %s is generated with circuit params %+v, but %+v are in use", name, *params, *want)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadCircuitParams(t *testing.T) {
+	builtin := CurrentCircuitParams()
+	defer SetCircuitParams(builtin)
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "circuit_params.json")
+	write := func(content string) {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"AccountTreeDepth": 28, "AssetCounts": 100, "BatchCreateUserOpsCountsTiers": {"100": 20, "20": 300, "5": 1000}}`)
+	if err := LoadCircuitParams(name); err != nil {
+		t.Fatal(err)
+	}
+	if AssetCounts != 100 || len(AssetCountsTiers) != 3 || AssetCountsTiers[0] != 5 || AssetCountsTiers[2] != 100 ||
+		BatchUpdateUserOpsCountsTiers[5] != 500 || GetAssetsCountOfUser(make([]AccountAsset, 6)) != 20 {
+		t.Fatalf("unexpected params %+v", CurrentCircuitParams())
+	}
+	if CheckAssetsCountTiers([]int{20, 100}) != nil || CheckAssetsCountTiers([]int{50}) == nil {
+		t.Fatal("unexpected tiers check")
+	}
+
+	// the keys of other params are rejected
+	keyFile := filepath.Join(dir, "zkpor20_300.vk")
+	if err := os.WriteFile(keyFile, []byte("key"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckBatchKeyParams(keyFile, 20); err != nil {
+		t.Fatal("the key without params should be accepted", err)
+	}
+	if err := AppendKeyParams(keyFile, NewBatchKeyParams(20, false)); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckBatchKeyParams(keyFile, 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetCircuitParams(builtin); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckBatchKeyParams(keyFile, 20); err == nil {
+		t.Fatal("the key should not be accepted with the built-in params")
+	}
+
+	for _, content := range []string{
+		`{"AccountTreeDepth": 20, "AssetCounts": 100, "BatchCreateUserOpsCountsTiers": {"100": 20}}`,
+		`{"AccountTreeDepth": 28, "AssetCounts": 100, "BatchCreateUserOpsCountsTiers": {"50": 20}}`,
+		`{"AccountTreeDepth": 28, "AssetCounts": 100, "BatchCreateUserOpsCountsTiers": {"100": 20, "200": 10}}`,
+		`{"AccountTreeDepth": 28, "AssetCounts": 100, "BatchCreateUserOpsCountsTiers": {"100": 20}, "BatchUpdateUserOpsCountsTiers": {"50": 10}}`,
+	} {
+		write(content)
+		if err := LoadCircuitParams(name); err == nil {
+			t.Fatalf("the params %s should be invalid", content)
+		}
+	}
+	if AssetCounts != builtin.AssetCounts || len(AssetCountsTiers) != len(builtin.BatchCreateUserOpsCountsTiers) {
+		t.Fatal("the invalid params should not be used")
+	}
+}
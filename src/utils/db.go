@@ -1,25 +1,110 @@
 package utils
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
 )
 
+const (
+	DbDriverMysql    = "mysql"
+	DbDriverPostgres = "postgres"
+	DbDriverSqlite   = "sqlite3"
+)
+
+// convertSqliteErr is set by db_sqlite.go, the sqlite driver needs cgo so it
+// is only built with the sqlite tag
+var convertSqliteErr func(err error) error
+
+// SqliteEnabled reports whether the binary is built with the sqlite driver
+func SqliteEnabled() bool {
+	return convertSqliteErr != nil
+}
+
 // DB is a wrapper around sql.DB with additional functionality
 type DB struct {
 	*sql.DB
-	maxExecutionTime time.Duration
+	driver string
+	// in milliseconds, it is read by the postgres connector
+	maxExecutionTime atomic.Int64
 }
 
-// NewDB creates a new database connection
-func NewDB(dataSource string) (*DB, error) {
-	db, err := sql.Open("mysql", dataSource)
+// postgresConnector sets the statement_timeout of every new connection, it is
+// the counterpart of the mysql MAX_EXECUTION_TIME hint
+type postgresConnector struct {
+	driver.Connector
+	db *DB
+}
+
+func (c *postgresConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
 	if err != nil {
 		return nil, err
 	}
+	execer, ok := conn.(driver.ExecerContext)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("the postgres connection can't set statement_timeout")
+	}
+	_, err = execer.ExecContext(ctx, fmt.Sprintf("SET statement_timeout = %d", c.db.maxExecutionTime.Load()), nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// NewDB creates a new mysql database connection
+func NewDB(dataSource string) (*DB, error) {
+	return NewDBWithDriver(DbDriverMysql, dataSource)
+}
+
+// NewDBWithDriver creates a new database connection of the driver, the
+// driver defaults to mysql when it is empty
+func NewDBWithDriver(driver string, dataSource string) (*DB, error) {
+	if driver == "" {
+		driver = DbDriverMysql
+	}
+	if driver != DbDriverMysql && driver != DbDriverPostgres && driver != DbDriverSqlite {
+		return nil, fmt.Errorf("unknown db driver %s", driver)
+	}
+	if driver == DbDriverSqlite && !SqliteEnabled() {
+		return nil, fmt.Errorf("the sqlite3 driver is not built in, build with -tags sqlite")
+	}
+	if driver == DbDriverSqlite && !strings.Contains(dataSource, "_txlock") {
+		// the witness rows are selected and updated in one transaction, the
+		// write lock must be taken at the beginning of the transaction
+		if strings.Contains(dataSource, "?") {
+			dataSource += "&_txlock=immediate&_busy_timeout=10000"
+		} else {
+			dataSource += "?_txlock=immediate&_busy_timeout=10000"
+		}
+	}
+	res := &DB{driver: driver}
+	res.SetMaxExecutionTime(10000)
+	var db *sql.DB
+	if driver == DbDriverPostgres {
+		connector, err := pq.NewConnector(dataSource)
+		if err != nil {
+			return nil, err
+		}
+		db = sql.OpenDB(&postgresConnector{Connector: connector, db: res})
+	} else {
+		var err error
+		db, err = sql.Open(driver, dataSource)
+		if err != nil {
+			return nil, err
+		}
+	}
+	res.DB = db
 
 	// Set connection pool settings
 	db.SetMaxOpenConns(100)
@@ -31,41 +116,166 @@ func NewDB(dataSource string) (*DB, error) {
 		return nil, err
 	}
 
-	return &DB{
-		DB:               db,
-		maxExecutionTime: 10000 * time.Second,
-	}, nil
+	return res, nil
+}
+
+func (db *DB) Driver() string {
+	return db.driver
+}
+
+// AutoIncrementPrimaryKey is the column type of the auto increment id in the
+// DDL of postgres and sqlite
+func (db *DB) AutoIncrementPrimaryKey() string {
+	if db.driver == DbDriverSqlite {
+		return "INTEGER PRIMARY KEY AUTOINCREMENT"
+	}
+	return "BIGSERIAL PRIMARY KEY"
+}
+
+// AddColumn adds the column to the table created by an older version, it
+// does nothing when the table already has the column.
+func (db *DB) AddColumn(table string, column string, definition string) error {
+	exists, err := db.hasColumn(table, column)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+func (db *DB) hasColumn(table string, column string) (bool, error) {
+	if db.driver == DbDriverSqlite {
+		rows, err := db.Query(fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
+		if err != nil {
+			return false, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var name string
+			err = rows.Scan(&name)
+			if err != nil {
+				return false, err
+			}
+			if name == column {
+				return true, nil
+			}
+		}
+		return false, rows.Err()
+	}
+	schema := "DATABASE()"
+	if db.driver == DbDriverPostgres {
+		schema = "current_schema()"
+	}
+	var count int
+	query := fmt.Sprintf("SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = %s AND table_name = ? AND column_name = ?", schema)
+	err := db.QueryRow(query, table, column).Scan(&count)
+	return count > 0, err
+}
+
+// rebind converts the mysql style query to the one of the driver, the ? in
+// the quoted literals and identifiers are kept
+func rebind(driver string, query string) string {
+	switch driver {
+	case DbDriverPostgres:
+		var b strings.Builder
+		n := 0
+		var quote rune
+		for _, c := range query {
+			switch {
+			case quote != 0:
+				// the escaped quote '' closes and reopens the literal
+				if c == quote {
+					quote = 0
+				}
+			case c == '\'' || c == '"':
+				quote = c
+			case c == '?':
+				n++
+				b.WriteString("$" + strconv.Itoa(n))
+				continue
+			}
+			b.WriteRune(c)
+		}
+		return b.String()
+	case DbDriverSqlite:
+		// the transaction of sqlite locks the whole database
+		return strings.Replace(query, " FOR UPDATE", "", 1)
+	}
+	return query
+}
+
+// ConvertSqlErrToDbErr converts the error of any driver to the DbErr, the
+// caller retries on DbErrQueryTimeout and DbErrQueryInterrupted
+func ConvertSqlErrToDbErr(err error) error {
+	if pqErr, ok := err.(*pq.Error); ok {
+		switch pqErr.Code {
+		case "57014":
+			// query_canceled, e.g. statement_timeout
+			return DbErrQueryTimeout
+		case "40001", "40P01":
+			// serialization_failure and deadlock_detected
+			return DbErrQueryInterrupted
+		case "42P01":
+			return DbErrTableNotFound
+		}
+	}
+	if convertSqliteErr != nil {
+		if dbErr := convertSqliteErr(err); dbErr != nil {
+			return dbErr
+		}
+	}
+	return ConvertMysqlErrToDbErr(err)
 }
 
-// SetMaxExecutionTime sets the maximum execution time for queries
+// SetMaxExecutionTime sets the maximum execution time for queries, it is the
+// hint of the mysql queries with timeout and the statement_timeout of the
+// postgres connections opened after the call
 func (db *DB) SetMaxExecutionTime(seconds int) {
-	db.maxExecutionTime = time.Duration(seconds) * time.Second
+	db.maxExecutionTime.Store((time.Duration(seconds) * time.Second).Milliseconds())
+}
+
+func (db *DB) withTimeoutHint(query string) string {
+	if db.driver != DbDriverMysql {
+		return query
+	}
+	// Add max execution time hint for MySQL
+	return fmt.Sprintf("/*+ MAX_EXECUTION_TIME(%d) */ %s", db.maxExecutionTime.Load(), query)
+}
+
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(rebind(db.driver, query), args...)
+}
+
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(rebind(db.driver, query), args...)
+}
+
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(rebind(db.driver, query), args...)
 }
 
 // ExecWithTimeout executes a query with timeout hint
 func (db *DB) ExecWithTimeout(query string, args ...interface{}) (sql.Result, error) {
-	// Add max execution time hint for MySQL
-	timeoutQuery := fmt.Sprintf("/*+ MAX_EXECUTION_TIME(%d) */ %s", int(db.maxExecutionTime.Milliseconds()), query)
-	return db.DB.Exec(timeoutQuery, args...)
+	return db.Exec(db.withTimeoutHint(query), args...)
 }
 
 // QueryWithTimeout executes a query with timeout hint
 func (db *DB) QueryWithTimeout(query string, args ...interface{}) (*sql.Rows, error) {
-	// Add max execution time hint for MySQL
-	timeoutQuery := fmt.Sprintf("/*+ MAX_EXECUTION_TIME(%d) */ %s", int(db.maxExecutionTime.Milliseconds()), query)
-	return db.DB.Query(timeoutQuery, args...)
+	return db.Query(db.withTimeoutHint(query), args...)
 }
 
 // QueryRowWithTimeout executes a query with timeout hint
 func (db *DB) QueryRowWithTimeout(query string, args ...interface{}) *sql.Row {
-	// Add max execution time hint for MySQL
-	timeoutQuery := fmt.Sprintf("/*+ MAX_EXECUTION_TIME(%d) */ %s", int(db.maxExecutionTime.Milliseconds()), query)
-	return db.DB.QueryRow(timeoutQuery, args...)
+	return db.QueryRow(db.withTimeoutHint(query), args...)
 }
 
 // Transaction represents a database transaction
 type Transaction struct {
 	*sql.Tx
+	driver string
 }
 
 // BeginTransaction starts a new transaction
@@ -74,7 +284,19 @@ func (db *DB) BeginTransaction() (*Transaction, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Transaction{Tx: tx}, nil
+	return &Transaction{Tx: tx, driver: db.driver}, nil
+}
+
+func (tx *Transaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(rebind(tx.driver, query), args...)
+}
+
+func (tx *Transaction) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(rebind(tx.driver, query), args...)
+}
+
+func (tx *Transaction) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(rebind(tx.driver, query), args...)
 }
 
 // Close closes the database connection
@@ -0,0 +1,31 @@
+//go:build sqlite
+
+package utils
+
+import (
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	convertSqliteErr = convertSqlite3Err
+}
+
+// convertSqlite3Err returns nil if err is not a sqlite error
+func convertSqlite3Err(err error) error {
+	sqliteErr, ok := err.(sqlite3.Error)
+	if !ok {
+		return nil
+	}
+	switch sqliteErr.Code {
+	case sqlite3.ErrBusy, sqlite3.ErrLocked:
+		return DbErrQueryTimeout
+	case sqlite3.ErrInterrupt:
+		return DbErrQueryInterrupted
+	}
+	if strings.HasPrefix(sqliteErr.Error(), "no such table") {
+		return DbErrTableNotFound
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package utils
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	query := "SELECT id FROM witness WHERE status = ? AND deleted_at IS NULL LIMIT ? FOR UPDATE"
+	if rebind(DbDriverMysql, query) != query {
+		t.Fatal("mysql query should not be changed")
+	}
+	expected := "SELECT id FROM witness WHERE status = $1 AND deleted_at IS NULL LIMIT $2 FOR UPDATE"
+	if q := rebind(DbDriverPostgres, query); q != expected {
+		t.Fatalf("unexpected postgres query %s", q)
+	}
+	expected = "SELECT id FROM witness WHERE status = ? AND deleted_at IS NULL LIMIT ?"
+	if q := rebind(DbDriverSqlite, query); q != expected {
+		t.Fatalf("unexpected sqlite query %s", q)
+	}
+
+	// the ? of the literals is not a placeholder
+	query = "SELECT name FROM pragma_table_info('a?b') WHERE type = 'it''s?' AND \"c?\" = ?"
+	expected = "SELECT name FROM pragma_table_info('a?b') WHERE type = 'it''s?' AND \"c?\" = $1"
+	if q := rebind(DbDriverPostgres, query); q != expected {
+		t.Fatalf("unexpected postgres query %s", q)
+	}
+}
@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// EmbeddedDB is the file-backed store of the tables in the local pipeline
+// mode. A row is stored as json under the key of its table name and primary
+// key, the rows of a table are scanned in the byte order of the primary key.
+type EmbeddedDB struct {
+	// serializes the read-modify-write of rows, e.g. the status transitions
+	// of the witness table
+	sync.Mutex
+	db *leveldb.DB
+}
+
+func NewEmbeddedDB(dir string) (*EmbeddedDB, error) {
+	db, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &EmbeddedDB{db: db}, nil
+}
+
+// EmbeddedUint64Key encodes the numeric primary key so that the rows are
+// scanned in ascending order.
+func EmbeddedUint64Key(v uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, v)
+	return key
+}
+
+func embeddedTablePrefix(table string) []byte {
+	return []byte(table + "/")
+}
+
+func embeddedRowKey(table string, key []byte) []byte {
+	return append(embeddedTablePrefix(table), key...)
+}
+
+func convertLevelDBErr(err error) error {
+	if err == leveldb.ErrNotFound {
+		return DbErrNotFound
+	}
+	return err
+}
+
+// EmbeddedBatch writes the rows of one or more tables atomically.
+type EmbeddedBatch struct {
+	batch leveldb.Batch
+}
+
+func (b *EmbeddedBatch) Put(table string, key []byte, row any) error {
+	value, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	b.batch.Put(embeddedRowKey(table, key), value)
+	return nil
+}
+
+func (db *EmbeddedDB) Write(b *EmbeddedBatch) error {
+	return db.db.Write(&b.batch, nil)
+}
+
+func (db *EmbeddedDB) Put(table string, key []byte, row any) error {
+	var b EmbeddedBatch
+	if err := b.Put(table, key, row); err != nil {
+		return err
+	}
+	return db.Write(&b)
+}
+
+// Get returns DbErrNotFound if there is no row of the key.
+func (db *EmbeddedDB) Get(table string, key []byte, row any) error {
+	value, err := db.db.Get(embeddedRowKey(table, key), nil)
+	if err != nil {
+		return convertLevelDBErr(err)
+	}
+	return json.Unmarshal(value, row)
+}
+
+func (db *EmbeddedDB) Has(table string, key []byte) (bool, error) {
+	return db.db.Has(embeddedRowKey(table, key), nil)
+}
+
+// Last returns the row of the largest key, DbErrNotFound if the table is empty.
+func (db *EmbeddedDB) Last(table string, row any) error {
+	iter := db.db.NewIterator(util.BytesPrefix(embeddedTablePrefix(table)), nil)
+	defer iter.Release()
+	if !iter.Last() {
+		if err := iter.Error(); err != nil {
+			return err
+		}
+		return DbErrNotFound
+	}
+	return json.Unmarshal(iter.Value(), row)
+}
+
+// Scan calls fn with the rows whose key is not less than start in ascending
+// order, it stops when fn returns false or an error.
+func (db *EmbeddedDB) Scan(table string, start []byte, fn func(value []byte) (bool, error)) error {
+	r := util.BytesPrefix(embeddedTablePrefix(table))
+	if start != nil {
+		r.Start = embeddedRowKey(table, start)
+	}
+	iter := db.db.NewIterator(r, nil)
+	defer iter.Release()
+	for iter.Next() {
+		next, err := fn(iter.Value())
+		if err != nil {
+			return err
+		}
+		if !next {
+			break
+		}
+	}
+	return iter.Error()
+}
+
+func (db *EmbeddedDB) Count(table string) (int64, error) {
+	var count int64
+	err := db.Scan(table, nil, func([]byte) (bool, error) {
+		count++
+		return true, nil
+	})
+	return count, err
+}
+
+// Drop deletes all rows of the table.
+func (db *EmbeddedDB) Drop(table string) error {
+	iter := db.db.NewIterator(util.BytesPrefix(embeddedTablePrefix(table)), nil)
+	defer iter.Release()
+	var batch leveldb.Batch
+	for iter.Next() {
+		batch.Delete(append([]byte{}, iter.Key()...))
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return db.db.Write(&batch, nil)
+}
+
+func (db *EmbeddedDB) Close() error {
+	return db.db.Close()
+}
@@ -8,4 +8,5 @@ var (
 	DbErrTableNotFound    = errors.New("sql: table not found")
 	DbErrQueryTimeout     = errors.New("sql: query timeout")
 	DbErrQueryInterrupted = errors.New("sql: query interrupted")
+	DbErrDuplicateKey     = errors.New("sql: duplicate key")
 )
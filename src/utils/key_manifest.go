@@ -0,0 +1,279 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// KeyManifestFile is written by keygen to the directory of the keys, it
+// records the params and the fingerprints of every key in the directory.
+const KeyManifestFile = "keys_manifest.json"
+
+const keyFileBufferSize = 4 << 20
+
+type KeyManifest struct {
+	Keys []*KeyManifestEntry
+}
+
+// KeyManifestEntry describes the files of a key name, Files maps the suffix
+// of a file, such as .pk, to its size and hex encoded SHA-256.
+type KeyManifestEntry struct {
+	Name string
+	KeyParams
+	ProvingSystem string
+	Constraints   int
+	Files         map[string]KeyFile
+}
+
+type KeyFile struct {
+	Size   int64
+	Sha256 string
+}
+
+// ReadKeyManifest reads the manifest of the key directory, it returns nil
+// when the directory has no manifest.
+func ReadKeyManifest(dir string) (*KeyManifest, error) {
+	content, err := os.ReadFile(filepath.Join(dir, KeyManifestFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &KeyManifest{}
+	err = json.Unmarshal(content, m)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key manifest in %s: %s", dir, err.Error())
+	}
+	return m, nil
+}
+
+// Write replaces the manifest of the key directory.
+func (m *KeyManifest) Write(dir string) error {
+	sort.Slice(m.Keys, func(i, j int) bool { return m.Keys[i].Name < m.Keys[j].Name })
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, KeyManifestFile+".tmp")
+	err = os.WriteFile(tmp, content, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, KeyManifestFile))
+}
+
+// Entry returns the entry of the key name, the name is the file name of the
+// key without directory and suffix.
+func (m *KeyManifest) Entry(name string) *KeyManifestEntry {
+	for _, e := range m.Keys {
+		if e.Name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// Put adds the entry, or replaces the entry of the same name.
+func (m *KeyManifest) Put(entry *KeyManifestEntry) {
+	for i, e := range m.Keys {
+		if e.Name == entry.Name {
+			m.Keys[i] = entry
+			return
+		}
+	}
+	m.Keys = append(m.Keys, entry)
+}
+
+// AddKeyManifestEntry fingerprints the files of the key and records them in
+// the manifest of the key directory.
+func AddKeyManifestEntry(zkKeyName string, params *KeyParams, provingSystem string, constraints int, suffixes []string) error {
+	entry := &KeyManifestEntry{
+		Name:          filepath.Base(zkKeyName),
+		KeyParams:     *params,
+		ProvingSystem: provingSystem,
+		Constraints:   constraints,
+		Files:         make(map[string]KeyFile, len(suffixes)),
+	}
+	for _, suffix := range suffixes {
+		f, err := os.Open(zkKeyName + suffix)
+		if err != nil {
+			return err
+		}
+		hasher := sha256.New()
+		size, err := io.Copy(hasher, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		entry.Files[suffix] = KeyFile{Size: size, Sha256: hex.EncodeToString(hasher.Sum(nil))}
+	}
+	dir := filepath.Dir(zkKeyName)
+	m, err := ReadKeyManifest(dir)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		m = &KeyManifest{}
+	}
+	m.Put(entry)
+	return m.Write(dir)
+}
+
+// skipKeyManifest accepts the keys whose directory has no manifest, it is
+// set by SkipKeyManifest
+var skipKeyManifest bool
+
+// SkipKeyManifest accepts the keys whose directory has no key manifest, such
+// as the keys generated before keygen wrote the manifest. The keys in a
+// manifest are still checked.
+func SkipKeyManifest() {
+	skipKeyManifest = true
+}
+
+// lookupKeyManifest returns the manifest entry of the key. When the
+// directory of the key has no manifest, it returns an error unless
+// SkipKeyManifest is called, then it returns nil with a warning.
+func lookupKeyManifest(zkKeyName string) (*KeyManifestEntry, error) {
+	m, err := ReadKeyManifest(filepath.Dir(zkKeyName))
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		if !skipKeyManifest {
+			return nil, fmt.Errorf("the directory of the key %s has no %s, generate the keys by keygen or skip the check by -skip_key_manifest", zkKeyName, KeyManifestFile)
+		}
+		fmt.Println("WARNING: the directory of the key", zkKeyName, "has no", KeyManifestFile+", the key isn't checked")
+		return nil, nil
+	}
+	entry := m.Entry(filepath.Base(zkKeyName))
+	if entry == nil {
+		return nil, fmt.Errorf("the key %s is not in the key manifest", zkKeyName)
+	}
+	return entry, nil
+}
+
+// CheckBatchKeyManifest checks the manifest entry of the batch key of the
+// assets count tier, see CheckKeyManifest.
+func CheckBatchKeyManifest(zkKeyName string, assetsCountTier int, provingSystem string, suffixes ...string) (*KeyManifestEntry, error) {
+	entry, err := lookupKeyManifest(zkKeyName)
+	if entry == nil || err != nil {
+		return nil, err
+	}
+	// the batch create and update keys of a tier share the key name
+	want := NewBatchKeyParams(assetsCountTier, entry.Circuit == KeyCircuitBatchUpdate)
+	return entry, entry.check(zkKeyName, want, provingSystem, suffixes)
+}
+
+// CheckKeyManifest checks that the manifest entry of the user inclusion or
+// aggregation key is generated with the circuit params and the proving
+// system in use, and that the files of the suffixes have the recorded
+// sizes. The key without manifest is refused unless SkipKeyManifest is
+// called, then a nil entry is returned. The content of the files is checked
+// by CheckContent or CheckFile of the returned entry.
+func CheckKeyManifest(zkKeyName string, keyCircuit string, provingSystem string, suffixes ...string) (*KeyManifestEntry, error) {
+	entry, err := lookupKeyManifest(zkKeyName)
+	if entry == nil || err != nil {
+		return nil, err
+	}
+	want := NewKeyParams(keyCircuit, 0)
+	if keyCircuit == KeyCircuitAggregation {
+		want.AssetsCountTier = entry.AssetsCountTier
+	}
+	return entry, entry.check(zkKeyName, want, provingSystem, suffixes)
+}
+
+func (e *KeyManifestEntry) check(zkKeyName string, want *KeyParams, provingSystem string, suffixes []string) error {
+	if e.KeyParams != *want {
+		return fmt.Errorf("the key %s is generated with circuit params %+v in the key manifest, but %+v are in use", zkKeyName, e.KeyParams, *want)
+	}
+	if e.ProvingSystem != provingSystem {
+		return fmt.Errorf("the key %s is a %s key in the key manifest, but %s is in use", zkKeyName, e.ProvingSystem, provingSystem)
+	}
+	for _, suffix := range suffixes {
+		file, ok := e.Files[suffix]
+		if !ok {
+			return fmt.Errorf("the key manifest has no %s file of %s", suffix, zkKeyName)
+		}
+		info, err := os.Stat(zkKeyName + suffix)
+		if err != nil {
+			return err
+		}
+		if info.Size() != file.Size {
+			return fmt.Errorf("the size of %s is %d, but the key manifest records %d", zkKeyName+suffix, info.Size(), file.Size)
+		}
+	}
+	return nil
+}
+
+// CheckContent checks the content of the file of the suffix against its
+// SHA-256 in the manifest, a nil entry accepts any content.
+func (e *KeyManifestEntry) CheckContent(suffix string, content []byte) error {
+	if e == nil {
+		return nil
+	}
+	hash := sha256.Sum256(content)
+	return e.CheckHash(suffix, hash[:])
+}
+
+// CheckHash checks the SHA-256 of the file of the suffix, a nil entry
+// accepts any hash.
+func (e *KeyManifestEntry) CheckHash(suffix string, hash []byte) error {
+	if e == nil {
+		return nil
+	}
+	file, ok := e.Files[suffix]
+	if !ok {
+		return fmt.Errorf("the key manifest has no %s file of %s", suffix, e.Name)
+	}
+	if hex.EncodeToString(hash) != file.Sha256 {
+		return fmt.Errorf("sha256 of %s%s is %x, but the key manifest records %s", e.Name, suffix, hash, file.Sha256)
+	}
+	return nil
+}
+
+// LoadKeyFile streams the key file of the suffix into read, which is the
+// ReadFrom or UnsafeReadFrom of the gnark object, so the file is never held
+// in memory as a whole. The SHA-256 of the file is checked against the
+// manifest entry e in the same pass, a nil entry skips the check.
+func LoadKeyFile(zkKeyName string, suffix string, e *KeyManifestEntry, read func(io.Reader) (int64, error)) (int64, error) {
+	f, err := os.Open(zkKeyName + suffix)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if e == nil {
+		return read(bufio.NewReaderSize(f, keyFileBufferSize))
+	}
+	hasher := sha256.New()
+	r := bufio.NewReaderSize(io.TeeReader(f, hasher), keyFileBufferSize)
+	n, err := read(r)
+	if err != nil {
+		return n, err
+	}
+	// the circuit params after the gnark encoding are hashed as well
+	_, err = io.Copy(io.Discard, r)
+	if err != nil {
+		return n, err
+	}
+	return n, e.CheckHash(suffix, hasher.Sum(nil))
+}
+
+// CheckFile reads the file of the suffix and checks its content.
+func (e *KeyManifestEntry) CheckFile(zkKeyName string, suffix string) error {
+	if e == nil {
+		return nil
+	}
+	content, err := os.ReadFile(zkKeyName + suffix)
+	if err != nil {
+		return err
+	}
+	return e.CheckContent(suffix, content)
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyManifest(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"zkpor50_700", "zkpor500_92"} {
+		write(name+".pk", "pk of "+name)
+		write(name+".vk", "vk of "+name)
+		write(name+".r1cs", "r1cs of "+name)
+	}
+	suffixes := []string{".pk", ".vk", ".r1cs"}
+	for _, k := range []int{50, 500} {
+		zkKeyName := filepath.Join(dir, "zkpor"+map[int]string{50: "50_700", 500: "500_92"}[k])
+		if err := AddKeyManifestEntry(zkKeyName, NewBatchKeyParams(k, false), "groth16", 100, suffixes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := ReadKeyManifest(dir)
+	if err != nil || len(m.Keys) != 2 || m.Entry("zkpor500_92").AssetsCountTier != 500 || m.Entry("zkpor500_92").Constraints != 100 {
+		t.Fatalf("unexpected key manifest %v %v", m, err)
+	}
+
+	zkKeyName := filepath.Join(dir, "zkpor50_700")
+	entry, err := CheckBatchKeyManifest(zkKeyName, 50, "groth16", suffixes...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = entry.CheckFile(zkKeyName, ".pk"); err != nil {
+		t.Fatal(err)
+	}
+	// the key of another tier, or of another proving system is refused
+	if _, err = CheckBatchKeyManifest(zkKeyName, 500, "groth16", suffixes...); err == nil {
+		t.Fatal("the key of tier 50 should be refused for tier 500")
+	}
+	if _, err = CheckBatchKeyManifest(zkKeyName, 50, "plonk", suffixes...); err == nil {
+		t.Fatal("the groth16 key should be refused for plonk")
+	}
+	if _, err = CheckKeyManifest(filepath.Join(dir, "zkpor_user_inclusion"), KeyCircuitUserInclusion, "groth16"); err == nil {
+		t.Fatal("the key which is not in the manifest should be refused")
+	}
+	// a tampered key of the same size is found by its hash
+	write("zkpor50_700.pk", "pk of zkpor50_701")
+	if _, err = CheckBatchKeyManifest(zkKeyName, 50, "groth16", suffixes...); err != nil {
+		t.Fatal(err)
+	}
+	if err = entry.CheckFile(zkKeyName, ".pk"); err == nil {
+		t.Fatal("the tampered key should be refused")
+	}
+	write("zkpor50_700.vk", "truncated")
+	if _, err = CheckBatchKeyManifest(zkKeyName, 50, "groth16", suffixes...); err == nil {
+		t.Fatal("the truncated key should be refused")
+	}
+
+	// the keys without manifest are only accepted by SkipKeyManifest
+	noManifestKey := filepath.Join(t.TempDir(), "zkpor50_700")
+	if _, err = CheckBatchKeyManifest(noManifestKey, 50, "groth16", suffixes...); err == nil {
+		t.Fatal("the key without manifest should be refused")
+	}
+	if _, err = CheckKeyManifest(noManifestKey, KeyCircuitUserInclusion, "groth16"); err == nil {
+		t.Fatal("the key without manifest should be refused")
+	}
+	SkipKeyManifest()
+	defer func() { skipKeyManifest = false }()
+	entry, err = CheckBatchKeyManifest(noManifestKey, 50, "groth16", suffixes...)
+	if entry != nil || err != nil || entry.CheckContent(".pk", nil) != nil {
+		t.Fatal("the key without manifest should be accepted by SkipKeyManifest")
+	}
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	WitnessBatchesGenerated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zkpos_witness_batches_generated_total",
+		Help: "The number of batch witnesses saved to db",
+	})
+	WitnessLatestHeight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "zkpos_witness_latest_height",
+		Help: "The height of the latest batch witness saved to db",
+	})
+	ProverBatchesProved = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zkpos_prover_batches_proved_total",
+		Help: "The number of batch proofs saved to db",
+	}, []string{"assets_count"})
+	ProofGenerationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "zkpos_prover_proof_generation_seconds",
+		Help:    "The time of generating a batch proof",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"assets_count"})
+	ProofVerificationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "zkpos_prover_proof_verification_seconds",
+		Help:    "The time of verifying a batch proof",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"assets_count"})
+	KeyLoadingSeconds = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "zkpos_prover_key_loading_seconds",
+		Help: "The time of loading the constraint system and keys of the asset tier",
+	}, []string{"assets_count"})
+	TaskQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "zkpos_prover_task_queue_depth",
+		Help: "The number of tasks waiting in the task queue",
+	})
+	TaskQueueLeases = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "zkpos_prover_task_leases",
+		Help: "The number of tasks leased to the provers",
+	})
+	UserProofsWritten = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zkpos_userproof_proofs_written_total",
+		Help: "The number of user proofs saved to db",
+	})
+	ProofApiRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zkpos_proofapi_requests_total",
+		Help: "The number of requests to the user proof api",
+	}, []string{"endpoint", "code"})
+	// ServiceErrors is labeled by the service and the stage where the error
+	// happens, the retried db timeouts are counted as well
+	ServiceErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zkpos_errors_total",
+		Help: "The number of errors",
+	}, []string{"service", "stage"})
+)
+
+// StartMetricsServer serves the prometheus metrics on http://addr/metrics in
+// the background, it does nothing when addr is empty.
+func StartMetricsServer(addr string) {
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		err := http.ListenAndServe(addr, mux)
+		if err != nil {
+			fmt.Println("metrics server stopped: ", err.Error())
+		}
+	}()
+}
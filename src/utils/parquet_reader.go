@@ -0,0 +1,233 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+// parquetBatchRows is the number of rows read from every column at a time
+const parquetBatchRows = 1024
+
+type parquetColumn struct {
+	name string
+	// path is the path of the column in the reader
+	path string
+	typ  parquet.Type
+	// isDecimal and scale are set for the DECIMAL columns
+	isDecimal bool
+	scale     int32
+	// values is the batch of the column being read
+	values []interface{}
+}
+
+// format converts a value of the column read by parquet-go into a decimal
+// string, the null values are 0
+func (c *parquetColumn) format(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "0", nil
+	case int32:
+		return c.formatInt(big.NewInt(int64(v))), nil
+	case int64:
+		return c.formatInt(big.NewInt(v)), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case string:
+		if !c.isDecimal {
+			return v, nil
+		}
+		// big endian two's complement
+		n := new(big.Int).SetBytes([]byte(v))
+		if len(v) > 0 && v[0]&0x80 != 0 {
+			n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(v)*8)))
+		}
+		return c.formatInt(n), nil
+	}
+	return "", fmt.Errorf("unsupported type %s of column %s", c.typ, c.name)
+}
+
+func (c *parquetColumn) formatInt(n *big.Int) string {
+	if !c.isDecimal || c.scale == 0 {
+		return n.String()
+	}
+	return decimal.NewFromBigInt(n, -c.scale).String()
+}
+
+type parquetUserDataReader struct {
+	name     string
+	file     source.ParquetFile
+	reader   *reader.ParquetReader
+	idColumn *parquetColumn
+	// assetColumns[i] is the columns of the equity, debt, loan, margin and
+	// portfolio margin of record.Assets[i]
+	assetColumns [][5]*parquetColumn
+	rowsLeft     int64
+	// the index of the next row in the batch
+	batchRow  int
+	batchRows int
+	record    UserRecord
+}
+
+// NewParquetUserDataReader reads the parquet user file which has the columns
+// of the csv layout: id, and e_<symbol>, d_<symbol>, vl_<symbol>, m_<symbol>,
+// pm_<symbol> of every asset. The other columns are ignored. The balances can
+// be strings, decimals, integers or floating point numbers, and the null
+// balances are 0. The file is decoded by parquet-go, the columns are read in
+// batches of parquetBatchRows rows.
+func NewParquetUserDataReader(name string) (UserDataReader, error) {
+	file, err := local.NewLocalFileReader(name)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newParquetUserDataReader(name, file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %s", name, err.Error())
+	}
+	return r, nil
+}
+
+func newParquetUserDataReader(name string, file source.ParquetFile) (*parquetUserDataReader, error) {
+	pr, err := reader.NewParquetColumnReader(file, 1)
+	if err != nil {
+		return nil, err
+	}
+	r := &parquetUserDataReader{name: name, file: file, reader: pr, rowsLeft: pr.GetNumRows()}
+	handler := pr.SchemaHandler
+	var ordered []*parquetColumn
+	columns := make(map[string]*parquetColumn)
+	for _, path := range handler.ValueColumns {
+		index, ok := handler.MapIndex[path]
+		if !ok {
+			return nil, fmt.Errorf("column %s is not in the schema", path)
+		}
+		// the nested and repeated columns are ignored
+		element := handler.SchemaElements[index]
+		if len(common.StrToPath(path)) != 2 || element.GetRepetitionType() == parquet.FieldRepetitionType_REPEATED {
+			continue
+		}
+		column := &parquetColumn{
+			name: strings.ToLower(handler.GetExName(int(index))),
+			path: path,
+			typ:  element.GetType(),
+		}
+		if element.GetConvertedType() == parquet.ConvertedType_DECIMAL {
+			column.isDecimal, column.scale = true, element.GetScale()
+		}
+		if element.IsSetLogicalType() && element.GetLogicalType().IsSetDECIMAL() {
+			column.isDecimal, column.scale = true, element.GetLogicalType().GetDECIMAL().GetScale()
+		}
+		ordered = append(ordered, column)
+		columns[column.name] = column
+	}
+
+	used := func(name string) (*parquetColumn, error) {
+		column, ok := columns[name]
+		if !ok {
+			return nil, fmt.Errorf("parquet user file has no column %s", name)
+		}
+		return column, nil
+	}
+	if r.idColumn, err = used("id"); err != nil {
+		return nil, err
+	}
+	for _, column := range ordered {
+		symbol, found := strings.CutPrefix(column.name, "e_")
+		if !found {
+			continue
+		}
+		indexes := [5]*parquetColumn{column}
+		for k, prefix := range []string{"d_", "vl_", "m_", "pm_"} {
+			if indexes[k+1], err = used(prefix + symbol); err != nil {
+				return nil, err
+			}
+		}
+		r.assetColumns = append(r.assetColumns, indexes)
+		r.record.Assets = append(r.record.Assets, UserAssetRecord{Symbol: symbol})
+	}
+	return r, nil
+}
+
+// readBatch reads the next batch of the used columns.
+func (r *parquetUserDataReader) readBatch() error {
+	rows := int64(parquetBatchRows)
+	if rows > r.rowsLeft {
+		rows = r.rowsLeft
+	}
+	read := func(column *parquetColumn) error {
+		values, _, _, err := r.reader.ReadColumnByPath(column.path, rows)
+		if err != nil {
+			return err
+		}
+		// parquet-go returns less values when a page can't be decoded
+		if int64(len(values)) != rows {
+			return fmt.Errorf("read %d values of column %s, expect %d", len(values), column.name, rows)
+		}
+		column.values = values
+		return nil
+	}
+	if err := read(r.idColumn); err != nil {
+		return err
+	}
+	for _, columns := range r.assetColumns {
+		for _, column := range columns {
+			if err := read(column); err != nil {
+				return err
+			}
+		}
+	}
+	r.rowsLeft -= rows
+	r.batchRow, r.batchRows = 0, int(rows)
+	return nil
+}
+
+func (r *parquetUserDataReader) Next() (*UserRecord, error) {
+	if r.batchRow == r.batchRows {
+		if r.rowsLeft == 0 {
+			return nil, io.EOF
+		}
+		if err := r.readBatch(); err != nil {
+			return nil, fmt.Errorf("%s: %s", r.name, err.Error())
+		}
+	}
+	i := r.batchRow
+	r.batchRow++
+	if r.idColumn.values[i] == nil {
+		return nil, errors.New("user without id in " + r.name)
+	}
+	id, err := r.idColumn.format(r.idColumn.values[i])
+	if err != nil {
+		return nil, err
+	}
+	r.record.Id = id
+	for j, columns := range r.assetColumns {
+		var values [5]string
+		for k, column := range columns {
+			if values[k], err = column.format(column.values[i]); err != nil {
+				return nil, err
+			}
+		}
+		asset := &r.record.Assets[j]
+		asset.Equity, asset.Debt, asset.Loan, asset.Margin, asset.PortfolioMargin =
+			values[0], values[1], values[2], values[3], values[4]
+	}
+	return &r.record, nil
+}
+
+func (r *parquetUserDataReader) Close() error {
+	r.reader.ReadStop()
+	return r.file.Close()
+}
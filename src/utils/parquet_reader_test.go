@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// writeTestParquetFile writes the rows by the CSV writer of parquet-go, the
+// columns are in the metadata format of the writer
+func writeTestParquetFile(t *testing.T, name string, columns []string, rows [][]interface{}, codec parquet.CompressionCodec) {
+	file, err := local.NewLocalFileWriter(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	w, err := writer.NewCSVWriter(columns, file, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.CompressionType = codec
+	// several row groups and pages
+	w.RowGroupSize = 512
+	w.PageSize = 128
+	for _, row := range rows {
+		if err = w.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.WriteStop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectParquetUsers reads the users of the parquet file which has the asset
+// btc and checks the ids and balances.
+func expectParquetUsers(t *testing.T, name string, ids []string, expected [][5]string) {
+	reader, err := NewUserDataReader(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	for i := range expected {
+		record, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(record.Assets) != 1 || record.Assets[0].Symbol != "btc" {
+			t.Fatalf("unexpected assets %v", record.Assets)
+		}
+		asset := record.Assets[0]
+		values := [5]string{asset.Equity, asset.Debt, asset.Loan, asset.Margin, asset.PortfolioMargin}
+		if record.Id != ids[i] || values != expected[i] {
+			t.Fatalf("%s: unexpected record %d: %s %v", name, i, record.Id, values)
+		}
+	}
+	if _, err = reader.Next(); err != io.EOF {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+var testParquetIds = []string{"00", "01", "02", "03", "04"}
+
+var testParquetBalances = [][5]string{
+	{"1.5", "1.5", "0.1", "1", "1.5"},
+	{"0", "0", "2.5", "-2", "-1.5"},
+	{"1.5", "0.00000001", "0", "3", "0"},
+	{"0.25", "-0.00000025", "0.00000001", "4", "2.56"},
+	{"1.5", "1000", "3", "5", "327.67"},
+}
+
+func TestParquetUserDataReader(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "users.parquet")
+	columns := []string{
+		"name=rn, type=INT64",
+		"name=id, type=BYTE_ARRAY, convertedtype=UTF8",
+		"name=e_BTC, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL, encoding=PLAIN_DICTIONARY",
+		"name=d_btc, type=INT64, convertedtype=DECIMAL, scale=8, precision=18",
+		"name=vl_btc, type=DOUBLE, repetitiontype=OPTIONAL",
+		"name=m_btc, type=INT32",
+		"name=pm_btc, type=FIXED_LEN_BYTE_ARRAY, length=2, convertedtype=DECIMAL, scale=2, precision=4",
+	}
+	rows := [][]interface{}{
+		{int64(0), "00", "1.5", int64(150000000), 0.1, int32(1), "\x00\x96"},
+		{int64(1), "01", nil, int64(0), 2.5, int32(-2), "\xff\x6a"},
+		{int64(2), "02", "1.5", int64(1), nil, int32(3), "\x00\x00"},
+		{int64(3), "03", "0.25", int64(-25), 1e-8, int32(4), "\x01\x00"},
+		{int64(4), "04", "1.5", int64(100000000000), 3.0, int32(5), "\x7f\xff"},
+	}
+	for _, codec := range []parquet.CompressionCodec{parquet.CompressionCodec_UNCOMPRESSED,
+		parquet.CompressionCodec_SNAPPY, parquet.CompressionCodec_GZIP, parquet.CompressionCodec_ZSTD} {
+		writeTestParquetFile(t, name, columns, rows, codec)
+		expectParquetUsers(t, name, testParquetIds, testParquetBalances)
+	}
+
+	// every asset must have the five balance columns
+	for i := range rows {
+		rows[i] = rows[i][:6]
+	}
+	writeTestParquetFile(t, name, columns[:6], rows, parquet.CompressionCodec_UNCOMPRESSED)
+	if _, err := NewUserDataReader(name); err == nil {
+		t.Fatal("the parquet file without pm_btc should be rejected")
+	}
+}
+
+// TestParquetUserDataReaderFixture reads the file of
+// testdata/generate_users_parquet.py, which has the layout written by
+// pyarrow: the balances of TestParquetUserDataReader in dictionary encoded,
+// DECIMAL and snappy compressed v2 data pages.
+func TestParquetUserDataReaderFixture(t *testing.T) {
+	expectParquetUsers(t, filepath.Join("testdata", "users.parquet"), testParquetIds, testParquetBalances)
+}
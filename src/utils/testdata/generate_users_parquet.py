@@ -0,0 +1,248 @@
+"""Generates users.parquet read by TestParquetUserDataReaderFixture.
+
+    python3 generate_users_parquet.py
+
+The file has the layout written by pyarrow for
+
+    pq.write_table(table, "users.parquet", row_group_size=2, compression="snappy",
+                   use_dictionary=["e_BTC"], data_page_version="2.0")
+
+of the table below: nullable columns, a dictionary encoded string column,
+DECIMAL columns stored as FIXED_LEN_BYTE_ARRAY and snappy compressed v2 data
+pages. It is written without dependencies, so the fixture doesn't depend on
+the writer of parquet-go which is used by the reader.
+"""
+
+from decimal import Decimal
+import os
+import struct
+
+# parquet.thrift
+INT32, INT64, DOUBLE, BYTE_ARRAY, FIXED_LEN_BYTE_ARRAY = 1, 2, 5, 6, 7
+UTF8, DECIMAL = 0, 5
+OPTIONAL = 1
+PLAIN, RLE, RLE_DICTIONARY = 0, 3, 8
+SNAPPY = 1
+DICTIONARY_PAGE, DATA_PAGE_V2 = 2, 3
+
+ROWS = 5
+ROW_GROUP_SIZE = 2
+
+# name, type, type length, converted type, logical type, precision, scale, dictionary, values
+COLUMNS = [
+    ("rn", INT64, None, None, None, None, None, False, [0, 1, 2, 3, 4]),
+    ("id", BYTE_ARRAY, None, UTF8, "string", None, None, False, ["00", "01", "02", "03", "04"]),
+    ("e_BTC", BYTE_ARRAY, None, UTF8, "string", None, None, True, ["1.5", None, "1.5", "0.25", "1.5"]),
+    ("d_btc", FIXED_LEN_BYTE_ARRAY, 8, DECIMAL, "decimal", 18, 8, False,
+     [Decimal("1.5"), Decimal("0"), Decimal("0.00000001"), Decimal("-0.00000025"), Decimal("1000")]),
+    ("vl_btc", DOUBLE, None, None, None, None, None, False, [0.1, 2.5, None, 1e-8, 3.0]),
+    ("m_btc", INT32, None, None, None, None, None, False, [1, -2, 3, 4, 5]),
+    ("pm_btc", FIXED_LEN_BYTE_ARRAY, 3, DECIMAL, "decimal", 5, 2, False,
+     [Decimal("1.5"), Decimal("-1.5"), Decimal("0"), Decimal("2.56"), Decimal("327.67")]),
+]
+
+
+def varint(n):
+    out = bytearray()
+    while True:
+        if n < 0x80:
+            out.append(n)
+            return bytes(out)
+        out.append((n & 0x7F) | 0x80)
+        n >>= 7
+
+
+def zigzag(n):
+    return (n << 1) ^ (n >> 63)
+
+
+class Struct:
+    """A thrift struct of the compact protocol, the fields are added in order."""
+
+    def __init__(self):
+        self.buf = bytearray()
+        self.last = 0
+
+    def field(self, fid, ftype):
+        delta = fid - self.last
+        if 0 < delta <= 15:
+            self.buf.append((delta << 4) | ftype)
+        else:
+            self.buf.append(ftype)
+            self.buf += varint(zigzag(fid))
+        self.last = fid
+
+    def i32(self, fid, v):
+        self.field(fid, 5)
+        self.buf += varint(zigzag(v))
+        return self
+
+    def i64(self, fid, v):
+        self.field(fid, 6)
+        self.buf += varint(zigzag(v))
+        return self
+
+    def bool(self, fid, v):
+        self.field(fid, 1 if v else 2)
+        return self
+
+    def string(self, fid, v):
+        self.field(fid, 8)
+        data = v.encode()
+        self.buf += varint(len(data)) + data
+        return self
+
+    def struct(self, fid, s):
+        self.field(fid, 12)
+        self.buf += s.end()
+        return self
+
+    def list(self, fid, etype, items):
+        self.field(fid, 9)
+        if len(items) < 15:
+            self.buf.append((len(items) << 4) | etype)
+        else:
+            self.buf.append(0xF0 | etype)
+            self.buf += varint(len(items))
+        for item in items:
+            if etype == 5:
+                self.buf += varint(zigzag(item))
+            elif etype == 8:
+                data = item.encode()
+                self.buf += varint(len(data)) + data
+            else:
+                self.buf += item.end()
+        return self
+
+    def end(self):
+        return bytes(self.buf) + b"\x00"
+
+
+def snappy(data):
+    """Compresses data to a snappy stream of literals."""
+    out = bytearray(varint(len(data)))
+    for start in range(0, len(data), 65536):
+        chunk = data[start:start + 65536]
+        n = len(chunk) - 1
+        if n < 60:
+            out.append(n << 2)
+        elif n < 256:
+            out += bytes([60 << 2, n])
+        else:
+            out += bytes([61 << 2]) + struct.pack("<H", n)
+        out += chunk
+    return bytes(out)
+
+
+def bit_packed(values, width):
+    """Encodes the values as a bit packed run of the RLE hybrid encoding."""
+    groups = (len(values) + 7) // 8
+    padded = values + [0] * (groups * 8 - len(values))
+    bits = 0
+    for i, v in enumerate(padded):
+        bits |= v << (i * width)
+    return varint((groups << 1) | 1) + bits.to_bytes(groups * width, "little")
+
+
+def plain(column, values):
+    _, typ, length, _, _, _, scale, _, _ = column
+    out = bytearray()
+    for v in values:
+        if typ == INT32:
+            out += struct.pack("<i", v)
+        elif typ == INT64:
+            out += struct.pack("<q", v)
+        elif typ == DOUBLE:
+            out += struct.pack("<d", v)
+        elif typ == BYTE_ARRAY:
+            out += struct.pack("<I", len(v.encode())) + v.encode()
+        else:
+            unscaled = int(v.scaleb(scale))
+            out += unscaled.to_bytes(length, "big", signed=True)
+    return bytes(out)
+
+
+def page(header, levels, values):
+    """Returns the page of the header with the sizes set, and its uncompressed size."""
+    compressed = snappy(values)
+    header.i32(2, len(levels) + len(values)).i32(3, len(levels) + len(compressed))
+    return header, levels + compressed, len(levels) + len(values)
+
+
+def column_chunk(column, values, offset):
+    name, typ, _, _, _, _, _, dictionary, _ = column
+    present = [v for v in values if v is not None]
+    levels = bit_packed([0 if v is None else 1 for v in values], 1)
+    data = bytearray()
+    uncompressed = 0
+    meta = Struct()
+    if dictionary:
+        entries = list(dict.fromkeys(present))
+        header, body, size = page(Struct().i32(1, DICTIONARY_PAGE), b"", plain(column, entries))
+        header.struct(7, Struct().i32(1, len(entries)).i32(2, PLAIN))
+        header = header.end()
+        data += header + body
+        uncompressed += len(header) + size
+        # the bit width of arrow is 1 for a single entry
+        width = max((len(entries) - 1).bit_length(), 1)
+        encoded = bytes([width]) + bit_packed([entries.index(v) for v in present], width)
+        encoding = RLE_DICTIONARY
+    else:
+        encoded = plain(column, present)
+        encoding = PLAIN
+    data_offset = offset + len(data)
+    header, body, size = page(Struct().i32(1, DATA_PAGE_V2), levels, encoded)
+    header.struct(8, Struct().i32(1, len(values)).i32(2, len(values) - len(present)).i32(3, len(values))
+                  .i32(4, encoding).i32(5, len(levels)).i32(6, 0).bool(7, True))
+    header = header.end()
+    data += header + body
+    uncompressed += len(header) + size
+    encodings = [PLAIN, RLE, RLE_DICTIONARY] if dictionary else [PLAIN, RLE]
+    meta.i32(1, typ).list(2, 5, encodings).list(3, 8, [name]).i32(4, SNAPPY).i64(5, len(values))
+    meta.i64(6, uncompressed).i64(7, len(data)).i64(9, data_offset)
+    if dictionary:
+        meta.i64(11, offset)
+    return bytes(data), Struct().i64(2, offset).struct(3, meta)
+
+
+def schema():
+    elements = [Struct().string(4, "schema").i32(5, len(COLUMNS))]
+    for name, typ, length, converted, logical, precision, scale, _, _ in COLUMNS:
+        element = Struct().i32(1, typ)
+        if length is not None:
+            element.i32(2, length)
+        element.i32(3, OPTIONAL).string(4, name)
+        if converted is not None:
+            element.i32(6, converted)
+        if converted == DECIMAL:
+            element.i32(7, scale).i32(8, precision)
+        if logical == "string":
+            element.struct(10, Struct().struct(1, Struct()))
+        elif logical == "decimal":
+            element.struct(10, Struct().struct(5, Struct().i32(1, scale).i32(2, precision)))
+        elements.append(element)
+    return elements
+
+
+def main():
+    out = bytearray(b"PAR1")
+    row_groups = []
+    for start in range(0, ROWS, ROW_GROUP_SIZE):
+        end = min(start + ROW_GROUP_SIZE, ROWS)
+        chunks = []
+        size = 0
+        for column in COLUMNS:
+            data, chunk = column_chunk(column, column[-1][start:end], len(out))
+            out += data
+            size += len(data)
+            chunks.append(chunk)
+        row_groups.append(Struct().list(1, 12, chunks).i64(2, size).i64(3, end - start))
+    footer = (Struct().i32(1, 2).list(2, 12, schema()).i64(3, ROWS).list(4, 12, row_groups)
+              .string(6, "generate_users_parquet.py").end())
+    out += footer + struct.pack("<I", len(footer)) + b"PAR1"
+    with open(os.path.join(os.path.dirname(os.path.abspath(__file__)), "users.parquet"), "wb") as f:
+        f.write(out)
+
+
+if __name__ == "__main__":
+    main()
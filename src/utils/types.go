@@ -55,7 +55,48 @@ type BatchCreateUserWitness struct {
 	AfterAccountTreeRoot      []byte
 	BeforeCEXAssetsCommitment []byte
 	AfterCEXAssetsCommitment  []byte
+	// AssetRegistryHash is the hash of the asset registry which scales the
+	// balances and prices of the snapshot
+	AssetRegistryHash string
 
 	BeforeCexAssets []CexAssetInfo
 	CreateUserOps   []CreateUserOperation
 }
+
+// UpdateUserOperation replaces the leaf of an account in the tree of the
+// previous snapshot. The old leaf is the empty leaf when the account is
+// inserted and the new leaf is the empty leaf when the account is deleted.
+type UpdateUserOperation struct {
+	BeforeAccountTreeRoot []byte
+	AfterAccountTreeRoot  []byte
+	IsOldEmpty            bool
+	OldTotalEquity        *big.Int
+	OldTotalDebt          *big.Int
+	OldTotalCollateral    *big.Int
+	OldAssets             []AccountAsset
+	IsNewEmpty            bool
+	Assets                []AccountAsset
+	AccountIndex          uint32
+	AccountIdHash         []byte
+	AccountProof          [AccountTreeDepth][]byte
+}
+
+type BatchUpdateUserWitness struct {
+	BatchCommitment           []byte
+	BeforeAccountTreeRoot     []byte
+	AfterAccountTreeRoot      []byte
+	BeforeCEXAssetsCommitment []byte
+	AfterCEXAssetsCommitment  []byte
+
+	// AssetsCount is the assets count tier of all the operations in the batch
+	AssetsCount int
+	// BaseTreeVersion is the account tree version of the previous snapshot and
+	// InsertStartAccountIndex is the first account index used by inserted accounts,
+	// they are the same for all the batches of a snapshot
+	BaseTreeVersion         uint64
+	InsertStartAccountIndex uint32
+	AssetRegistryHash       string
+
+	BeforeCexAssets []CexAssetInfo
+	UpdateUserOps   []UpdateUserOperation
+}
@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UserAssetRecord is the balances of an asset of a user, they are decimal
+// strings in the unit of the asset.
+type UserAssetRecord struct {
+	// Symbol is in lower case
+	Symbol          string
+	Equity          string
+	Debt            string
+	Loan            string
+	Margin          string
+	PortfolioMargin string
+}
+
+// UserRecord is a user of the user file, Id is the hex encoded account id.
+// The wide formats list all assets of the file while the sparse formats only
+// list the assets the user holds.
+type UserRecord struct {
+	Id     string
+	Assets []UserAssetRecord
+}
+
+// UserDataReader reads the users of a user file one by one
+type UserDataReader interface {
+	// Next returns io.EOF after the last user, the returned record may be
+	// reused by the next call
+	Next() (*UserRecord, error)
+	Close() error
+}
+
+// userDataReaders maps the extension of the user files to their readers
+var userDataReaders = map[string]func(name string) (UserDataReader, error){
+	".csv":     NewCsvUserDataReader,
+	".jsonl":   NewJsonlUserDataReader,
+	".parquet": NewParquetUserDataReader,
+}
+
+// RegisterUserDataReader adds the reader of the user files whose extension
+// is ext, it must be called before the user data set is parsed.
+func RegisterUserDataReader(ext string, open func(name string) (UserDataReader, error)) {
+	userDataReaders[strings.ToLower(ext)] = open
+}
+
+// IsUserDataFile reports whether the file has a registered reader
+func IsUserDataFile(name string) bool {
+	_, ok := userDataReaders[strings.ToLower(filepath.Ext(name))]
+	return ok
+}
+
+// NewUserDataReader opens the user file with the reader of its extension
+func NewUserDataReader(name string) (UserDataReader, error) {
+	open, ok := userDataReaders[strings.ToLower(filepath.Ext(name))]
+	if !ok {
+		return nil, fmt.Errorf("unknown user file format %s", name)
+	}
+	return open(name)
+}
+
+type csvUserDataReader struct {
+	f      *os.File
+	reader *csv.Reader
+	record UserRecord
+}
+
+// NewCsvUserDataReader reads the wide csv layout, every asset has six columns
+// in the order of the header:
+// rn, id,
+// equity_assetA, debt_assetA, assetA, assetA_loan, assetA_margin, assetA_portfolio_margin,
+// equity_assetB, debt_assetB, assetB, assetB_loan, assetB_margin, assetB_portfolio_margin,
+// ......
+// total_net_balance
+func NewCsvUserDataReader(name string) (UserDataReader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	reader := csv.NewReader(bufio.NewReaderSize(f, 1<<20))
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	assetCounts := (len(header) - 3) / 6
+	r := &csvUserDataReader{f: f, reader: reader}
+	r.record.Assets = make([]UserAssetRecord, assetCounts)
+	for i := range assetCounts {
+		r.record.Assets[i].Symbol = strings.ToLower(header[i*6+4])
+	}
+	return r, nil
+}
+
+func (r *csvUserDataReader) Next() (*UserRecord, error) {
+	row, err := r.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	r.record.Id = row[1]
+	for i := range r.record.Assets {
+		asset := &r.record.Assets[i]
+		asset.Equity = row[i*6+2]
+		asset.Debt = row[i*6+3]
+		asset.Loan = row[i*6+5]
+		asset.Margin = row[i*6+6]
+		asset.PortfolioMargin = row[i*6+7]
+	}
+	return &r.record, nil
+}
+
+func (r *csvUserDataReader) Close() error {
+	return r.f.Close()
+}
+
+// jsonlUser is a line of the JSON Lines user file, only the assets the user
+// holds are listed and the omitted balances are 0:
+// {"id": "<hex>", "assets": [{"symbol": "btc", "equity": "1.5", "debt": "0.2", "loan": "0.5", "margin": "0", "portfolio_margin": "0"}]}
+// The balances can be JSON numbers or strings.
+type jsonlUser struct {
+	Id     string `json:"id"`
+	Assets []struct {
+		Symbol          string      `json:"symbol"`
+		Equity          json.Number `json:"equity"`
+		Debt            json.Number `json:"debt"`
+		Loan            json.Number `json:"loan"`
+		Margin          json.Number `json:"margin"`
+		PortfolioMargin json.Number `json:"portfolio_margin"`
+	} `json:"assets"`
+}
+
+type jsonlUserDataReader struct {
+	f       *os.File
+	decoder *json.Decoder
+	record  UserRecord
+}
+
+// NewJsonlUserDataReader reads the sparse JSON Lines layout, see jsonlUser
+func NewJsonlUserDataReader(name string) (UserDataReader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bufio.NewReaderSize(f, 1<<20))
+	decoder.DisallowUnknownFields()
+	return &jsonlUserDataReader{f: f, decoder: decoder}, nil
+}
+
+func (r *jsonlUserDataReader) Next() (*UserRecord, error) {
+	var user jsonlUser
+	if err := r.decoder.Decode(&user); err != nil {
+		return nil, err
+	}
+	if user.Id == "" {
+		return nil, errors.New("user without id in " + r.f.Name())
+	}
+	r.record.Id = user.Id
+	r.record.Assets = r.record.Assets[:0]
+	for _, asset := range user.Assets {
+		r.record.Assets = append(r.record.Assets, UserAssetRecord{
+			Symbol:          strings.ToLower(asset.Symbol),
+			Equity:          zeroIfEmpty(asset.Equity),
+			Debt:            zeroIfEmpty(asset.Debt),
+			Loan:            zeroIfEmpty(asset.Loan),
+			Margin:          zeroIfEmpty(asset.Margin),
+			PortfolioMargin: zeroIfEmpty(asset.PortfolioMargin),
+		})
+	}
+	return &r.record, nil
+}
+
+func (r *jsonlUserDataReader) Close() error {
+	return r.f.Close()
+}
+
+func zeroIfEmpty(n json.Number) string {
+	if n == "" {
+		return "0"
+	}
+	return n.String()
+}
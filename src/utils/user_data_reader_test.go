@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xitongsys/parquet-go/parquet"
+)
+
+// testSymbols are the assets of the sample cex_assets_info.csv
+var testSymbols = []string{"btc", "eth", "bnb", "shib"}
+
+// newTestUserDataDir returns a temporary user data directory which has the
+// sample cex_assets_info.csv, and the header line of the csv user files of
+// testSymbols.
+func newTestUserDataDir(t *testing.T) (string, string) {
+	cexAssets, err := os.ReadFile("../sampledata/cex_assets_info.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err = os.WriteFile(filepath.Join(dir, "cex_assets_info.csv"), cexAssets, 0644); err != nil {
+		t.Fatal(err)
+	}
+	header := "rn,id"
+	for _, symbol := range testSymbols {
+		header += fmt.Sprintf(",e_%s,d_%s,%s,vl_%s,m_%s,pm_%s", symbol, symbol, symbol, symbol, symbol, symbol)
+	}
+	return dir, header + ",total_net_balance_usdt\n"
+}
+
+func TestUserDataFormats(t *testing.T) {
+	users := make([]UserRecord, 0)
+	for id := 0; id < 40; id++ {
+		user := UserRecord{Id: fmt.Sprintf("%064x", id)}
+		for j, symbol := range testSymbols {
+			asset := UserAssetRecord{Symbol: symbol, Equity: "0", Debt: "0", Loan: "0", Margin: "0", PortfolioMargin: "0"}
+			if (id+j)%3 != 0 {
+				asset.Equity = fmt.Sprintf("%d.5", id+j)
+				asset.Loan = fmt.Sprintf("%d", id+j)
+				asset.PortfolioMargin = "0.25"
+				if j == 1 {
+					asset.Debt = "0.01"
+				}
+			}
+			user.Assets = append(user.Assets, asset)
+		}
+		if id == 7 {
+			// the collateral is bigger than the equity
+			user.Assets[0].Loan = "100"
+		}
+		users = append(users, user)
+	}
+
+	var csvFile strings.Builder
+	var jsonlFile strings.Builder
+	parquetColumns := []string{"name=id, type=BYTE_ARRAY, convertedtype=UTF8"}
+	for _, symbol := range testSymbols {
+		for _, prefix := range []string{"e_", "d_", "vl_", "m_", "pm_"} {
+			column := "name=" + prefix + symbol + ", type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"
+			if prefix == "pm_" {
+				column += ", encoding=PLAIN_DICTIONARY"
+			}
+			parquetColumns = append(parquetColumns, column)
+		}
+	}
+	parquetRows := make([][]interface{}, 0, len(users))
+	for i, user := range users {
+		csvFile.WriteString(fmt.Sprintf("%d,%s", i, user.Id))
+		parquetRow := []interface{}{user.Id}
+		sparseAssets := make([]map[string]any, 0)
+		for _, asset := range user.Assets {
+			csvFile.WriteString(fmt.Sprintf(",%s,%s,0,%s,%s,%s", asset.Equity, asset.Debt, asset.Loan, asset.Margin, asset.PortfolioMargin))
+			for _, v := range []string{asset.Equity, asset.Debt, asset.Loan, asset.Margin, asset.PortfolioMargin} {
+				var value interface{} = v
+				if v == "0" {
+					value = nil
+				}
+				parquetRow = append(parquetRow, value)
+			}
+			if asset.Equity == "0" && asset.Debt == "0" {
+				continue
+			}
+			// the sparse assets are listed in the reverse order, the zero
+			// balances are omitted and the loan is a JSON number
+			sparseAsset := map[string]any{"symbol": strings.ToUpper(asset.Symbol), "equity": asset.Equity,
+				"loan": json.Number(asset.Loan), "portfolio_margin": asset.PortfolioMargin}
+			if asset.Debt != "0" {
+				sparseAsset["debt"] = asset.Debt
+			}
+			sparseAssets = append([]map[string]any{sparseAsset}, sparseAssets...)
+		}
+		parquetRows = append(parquetRows, parquetRow)
+		csvFile.WriteString(",0\n")
+		line, err := json.Marshal(map[string]any{"id": user.Id, "assets": sparseAssets})
+		if err != nil {
+			t.Fatal(err)
+		}
+		jsonlFile.Write(line)
+		jsonlFile.WriteString("\n")
+	}
+
+	parseDir := func(write func(dir string, header string)) map[int][]AccountInfo {
+		dir, header := newTestUserDataDir(t)
+		write(dir, header)
+		accounts, _, err := ParseUserDataSet(dir)
+		if err == nil || err.Error() != "invalid account data" {
+			t.Fatalf("unexpected error %v", err)
+		}
+		return accounts
+	}
+	csvAccounts := parseDir(func(dir string, header string) {
+		if err := os.WriteFile(filepath.Join(dir, "users.csv"), []byte(header+csvFile.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+	})
+	jsonlAccounts := parseDir(func(dir string, _ string) {
+		if err := os.WriteFile(filepath.Join(dir, "users.jsonl"), []byte(jsonlFile.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+	})
+	parquetAccounts := parseDir(func(dir string, _ string) {
+		writeTestParquetFile(t, filepath.Join(dir, "users.parquet"), parquetColumns, parquetRows, parquet.CompressionCodec_SNAPPY)
+	})
+
+	if len(csvAccounts[AssetCountsTiers[0]]) != len(users)-1 {
+		t.Fatalf("unexpected accounts number %d", len(csvAccounts[AssetCountsTiers[0]]))
+	}
+	if !reflect.DeepEqual(csvAccounts, jsonlAccounts) {
+		t.Fatal("the accounts of the jsonl file are different from the csv file")
+	}
+	if !reflect.DeepEqual(csvAccounts, parquetAccounts) {
+		t.Fatal("the accounts of the parquet file are different from the csv file")
+	}
+}
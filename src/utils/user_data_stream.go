@@ -0,0 +1,320 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// AccountIterator returns the accounts of an assets count tier one by one in
+// the order of the account index.
+type AccountIterator interface {
+	// Next returns io.EOF after the last account
+	Next() (AccountInfo, error)
+	Close() error
+}
+
+// AccountSource is the accounts of the user data set partitioned by the
+// assets count tier, the accounts are read through iterators so that they
+// don't have to be in memory at the same time.
+type AccountSource interface {
+	// Counts returns the number of accounts of every tier
+	Counts() map[int]int
+	Iterator(tier int) (AccountIterator, error)
+	// Close releases the accounts, the spilled accounts are deleted
+	Close() error
+}
+
+type memoryAccountSource struct {
+	accounts map[int][]AccountInfo
+}
+
+// NewMemoryAccountSource returns the source of the accounts parsed by
+// ParseUserDataSet
+func NewMemoryAccountSource(accounts map[int][]AccountInfo) AccountSource {
+	return &memoryAccountSource{accounts: accounts}
+}
+
+func (s *memoryAccountSource) Counts() map[int]int {
+	counts := make(map[int]int, len(s.accounts))
+	for k, v := range s.accounts {
+		counts[k] = len(v)
+	}
+	return counts
+}
+
+func (s *memoryAccountSource) Iterator(tier int) (AccountIterator, error) {
+	return &memoryAccountIterator{accounts: s.accounts[tier]}, nil
+}
+
+type memoryAccountIterator struct {
+	accounts []AccountInfo
+	next     int
+}
+
+func (s *memoryAccountSource) Close() error {
+	return nil
+}
+
+func (it *memoryAccountIterator) Next() (AccountInfo, error) {
+	if it.next >= len(it.accounts) {
+		return AccountInfo{}, io.EOF
+	}
+	it.next++
+	return it.accounts[it.next-1], nil
+}
+
+func (it *memoryAccountIterator) Close() error {
+	return nil
+}
+
+// ParseUserDataSource parses the user data set in memory when spillDir is
+// empty, otherwise the accounts are spilled to spillDir.
+func ParseUserDataSource(dirname string, spillDir string) (AccountSource, []CexAssetInfo, error) {
+	if spillDir == "" {
+		accounts, cexAssetsInfo, err := ParseUserDataSet(dirname)
+		if err != nil {
+			return nil, nil, err
+		}
+		return NewMemoryAccountSource(accounts), cexAssetsInfo, nil
+	}
+	accounts, cexAssetsInfo, err := ParseUserDataSetToDisk(dirname, spillDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return accounts, cexAssetsInfo, nil
+}
+
+// ReadAllAccounts loads all accounts of the source into memory
+func ReadAllAccounts(source AccountSource) (map[int][]AccountInfo, error) {
+	accounts := make(map[int][]AccountInfo)
+	for k, count := range source.Counts() {
+		it, err := source.Iterator(k)
+		if err != nil {
+			return nil, err
+		}
+		accounts[k] = make([]AccountInfo, 0, count)
+		for {
+			account, err := it.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				it.Close()
+				return nil, err
+			}
+			accounts[k] = append(accounts[k], account)
+		}
+		it.Close()
+	}
+	return accounts, nil
+}
+
+// SpilledAccountSource keeps the accounts in gob encoded files on the disk, one
+// file per user file and tier. The account indexes in the files start from 0
+// for every user file, they are shifted by the number of accounts of the
+// previous user files when the accounts are read.
+type SpilledAccountSource struct {
+	dir string
+	// counts[i] is the number of accounts of every tier in the user file i
+	counts []map[int]int
+}
+
+// ParseUserDataSetToDisk parses the user files of dirname row by row and spills
+// the accounts to a new directory under spillDir, so the memory usage doesn't
+// grow with the number of users. The accounts and their indexes are the same
+// as the ones of ParseUserDataSet.
+func ParseUserDataSetToDisk(dirname string, spillDir string) (*SpilledAccountSource, []CexAssetInfo, error) {
+	userFileNames, cexAssetInfo, assetRegistry, err := prepareUserDataSet(dirname)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = os.MkdirAll(spillDir, 0755)
+	if err != nil {
+		return nil, nil, err
+	}
+	dir, err := os.MkdirTemp(spillDir, "accounts")
+	if err != nil {
+		return nil, nil, err
+	}
+	source := &SpilledAccountSource{
+		dir:    dir,
+		counts: make([]map[int]int, len(userFileNames)),
+	}
+
+	workersNum := 8
+	invalidAccountNums := make([]int, len(userFileNames))
+	errs := make([]error, len(userFileNames))
+	var wg sync.WaitGroup
+	for i := 0; i < workersNum; i++ {
+		wg.Add(1)
+		go func(workerId int) {
+			defer wg.Done()
+			for j := workerId; j < len(userFileNames); j += workersNum {
+				source.counts[j], invalidAccountNums[j], errs[j] = source.spillUserFile(j, userFileNames[j], cexAssetInfo, assetRegistry)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	totalInvalidAccountNum := 0
+	for j := range userFileNames {
+		if errs[j] != nil {
+			source.Close()
+			return nil, nil, errs[j]
+		}
+		totalInvalidAccountNum += invalidAccountNums[j]
+	}
+	if totalInvalidAccountNum > 0 {
+		fmt.Println("the total invalid account number is ", totalInvalidAccountNum)
+		source.Close()
+		return nil, nil, errors.New("invalid account data")
+	}
+	return source, cexAssetInfo, nil
+}
+
+func (s *SpilledAccountSource) fileName(fileIndex int, tier int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%d_%d.gob", fileIndex, tier))
+}
+
+func (s *SpilledAccountSource) spillUserFile(fileIndex int, name string, cexAssetInfo []CexAssetInfo,
+	assetRegistry *AssetRegistry) (map[int]int, int, error) {
+	type spillFile struct {
+		f   *os.File
+		w   *bufio.Writer
+		enc *gob.Encoder
+	}
+	files := make(map[int]*spillFile)
+	counts := make(map[int]int)
+	closeFiles := func() error {
+		var err error
+		for _, sf := range files {
+			if flushErr := sf.w.Flush(); flushErr != nil && err == nil {
+				err = flushErr
+			}
+			if closeErr := sf.f.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}
+	invalidCounts, err := StreamUserDataFromFile(name, cexAssetInfo, assetRegistry, func(tier int, account AccountInfo) error {
+		sf, ok := files[tier]
+		if !ok {
+			f, err := os.Create(s.fileName(fileIndex, tier))
+			if err != nil {
+				return err
+			}
+			w := bufio.NewWriterSize(f, 1<<20)
+			sf = &spillFile{f: f, w: w, enc: gob.NewEncoder(w)}
+			files[tier] = sf
+		}
+		counts[tier]++
+		return sf.enc.Encode(&account)
+	})
+	closeErr := closeFiles()
+	if err != nil {
+		return nil, 0, err
+	}
+	if closeErr != nil {
+		return nil, 0, closeErr
+	}
+	validAccountNum := 0
+	for _, v := range counts {
+		validAccountNum += v
+	}
+	fmt.Println(name, "the valid accounts number is ", validAccountNum)
+	return counts, invalidCounts, nil
+}
+
+func (s *SpilledAccountSource) Counts() map[int]int {
+	counts := make(map[int]int)
+	for _, fileCounts := range s.counts {
+		for k, v := range fileCounts {
+			counts[k] += v
+		}
+	}
+	return counts
+}
+
+func (s *SpilledAccountSource) Iterator(tier int) (AccountIterator, error) {
+	return &spilledAccountIterator{source: s, tier: tier, fileIndex: -1}, nil
+}
+
+func (s *SpilledAccountSource) Close() error {
+	return os.RemoveAll(s.dir)
+}
+
+// SortedTiers returns the tiers of the source in ascending order
+func SortedTiers(source AccountSource) []int {
+	keys := make([]int, 0)
+	for k := range source.Counts() {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+type spilledAccountIterator struct {
+	source    *SpilledAccountSource
+	tier      int
+	fileIndex int
+	// offset is the number of accounts of the user files before fileIndex
+	offset uint32
+	f      *os.File
+	dec    *gob.Decoder
+}
+
+func (it *spilledAccountIterator) Next() (AccountInfo, error) {
+	for {
+		if it.dec != nil {
+			// the fields of gob are not reset, decode into a new value
+			var account AccountInfo
+			err := it.dec.Decode(&account)
+			if err == nil {
+				account.AccountIndex += it.offset
+				return account, nil
+			}
+			if err != io.EOF {
+				return AccountInfo{}, err
+			}
+			it.f.Close()
+			it.f = nil
+			it.dec = nil
+		}
+		if it.fileIndex >= len(it.source.counts) {
+			return AccountInfo{}, io.EOF
+		}
+		if it.fileIndex >= 0 {
+			for _, v := range it.source.counts[it.fileIndex] {
+				it.offset += uint32(v)
+			}
+		}
+		it.fileIndex++
+		if it.fileIndex >= len(it.source.counts) {
+			return AccountInfo{}, io.EOF
+		}
+		if it.source.counts[it.fileIndex][it.tier] == 0 {
+			continue
+		}
+		f, err := os.Open(it.source.fileName(it.fileIndex, it.tier))
+		if err != nil {
+			return AccountInfo{}, err
+		}
+		it.f = f
+		it.dec = gob.NewDecoder(bufio.NewReaderSize(f, 1<<20))
+	}
+}
+
+func (it *spilledAccountIterator) Close() error {
+	if it.f != nil {
+		return it.f.Close()
+	}
+	return nil
+}
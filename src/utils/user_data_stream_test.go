@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseUserDataSetToDisk(t *testing.T) {
+	dir, header := newTestUserDataDir(t)
+	id := 0
+	for f := 0; f < 3; f++ {
+		var b strings.Builder
+		b.WriteString(header)
+		for i := 0; i < 50+f*20; i++ {
+			b.WriteString(fmt.Sprintf("%d,%064x", i, id))
+			for j := 0; j < 4; j++ {
+				equity := 0
+				if (id+j)%3 != 0 {
+					equity = id + j
+				}
+				b.WriteString(fmt.Sprintf(",%d,0,%d,0,0,0", equity, equity))
+			}
+			b.WriteString(",0\n")
+			id++
+		}
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("users%d.csv", f)), []byte(b.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	accounts, _, err := ParseUserDataSet(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spillDir := t.TempDir()
+	source, _, err := ParseUserDataSetToDisk(dir, spillDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(source.Counts(), NewMemoryAccountSource(accounts).Counts()) {
+		t.Fatalf("unexpected counts %v", source.Counts())
+	}
+	for k, expected := range accounts {
+		it, err := source.Iterator(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range expected {
+			account, err := it.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if account.AccountIndex != expected[i].AccountIndex ||
+				!reflect.DeepEqual(account.AccountId, expected[i].AccountId) ||
+				!reflect.DeepEqual(account.Assets, expected[i].Assets) ||
+				account.TotalEquity.Cmp(expected[i].TotalEquity) != 0 ||
+				account.TotalDebt.Cmp(expected[i].TotalDebt) != 0 ||
+				account.TotalCollateral.Cmp(expected[i].TotalCollateral) != 0 {
+				t.Fatalf("unexpected account %d of tier %d", i, k)
+			}
+		}
+		if _, err = it.Next(); err != io.EOF {
+			t.Fatalf("unexpected error %v", err)
+		}
+		it.Close()
+	}
+	if err = source.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(spillDir); len(entries) != 0 {
+		t.Fatal("the spilled accounts should be deleted")
+	}
+}
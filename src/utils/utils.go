@@ -9,10 +9,13 @@ import (
 	"errors"
 	"fmt"
 	"hash"
+	"io"
 	"math/big"
+	"math/bits"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
 
@@ -220,46 +223,85 @@ func ComputeUserAssetsCommitment(hasher *hash.Hash, assets []AccountAsset) []byt
 	return (*hasher).Sum(nil)
 }
 
-func ParseUserDataSet(dirname string) (map[int][]AccountInfo, []CexAssetInfo, error) {
-	const CEX_ASSET_INFO_FILE string = "cex_assets_info.csv"
+// CexAssetsInfoFile is the prices and tier ratios of the assets in the user
+// data directory
+const CexAssetsInfoFile = "cex_assets_info.csv"
+
+// listUserDataFiles returns the user files of the directory in name order
+func listUserDataFiles(dirname string) ([]string, error) {
 	userFiles, err := os.ReadDir(dirname)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	accountInfo := make(map[int][]AccountInfo)
-	var cexAssetInfo []CexAssetInfo
-
-	workersNum := 8
 	userFileNames := make([]string, 0)
-
-	type UserParseRes struct {
-		accounts      map[int][]AccountInfo
-		invalidAccNum int
-	}
-	results := make([]chan UserParseRes, workersNum)
-	for i := 0; i < workersNum; i++ {
-		results[i] = make(chan UserParseRes, 1)
-	}
-
 	for _, userFile := range userFiles {
-		if !strings.Contains(userFile.Name(), ".csv") {
+		if !IsUserDataFile(userFile.Name()) {
 			continue
 		}
-		if userFile.Name() == CEX_ASSET_INFO_FILE {
+		if userFile.Name() == CexAssetsInfoFile {
 			continue
 		}
 
 		userFileNames = append(userFileNames, filepath.Join(dirname, userFile.Name()))
 	}
-	assetIndexes, err := ParseAssetIndexFromUserFile(userFileNames[0])
+	if len(userFileNames) == 0 {
+		return nil, errors.New("there is no user file in " + dirname)
+	}
+	return userFileNames, nil
+}
+
+// parseAssetIndexes returns the symbols in the order of the asset indexes,
+// they follow the columns of the first csv user file, or the rows of
+// cex_assets_info.csv when there is no csv user file
+func parseAssetIndexes(dirname string, userFileNames []string) ([]string, error) {
+	for _, name := range userFileNames {
+		if strings.ToLower(filepath.Ext(name)) == ".csv" {
+			return ParseAssetIndexFromUserFile(name)
+		}
+	}
+	return ParseAssetIndexFromCexAssetFile(filepath.Join(dirname, CexAssetsInfoFile))
+}
+
+// prepareUserDataSet returns the user files of the directory in name order,
+// and the cex assets parsed by the asset registry of the directory.
+func prepareUserDataSet(dirname string) ([]string, []CexAssetInfo, *AssetRegistry, error) {
+	userFileNames, err := listUserDataFiles(dirname)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
+	}
+	assetIndexes, err := parseAssetIndexes(dirname, userFileNames)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	assetRegistry, err := LoadAssetRegistryFromDir(dirname)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
-	cexAssetInfo, err = ParseCexAssetInfoFromFile(filepath.Join(dirname, CEX_ASSET_INFO_FILE), assetIndexes)
+	cexAssetInfo, err := ParseCexAssetInfoFromFile(filepath.Join(dirname, CexAssetsInfoFile), assetIndexes, assetRegistry)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return userFileNames, cexAssetInfo, assetRegistry, nil
+}
+
+func ParseUserDataSet(dirname string) (map[int][]AccountInfo, []CexAssetInfo, error) {
+	userFileNames, cexAssetInfo, assetRegistry, err := prepareUserDataSet(dirname)
 	if err != nil {
 		return nil, nil, err
 	}
+	accountInfo := make(map[int][]AccountInfo)
+
+	workersNum := 8
+
+	type UserParseRes struct {
+		accounts      map[int][]AccountInfo
+		invalidAccNum int
+	}
+	results := make([]chan UserParseRes, workersNum)
+	for i := 0; i < workersNum; i++ {
+		results[i] = make(chan UserParseRes, 1)
+	}
 
 	for i := 0; i < workersNum; i++ {
 		go func(workerId int) {
@@ -267,7 +309,7 @@ func ParseUserDataSet(dirname string) (map[int][]AccountInfo, []CexAssetInfo, er
 				if j >= len(userFileNames) {
 					break
 				}
-				tmpAccountInfo, invalidAccountNum, err := ReadUserDataFromCsvFile(userFileNames[j], cexAssetInfo)
+				tmpAccountInfo, invalidAccountNum, err := ReadUserDataFromFile(userFileNames[j], cexAssetInfo, assetRegistry)
 				if err != nil {
 					panic(err.Error())
 				}
@@ -334,6 +376,13 @@ func SafeAdd(a uint64, b uint64) (c uint64) {
 	return c
 }
 
+func SafeSub(a uint64, b uint64) (c uint64) {
+	if a < b {
+		panic("underflow for balance")
+	}
+	return a - b
+}
+
 func ParseAssetIndexFromUserFile(userFilename string) ([]string, error) {
 	f, err := os.Open(userFilename)
 	if err != nil {
@@ -356,6 +405,25 @@ func ParseAssetIndexFromUserFile(userFilename string) ([]string, error) {
 	return cexAssetsList, nil
 }
 
+// ParseAssetIndexFromCexAssetFile returns the symbols of cex_assets_info.csv
+// in the order of its rows
+func ParseAssetIndexFromCexAssetFile(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	cexAssetsList := make([]string, 0, len(data))
+	for i := 1; i < len(data); i++ {
+		cexAssetsList = append(cexAssetsList, strings.ToLower(data[i][0]))
+	}
+	return cexAssetsList, nil
+}
+
 func PaddingTierRatios(tiersRatio []TierRatio) (res [TierCount]TierRatio) {
 	if len(tiersRatio) > TierCount {
 		panic("the length of tiers ratio is bigger than TierCount")
@@ -444,7 +512,9 @@ func CalculatePrecomputedValue(tiersRatio []TierRatio) {
 	}
 }
 
-func ParseCexAssetInfoFromFile(name string, assetIndexes []string) ([]CexAssetInfo, error) {
+// ParseCexAssetInfoFromFile parses the prices and tier ratios of the assets,
+// the prices are scaled by the price decimals of assetRegistry.
+func ParseCexAssetInfoFromFile(name string, assetIndexes []string, assetRegistry *AssetRegistry) ([]CexAssetInfo, error) {
 	f, err := os.Open(name)
 	if err != nil {
 		return nil, err
@@ -458,38 +528,11 @@ func ParseCexAssetInfoFromFile(name string, assetIndexes []string) ([]CexAssetIn
 	cexAssets2Info := make(map[string]CexAssetInfo)
 	data = data[1:]
 	for i := 0; i < len(data); i++ {
-		if len(data[i]) != 5 {
-			fmt.Println("cex asset data wrong:", data[i])
-			return nil, errors.New("cex asset data wrong")
-		}
-		tmpCexAssetInfo := CexAssetInfo{
-			Symbol: strings.ToLower(data[i][0]),
-		}
-		multiplier := int64(100000000)
-		if AssetTypeForTwoDigits[tmpCexAssetInfo.Symbol] {
-			multiplier = 100000000000000
-		}
-		tmpCexAssetInfo.BasePrice, err = ConvertFloatStrToUint64(data[i][1], multiplier)
-		if err != nil {
-			fmt.Println("asset data wrong:", data[i][0], err.Error())
-			return nil, err
-		}
-		tmpCexAssetInfo.LoanRatios, err = ParseTiersRatioFromStr(data[i][2])
-		if err != nil {
-			fmt.Println("parse loan tiers ratio failed:", data[i][2], err.Error())
-			return nil, err
-		}
-		tmpCexAssetInfo.MarginRatios, err = ParseTiersRatioFromStr(data[i][3])
-		if err != nil {
-			fmt.Println("parse margin tiers ratio failed:", data[i][3], err.Error())
-			return nil, err
-		}
-		tmpCexAssetInfo.PortfolioMarginRatios, err = ParseTiersRatioFromStr(data[i][4])
+		tmpCexAssetInfo, err := parseCexAssetRow(data[i], assetRegistry)
 		if err != nil {
-			fmt.Println("parse portfolio margin tiers ratio failed:", data[i][4], err.Error())
+			fmt.Println("cex asset data wrong:", data[i], err.Error())
 			return nil, err
 		}
-
 		cexAssets2Info[tmpCexAssetInfo.Symbol] = tmpCexAssetInfo
 	}
 
@@ -517,147 +560,211 @@ func ParseCexAssetInfoFromFile(name string, assetIndexes []string) ([]CexAssetIn
 
 }
 
-func ReadUserDataFromCsvFile(name string, cexAssetsInfo []CexAssetInfo) (map[int][]AccountInfo, int, error) {
-	f, err := os.Open(name)
+// parseCexAssetRow parses a row of cex_assets_info.csv:
+// symbol, usdt_price, loan_tiers_ratio, margin_tiers_ratio, portfolio_tiers_ratio
+func parseCexAssetRow(row []string, assetRegistry *AssetRegistry) (CexAssetInfo, error) {
+	var err error
+	if len(row) != 5 {
+		return CexAssetInfo{}, errors.New("cex asset data wrong")
+	}
+	cexAssetInfo := CexAssetInfo{
+		Symbol: strings.ToLower(row[0]),
+	}
+	cexAssetInfo.BasePrice, err = ConvertFloatStrToUint64(row[1], assetRegistry.PriceMultiplier(cexAssetInfo.Symbol))
 	if err != nil {
-		return nil, 0, err
+		return cexAssetInfo, fmt.Errorf("invalid price %s: %s", row[1], err.Error())
 	}
-	defer f.Close()
-	csvReader := csv.NewReader(f)
-	data, err := csvReader.ReadAll()
+	cexAssetInfo.LoanRatios, err = ParseTiersRatioFromStr(row[2])
+	if err != nil {
+		return cexAssetInfo, fmt.Errorf("parse loan tiers ratio failed %s: %s", row[2], err.Error())
+	}
+	cexAssetInfo.MarginRatios, err = ParseTiersRatioFromStr(row[3])
+	if err != nil {
+		return cexAssetInfo, fmt.Errorf("parse margin tiers ratio failed %s: %s", row[3], err.Error())
+	}
+	cexAssetInfo.PortfolioMarginRatios, err = ParseTiersRatioFromStr(row[4])
+	if err != nil {
+		return cexAssetInfo, fmt.Errorf("parse portfolio margin tiers ratio failed %s: %s", row[4], err.Error())
+	}
+	return cexAssetInfo, nil
+}
+
+// ReadUserDataFromFile parses the accounts of the user file, the balances
+// are scaled by the balance decimals of assetRegistry.
+func ReadUserDataFromFile(name string, cexAssetsInfo []CexAssetInfo, assetRegistry *AssetRegistry) (map[int][]AccountInfo, int, error) {
+	accounts := make(map[int][]AccountInfo)
+	invalidCounts, err := StreamUserDataFromFile(name, cexAssetsInfo, assetRegistry, func(tier int, account AccountInfo) error {
+		accounts[tier] = append(accounts[tier], account)
+		return nil
+	})
 	if err != nil {
 		return nil, 0, err
 	}
+	validAccountNum := 0
+	for _, v := range accounts {
+		validAccountNum += len(v)
+	}
+	fmt.Println("The valid accounts number is ", validAccountNum)
+	return accounts, invalidCounts, nil
+}
+
+// StreamUserDataFromFile reads the user file by the reader of its format user
+// by user instead of loading the whole file, emit is called with the assets
+// count tier of every valid account in the order of the file. It returns the
+// number of invalid accounts.
+func StreamUserDataFromFile(name string, cexAssetsInfo []CexAssetInfo, assetRegistry *AssetRegistry,
+	emit func(tier int, account AccountInfo) error) (int, error) {
+	reader, err := NewUserDataReader(name)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	assetIndexes := assetIndexesOf(cexAssetsInfo)
 	accountIndex := 0
-	accounts := make(map[int][]AccountInfo)
-	// rn, id,
-	// equity_assetA, debt_assetA, assetA, assetA_loan, assetA_margin, assetA_portfolio_margin,
-	// equity_assetB, debt_assetB, assetB, assetB_loan, assetB_margin, assetA_portfolio_margin,
-	// ......
-	assetCounts := (len(data[0]) - 3) / 6
-	data = data[1:]
 	invalidCounts := 0
-	for i := 0; i < len(data); i++ {
-		invalidAccountFlag := false
-		var account AccountInfo
-		assets := make([]AccountAsset, 0, 8)
-		account.TotalEquity = new(big.Int).SetInt64(0)
-		account.TotalDebt = new(big.Int).SetInt64(0)
-		account.TotalCollateral = new(big.Int).SetInt64(0)
-		// first element of data[i] is ID. we use accountIndex instead
+	for row := 1; ; row++ {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return invalidCounts, err
+		}
+		account, violation := convertUserRecord(record, cexAssetsInfo, assetIndexes, assetRegistry)
+		if violation != nil {
+			violation.File, violation.Row = name, row
+			fmt.Println(violation.String())
+			invalidCounts += 1
+			continue
+		}
+		// the account index is the order of the valid accounts in the file
 		account.AccountIndex = uint32(accountIndex)
-		accountId, err := hex.DecodeString(data[i][1])
-		if err != nil || len(accountId) != 32 {
-			panic("accountId is invalid: " + data[i][1])
-		}
-		account.AccountId = new(fr.Element).SetBytes(accountId).Marshal()
-		var tmpAsset AccountAsset
-		for j := 0; j < assetCounts; j++ {
-			multiplier := int64(100000000)
-			if AssetTypeForTwoDigits[cexAssetsInfo[j].Symbol] {
-				multiplier = 100
-			}
-			equity, err := ConvertFloatStrToUint64(data[i][j*6+2], multiplier)
-			if err != nil {
-				fmt.Println("the symbol is ", cexAssetsInfo[j].Symbol)
-				fmt.Println("account", data[i][1], "equity data wrong:", err.Error())
-				invalidCounts += 1
-				invalidAccountFlag = true
-				break
+		accountIndex += 1
+		if tier, ok := assetCountsTier(len(account.Assets)); ok {
+			if err := emit(tier, account); err != nil {
+				return invalidCounts, err
 			}
+		}
+	}
+	fmt.Println("The invalid accounts number is ", invalidCounts)
+	return invalidCounts, nil
+}
 
-			debt, err := ConvertFloatStrToUint64(data[i][j*6+3], multiplier)
-			if err != nil {
-				fmt.Println("the debt symbol is ", cexAssetsInfo[j].Symbol)
-				fmt.Println("account", data[i][1], "debt data wrong:", err.Error())
-				invalidCounts += 1
-				invalidAccountFlag = true
-				break
-			}
+// assetIndexesOf maps the symbols of the cex assets to their indexes
+func assetIndexesOf(cexAssetsInfo []CexAssetInfo) map[string]int {
+	assetIndexes := make(map[string]int, len(cexAssetsInfo))
+	for i := range cexAssetsInfo {
+		if cexAssetsInfo[i].Symbol != "reserved" {
+			assetIndexes[cexAssetsInfo[i].Symbol] = i
+		}
+	}
+	return assetIndexes
+}
 
-			loan, err := ConvertFloatStrToUint64(data[i][j*6+5], multiplier)
-			if err != nil {
-				fmt.Println("the loan symbol is ", cexAssetsInfo[j].Symbol)
-				fmt.Println("account", data[i][1], "loan data wrong:", err.Error())
-				invalidCounts += 1
-				invalidAccountFlag = true
-				break
-			}
+// assetCountsTier returns the smallest tier of AssetCountsTiers which can hold
+// the assets
+func assetCountsTier(assetsCount int) (int, bool) {
+	for p := 0; p < len(AssetCountsTiers); p++ {
+		if assetsCount <= AssetCountsTiers[p] {
+			return AssetCountsTiers[p], true
+		}
+	}
+	return 0, false
+}
 
-			margin, err := ConvertFloatStrToUint64(data[i][j*6+6], multiplier)
+// convertUserRecord converts the user record to the account, the assets
+// whose equity and debt are 0 are dropped. It returns the first rule the
+// record violates, whose File and Row are left to the caller.
+func convertUserRecord(record *UserRecord, cexAssetsInfo []CexAssetInfo, assetIndexes map[string]int,
+	assetRegistry *AssetRegistry) (AccountInfo, *UserDataViolation) {
+	var account AccountInfo
+	assets := make([]AccountAsset, 0, 8)
+	account.TotalEquity = new(big.Int).SetInt64(0)
+	account.TotalDebt = new(big.Int).SetInt64(0)
+	account.TotalCollateral = new(big.Int).SetInt64(0)
+	violate := func(rule string, asset string, field string, value string, limit string, detail string) *UserDataViolation {
+		return &UserDataViolation{AccountId: record.Id, Rule: rule, Asset: asset, Field: field, Value: value, Limit: limit, Detail: detail}
+	}
+	accountId, err := hex.DecodeString(record.Id)
+	if err != nil || len(accountId) != 32 {
+		return account, violate(RuleInvalidAccountId, "", "id", record.Id, "", "the account id must be 32 bytes hex")
+	}
+	account.AccountId = new(fr.Element).SetBytes(accountId).Marshal()
+	var tmpAsset AccountAsset
+	for _, assetRecord := range record.Assets {
+		j, ok := assetIndexes[assetRecord.Symbol]
+		if !ok {
+			return account, violate(RuleUnknownAsset, assetRecord.Symbol, "", "", "", "the asset isn't in "+CexAssetsInfoFile)
+		}
+		multiplier := assetRegistry.BalanceMultiplier(cexAssetsInfo[j].Symbol)
+		var balances [5]uint64
+		for k, v := range []string{assetRecord.Equity, assetRecord.Debt, assetRecord.Loan, assetRecord.Margin, assetRecord.PortfolioMargin} {
+			balances[k], err = ConvertFloatStrToUint64(v, multiplier)
 			if err != nil {
-				fmt.Println("the margin symbol is ", cexAssetsInfo[j].Symbol)
-				fmt.Println("account", data[i][1], "margin data wrong:", err.Error())
-				invalidCounts += 1
-				invalidAccountFlag = true
-				break
+				return account, violate(RuleInvalidBalance, cexAssetsInfo[j].Symbol, balanceFields[k], v, "", err.Error())
 			}
-
-			portfolioMargin, err := ConvertFloatStrToUint64(data[i][j*6+7], multiplier)
-			if err != nil {
-				fmt.Println("the portfolio margin symbol is ", cexAssetsInfo[j].Symbol)
-				fmt.Println("account", data[i][1], "portfolio margin data wrong:", err.Error())
-				invalidCounts += 1
-				invalidAccountFlag = true
-				break
+		}
+		equity, debt, loan, margin, portfolioMargin := balances[0], balances[1], balances[2], balances[3], balances[4]
+
+		if equity != 0 || debt != 0 {
+			tmpAsset.Index = uint16(j)
+			tmpAsset.Equity = equity
+			tmpAsset.Debt = debt
+			tmpAsset.Loan = loan
+			tmpAsset.Margin = margin
+			tmpAsset.PortfolioMargin = portfolioMargin
+			assets = append(assets, tmpAsset)
+			assetTotalCollateral, carry := bits.Add64(tmpAsset.Loan, tmpAsset.Margin, 0)
+			assetTotalCollateral, carry = bits.Add64(assetTotalCollateral, tmpAsset.PortfolioMargin, carry)
+			if carry != 0 {
+				return account, violate(RuleInvalidBalance, cexAssetsInfo[j].Symbol, "collateral", "", "", "overflow uint64")
+			}
+			if assetTotalCollateral > tmpAsset.Equity {
+				return account, violate(RuleCollateralExceedsEquity, cexAssetsInfo[j].Symbol, "collateral",
+					assetRegistry.FormatBalance(cexAssetsInfo[j].Symbol, assetTotalCollateral),
+					assetRegistry.FormatBalance(cexAssetsInfo[j].Symbol, tmpAsset.Equity), "")
 			}
 
-			if equity != 0 || debt != 0 {
-				tmpAsset.Index = uint16(j)
-				tmpAsset.Equity = equity
-				tmpAsset.Debt = debt
-				tmpAsset.Loan = loan
-				tmpAsset.Margin = margin
-				tmpAsset.PortfolioMargin = portfolioMargin
-				assets = append(assets, tmpAsset)
-				assetTotalCollateral := SafeAdd(tmpAsset.Loan, tmpAsset.Margin)
-				assetTotalCollateral = SafeAdd(assetTotalCollateral, tmpAsset.PortfolioMargin)
-				if assetTotalCollateral > tmpAsset.Equity {
-					fmt.Println("account", data[i][1], "data wrong: total collateral is bigger than equity", assetTotalCollateral, tmpAsset.Equity)
-					invalidCounts += 1
-					invalidAccountFlag = true
-					break
-				}
-
-				account.TotalEquity = account.TotalEquity.Add(account.TotalEquity,
-					new(big.Int).Mul(new(big.Int).SetUint64(tmpAsset.Equity), new(big.Int).SetUint64(cexAssetsInfo[j].BasePrice)))
-				account.TotalDebt = account.TotalDebt.Add(account.TotalDebt,
-					new(big.Int).Mul(new(big.Int).SetUint64(tmpAsset.Debt), new(big.Int).SetUint64(cexAssetsInfo[j].BasePrice)))
+			account.TotalEquity = account.TotalEquity.Add(account.TotalEquity,
+				new(big.Int).Mul(new(big.Int).SetUint64(tmpAsset.Equity), new(big.Int).SetUint64(cexAssetsInfo[j].BasePrice)))
+			account.TotalDebt = account.TotalDebt.Add(account.TotalDebt,
+				new(big.Int).Mul(new(big.Int).SetUint64(tmpAsset.Debt), new(big.Int).SetUint64(cexAssetsInfo[j].BasePrice)))
 
-				account.TotalCollateral = account.TotalCollateral.Add(account.TotalCollateral,
-					CalculateAssetValueForCollateral(loan, margin, portfolioMargin, &cexAssetsInfo[j]))
-			}
+			account.TotalCollateral = account.TotalCollateral.Add(account.TotalCollateral,
+				CalculateAssetValueForCollateral(loan, margin, portfolioMargin, &cexAssetsInfo[j]))
 		}
+	}
 
-		if !invalidAccountFlag {
-			account.Assets = assets
-			if account.TotalCollateral.Cmp(account.TotalDebt) >= 0 {
-				accountIndex += 1
-				for p := 0; p < len(AssetCountsTiers); p++ {
-					if len(account.Assets) <= AssetCountsTiers[p] {
-						if accounts[AssetCountsTiers[p]] == nil {
-							accounts[AssetCountsTiers[p]] = make([]AccountInfo, 0, len(data))
-						}
-						accounts[AssetCountsTiers[p]] = append(accounts[AssetCountsTiers[p]], account)
-						break
-					}
-				}
-			} else {
-				invalidCounts += 1
-				fmt.Println("account", data[i][1], "data wrong: total debt is bigger than collateral:", account.TotalDebt, account.TotalCollateral)
-			}
-		}
-		if i%100000 == 0 {
-			runtime.GC()
+	// the sparse formats may list the assets in any order
+	if !sort.SliceIsSorted(assets, func(i, j int) bool { return assets[i].Index < assets[j].Index }) {
+		sort.Slice(assets, func(i, j int) bool { return assets[i].Index < assets[j].Index })
+	}
+	for i := 1; i < len(assets); i++ {
+		if assets[i].Index == assets[i-1].Index {
+			return account, violate(RuleDuplicatedAsset, cexAssetsInfo[assets[i].Index].Symbol, "", "", "", "")
 		}
 	}
-	fmt.Println("The invalid accounts number is ", invalidCounts)
-	validAccountNum := 0
-	for _, v := range accounts {
-		validAccountNum += len(v)
+	account.Assets = assets
+	if account.TotalCollateral.Cmp(account.TotalDebt) < 0 {
+		return account, violate(RuleDebtExceedsCollateral, "", "total_debt",
+			formatValue(account.TotalDebt), formatValue(account.TotalCollateral), "")
+	}
+	return account, nil
+}
+
+// RecomputeAccountTotals computes the total equity, debt and collateral of
+// the account by the prices and tier ratios of cexAssetsInfo
+func RecomputeAccountTotals(account *AccountInfo, cexAssetsInfo []CexAssetInfo) {
+	account.TotalEquity = new(big.Int).SetInt64(0)
+	account.TotalDebt = new(big.Int).SetInt64(0)
+	account.TotalCollateral = new(big.Int).SetInt64(0)
+	for _, asset := range account.Assets {
+		price := new(big.Int).SetUint64(cexAssetsInfo[asset.Index].BasePrice)
+		account.TotalEquity.Add(account.TotalEquity, new(big.Int).Mul(new(big.Int).SetUint64(asset.Equity), price))
+		account.TotalDebt.Add(account.TotalDebt, new(big.Int).Mul(new(big.Int).SetUint64(asset.Debt), price))
+		account.TotalCollateral.Add(account.TotalCollateral,
+			CalculateAssetValueForCollateral(asset.Loan, asset.Margin, asset.PortfolioMargin, &cexAssetsInfo[asset.Index]))
 	}
-	fmt.Println("The valid accounts number is ", validAccountNum)
-	return accounts, invalidCounts, nil
 }
 
 func CalculateAssetValueForCollateral(loan uint64, margin uint64, portfolioMargin uint64, cexAssetInfo *CexAssetInfo) *big.Int {
@@ -716,42 +823,79 @@ func ConvertFloatStrToUint64(f string, multiplier int64) (uint64, error) {
 	return num, nil
 }
 
-func DecodeBatchWitness(data string) *BatchCreateUserWitness {
-	var witnessForCircuit BatchCreateUserWitness
+// EncodeBatchWitness serializes the batch witness which is stored in the witness table
+func EncodeBatchWitness(v any) (string, error) {
+	var serializeBuf bytes.Buffer
+	enc := gob.NewEncoder(&serializeBuf)
+	err := enc.Encode(v)
+	if err != nil {
+		return "", err
+	}
+	compressedBuf := s2.Encode(nil, serializeBuf.Bytes())
+	return base64.StdEncoding.EncodeToString(compressedBuf), nil
+}
+
+func decodeWitnessData(data string, v any) bool {
 	b, err := base64.StdEncoding.DecodeString(data)
 	if err != nil {
 		fmt.Println("deserialize batch witness failed: ", err.Error())
-		return nil
+		return false
 	}
 	uncompressedData, err := s2.Decode(nil, b)
 	if err != nil {
 		fmt.Println("uncompress batch witness failed: ", err.Error())
-		return nil
+		return false
 	}
 	unserializeBuf := bytes.NewBuffer(uncompressedData)
 	dec := gob.NewDecoder(unserializeBuf)
-	err = dec.Decode(&witnessForCircuit)
+	err = dec.Decode(v)
 	if err != nil {
 		fmt.Println("unmarshal batch witness failed: ", err.Error())
+		return false
+	}
+	return true
+}
+
+// expandAccountAssets puts the stored non-empty assets to the position of their asset index
+func expandAccountAssets(storeUserAssets []AccountAsset) []AccountAsset {
+	userAssets := make([]AccountAsset, AssetCounts)
+	for p := 0; p < AssetCounts; p++ {
+		userAssets[p] = AccountAsset{
+			Index:           uint16(p),
+			Equity:          0,
+			Debt:            0,
+			Loan:            0,
+			Margin:          0,
+			PortfolioMargin: 0,
+		}
+	}
+	for p := 0; p < len(storeUserAssets); p++ {
+		userAssets[storeUserAssets[p].Index] = storeUserAssets[p]
+	}
+	return userAssets
+}
+
+func DecodeBatchWitness(data string) *BatchCreateUserWitness {
+	var witnessForCircuit BatchCreateUserWitness
+	if !decodeWitnessData(data, &witnessForCircuit) {
 		return nil
 	}
 	for i := 0; i < len(witnessForCircuit.CreateUserOps); i++ {
-		userAssets := make([]AccountAsset, AssetCounts)
-		for p := 0; p < AssetCounts; p++ {
-			userAssets[p] = AccountAsset{
-				Index:           uint16(p),
-				Equity:          0,
-				Debt:            0,
-				Loan:            0,
-				Margin:          0,
-				PortfolioMargin: 0,
-			}
-		}
-		storeUserAssets := witnessForCircuit.CreateUserOps[i].Assets
-		for p := 0; p < len(storeUserAssets); p++ {
-			userAssets[storeUserAssets[p].Index] = storeUserAssets[p]
-		}
-		witnessForCircuit.CreateUserOps[i].Assets = userAssets
+		witnessForCircuit.CreateUserOps[i].Assets = expandAccountAssets(witnessForCircuit.CreateUserOps[i].Assets)
+	}
+	return &witnessForCircuit
+}
+
+// DecodeBatchUpdateWitness decodes the witness generated by the incremental
+// snapshot, the UpdateUserOps is empty for the witness of batch create user.
+func DecodeBatchUpdateWitness(data string) *BatchUpdateUserWitness {
+	var witnessForCircuit BatchUpdateUserWitness
+	if !decodeWitnessData(data, &witnessForCircuit) {
+		return nil
+	}
+	for i := 0; i < len(witnessForCircuit.UpdateUserOps); i++ {
+		witnessForCircuit.UpdateUserOps[i].OldAssets = expandAccountAssets(witnessForCircuit.UpdateUserOps[i].OldAssets)
+		witnessForCircuit.UpdateUserOps[i].Assets = expandAccountAssets(witnessForCircuit.UpdateUserOps[i].Assets)
 	}
 	return &witnessForCircuit
 }
@@ -764,18 +908,106 @@ func AccountInfoToHash(account *AccountInfo, hasher *hash.Hash) []byte {
 	return accountHash
 }
 
+// ComputeUserInclusionCommitment binds the user assets commitment to the
+// account, it is the public input of the user inclusion circuit besides the
+// account tree root.
+func ComputeUserInclusionCommitment(accountIdHash []byte, assetsCommitment []byte) []byte {
+	return poseidon.PoseidonBytes(accountIdHash, assetsCommitment)
+}
+
+// IncrementalCexAssets returns the totals of prevCexAssets ordered by index,
+// an incremental snapshot starts from it. The unchanged accounts are not
+// proved again, so cexAssets must have the prices and tier ratios of the
+// previous snapshot, or an unchanged account could be undercollateralized at
+// the new prices.
+func IncrementalCexAssets(prevCexAssets []CexAssetInfo, cexAssets []CexAssetInfo) ([]CexAssetInfo, error) {
+	if len(prevCexAssets) != len(cexAssets) {
+		return nil, fmt.Errorf("the cex assets number %d is not the one of the previous snapshot %d", len(cexAssets), len(prevCexAssets))
+	}
+	startCexAssets := make([]CexAssetInfo, len(prevCexAssets))
+	for _, asset := range prevCexAssets {
+		if int(asset.Index) >= len(startCexAssets) {
+			return nil, fmt.Errorf("invalid index %d of the previous cex asset %s", asset.Index, asset.Symbol)
+		}
+		startCexAssets[asset.Index] = asset
+	}
+	for _, asset := range cexAssets {
+		if int(asset.Index) >= len(startCexAssets) || startCexAssets[asset.Index].Symbol != asset.Symbol {
+			return nil, fmt.Errorf("the cex asset %s of index %d is not the one of the previous snapshot", asset.Symbol, asset.Index)
+		}
+		prevAsset := &startCexAssets[asset.Index]
+		if prevAsset.BasePrice != asset.BasePrice {
+			return nil, fmt.Errorf("the price of the cex asset %s is not the one of the previous snapshot, generate a full snapshot instead", asset.Symbol)
+		}
+		if !tierRatiosEqual(prevAsset.LoanRatios, asset.LoanRatios) || !tierRatiosEqual(prevAsset.MarginRatios, asset.MarginRatios) ||
+			!tierRatiosEqual(prevAsset.PortfolioMarginRatios, asset.PortfolioMarginRatios) {
+			return nil, fmt.Errorf("the tier ratios of the cex asset %s are not the ones of the previous snapshot, generate a full snapshot instead", asset.Symbol)
+		}
+	}
+	return startCexAssets, nil
+}
+
+// tierRatiosEqual compares the boundaries and ratios, the precomputed values
+// are derived from them.
+func tierRatiosEqual(a [TierCount]TierRatio, b [TierCount]TierRatio) bool {
+	for i := range a {
+		if a[i].Ratio != b[i].Ratio {
+			return false
+		}
+		if (a[i].BoundaryValue == nil) != (b[i].BoundaryValue == nil) {
+			return false
+		}
+		if a[i].BoundaryValue != nil && a[i].BoundaryValue.Cmp(b[i].BoundaryValue) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
 func RecoverAfterCexAssets(witness *BatchCreateUserWitness) []CexAssetInfo {
 	cexAssets := witness.BeforeCexAssets
 	for i := 0; i < len(witness.CreateUserOps); i++ {
 		for j := 0; j < len(witness.CreateUserOps[i].Assets); j++ {
-			asset := &witness.CreateUserOps[i].Assets[j]
-			cexAssets[asset.Index].TotalEquity = SafeAdd(cexAssets[asset.Index].TotalEquity, asset.Equity)
-			cexAssets[asset.Index].TotalDebt = SafeAdd(cexAssets[asset.Index].TotalDebt, asset.Debt)
-			cexAssets[asset.Index].LoanCollateral = SafeAdd(cexAssets[asset.Index].LoanCollateral, asset.Loan)
-			cexAssets[asset.Index].MarginCollateral = SafeAdd(cexAssets[asset.Index].MarginCollateral, asset.Margin)
-			cexAssets[asset.Index].PortfolioMarginCollateral = SafeAdd(cexAssets[asset.Index].PortfolioMarginCollateral, asset.PortfolioMargin)
+			AddAccountAsset(cexAssets, &witness.CreateUserOps[i].Assets[j])
+		}
+	}
+	checkCexAssetsCommitment(cexAssets, witness.AfterCEXAssetsCommitment)
+	return cexAssets
+}
+
+func RecoverAfterCexAssetsFromUpdateWitness(witness *BatchUpdateUserWitness) []CexAssetInfo {
+	cexAssets := witness.BeforeCexAssets
+	for i := 0; i < len(witness.UpdateUserOps); i++ {
+		for j := 0; j < len(witness.UpdateUserOps[i].OldAssets); j++ {
+			SubAccountAsset(cexAssets, &witness.UpdateUserOps[i].OldAssets[j])
+		}
+		for j := 0; j < len(witness.UpdateUserOps[i].Assets); j++ {
+			AddAccountAsset(cexAssets, &witness.UpdateUserOps[i].Assets[j])
 		}
 	}
+	checkCexAssetsCommitment(cexAssets, witness.AfterCEXAssetsCommitment)
+	return cexAssets
+}
+
+// AddAccountAsset adds the asset of an account to the cex assets
+func AddAccountAsset(cexAssets []CexAssetInfo, asset *AccountAsset) {
+	cexAssets[asset.Index].TotalEquity = SafeAdd(cexAssets[asset.Index].TotalEquity, asset.Equity)
+	cexAssets[asset.Index].TotalDebt = SafeAdd(cexAssets[asset.Index].TotalDebt, asset.Debt)
+	cexAssets[asset.Index].LoanCollateral = SafeAdd(cexAssets[asset.Index].LoanCollateral, asset.Loan)
+	cexAssets[asset.Index].MarginCollateral = SafeAdd(cexAssets[asset.Index].MarginCollateral, asset.Margin)
+	cexAssets[asset.Index].PortfolioMarginCollateral = SafeAdd(cexAssets[asset.Index].PortfolioMarginCollateral, asset.PortfolioMargin)
+}
+
+// SubAccountAsset removes the asset of an updated or deleted account from the cex assets
+func SubAccountAsset(cexAssets []CexAssetInfo, asset *AccountAsset) {
+	cexAssets[asset.Index].TotalEquity = SafeSub(cexAssets[asset.Index].TotalEquity, asset.Equity)
+	cexAssets[asset.Index].TotalDebt = SafeSub(cexAssets[asset.Index].TotalDebt, asset.Debt)
+	cexAssets[asset.Index].LoanCollateral = SafeSub(cexAssets[asset.Index].LoanCollateral, asset.Loan)
+	cexAssets[asset.Index].MarginCollateral = SafeSub(cexAssets[asset.Index].MarginCollateral, asset.Margin)
+	cexAssets[asset.Index].PortfolioMarginCollateral = SafeSub(cexAssets[asset.Index].PortfolioMarginCollateral, asset.PortfolioMargin)
+}
+
+func checkCexAssetsCommitment(cexAssets []CexAssetInfo, expectedCommitment []byte) {
 	// sanity check
 	hasher := poseidon.NewPoseidon()
 	for i := 0; i < len(cexAssets); i++ {
@@ -785,10 +1017,9 @@ func RecoverAfterCexAssets(witness *BatchCreateUserWitness) []CexAssetInfo {
 		}
 	}
 	cexCommitment := hasher.Sum(nil)
-	if string(cexCommitment) != string(witness.AfterCEXAssetsCommitment) {
+	if string(cexCommitment) != string(expectedCommitment) {
 		panic("after cex commitment verify failed")
 	}
-	return cexAssets
 }
 
 func ComputeCexAssetsCommitment(cexAssetsInfo []CexAssetInfo) []byte {
@@ -819,29 +1050,35 @@ func PaddingAccounts(accounts []AccountInfo, assetKey int, paddingStartIndex int
 	batchCounts := (len(accounts) + opsPerBatch - 1) / opsPerBatch
 	paddingAccountCounts := batchCounts*opsPerBatch - len(accounts)
 	for i := 0; i < paddingAccountCounts; i++ {
-		assets := make([]AccountAsset, assetKey)
-		for j := 0; j < assetKey; j++ {
-			assets[j] = AccountAsset{
-				Index:           uint16(j),
-				Equity:          0,
-				Debt:            0,
-				Loan:            0,
-				Margin:          0,
-				PortfolioMargin: 0,
-			}
-		}
-		accounts = append(accounts, AccountInfo{
-			AccountIndex:    uint32(paddingStartIndex),
-			TotalEquity:     new(big.Int).SetInt64(0),
-			TotalDebt:       new(big.Int).SetInt64(0),
-			TotalCollateral: new(big.Int).SetInt64(0),
-			Assets:          assets,
-		})
+		accounts = append(accounts, NewPaddingAccount(assetKey, paddingStartIndex))
 		paddingStartIndex += 1
 	}
 	return paddingStartIndex, accounts
 }
 
+// NewPaddingAccount returns the empty account which fills the last batch of
+// the assets count tier
+func NewPaddingAccount(assetKey int, accountIndex int) AccountInfo {
+	assets := make([]AccountAsset, assetKey)
+	for j := 0; j < assetKey; j++ {
+		assets[j] = AccountAsset{
+			Index:           uint16(j),
+			Equity:          0,
+			Debt:            0,
+			Loan:            0,
+			Margin:          0,
+			PortfolioMargin: 0,
+		}
+	}
+	return AccountInfo{
+		AccountIndex:    uint32(accountIndex),
+		TotalEquity:     new(big.Int).SetInt64(0),
+		TotalDebt:       new(big.Int).SetInt64(0),
+		TotalCollateral: new(big.Int).SetInt64(0),
+		Assets:          assets,
+	}
+}
+
 func ConvertMysqlErrToDbErr(err error) error {
 	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
 		if mysqlErr.Number == 1317 {
@@ -126,8 +126,11 @@ func TestParseUserDataSet(t *testing.T) {
 		t.Errorf("error: %d\n", totalNum)
 	}
 
-	_ = cexAssetsInfo
-	accounts0, invalidAccountNum, _ := ReadUserDataFromCsvFile("../sampledata/sample_users0.csv", cexAssetsInfo)
+	assetRegistry, err := LoadAssetRegistryFromDir("../sampledata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts0, invalidAccountNum, _ := ReadUserDataFromFile("../sampledata/sample_users0.csv", cexAssetsInfo, assetRegistry)
 	totalNum = 0
 	for _, v := range accounts0 {
 		totalNum += len(v)
@@ -138,7 +141,7 @@ func TestParseUserDataSet(t *testing.T) {
 	if totalNum != 90 {
 		t.Errorf("error: %d\n", totalNum)
 	}
-	accounts1, invalidAccountNum, _ := ReadUserDataFromCsvFile("../sampledata/sample_users1.csv", cexAssetsInfo)
+	accounts1, invalidAccountNum, _ := ReadUserDataFromFile("../sampledata/sample_users1.csv", cexAssetsInfo, assetRegistry)
 	totalNum = 0
 	for _, v := range accounts1 {
 		totalNum += len(v)
@@ -169,7 +172,11 @@ func TestParseCexAssetInfoFromFile(t *testing.T) {
 		assetIndexes[i] = d[0]
 	}
 	fmt.Println("assetIndexes: ", len(assetIndexes))
-	cexAssetsInfo, err := ParseCexAssetInfoFromFile("./cex_assets_info.csv", assetIndexes)
+	assetRegistry, err := LoadAssetRegistryFromDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cexAssetsInfo, err := ParseCexAssetInfoFromFile("./cex_assets_info.csv", assetIndexes, assetRegistry)
 	if err != nil {
 		t.Errorf("error: %s\n", err.Error())
 	}
@@ -0,0 +1,443 @@
+package utils
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+// The rules checked for the user files and cex_assets_info.csv
+const (
+	RuleInvalidUserFile         = "invalid_user_file"
+	RuleInvalidAccountId        = "invalid_account_id"
+	RuleUnknownAsset            = "unknown_asset"
+	RuleDuplicatedAsset         = "duplicated_asset"
+	RuleInvalidBalance          = "invalid_balance"
+	RuleCollateralExceedsEquity = "collateral_exceeds_equity"
+	RuleDebtExceedsCollateral   = "debt_exceeds_collateral"
+	// RuleAssetTotalOverflow is a balance whose sum over the valid users
+	// doesn't fit in the uint64 totals of the cex assets
+	RuleAssetTotalOverflow = "asset_total_overflow"
+
+	RuleInvalidCexAsset    = "invalid_cex_asset"
+	RuleDuplicatedCexAsset = "duplicated_cex_asset"
+	// RuleMissingCexAsset is an asset of the user files which isn't in
+	// cex_assets_info.csv, RuleExtraCexAsset is the opposite
+	RuleMissingCexAsset = "missing_cex_asset"
+	RuleExtraCexAsset   = "extra_cex_asset"
+)
+
+// balanceFields is the order of the balances of UserAssetRecord
+var balanceFields = [5]string{"equity", "debt", "loan", "margin", "portfolio_margin"}
+
+// UserDataViolation is a rule violated by a user or a cex asset. Row is the
+// 1-based position of the user or the asset in the file, the csv header isn't
+// counted. Value is the value which violates the rule and Limit is the bound
+// it's compared with, the balances are in the unit of the asset and the
+// totals are in USDT.
+type UserDataViolation struct {
+	File      string
+	Row       int
+	AccountId string
+	Rule      string
+	Asset     string
+	Field     string
+	Value     string
+	Limit     string
+	Detail    string
+}
+
+func (v *UserDataViolation) String() string {
+	s := fmt.Sprintf("%s row %d", v.File, v.Row)
+	if v.AccountId != "" {
+		s += " account " + v.AccountId
+	}
+	s += " data wrong: " + v.Rule
+	if v.Asset != "" {
+		s += " asset " + v.Asset
+	}
+	if v.Field != "" {
+		s += " " + v.Field + " " + v.Value
+	}
+	if v.Limit != "" {
+		s += " limit " + v.Limit
+	}
+	if v.Detail != "" {
+		s += " (" + v.Detail + ")"
+	}
+	return s
+}
+
+func (v *UserDataViolation) csvRecord() []string {
+	return []string{v.File, strconv.Itoa(v.Row), v.AccountId, v.Rule, v.Asset, v.Field, v.Value, v.Limit, v.Detail}
+}
+
+// AssetSummary is the statistics of an asset over the valid users, the
+// balances are in the unit of the asset
+type AssetSummary struct {
+	Symbol          string
+	Price           string
+	Users           int
+	Equity          string
+	Debt            string
+	Loan            string
+	Margin          string
+	PortfolioMargin string
+	// Violations is the number of the violations of the asset
+	Violations int
+}
+
+// ValidationReport is the result of ValidateUserDataSet. Violations holds at
+// most the first maxViolations violations, the rest are only counted in
+// TruncatedViolations.
+type ValidationReport struct {
+	UserDataFile      string
+	AssetRegistryHash string
+	Files             []string
+	Users             int
+	ValidUsers        int
+	InvalidUsers      int
+	// TierCounts is the number of the valid users of every assets count tier
+	TierCounts          map[int]int
+	Assets              []AssetSummary
+	Violations          []UserDataViolation
+	TruncatedViolations int
+}
+
+func (r *ValidationReport) addViolation(v UserDataViolation, maxViolations int) {
+	if len(r.Violations) < maxViolations {
+		r.Violations = append(r.Violations, v)
+	} else {
+		r.TruncatedViolations++
+	}
+}
+
+// ViolationsCount returns the number of all violations
+func (r *ValidationReport) ViolationsCount() int {
+	return len(r.Violations) + r.TruncatedViolations
+}
+
+// WriteJson writes the whole report to the file
+func (r *ValidationReport) WriteJson(name string) error {
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, content, 0644)
+}
+
+// WriteCsv writes the violations to violationsFile and the asset summaries to
+// assetsFile
+func (r *ValidationReport) WriteCsv(violationsFile string, assetsFile string) error {
+	records := [][]string{{"file", "row", "account_id", "rule", "asset", "field", "value", "limit", "detail"}}
+	for i := range r.Violations {
+		records = append(records, r.Violations[i].csvRecord())
+	}
+	if err := writeCsvFile(violationsFile, records); err != nil {
+		return err
+	}
+	records = [][]string{{"symbol", "price", "users", "equity", "debt", "loan", "margin", "portfolio_margin", "violations"}}
+	for _, a := range r.Assets {
+		records = append(records, []string{a.Symbol, a.Price, strconv.Itoa(a.Users), a.Equity, a.Debt,
+			a.Loan, a.Margin, a.PortfolioMargin, strconv.Itoa(a.Violations)})
+	}
+	return writeCsvFile(assetsFile, records)
+}
+
+// Write writes the report to the file, the violations are written as csv and
+// the asset summaries to <name>_assets.csv when the file ends with .csv
+func (r *ValidationReport) Write(name string) error {
+	ext := filepath.Ext(name)
+	if strings.ToLower(ext) == ".csv" {
+		return r.WriteCsv(name, strings.TrimSuffix(name, ext)+"_assets.csv")
+	}
+	return r.WriteJson(name)
+}
+
+// PrintSummary prints the users of every tier and the violations of every rule
+func (r *ValidationReport) PrintSummary() {
+	fmt.Println("user files:", len(r.Files))
+	fmt.Println("users:", r.Users, "valid:", r.ValidUsers, "invalid:", r.InvalidUsers)
+	tiers := make([]int, 0, len(r.TierCounts))
+	for k := range r.TierCounts {
+		tiers = append(tiers, k)
+	}
+	sort.Ints(tiers)
+	for _, k := range tiers {
+		fmt.Printf("users of assets count tier %d: %d\n", k, r.TierCounts[k])
+	}
+	rules := make(map[string]int)
+	for _, v := range r.Violations {
+		rules[v.Rule]++
+	}
+	ruleNames := make([]string, 0, len(rules))
+	for rule := range rules {
+		ruleNames = append(ruleNames, rule)
+	}
+	sort.Strings(ruleNames)
+	for _, rule := range ruleNames {
+		fmt.Printf("violations of %s: %d\n", rule, rules[rule])
+	}
+	if r.TruncatedViolations > 0 {
+		fmt.Println("violations not written to the report:", r.TruncatedViolations)
+	}
+}
+
+func writeCsvFile(name string, records [][]string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	if err = w.WriteAll(records); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// formatValue converts the asset value, which is the balance multiplied by
+// the price, back to the decimal string in USDT
+func formatValue(v *big.Int) string {
+	return decimal.NewFromBigInt(v, -ValueDecimals).String()
+}
+
+// assetTotals is the sums of the balances of an asset over the valid users
+type assetTotals struct {
+	users      int
+	balances   [5]*big.Int
+	violations int
+}
+
+type userFileValidation struct {
+	users      int
+	validUsers int
+	tierCounts map[int]int
+	assets     []assetTotals
+	violations []UserDataViolation
+	truncated  int
+}
+
+// ValidateUserDataSet checks the user files and cex_assets_info.csv of dirname
+// without stopping at the first invalid user. The user files are only read
+// when cex_assets_info.csv is valid.
+func ValidateUserDataSet(dirname string, maxViolations int) (*ValidationReport, error) {
+	userFileNames, err := listUserDataFiles(dirname)
+	if err != nil {
+		return nil, err
+	}
+	assetIndexes, err := parseAssetIndexes(dirname, userFileNames)
+	if err != nil {
+		return nil, err
+	}
+	assetRegistry, err := LoadAssetRegistryFromDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	report := &ValidationReport{
+		UserDataFile:      dirname,
+		AssetRegistryHash: assetRegistry.Hash,
+		Files:             userFileNames,
+		TierCounts:        make(map[int]int),
+		Violations:        make([]UserDataViolation, 0),
+	}
+
+	cexAssetsFile := filepath.Join(dirname, CexAssetsInfoFile)
+	for _, v := range validateCexAssetFile(cexAssetsFile, assetIndexes, assetRegistry) {
+		report.addViolation(v, maxViolations)
+	}
+	if report.ViolationsCount() > 0 {
+		return report, nil
+	}
+	cexAssetsInfo, err := ParseCexAssetInfoFromFile(cexAssetsFile, assetIndexes, assetRegistry)
+	if err != nil {
+		return nil, err
+	}
+
+	workersNum := 8
+	results := make([]*userFileValidation, len(userFileNames))
+	var wg sync.WaitGroup
+	for i := 0; i < workersNum; i++ {
+		wg.Add(1)
+		go func(workerId int) {
+			defer wg.Done()
+			for j := workerId; j < len(userFileNames); j += workersNum {
+				results[j] = validateUserFile(userFileNames[j], cexAssetsInfo, len(assetIndexes), assetRegistry, maxViolations)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	totals := make([]assetTotals, len(assetIndexes))
+	for i := range totals {
+		totals[i] = newAssetTotals()
+	}
+	for _, res := range results {
+		report.Users += res.users
+		report.ValidUsers += res.validUsers
+		for k, v := range res.tierCounts {
+			report.TierCounts[k] += v
+		}
+		for i := range res.assets {
+			totals[i].users += res.assets[i].users
+			totals[i].violations += res.assets[i].violations
+			for k := range totals[i].balances {
+				totals[i].balances[k].Add(totals[i].balances[k], res.assets[i].balances[k])
+			}
+		}
+		for _, v := range res.violations {
+			report.addViolation(v, maxViolations)
+		}
+		report.TruncatedViolations += res.truncated
+	}
+	report.InvalidUsers = report.Users - report.ValidUsers
+	for i := range totals {
+		symbol := cexAssetsInfo[i].Symbol
+		formatBalance := func(v *big.Int) string {
+			return decimal.NewFromBigInt(v, -int32(assetRegistry.Decimals(symbol).BalanceDecimals)).String()
+		}
+		for k, v := range totals[i].balances {
+			if !v.IsUint64() {
+				report.addViolation(UserDataViolation{File: dirname, Rule: RuleAssetTotalOverflow, Asset: symbol,
+					Field: balanceFields[k], Value: formatBalance(v), Detail: "overflow uint64"}, maxViolations)
+			}
+		}
+		report.Assets = append(report.Assets, AssetSummary{
+			Symbol:          symbol,
+			Price:           assetRegistry.FormatPrice(symbol, cexAssetsInfo[i].BasePrice),
+			Users:           totals[i].users,
+			Equity:          formatBalance(totals[i].balances[0]),
+			Debt:            formatBalance(totals[i].balances[1]),
+			Loan:            formatBalance(totals[i].balances[2]),
+			Margin:          formatBalance(totals[i].balances[3]),
+			PortfolioMargin: formatBalance(totals[i].balances[4]),
+			Violations:      totals[i].violations,
+		})
+	}
+	return report, nil
+}
+
+func newAssetTotals() assetTotals {
+	t := assetTotals{}
+	for k := range t.balances {
+		t.balances[k] = new(big.Int)
+	}
+	return t
+}
+
+// validateCexAssetFile checks every row of cex_assets_info.csv, and that its
+// assets are the ones of the user files
+func validateCexAssetFile(name string, assetIndexes []string, assetRegistry *AssetRegistry) []UserDataViolation {
+	violations := make([]UserDataViolation, 0)
+	f, err := os.Open(name)
+	if err != nil {
+		return append(violations, UserDataViolation{File: name, Rule: RuleInvalidCexAsset, Detail: err.Error()})
+	}
+	defer f.Close()
+	data, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return append(violations, UserDataViolation{File: name, Rule: RuleInvalidCexAsset, Detail: err.Error()})
+	}
+	rows := make(map[string]int)
+	symbols := make([]string, 0, len(data))
+	for i := 1; i < len(data); i++ {
+		cexAssetInfo, err := parseCexAssetRow(data[i], assetRegistry)
+		if err != nil {
+			violations = append(violations, UserDataViolation{File: name, Row: i, Rule: RuleInvalidCexAsset,
+				Asset: cexAssetInfo.Symbol, Detail: err.Error()})
+			continue
+		}
+		if _, ok := rows[cexAssetInfo.Symbol]; ok {
+			violations = append(violations, UserDataViolation{File: name, Row: i, Rule: RuleDuplicatedCexAsset,
+				Asset: cexAssetInfo.Symbol})
+			continue
+		}
+		rows[cexAssetInfo.Symbol] = i
+		symbols = append(symbols, cexAssetInfo.Symbol)
+	}
+	userAssets := make(map[string]bool, len(assetIndexes))
+	for _, symbol := range assetIndexes {
+		userAssets[symbol] = true
+		if _, ok := rows[symbol]; !ok {
+			violations = append(violations, UserDataViolation{File: name, Rule: RuleMissingCexAsset, Asset: symbol})
+		}
+	}
+	for _, symbol := range symbols {
+		if !userAssets[symbol] {
+			violations = append(violations, UserDataViolation{File: name, Row: rows[symbol], Rule: RuleExtraCexAsset, Asset: symbol})
+		}
+	}
+	if len(assetIndexes) > AssetCounts {
+		violations = append(violations, UserDataViolation{File: name, Rule: RuleInvalidCexAsset,
+			Value: strconv.Itoa(len(assetIndexes)), Limit: strconv.Itoa(AssetCounts), Detail: "too many assets"})
+	}
+	return violations
+}
+
+func validateUserFile(name string, cexAssetsInfo []CexAssetInfo, assetCounts int, assetRegistry *AssetRegistry,
+	maxViolations int) *userFileValidation {
+	res := &userFileValidation{
+		tierCounts: make(map[int]int),
+		assets:     make([]assetTotals, assetCounts),
+	}
+	for i := range res.assets {
+		res.assets[i] = newAssetTotals()
+	}
+	addViolation := func(v *UserDataViolation) {
+		if len(res.violations) < maxViolations {
+			res.violations = append(res.violations, *v)
+		} else {
+			res.truncated++
+		}
+	}
+	reader, err := NewUserDataReader(name)
+	if err != nil {
+		addViolation(&UserDataViolation{File: name, Rule: RuleInvalidUserFile, Detail: err.Error()})
+		return res
+	}
+	defer reader.Close()
+	assetIndexes := assetIndexesOf(cexAssetsInfo)
+	for row := 1; ; row++ {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// the rest of the file can't be read
+			addViolation(&UserDataViolation{File: name, Row: row, Rule: RuleInvalidUserFile, Detail: err.Error()})
+			break
+		}
+		res.users++
+		account, violation := convertUserRecord(record, cexAssetsInfo, assetIndexes, assetRegistry)
+		if violation != nil {
+			violation.File, violation.Row = name, row
+			if j, ok := assetIndexes[strings.ToLower(violation.Asset)]; ok {
+				res.assets[j].violations++
+			}
+			addViolation(violation)
+			continue
+		}
+		res.validUsers++
+		if tier, ok := assetCountsTier(len(account.Assets)); ok {
+			res.tierCounts[tier]++
+		}
+		for _, asset := range account.Assets {
+			t := &res.assets[asset.Index]
+			t.users++
+			for k, v := range []uint64{asset.Equity, asset.Debt, asset.Loan, asset.Margin, asset.PortfolioMargin} {
+				t.balances[k].Add(t.balances[k], new(big.Int).SetUint64(v))
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateUserDataSet(t *testing.T) {
+	dir, header := newTestUserDataDir(t)
+	valid := ",2,0,2,1,0,0" + strings.Repeat(",0", 18)
+	rows := []string{
+		"0,%064x" + valid,
+		"1,xyz" + valid,
+		"2,%064x,2,0,2,1,1,1" + strings.Repeat(",0", 18),
+		"3,%064x,1,0,1,0,0,0,0,100,0,0,0,0" + strings.Repeat(",0", 12),
+		"4,%064x,abc,0,0,0,0,0" + strings.Repeat(",0", 18),
+		"5,%064x,1e30,0,0,0,0,0" + strings.Repeat(",0", 18),
+		"6,%064x" + valid,
+	}
+	var b strings.Builder
+	b.WriteString(header)
+	for i, row := range rows {
+		if strings.Contains(row, "%") {
+			row = fmt.Sprintf(row, i)
+		}
+		b.WriteString(row + ",0\n")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "users0.csv"), []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	jsonl := fmt.Sprintf(`{"id": "%064x", "assets": [{"symbol": "doge", "equity": "1"}]}
+{"id": "%064x", "assets": [{"symbol": "eth", "equity": "1"}, {"symbol": "ETH", "equity": "2"}]}
+{"id": "%064x", "assets": [{"symbol": "eth", "equity": "1", "loan": "1"}]}
+{"id": "%064x", "assets": [
+`, 7, 8, 9, 10)
+	if err := os.WriteFile(filepath.Join(dir, "users1.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := ValidateUserDataSet(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Users != 10 || report.ValidUsers != 3 || report.InvalidUsers != 7 || report.TierCounts[AssetCountsTiers[0]] != 3 {
+		t.Fatalf("unexpected users %d %d %d %v", report.Users, report.ValidUsers, report.InvalidUsers, report.TierCounts)
+	}
+	expected := []struct {
+		file  string
+		row   int
+		rule  string
+		asset string
+		value string
+		limit string
+	}{
+		{"users0.csv", 2, RuleInvalidAccountId, "", "xyz", ""},
+		{"users0.csv", 3, RuleCollateralExceedsEquity, "btc", "3", "2"},
+		{"users0.csv", 4, RuleDebtExceedsCollateral, "", "362056", "0"},
+		{"users0.csv", 5, RuleInvalidBalance, "btc", "abc", ""},
+		{"users0.csv", 6, RuleInvalidBalance, "btc", "1e30", ""},
+		{"users1.jsonl", 1, RuleUnknownAsset, "doge", "", ""},
+		{"users1.jsonl", 2, RuleDuplicatedAsset, "eth", "", ""},
+		{"users1.jsonl", 4, RuleInvalidUserFile, "", "", ""},
+	}
+	if len(report.Violations) != len(expected) {
+		t.Fatalf("unexpected violations %v", report.Violations)
+	}
+	for i, e := range expected {
+		v := report.Violations[i]
+		if filepath.Base(v.File) != e.file || v.Row != e.row || v.Rule != e.rule || v.Asset != e.asset ||
+			v.Value != e.value || v.Limit != e.limit {
+			t.Fatalf("unexpected violation %d: %s", i, v.String())
+		}
+	}
+	btc := report.Assets[0]
+	if btc.Symbol != "btc" || btc.Users != 2 || btc.Equity != "4" || btc.Loan != "2" || btc.Violations != 3 {
+		t.Fatalf("unexpected btc summary %v", btc)
+	}
+	if eth := report.Assets[1]; eth.Users != 1 || eth.Equity != "1" || eth.Violations != 1 {
+		t.Fatalf("unexpected eth summary %v", eth)
+	}
+
+	// the user files are not read when cex_assets_info.csv is invalid
+	cexAssets, err := os.ReadFile(filepath.Join(dir, "cex_assets_info.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(cexAssets)), "\n")
+	invalidCexAssets := strings.Join(append(lines[:2], strings.Replace(lines[2], "eth,", "btc,", 1), lines[3]), "\n")
+	if err = os.WriteFile(filepath.Join(dir, "cex_assets_info.csv"), []byte(invalidCexAssets), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err = ValidateUserDataSet(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Users != 0 || len(report.Violations) != 3 || report.Violations[0].Rule != RuleDuplicatedCexAsset ||
+		report.Violations[1].Rule != RuleMissingCexAsset || report.Violations[1].Asset != "eth" ||
+		report.Violations[2].Rule != RuleMissingCexAsset || report.Violations[2].Asset != "shib" {
+		t.Fatalf("unexpected violations %v", report.Violations)
+	}
+}
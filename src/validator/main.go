@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/zkpor/zkpor"
+)
+
+// validator runs zkpor validate
+func main() {
+	p := &zkpor.Program{Name: "validator", Groups: []string{"validate"}}
+	p.Main(os.Args[1:])
+}
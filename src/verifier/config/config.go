@@ -9,7 +9,38 @@ type Config struct {
 	ProofTable       string
 	ZkKeyName        []string
 	AssetsCountTiers []int
-	CexAssetsInfo    []utils.CexAssetInfo
+	// ProvingSystems is parallel to AssetsCountTiers, every tier defaults
+	// to groth16 when it is empty
+	ProvingSystems []string
+	// RecursiveProof must be set when the batch proofs are generated by
+	// provers with RecursiveProof enabled
+	RecursiveProof bool
+	// SolidityProof must be set when the batch proofs are generated by
+	// provers with SolidityProof enabled
+	SolidityProof bool
+	CexAssetsInfo []utils.CexAssetInfo
+	// AggregatedProofTable and AggregationZkKeyName are used by -aggregated,
+	// which only verifies the root proof generated by the aggregator service
+	AggregatedProofTable string
+	AggregationZkKeyName string
+	// AccountTreeRoot is optional, the final account tree root of the
+	// proofs must be it when it is set
+	AccountTreeRoot string
+	// PrevAccountTreeRoot and PrevCexAssetsInfo are the final account tree
+	// root and cex assets of the previous snapshot, the proofs are verified
+	// as an incremental snapshot when PrevAccountTreeRoot is set
+	PrevAccountTreeRoot string
+	PrevCexAssetsInfo   []utils.CexAssetInfo
+	// AssetRegistry is the asset registry file published with the snapshot,
+	// its hash must be AssetRegistryHash recorded by the witness service.
+	// The hash of the config only guards against an accidental edit of the
+	// file, the bundle takes it from its manifest
+	AssetRegistry     string
+	AssetRegistryHash string
+	// CircuitParams is the circuit params file of the proofs, it is set by
+	// the bundle which carries its params. The params in use are kept when it
+	// is empty
+	CircuitParams string
 }
 
 type UserConfig struct {
@@ -21,4 +52,15 @@ type UserConfig struct {
 	Root            string
 	Assets          []utils.AccountAsset
 	Proof           []string
+	// AssetRegistryHash is the hash of the asset registry which scales the
+	// balances of Assets
+	AssetRegistryHash string
+}
+
+type ZkUserConfig struct {
+	AccountIdHash string
+	Assets        []utils.AccountAsset
+	Root          string
+	ProvingSystem string
+	Proof         string
 }
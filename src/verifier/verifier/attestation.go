@@ -0,0 +1,107 @@
+package verifier
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/attestation"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/bundle"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/verifier/config"
+)
+
+// bundleStatement is the statement of the snapshot of the bundle, the
+// verifier config must be prepared by PrepareConfig.
+func bundleStatement(manifest *bundle.Manifest, verifierConfig *config.Config, snapshotTime time.Time) *attestation.Statement {
+	_, cexAssetsCommitment := ComputeCexAssetsCommitments(verifierConfig.CexAssetsInfo)
+	statement := &attestation.Statement{
+		Version:             attestation.Version,
+		SnapshotTime:        snapshotTime.UTC(),
+		BatchCount:          manifest.BatchCount,
+		AccountTreeRoot:     manifest.AccountTreeRoot,
+		CexAssetsCommitment: hex.EncodeToString(cexAssetsCommitment),
+		PrevAccountTreeRoot: manifest.PrevAccountTreeRoot,
+		AssetRegistryHash:   manifest.AssetRegistryHash,
+		VerifyingKeys:       make([]attestation.VerifyingKey, len(manifest.VerifyingKeys)),
+		CexAssetsInfo:       verifierConfig.CexAssetsInfo,
+	}
+	for i, vkFile := range manifest.VerifyingKeys {
+		statement.VerifyingKeys[i] = attestation.VerifyingKey{
+			AssetsCount:   manifest.AssetsCountTiers[i],
+			ProvingSystem: verifierConfig.ProvingSystems[i],
+			Sha256:        manifest.Files[vkFile],
+		}
+	}
+	return statement
+}
+
+// AttestBundle verifies the batch proofs of the bundle, then signs the
+// statement of the snapshot with the private key of the exchange and writes
+// the attestation to output.
+func AttestBundle(bundleName string, privateKeyFile string, snapshotTime time.Time, output string) bool {
+	privateKey, err := attestation.LoadPrivateKey(privateKeyFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	dir, cleanup := openBundle(bundleName)
+	defer cleanup()
+	manifest, verifierConfig, ok := verifyBundleDir(dir)
+	if !ok {
+		return false
+	}
+	a, err := attestation.Sign(bundleStatement(manifest, verifierConfig, snapshotTime), privateKey)
+	if err != nil {
+		panic(err.Error())
+	}
+	err = attestation.Write(output, a)
+	if err != nil {
+		panic(err.Error())
+	}
+	fmt.Println("write attestation to", output, "signed by public key", a.PublicKey)
+	return true
+}
+
+// VerifyAttestation checks the signature of the attestation with the public
+// key published by the exchange. When bundleName is set, the batch proofs of
+// the bundle are verified first, then the statement must commit to the
+// snapshot of the bundle as well.
+func VerifyAttestation(attestationFile string, publicKeyFile string, bundleName string) bool {
+	publicKey, err := attestation.LoadPublicKey(publicKeyFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	a, err := attestation.Read(attestationFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	statement, err := a.Verify(publicKey)
+	if err != nil {
+		fmt.Println("attestation verify failed:", err.Error())
+		return false
+	}
+	fmt.Printf("attestation signed for snapshot %s, %d batches\n", statement.SnapshotTime.Format(time.RFC3339), statement.BatchCount)
+	fmt.Printf("account tree root %s\n", statement.AccountTreeRoot)
+	fmt.Printf("cex assets commitment %s\n", statement.CexAssetsCommitment)
+	for _, vk := range statement.VerifyingKeys {
+		fmt.Printf("verifying key of tier %d %s: sha256 %s\n", vk.AssetsCount, vk.ProvingSystem, vk.Sha256)
+	}
+	for _, asset := range statement.CexAssetsInfo {
+		fmt.Printf("%s total equity %d, total debt %d\n", asset.Symbol, asset.TotalEquity, asset.TotalDebt)
+	}
+	if bundleName != "" {
+		dir, cleanup := openBundle(bundleName)
+		defer cleanup()
+		manifest, verifierConfig, ok := verifyBundleDir(dir)
+		if !ok {
+			fmt.Println("the batch proofs of the bundle verify failed")
+			return false
+		}
+		if !statement.Matches(bundleStatement(manifest, verifierConfig, statement.SnapshotTime)) {
+			fmt.Println("the attestation doesn't match the bundle")
+			return false
+		}
+		fmt.Println("the attestation matches the bundle")
+	}
+	fmt.Println("attestation verify passed")
+	return true
+}
@@ -0,0 +1,689 @@
+package verifier
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/circuit"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/bundle"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/verifier/config"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
+	"github.com/consensys/gnark/backend"
+	"github.com/consensys/gnark/frontend"
+	"github.com/gocarina/gocsv"
+)
+
+func LoadVerifyingKey(vkFileName string, provingSystem string) (circuit.VerifyingKey, error) {
+	vkFile, err := os.ReadFile(vkFileName)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(vkFile)
+	vk := circuit.NewVerifyingKey(provingSystem)
+	_, err = vk.ReadFrom(buf)
+	if err != nil {
+		return nil, err
+	}
+	return vk, nil
+}
+
+// depth-28 empty account tree root
+const emptyAccountTreeRootHex = "08696bfcb563a2ee4dde9e1dbd34f68d3f4643df6e3709cdb1855c9f886240c7"
+
+// ComputeCexAssetsCommitments returns the cex assets commitment before the
+// first batch and the expected one after the last batch.
+func ComputeCexAssetsCommitments(cexAssetsInfoConfig []utils.CexAssetInfo) (emptyCexAssetListCommitment []byte, expectFinalCexAssetsInfoComm []byte) {
+	// according to asset price info to compute
+	cexAssetsInfo := make([]utils.CexAssetInfo, len(cexAssetsInfoConfig))
+	for i := 0; i < len(cexAssetsInfoConfig); i++ {
+		cexAssetsInfo[cexAssetsInfoConfig[i].Index] = cexAssetsInfoConfig[i]
+		if cexAssetsInfoConfig[i].TotalEquity < cexAssetsInfoConfig[i].TotalDebt {
+			fmt.Printf("%s asset equity %d less then debt %d\n", cexAssetsInfoConfig[i].Symbol, cexAssetsInfoConfig[i].TotalEquity, cexAssetsInfoConfig[i].TotalDebt)
+			panic("invalid cex asset info")
+		}
+	}
+	emptyCexAssetsInfo := make([]utils.CexAssetInfo, len(cexAssetsInfo))
+	copy(emptyCexAssetsInfo, cexAssetsInfo)
+	for i := 0; i < len(emptyCexAssetsInfo); i++ {
+		emptyCexAssetsInfo[i].TotalDebt = 0
+		emptyCexAssetsInfo[i].TotalEquity = 0
+		emptyCexAssetsInfo[i].LoanCollateral = 0
+		emptyCexAssetsInfo[i].MarginCollateral = 0
+		emptyCexAssetsInfo[i].PortfolioMarginCollateral = 0
+	}
+	emptyCexAssetListCommitment = utils.ComputeCexAssetsCommitment(emptyCexAssetsInfo)
+	expectFinalCexAssetsInfoComm = utils.ComputeCexAssetsCommitment(cexAssetsInfo)
+	return emptyCexAssetListCommitment, expectFinalCexAssetsInfoComm
+}
+
+func decodeBase64List(list []string, name string) [][]byte {
+	if len(list) != 2 {
+		panic("invalid " + name)
+	}
+	res := make([][]byte, len(list))
+	for i := 0; i < len(list); i++ {
+		var err error
+		res[i], err = base64.StdEncoding.DecodeString(list[i])
+		if err != nil {
+			fmt.Println("decode " + name + " failed")
+			panic(err.Error())
+		}
+	}
+	return res
+}
+
+// loadAssetRegistry loads the asset registry file and checks its hash against
+// the hash recorded with the snapshot.
+func loadAssetRegistry(name string, expectHash string) *utils.AssetRegistry {
+	assetRegistry, err := utils.LoadAssetRegistry(name)
+	if err != nil {
+		panic(err.Error())
+	}
+	if assetRegistry.Hash != expectHash {
+		panic(fmt.Sprintf("asset registry hash %s doesn't match the recorded hash %s", assetRegistry.Hash, expectHash))
+	}
+	fmt.Println("asset registry hash matches:", assetRegistry.Hash)
+	return assetRegistry
+}
+
+// VerifyAggregatedProof verifies the root proof of the aggregator service,
+// which covers all batch proofs of the snapshot.
+func VerifyAggregatedProof(verifierConfig *config.Config) {
+	f, err := os.Open(verifierConfig.AggregatedProofTable)
+	if err != nil {
+		panic(err.Error())
+	}
+	defer f.Close()
+	type AggregatedProof struct {
+		ZkProof              string   `csv:"proof_info"`
+		CexAssetCommitment   []string `csv:"cex_asset_list_commitments"`
+		AccountTreeRoots     []string `csv:"account_tree_roots"`
+		AggregatedCommitment string   `csv:"aggregated_commitment"`
+		AssetsCount          int      `csv:"assets_count"`
+		Level                int      `csv:"level"`
+	}
+	aggregatedProofs := []*AggregatedProof{}
+	err = gocsv.UnmarshalFile(f, &aggregatedProofs)
+	if err != nil {
+		panic(err.Error())
+	}
+	// the root proof is the only proof of assets count 0 at the highest level
+	var rootProof *AggregatedProof
+	for _, p := range aggregatedProofs {
+		if p.AssetsCount == 0 && (rootProof == nil || p.Level > rootProof.Level) {
+			rootProof = p
+		}
+	}
+	if rootProof == nil {
+		panic("root aggregated proof not found")
+	}
+
+	accountTreeRoots := decodeBase64List(rootProof.AccountTreeRoots, "account tree roots")
+	cexAssetListCommitments := decodeBase64List(rootProof.CexAssetCommitment, "cex asset list commitments")
+	emptyAccountTreeRoot, _ := hex.DecodeString(emptyAccountTreeRootHex)
+	emptyCexAssetListCommitment, expectFinalCexAssetsInfoComm := ComputeCexAssetsCommitments(verifierConfig.CexAssetsInfo)
+	if string(accountTreeRoots[0]) != string(emptyAccountTreeRoot) {
+		panic("the root aggregated proof doesn't start from the empty account tree")
+	}
+	if string(cexAssetListCommitments[0]) != string(emptyCexAssetListCommitment) {
+		panic("the root aggregated proof doesn't start from the empty cex assets")
+	}
+	if string(cexAssetListCommitments[1]) != string(expectFinalCexAssetsInfoComm) {
+		panic("Final Cex Assets Info Not Match")
+	}
+	if verifierConfig.AccountTreeRoot != "" && hex.EncodeToString(accountTreeRoots[1]) != verifierConfig.AccountTreeRoot {
+		panic(fmt.Sprintf("account tree root %x doesn't match the expected root %s", accountTreeRoots[1], verifierConfig.AccountTreeRoot))
+	}
+	expectHash := poseidon.PoseidonBytes(accountTreeRoots[0], accountTreeRoots[1], cexAssetListCommitments[0], cexAssetListCommitments[1])
+	actualHash, err := base64.StdEncoding.DecodeString(rootProof.AggregatedCommitment)
+	if err != nil {
+		panic("decode aggregated commitment failed")
+	}
+	if string(expectHash) != string(actualHash) {
+		fmt.Printf("%x:%x\n", expectHash, actualHash)
+		panic("public input verify failed")
+	}
+
+	proofRaw, err := base64.StdEncoding.DecodeString(rootProof.ZkProof)
+	if err != nil {
+		panic("decode proof failed")
+	}
+	proof := circuit.NewProof(circuit.ProvingSystemGroth16)
+	_, err = proof.ReadFrom(bytes.NewBuffer(proofRaw))
+	if err != nil {
+		panic(err.Error())
+	}
+	err = utils.CheckKeyParams(verifierConfig.AggregationZkKeyName+".vk", utils.KeyCircuitAggregation)
+	if err != nil {
+		panic(err.Error())
+	}
+	checkVerifyingKey(verifierConfig.AggregationZkKeyName, utils.KeyCircuitAggregation, 0, circuit.ProvingSystemGroth16)
+	vk, err := LoadVerifyingKey(verifierConfig.AggregationZkKeyName+".vk", circuit.ProvingSystemGroth16)
+	if err != nil {
+		panic(err.Error())
+	}
+	vWitness, err := frontend.NewWitness(circuit.NewVerifyBatchProofAggregationCircuit(actualHash), ecc.BN254.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		panic(err.Error())
+	}
+	err = circuit.Verify(circuit.ProvingSystemGroth16, proof, vk, vWitness, circuit.RecursiveVerifierOptions())
+	if err != nil {
+		fmt.Println("root aggregated proof verify failed:", err.Error())
+		return
+	}
+	fmt.Printf("account merkle tree root is %x\n", accountTreeRoots[1])
+	fmt.Println("Aggregated proof verify passed!!!")
+}
+
+// VerifyZkUserProof verifies the zero-knowledge user inclusion proof of the
+// zk user config file with the verifying key zkUserVk.
+func VerifyZkUserProof(zkUserConfigFile string, zkUserVk string) {
+	zkUserConfig := &config.ZkUserConfig{}
+	content, err := ioutil.ReadFile(zkUserConfigFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	err = json.Unmarshal(content, zkUserConfig)
+	if err != nil {
+		panic(err.Error())
+	}
+	root, err := hex.DecodeString(zkUserConfig.Root)
+	if err != nil || len(root) != 32 {
+		panic("invalid account tree root")
+	}
+	accountIdHash, err := hex.DecodeString(zkUserConfig.AccountIdHash)
+	if err != nil || len(accountIdHash) != 32 {
+		panic("the AccountIdHash is invalid")
+	}
+	provingSystem, err := circuit.NormalizeProvingSystem(zkUserConfig.ProvingSystem)
+	if err != nil {
+		panic(err.Error())
+	}
+	proofRaw, err := base64.StdEncoding.DecodeString(zkUserConfig.Proof)
+	if err != nil {
+		panic("invalid proof")
+	}
+	proof := circuit.NewProof(provingSystem)
+	_, err = proof.ReadFrom(bytes.NewBuffer(proofRaw))
+	if err != nil {
+		panic("invalid proof")
+	}
+	err = utils.CheckKeyParams(zkUserVk, utils.KeyCircuitUserInclusion)
+	if err != nil {
+		panic(err.Error())
+	}
+	checkVerifyingKey(strings.TrimSuffix(zkUserVk, ".vk"), utils.KeyCircuitUserInclusion, 0, provingSystem)
+	vk, err := LoadVerifyingKey(zkUserVk, provingSystem)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	// the public input is computed from the user's own data only
+	hasher := poseidon.NewPoseidon()
+	assetCommitment := utils.ComputeUserAssetsCommitment(&hasher, zkUserConfig.Assets)
+	userAssetsCommitment := utils.ComputeUserInclusionCommitment(accountIdHash, assetCommitment)
+	vWitness, err := frontend.NewWitness(circuit.NewVerifyUserInclusionCircuit(root, userAssetsCommitment), ecc.BN254.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		panic(err.Error())
+	}
+	err = circuit.Verify(provingSystem, proof, vk, vWitness)
+	if err != nil {
+		fmt.Println("verify failed...", err.Error())
+	} else {
+		fmt.Println("verify pass!!!")
+	}
+}
+
+// VerifyUserProof verifies the merkle proof of the user config file, the asset
+// registry file is checked against the AssetRegistryHash of the user config
+// when it is not empty. The user config is written by the exchange, so its
+// root and AssetRegistryHash are only bound to the snapshot when they are
+// checked against the published bundle bundleName, whose registry file is
+// used when assetRegistryFile is empty.
+func VerifyUserProof(userConfigFile string, assetRegistryFile string, bundleName string) {
+	userConfig := &config.UserConfig{}
+	content, err := ioutil.ReadFile(userConfigFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	err = json.Unmarshal(content, userConfig)
+	if err != nil {
+		panic(err.Error())
+	}
+	root, err := hex.DecodeString(userConfig.Root)
+	if err != nil || len(root) != 32 {
+		panic("invalid account tree root")
+	}
+	if bundleName != "" {
+		dir, cleanup := openBundle(bundleName)
+		defer cleanup()
+		manifest, bundleConfig, err := bundle.Load(dir)
+		if err != nil {
+			panic(err.Error())
+		}
+		bundleRoot, err := hex.DecodeString(manifest.AccountTreeRoot)
+		if err != nil || !bytes.Equal(bundleRoot, root) {
+			panic(fmt.Sprintf("the account tree root %s of the user config doesn't match the bundle root %s", userConfig.Root, manifest.AccountTreeRoot))
+		}
+		if userConfig.AssetRegistryHash != manifest.AssetRegistryHash {
+			panic(fmt.Sprintf("asset registry hash %s of the user config doesn't match the bundle hash %s", userConfig.AssetRegistryHash, manifest.AssetRegistryHash))
+		}
+		fmt.Println("the user config matches the bundle")
+		if assetRegistryFile == "" {
+			assetRegistryFile = bundleConfig.AssetRegistry
+		}
+	}
+	if assetRegistryFile != "" {
+		loadAssetRegistry(assetRegistryFile, userConfig.AssetRegistryHash)
+	}
+
+	var proof [][]byte
+	for i := 0; i < len(userConfig.Proof); i++ {
+		p, err := base64.StdEncoding.DecodeString(userConfig.Proof[i])
+		if err != nil || len(p) != 32 {
+			panic("invalid proof")
+		}
+		proof = append(proof, p)
+	}
+
+	// padding user assets
+	hasher := poseidon.NewPoseidon()
+	assetCommitment := utils.ComputeUserAssetsCommitment(&hasher, userConfig.Assets)
+	hasher.Reset()
+	// compute new account leaf node hash
+	accountIdHash, err := hex.DecodeString(userConfig.AccountIdHash)
+	if err != nil || len(accountIdHash) != 32 {
+		panic("the AccountIdHash is invalid")
+	}
+	accountHash := poseidon.PoseidonBytes(accountIdHash, userConfig.TotalEquity.Bytes(), userConfig.TotalDebt.Bytes(), userConfig.TotalCollateral.Bytes(), assetCommitment)
+	fmt.Println("user merkle leave hash base64 encode: ", base64.StdEncoding.EncodeToString(accountHash))
+	fmt.Printf("user merkle leave hash hex encode: %x\n", accountHash)
+	verifyFlag := utils.VerifyMerkleProof(root, userConfig.AccountIndex, proof, accountHash)
+	if verifyFlag {
+		fmt.Println("verify pass!!!")
+	} else {
+		fmt.Println("verify failed...")
+	}
+}
+
+// Hash prints the poseidon hash of the two base64 encoded merkle nodes.
+func Hash(left string, right string) {
+	hasher := poseidon.NewPoseidon()
+	p0, err := base64.StdEncoding.DecodeString(left)
+	if err != nil {
+		panic("invalid hash command, the first argument is not base64 encoded")
+	}
+	p1, err := base64.StdEncoding.DecodeString(right)
+	if err != nil {
+		panic("invalid hash command, the second argument is not base64 encoded")
+	}
+	hasher.Write(p0)
+	hasher.Write(p1)
+	res := hasher.Sum(nil)
+	resBase64 := base64.StdEncoding.EncodeToString(res)
+	fmt.Printf("hash result base64 encode: %s\n", resBase64)
+	fmt.Printf("hash result hex encode: %x\n", res)
+}
+
+// checkVerifyingKey checks the verifying key of the key name against its
+// entry in the key manifest, the batch keys are checked for the tier. The
+// key without manifest is refused unless utils.SkipKeyManifest is called.
+func checkVerifyingKey(zkKeyName string, keyCircuit string, assetsCountTier int, provingSystem string) {
+	var entry *utils.KeyManifestEntry
+	var err error
+	if keyCircuit == utils.KeyCircuitBatchCreate {
+		entry, err = utils.CheckBatchKeyManifest(zkKeyName, assetsCountTier, provingSystem, ".vk")
+	} else {
+		entry, err = utils.CheckKeyManifest(zkKeyName, keyCircuit, provingSystem, ".vk")
+	}
+	if err == nil {
+		err = entry.CheckFile(zkKeyName, ".vk")
+	}
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+// PrepareConfig normalizes the proving systems of the verifier config and
+// checks the asset registry against AssetRegistryHash when it is set.
+func PrepareConfig(verifierConfig *config.Config) {
+	err := utils.LoadCircuitParams(verifierConfig.CircuitParams)
+	if err != nil {
+		panic(err.Error())
+	}
+	if len(verifierConfig.ZkKeyName) != len(verifierConfig.AssetsCountTiers) {
+		panic("asset tiers and asset tier names should have the same length")
+	}
+	err = utils.CheckAssetsCountTiers(verifierConfig.AssetsCountTiers)
+	if err != nil {
+		panic(err.Error())
+	}
+	if len(verifierConfig.ProvingSystems) == 0 {
+		verifierConfig.ProvingSystems = make([]string, len(verifierConfig.AssetsCountTiers))
+	}
+	if len(verifierConfig.ProvingSystems) != len(verifierConfig.AssetsCountTiers) {
+		panic("asset tiers and proving systems should have the same length")
+	}
+	for i := range verifierConfig.ProvingSystems {
+		verifierConfig.ProvingSystems[i], err = circuit.NormalizeProvingSystem(verifierConfig.ProvingSystems[i])
+		if err != nil {
+			panic(err.Error())
+		}
+		// a swapped or tampered verifying key is refused before any proof
+		// is verified
+		checkVerifyingKey(verifierConfig.ZkKeyName[i], utils.KeyCircuitBatchCreate, verifierConfig.AssetsCountTiers[i], verifierConfig.ProvingSystems[i])
+	}
+	if verifierConfig.AssetRegistry != "" {
+		// the prices are shown in the unit of the cex assets file, so
+		// that they can be compared with the market prices
+		assetRegistry := loadAssetRegistry(verifierConfig.AssetRegistry, verifierConfig.AssetRegistryHash)
+		for _, asset := range verifierConfig.CexAssetsInfo {
+			fmt.Printf("%s price %s\n", asset.Symbol, assetRegistry.FormatPrice(asset.Symbol, asset.BasePrice))
+		}
+	}
+}
+
+// proofRow is a row of the proof table exported by dbtool.
+type proofRow struct {
+	BatchNumber        int64    `csv:"batch_number"`
+	ZkProof            string   `csv:"proof_info"`
+	CexAssetCommitment []string `csv:"cex_asset_list_commitments"`
+	AccountTreeRoots   []string `csv:"account_tree_roots"`
+	BatchCommitment    string   `csv:"batch_commitment"`
+	AssetsCount        int      `csv:"assets_count"`
+	ProvingSystem      string   `csv:"proving_system"`
+}
+
+// readProofTable reads the proof table indexed by the batch number. The
+// table exported before the plonk backend has no proving_system column, its
+// proofs are groth16 proofs.
+func readProofTable(name string) ([]proofRow, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tmpProofs := []*proofRow{}
+	err = gocsv.UnmarshalFile(f, &tmpProofs)
+	if err != nil {
+		return nil, err
+	}
+
+	proofs := make([]proofRow, len(tmpProofs))
+	for _, p := range tmpProofs {
+		if p.BatchNumber < 0 || p.BatchNumber >= int64(len(proofs)) {
+			return nil, fmt.Errorf("batch number %d is out of the range of the proof table", p.BatchNumber)
+		}
+		if p.ProvingSystem == "" {
+			p.ProvingSystem = circuit.ProvingSystemGroth16
+		}
+		proofs[p.BatchNumber] = *p
+	}
+	return proofs, nil
+}
+
+// VerifyBatchProofs verifies all batch proofs of the proof table and checks
+// that they are chained from the empty account tree, or the final state of
+// the previous snapshot, to the cex assets of the config. It returns false
+// when a proof fails to verify.
+func VerifyBatchProofs(verifierConfig *config.Config) bool {
+	var verifierOpts []backend.VerifierOption
+	if verifierConfig.RecursiveProof {
+		verifierOpts = append(verifierOpts, circuit.RecursiveVerifierOptions())
+	}
+
+	proofs, err := readProofTable(verifierConfig.ProofTable)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	prevCexAssetListCommitments := make([][]byte, 2)
+	prevAccountTreeRoots := make([][]byte, 2)
+	emptyAccountTreeRoot, err := hex.DecodeString(emptyAccountTreeRootHex)
+	if err != nil {
+		fmt.Println("wrong empty empty account tree root")
+		return false
+	}
+	prevAccountTreeRoots[1] = emptyAccountTreeRoot
+	emptyCexAssetListCommitment, expectFinalCexAssetsInfoComm := ComputeCexAssetsCommitments(verifierConfig.CexAssetsInfo)
+	prevCexAssetListCommitments[1] = emptyCexAssetListCommitment
+	incremental := verifierConfig.PrevAccountTreeRoot != ""
+	if incremental {
+		// the batches of the incremental snapshot start from the final
+		// state of the previous snapshot
+		prevAccountTreeRoots[1], err = hex.DecodeString(verifierConfig.PrevAccountTreeRoot)
+		if err != nil || len(prevAccountTreeRoots[1]) != 32 {
+			panic("invalid previous account tree root")
+		}
+		// the unchanged accounts are not proved again, so the snapshot is
+		// rejected when the prices or tier ratios are changed
+		startCexAssetsInfo, err := utils.IncrementalCexAssets(verifierConfig.PrevCexAssetsInfo, verifierConfig.CexAssetsInfo)
+		if err != nil {
+			fmt.Println(err.Error())
+			return false
+		}
+		_, prevCexAssetListCommitments[1] = ComputeCexAssetsCommitments(startCexAssetsInfo)
+	}
+	var finalCexAssetsInfoComm []byte
+	var accountTreeRoot []byte
+
+	workersNum := 16
+	if runtime.NumCPU() > workersNum {
+		workersNum = runtime.NumCPU()
+	}
+	averageProofCount := (len(proofs) + workersNum - 1) / workersNum
+
+	type ProofMetaData struct {
+		accountTreeRoots        [][]byte
+		cexAssetListCommitments [][]byte
+	}
+	type SafeProofMap struct {
+		sync.Mutex
+		proofMap map[int]ProofMetaData
+	}
+	safeProofMap := &SafeProofMap{proofMap: make(map[int]ProofMetaData)}
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < workersNum; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			var vk circuit.VerifyingKey
+			currentAssetCountsTier := 0
+			currentProvingSystem := ""
+			startIndex := index * averageProofCount
+			endIndex := (index + 1) * averageProofCount
+			if endIndex > len(proofs) {
+				endIndex = len(proofs)
+			}
+			for j := startIndex; j < endIndex; j++ {
+				batchNumber := int(proofs[j].BatchNumber)
+				provingSystem, err := circuit.NormalizeProvingSystem(proofs[j].ProvingSystem)
+				if err != nil {
+					fmt.Println("invalid proving system:", batchNumber, err.Error())
+					panic("verify proof " + strconv.Itoa(batchNumber) + " failed")
+				}
+				// first deserialize proof
+				proof := circuit.NewProof(provingSystem)
+				var bufRaw bytes.Buffer
+				proofRaw, err := base64.StdEncoding.DecodeString(proofs[j].ZkProof)
+				if err != nil {
+					fmt.Println("decode proof failed:", batchNumber)
+					panic("verify proof " + strconv.Itoa(batchNumber) + " failed")
+				}
+				bufRaw.Write(proofRaw)
+				proof.ReadFrom(&bufRaw)
+				// deserialize cex asset list commitment and account tree root
+				cexAssetListCommitments := make([][]byte, 2)
+				accountTreeRoots := make([][]byte, 2)
+
+				for p := 0; p < len(proofs[j].CexAssetCommitment); p++ {
+					cexAssetListCommitments[p], err = base64.StdEncoding.DecodeString(proofs[j].CexAssetCommitment[p])
+					if err != nil {
+						fmt.Println("decode cex asset commitment failed")
+						panic(err.Error())
+					}
+				}
+				for p := 0; p < len(proofs[j].AccountTreeRoots); p++ {
+					accountTreeRoots[p], err = base64.StdEncoding.DecodeString(proofs[j].AccountTreeRoots[p])
+					if err != nil {
+						fmt.Println("decode account tree root failed")
+						panic(err.Error())
+					}
+				}
+				// verify the public input is correctly computed by cex asset list and account tree root
+				poseidonHasher := poseidon.NewPoseidon()
+				poseidonHasher.Write(accountTreeRoots[0])
+				poseidonHasher.Write(accountTreeRoots[1])
+				poseidonHasher.Write(cexAssetListCommitments[0])
+				poseidonHasher.Write(cexAssetListCommitments[1])
+				expectHash := poseidonHasher.Sum(nil)
+				actualHash, err := base64.StdEncoding.DecodeString(proofs[j].BatchCommitment)
+				if err != nil {
+					fmt.Println("decode batch commitment failed", batchNumber)
+					panic("verify proof " + strconv.Itoa(batchNumber) + " failed")
+				}
+				if string(expectHash) != string(actualHash) {
+					fmt.Println("public input verify failed ", batchNumber)
+					fmt.Printf("%x:%x\n", expectHash, actualHash)
+					panic("verify proof " + strconv.Itoa(batchNumber) + " failed")
+				}
+				safeProofMap.Lock()
+				safeProofMap.proofMap[int(batchNumber)] = ProofMetaData{accountTreeRoots: accountTreeRoots, cexAssetListCommitments: cexAssetListCommitments}
+				safeProofMap.Unlock()
+				var verifyWitness frontend.Circuit
+				if incremental {
+					verifyWitness = circuit.NewVerifyBatchUpdateUserCircuit(actualHash)
+				} else {
+					verifyWitness = circuit.NewVerifyBatchCreateUserCircuit(actualHash)
+				}
+				vWitness, err := frontend.NewWitness(verifyWitness, ecc.BN254.ScalarField(), frontend.PublicOnly())
+				if err != nil {
+					panic(err.Error())
+				}
+				if proofs[j].AssetsCount != currentAssetCountsTier || provingSystem != currentProvingSystem {
+					index := -1
+					for p := 0; p < len(verifierConfig.AssetsCountTiers); p++ {
+						if verifierConfig.AssetsCountTiers[p] == proofs[j].AssetsCount && verifierConfig.ProvingSystems[p] == provingSystem {
+							index = p
+							break
+						}
+					}
+					if index == -1 {
+						panic("invalid asset counts tier or proving system")
+					}
+					err = utils.CheckBatchKeyParams(verifierConfig.ZkKeyName[index]+".vk", proofs[j].AssetsCount)
+					if err != nil {
+						panic(err.Error())
+					}
+					vk, err = LoadVerifyingKey(verifierConfig.ZkKeyName[index]+".vk", provingSystem)
+					if err != nil {
+						panic(err.Error())
+					}
+					currentAssetCountsTier = proofs[j].AssetsCount
+					currentProvingSystem = provingSystem
+				}
+				proofVerifierOpts := verifierOpts
+				if verifierConfig.SolidityProof {
+					proofVerifierOpts = append([]backend.VerifierOption{circuit.SolidityVerifierOptions(provingSystem)}, verifierOpts...)
+				}
+				err = circuit.Verify(provingSystem, proof, vk, vWitness, proofVerifierOpts...)
+				if err != nil {
+					fmt.Println("proof verify failed:", batchNumber, err.Error())
+					failed.Store(true)
+					return
+				} else {
+					fmt.Println("proof verify success", batchNumber)
+				}
+			}
+
+		}(i)
+	}
+
+	wg.Wait()
+	if failed.Load() {
+		return false
+	}
+	for batchNumber := 0; batchNumber < len(proofs); batchNumber++ {
+		proofData, ok := safeProofMap.proofMap[batchNumber]
+		if !ok {
+			panic("proof data not found: " + strconv.Itoa(batchNumber))
+		}
+		if string(proofData.accountTreeRoots[0]) != string(prevAccountTreeRoots[1]) {
+			panic("account tree root not match: " + strconv.Itoa(batchNumber))
+		}
+		if string(proofData.cexAssetListCommitments[0]) != string(prevCexAssetListCommitments[1]) {
+			panic("cex asset list commitment not match: " + strconv.Itoa(batchNumber))
+		}
+		prevAccountTreeRoots = proofData.accountTreeRoots
+		prevCexAssetListCommitments = proofData.cexAssetListCommitments
+		accountTreeRoot = proofData.accountTreeRoots[1]
+		finalCexAssetsInfoComm = proofData.cexAssetListCommitments[1]
+	}
+
+	if string(finalCexAssetsInfoComm) != string(expectFinalCexAssetsInfoComm) {
+		panic("Final Cex Assets Info Not Match")
+	}
+	if verifierConfig.AccountTreeRoot != "" && hex.EncodeToString(accountTreeRoot) != verifierConfig.AccountTreeRoot {
+		panic(fmt.Sprintf("account tree root %x doesn't match the expected root %s", accountTreeRoot, verifierConfig.AccountTreeRoot))
+	}
+	fmt.Printf("account merkle tree root is %x\n", accountTreeRoot)
+	fmt.Println("All proofs verify passed!!!")
+	return true
+}
+
+// openBundle returns the directory of the bundle, an archive is extracted to
+// a temporary directory which is removed by cleanup.
+func openBundle(name string) (dir string, cleanup func()) {
+	info, err := os.Stat(name)
+	if err != nil {
+		panic(err.Error())
+	}
+	if info.IsDir() {
+		return name, func() {}
+	}
+	dir, err = os.MkdirTemp("", "zkpor_bundle")
+	if err != nil {
+		panic(err.Error())
+	}
+	err = bundle.Extract(name, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		panic(err.Error())
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// VerifyBundle verifies the batch proofs of the audit bundle directory or
+// archive exported by dbtool. The files of the bundle are checked against
+// its manifest first.
+func VerifyBundle(name string) bool {
+	dir, cleanup := openBundle(name)
+	defer cleanup()
+	_, _, ok := verifyBundleDir(dir)
+	return ok
+}
+
+func verifyBundleDir(dir string) (*bundle.Manifest, *config.Config, bool) {
+	manifest, verifierConfig, err := bundle.Load(dir)
+	if err != nil {
+		panic(err.Error())
+	}
+	fmt.Printf("bundle version %d created at %s, %d batches, account tree root %s\n",
+		manifest.Version, manifest.CreatedAt.Format(time.RFC3339), manifest.BatchCount, manifest.AccountTreeRoot)
+	PrepareConfig(verifierConfig)
+	return manifest, verifierConfig, VerifyBatchProofs(verifierConfig)
+}
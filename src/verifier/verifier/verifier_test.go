@@ -0,0 +1,196 @@
+package verifier
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/circuit"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/attestation"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/bundle"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/prover/prover"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/verifier/config"
+)
+
+func TestReadProofTable(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "proof.csv")
+	// the proof table exported before the plonk backend
+	content := "batch_number,proof_info,cex_asset_list_commitments,account_tree_roots,batch_commitment,assets_count\n" +
+		"1,proof1,\"[\"\"a\"\",\"\"b\"\"]\",\"[\"\"c\"\",\"\"d\"\"]\",e,500\n" +
+		"0,proof0,\"[\"\"a\"\",\"\"b\"\"]\",\"[\"\"c\"\",\"\"d\"\"]\",e,50\n"
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	proofs, err := readProofTable(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proofs) != 2 || proofs[0].ZkProof != "proof0" || proofs[1].AssetsCount != 500 || len(proofs[1].CexAssetCommitment) != 2 {
+		t.Fatalf("unexpected proofs %+v", proofs)
+	}
+	for _, p := range proofs {
+		if p.ProvingSystem != circuit.ProvingSystemGroth16 {
+			t.Fatalf("unexpected proving system %s", p.ProvingSystem)
+		}
+	}
+
+	content = "batch_number,proof_info,cex_asset_list_commitments,account_tree_roots,batch_commitment,assets_count,proving_system\n" +
+		"0,proof0,\"[\"\"a\"\",\"\"b\"\"]\",\"[\"\"c\"\",\"\"d\"\"]\",e,50,plonk\n"
+	if err = os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	proofs, err = readProofTable(name)
+	if err != nil || proofs[0].ProvingSystem != circuit.ProvingSystemPlonk {
+		t.Fatalf("unexpected proofs %+v %v", proofs, err)
+	}
+}
+
+func TestVerifyUserProofAgainstBundle(t *testing.T) {
+	keyDir := t.TempDir()
+	zkKeyName := filepath.Join(keyDir, "zkpor50_700")
+	if err := os.WriteFile(zkKeyName+".vk", []byte("vk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.AddKeyManifestEntry(zkKeyName, utils.NewBatchKeyParams(50, false), "groth16", 1, []string{".vk"}); err != nil {
+		t.Fatal(err)
+	}
+	root := make([]byte, 32)
+	root[0] = 1
+	archive := filepath.Join(t.TempDir(), "bundle"+bundle.ArchiveSuffix)
+	_, err := bundle.Export(archive, &bundle.Content{
+		Proofs:            []*prover.Proof{{BatchNumber: 0, ProofInfo: "proof", AssetsCount: 50}},
+		AccountTreeRoot:   root,
+		CexAssetsInfo:     []utils.CexAssetInfo{{Symbol: "btc", Index: 0}},
+		AssetRegistryHash: "registry",
+		ZkKeyName:         []string{zkKeyName},
+		AssetsCountTiers:  []int{50},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify := func(userConfig map[string]any) (err any) {
+		name := filepath.Join(t.TempDir(), "user_config.json")
+		content, _ := json.Marshal(userConfig)
+		if err := os.WriteFile(name, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer func() { err = recover() }()
+		VerifyUserProof(name, "", archive)
+		return nil
+	}
+
+	userConfig := map[string]any{
+		"AccountIdHash":     hex.EncodeToString(make([]byte, 32)),
+		"TotalEquity":       0,
+		"TotalDebt":         0,
+		"TotalCollateral":   0,
+		"Root":              hex.EncodeToString(root),
+		"AssetRegistryHash": "registry",
+	}
+	if err := verify(userConfig); err != nil {
+		t.Fatalf("the user config matching the bundle is refused: %v", err)
+	}
+	// the hash supplied with the user config must be the one of the bundle
+	userConfig["AssetRegistryHash"] = "other"
+	if err := verify(userConfig); err == nil {
+		t.Fatal("expect a panic for the asset registry hash which doesn't match the bundle")
+	}
+	userConfig["AssetRegistryHash"] = "registry"
+	root[0] = 2
+	userConfig["Root"] = hex.EncodeToString(root)
+	if err := verify(userConfig); err == nil {
+		t.Fatal("expect a panic for the root which doesn't match the bundle")
+	}
+}
+
+func TestVerifyIncrementalBatchProofsWithNewPrices(t *testing.T) {
+	var ratios [utils.TierCount]utils.TierRatio
+	for i := range ratios {
+		ratios[i] = utils.TierRatio{BoundaryValue: big.NewInt(1000), Ratio: 100, PrecomputedValue: new(big.Int)}
+	}
+	cexAssets := func(price uint64) []utils.CexAssetInfo {
+		return []utils.CexAssetInfo{{Symbol: "btc", Index: 0, BasePrice: price, TotalEquity: 10, LoanRatios: ratios, MarginRatios: ratios, PortfolioMarginRatios: ratios}}
+	}
+	name := filepath.Join(t.TempDir(), "proof.csv")
+	if err := os.WriteFile(name, []byte("batch_number,proof_info,cex_asset_list_commitments,account_tree_roots,batch_commitment,assets_count,proving_system\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the unchanged accounts of the previous snapshot are not proved by the
+	// new price
+	verifierConfig := &config.Config{
+		ProofTable:          name,
+		CexAssetsInfo:       cexAssets(2),
+		PrevAccountTreeRoot: hex.EncodeToString(make([]byte, 32)),
+		PrevCexAssetsInfo:   cexAssets(1),
+	}
+	if VerifyBatchProofs(verifierConfig) {
+		t.Fatal("the incremental snapshot of new prices should be rejected")
+	}
+}
+
+func TestVerifyAttestationVerifiesBundleProofs(t *testing.T) {
+	keyDir := t.TempDir()
+	zkKeyName := filepath.Join(keyDir, "zkpor50_700")
+	if err := os.WriteFile(zkKeyName+".vk", []byte("vk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.AddKeyManifestEntry(zkKeyName, utils.NewBatchKeyParams(50, false), "groth16", 1, []string{".vk"}); err != nil {
+		t.Fatal(err)
+	}
+	var ratios [utils.TierCount]utils.TierRatio
+	for i := range ratios {
+		ratios[i] = utils.TierRatio{BoundaryValue: big.NewInt(1000), Ratio: 100, PrecomputedValue: new(big.Int)}
+	}
+	cexAssets := func(price uint64) []utils.CexAssetInfo {
+		return []utils.CexAssetInfo{{Symbol: "btc", Index: 0, BasePrice: price, LoanRatios: ratios, MarginRatios: ratios, PortfolioMarginRatios: ratios}}
+	}
+	// the batch proofs of the incremental snapshot are refused for the new
+	// price, see TestVerifyIncrementalBatchProofsWithNewPrices
+	dir := filepath.Join(t.TempDir(), "bundle")
+	_, err := bundle.Export(dir, &bundle.Content{
+		AccountTreeRoot:     make([]byte, 32),
+		CexAssetsInfo:       cexAssets(2),
+		PrevAccountTreeRoot: make([]byte, 32),
+		PrevCexAssetsInfo:   cexAssets(1),
+		ZkKeyName:           []string{zkKeyName},
+		AssetsCountTiers:    []int{50},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the statement matches the bundle
+	manifest, verifierConfig, err := bundle.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	PrepareConfig(verifierConfig)
+	privateKeyFile := filepath.Join(keyDir, "attestation.key")
+	publicKeyFile := filepath.Join(keyDir, "attestation.pub")
+	if err = attestation.GenerateKey(privateKeyFile, publicKeyFile); err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := attestation.LoadPrivateKey(privateKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := attestation.Sign(bundleStatement(manifest, verifierConfig, time.Now()), privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attestationFile := filepath.Join(keyDir, "attestation.json")
+	if err = attestation.Write(attestationFile, a); err != nil {
+		t.Fatal(err)
+	}
+
+	if !VerifyAttestation(attestationFile, publicKeyFile, "") {
+		t.Fatal("the attestation signed by the key is refused")
+	}
+	if VerifyAttestation(attestationFile, publicKeyFile, dir) {
+		t.Fatal("the attestation of a bundle whose batch proofs are refused should be refused")
+	}
+}
@@ -1,13 +1,26 @@
 package config
 
 type Config struct {
+	// DbDriver is mysql, postgres or sqlite3, it defaults to mysql. MysqlDataSource
+	// is the data source of the driver
+	DbDriver        string
 	MysqlDataSource string
 	UserDataFile    string
 	DbSuffix        string
-	TreeDB          struct {
+	// PrevDbSuffix is the db suffix of the previous snapshot, the witness of
+	// the incremental snapshot is generated when it is set
+	PrevDbSuffix string
+	// SpillDir is the directory where the parsed accounts are spilled to, so
+	// the memory usage doesn't grow with the number of users. The user data
+	// is kept in memory when it is empty
+	SpillDir string
+	TreeDB   struct {
 		Driver string
 		Option struct {
 			Addr string
 		}
 	}
+	// MetricsAddr is the listen address of the prometheus /metrics endpoint,
+	// such as ":9100". The endpoint is disabled when it is empty
+	MetricsAddr string
 }
@@ -0,0 +1,399 @@
+package witness
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync/atomic"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/model"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	bsmt "github.com/bnb-chain/zkbnb-smt"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon"
+)
+
+const prevAccountsPageSize = 10000
+
+// AccountUpdate is an account leaf changed since the previous snapshot. Old is
+// nil for the inserted account and New is nil for the deleted account, both
+// of them are nil for the padding operation.
+type AccountUpdate struct {
+	AccountIndex uint32
+	Old          *utils.AccountInfo
+	New          *utils.AccountInfo
+}
+
+func assetsCountTier(assetsCount int) int {
+	for _, k := range utils.AssetCountsTiers {
+		if assetsCount <= k {
+			return k
+		}
+	}
+	panic(fmt.Sprintf("assets count %d exceeds the max tier", assetsCount))
+}
+
+func accountAssetsEqual(a []utils.AccountAsset, b []utils.AccountAsset) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadPrevAccounts loads the accounts of the previous snapshot from its
+// userproof table, the key of the returned map is the hex encoded account id.
+func LoadPrevAccounts(userProofModel model.UserProofModel) (map[string]*utils.AccountInfo, error) {
+	prevAccounts := make(map[string]*utils.AccountInfo)
+	startIndex := uint32(0)
+	for {
+		rows, err := userProofModel.GetUserAssetsFromIndex(startIndex, prevAccountsPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			accountId, err := hex.DecodeString(row.AccountId)
+			if err != nil {
+				return nil, err
+			}
+			account := &utils.AccountInfo{
+				AccountIndex:    row.AccountIndex,
+				AccountId:       accountId,
+				TotalEquity:     new(big.Int),
+				TotalDebt:       new(big.Int),
+				TotalCollateral: new(big.Int),
+			}
+			_, ok1 := account.TotalEquity.SetString(row.TotalEquity, 10)
+			_, ok2 := account.TotalDebt.SetString(row.TotalDebt, 10)
+			_, ok3 := account.TotalCollateral.SetString(row.TotalCollateral, 10)
+			if !ok1 || !ok2 || !ok3 {
+				return nil, errors.New("invalid account totals of account " + row.AccountId)
+			}
+			err = json.Unmarshal([]byte(row.Assets), &account.Assets)
+			if err != nil {
+				return nil, err
+			}
+			prevAccounts[row.AccountId] = account
+		}
+		if len(rows) < prevAccountsPageSize {
+			break
+		}
+		startIndex = rows[len(rows)-1].AccountIndex + 1
+	}
+	return prevAccounts, nil
+}
+
+// ComputeAccountUpdates recomputes the account totals by the prices and tier
+// ratios of cexAssets, assigns the account index of every account and returns
+// the changed accounts grouped by assets count tier. The unchanged accounts
+// keep their previous index, the new accounts and the accounts moved to
+// another tier are inserted from insertStartAccountIndex in the order of
+// accounts. The deleted accounts come first in their tier in ascending order
+// of account index. The returned index is the first unused account index which
+// is used by the padding operations. It returns an error if the total debt of
+// an account is bigger than its collateral.
+func ComputeAccountUpdates(accounts map[int][]utils.AccountInfo, prevAccounts map[string]*utils.AccountInfo,
+	cexAssets []utils.CexAssetInfo, insertStartAccountIndex uint32) (map[int][]AccountUpdate, uint32, error) {
+	upserts := make(map[int][]AccountUpdate)
+	deletes := make(map[int][]AccountUpdate)
+	kept := make(map[string]bool)
+	nextAccountIndex := insertStartAccountIndex
+	for _, k := range utils.AssetCountsTiers {
+		for i := range accounts[k] {
+			account := &accounts[k][i]
+			utils.RecomputeAccountTotals(account, cexAssets)
+			if account.TotalCollateral.Cmp(account.TotalDebt) < 0 {
+				return nil, 0, fmt.Errorf("account %x total debt is bigger than collateral", account.AccountId)
+			}
+			accountId := hex.EncodeToString(account.AccountId)
+			prevAccount := prevAccounts[accountId]
+			if prevAccount != nil && assetsCountTier(len(prevAccount.Assets)) == k {
+				kept[accountId] = true
+				account.AccountIndex = prevAccount.AccountIndex
+				if !accountAssetsEqual(prevAccount.Assets, account.Assets) {
+					upserts[k] = append(upserts[k], AccountUpdate{AccountIndex: account.AccountIndex, Old: prevAccount, New: account})
+				}
+				continue
+			}
+			account.AccountIndex = nextAccountIndex
+			nextAccountIndex += 1
+			upserts[k] = append(upserts[k], AccountUpdate{AccountIndex: account.AccountIndex, New: account})
+		}
+	}
+	for accountId, prevAccount := range prevAccounts {
+		if kept[accountId] {
+			continue
+		}
+		k := assetsCountTier(len(prevAccount.Assets))
+		deletes[k] = append(deletes[k], AccountUpdate{AccountIndex: prevAccount.AccountIndex, Old: prevAccount})
+	}
+	updates := make(map[int][]AccountUpdate)
+	for _, k := range utils.AssetCountsTiers {
+		sort.Slice(deletes[k], func(i, j int) bool {
+			return deletes[k][i].AccountIndex < deletes[k][j].AccountIndex
+		})
+		if len(deletes[k])+len(upserts[k]) > 0 {
+			updates[k] = append(deletes[k], upserts[k]...)
+		}
+	}
+	return updates, nextAccountIndex, nil
+}
+
+// FindInsertStartAccountIndex returns the first empty leaf after the accounts
+// and the padding accounts of the previous snapshot.
+func FindInsertStartAccountIndex(accountTree bsmt.SparseMerkleTree, prevAccounts map[string]*utils.AccountInfo) uint32 {
+	index := uint32(0)
+	for _, account := range prevAccounts {
+		if account.AccountIndex >= index {
+			index = account.AccountIndex + 1
+		}
+	}
+	for ; ; index++ {
+		leaf, err := accountTree.Get(uint64(index), nil)
+		if errors.Is(err, bsmt.ErrNodeNotFound) || (err == nil && bytes.Equal(leaf, utils.NilAccountHash)) {
+			return index
+		}
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+}
+
+// RecoverAfterState returns the cex assets and the account tree root after
+// the batch, the batch may be either created by Run or RunIncremental.
+func RecoverAfterState(wit *BatchWitness) ([]utils.CexAssetInfo, []byte) {
+	createWitness := utils.DecodeBatchWitness(wit.WitnessData)
+	if createWitness == nil {
+		panic("decode invalid witness data")
+	}
+	if len(createWitness.CreateUserOps) != 0 {
+		return utils.RecoverAfterCexAssets(createWitness), createWitness.AfterAccountTreeRoot
+	}
+	updateWitness := utils.DecodeBatchUpdateWitness(wit.WitnessData)
+	if updateWitness == nil || len(updateWitness.UpdateUserOps) == 0 {
+		panic("decode invalid witness data")
+	}
+	return utils.RecoverAfterCexAssetsFromUpdateWitness(updateWitness), updateWitness.AfterAccountTreeRoot
+}
+
+// RecoverAssetRegistryHash returns the hash of the asset registry recorded in
+// the batch witness, it is empty for the witness generated before the asset
+// registry is recorded.
+func RecoverAssetRegistryHash(wit *BatchWitness) string {
+	// the batch update user witness has the field of the same name
+	createWitness := utils.DecodeBatchWitness(wit.WitnessData)
+	if createWitness == nil {
+		panic("decode invalid witness data")
+	}
+	return createWitness.AssetRegistryHash
+}
+
+// RunIncremental generates the batch update user witness of the accounts
+// changed since the snapshot whose tables have the suffix prevDbSuffix. The
+// account tree must be the one of the previous snapshot. The unchanged
+// accounts keep the totals of the previous snapshot, so the prices and tier
+// ratios must be the ones of the previous snapshot.
+func (w *Witness) RunIncremental(prevDbSuffix string) {
+	if w.db == nil {
+		panic("the tables of the previous snapshot are in the mysql database")
+	}
+	w.witnessModel.CreateBatchWitnessTable()
+	latestWitness, latestWitnessErr := getLatestBatchWitness(w.witnessModel)
+	if latestWitnessErr != nil && latestWitnessErr != utils.DbErrNotFound {
+		panic(latestWitnessErr.Error())
+	}
+	prevAccounts, err := LoadPrevAccounts(model.NewUserProofModel(w.db, prevDbSuffix))
+	if err != nil {
+		panic(err.Error())
+	}
+	fmt.Println("the accounts number of the previous snapshot is ", len(prevAccounts))
+
+	var height int64
+	var baseVersion bsmt.Version
+	var insertStartAccountIndex uint32
+	if latestWitnessErr == nil {
+		updateWitness := utils.DecodeBatchUpdateWitness(latestWitness.WitnessData)
+		if updateWitness == nil || len(updateWitness.UpdateUserOps) == 0 {
+			panic("decode invalid witness data")
+		}
+		height = latestWitness.Height
+		baseVersion = bsmt.Version(updateWitness.BaseTreeVersion)
+		insertStartAccountIndex = updateWitness.InsertStartAccountIndex
+		w.cexAssets = utils.RecoverAfterCexAssetsFromUpdateWitness(updateWitness)
+		fmt.Println("recover cex assets successfully")
+	} else {
+		prevLatestWitness, err := getLatestBatchWitness(NewWitnessModel(w.db, prevDbSuffix))
+		if err != nil {
+			panic("get the witness of the previous snapshot failed: " + err.Error())
+		}
+		prevCexAssets, prevAccountTreeRoot := RecoverAfterState(prevLatestWitness)
+		if !bytes.Equal(w.accountTree.Root(), prevAccountTreeRoot) {
+			panic("the account tree root is not the one of the previous snapshot")
+		}
+		// the balances are scaled by the registry of the new snapshot, so
+		// it must be the one of the previous snapshot
+		prevAssetRegistryHash := RecoverAssetRegistryHash(prevLatestWitness)
+		if prevAssetRegistryHash != "" && prevAssetRegistryHash != w.assetRegistryHash {
+			panic("the asset registry is not the one of the previous snapshot")
+		}
+		height = -1
+		baseVersion = w.accountTree.LatestVersion()
+		insertStartAccountIndex = FindInsertStartAccountIndex(w.accountTree, prevAccounts)
+		// the totals of the previous snapshot are carried over, the unchanged
+		// accounts are only checked by the prices of the previous snapshot
+		w.cexAssets, err = utils.IncrementalCexAssets(prevCexAssets, w.cexAssets)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+
+	// the accounts are compared with the ones of the previous snapshot in memory
+	ops, err := utils.ReadAllAccounts(w.accounts)
+	if err != nil {
+		panic(err.Error())
+	}
+	updates, paddingAccountIndex, err := ComputeAccountUpdates(ops, prevAccounts, w.cexAssets, insertStartAccountIndex)
+	if err != nil {
+		panic(err.Error())
+	}
+	batchNumber := 0
+	for _, k := range utils.AssetCountsTiers {
+		opsPerBatch := utils.BatchUpdateUserOpsCountsTiers[k]
+		batchNumber += (len(updates[k]) + opsPerBatch - 1) / opsPerBatch
+		fmt.Println("the asset counts of user is ", k, "update ops number is ", len(updates[k]))
+	}
+	if height == int64(batchNumber)-1 {
+		fmt.Println("already generate all accounts witness")
+		return
+	}
+	w.currentBatchNumber = height
+	fmt.Println("latest height is ", height)
+
+	expectedVersion := baseVersion + bsmt.Version(height+1)
+	if w.accountTree.LatestVersion() > expectedVersion {
+		err = w.accountTree.Rollback(expectedVersion)
+		if err != nil {
+			fmt.Println("rollback failed ", expectedVersion, err.Error())
+			panic("rollback failed")
+		} else {
+			fmt.Printf("rollback to %x\n", w.accountTree.Root())
+		}
+	} else if w.accountTree.LatestVersion() < expectedVersion {
+		panic("account tree version is less than current height")
+	} else {
+		fmt.Println("normal starting...")
+	}
+
+	go w.WriteBatchWitnessToDB()
+	currentBatchNum := 0
+	recoveredBatchNum := int(height)
+	for _, k := range utils.AssetCountsTiers {
+		opsPerBatch := utils.BatchUpdateUserOpsCountsTiers[k]
+		tierUpdates := updates[k]
+		for start := 0; start < len(tierUpdates); start += opsPerBatch {
+			if currentBatchNum <= recoveredBatchNum {
+				currentBatchNum++
+				continue
+			}
+			batchUpdateUserWit := &utils.BatchUpdateUserWitness{
+				BeforeAccountTreeRoot:     w.accountTree.Root(),
+				BeforeCEXAssetsCommitment: utils.ComputeCexAssetsCommitment(w.cexAssets),
+				AssetsCount:               k,
+				BaseTreeVersion:           uint64(baseVersion),
+				InsertStartAccountIndex:   insertStartAccountIndex,
+				AssetRegistryHash:         w.assetRegistryHash,
+				BeforeCexAssets:           make([]utils.CexAssetInfo, utils.AssetCounts),
+				UpdateUserOps:             make([]utils.UpdateUserOperation, opsPerBatch),
+			}
+			copy(batchUpdateUserWit.BeforeCexAssets[:], w.cexAssets[:])
+			for j := 0; j < opsPerBatch; j++ {
+				update := AccountUpdate{AccountIndex: paddingAccountIndex}
+				if start+j < len(tierUpdates) {
+					update = tierUpdates[start+j]
+				}
+				w.ExecuteBatchUpdateUser(&update, &batchUpdateUserWit.UpdateUserOps[j])
+			}
+			batchUpdateUserWit.AfterCEXAssetsCommitment = utils.ComputeCexAssetsCommitment(w.cexAssets)
+			batchUpdateUserWit.AfterAccountTreeRoot = w.accountTree.Root()
+			batchUpdateUserWit.BatchCommitment = poseidon.PoseidonBytes(batchUpdateUserWit.BeforeAccountTreeRoot,
+				batchUpdateUserWit.AfterAccountTreeRoot,
+				batchUpdateUserWit.BeforeCEXAssetsCommitment,
+				batchUpdateUserWit.AfterCEXAssetsCommitment)
+			witnessData, err := utils.EncodeBatchWitness(batchUpdateUserWit)
+			if err != nil {
+				panic(err.Error())
+			}
+			witness := BatchWitness{
+				Height:      int64(currentBatchNum),
+				WitnessData: witnessData,
+				Status:      StatusPublished,
+				AssetsCount: int64(k),
+			}
+			accPrunedVersion := baseVersion + bsmt.Version(atomic.LoadInt64(&w.currentBatchNumber)+1)
+			ver, err := w.accountTree.Commit(&accPrunedVersion)
+			if err != nil {
+				fmt.Println("ver is ", ver)
+				panic(err.Error())
+			}
+			w.ch <- witness
+			currentBatchNum++
+		}
+	}
+
+	close(w.ch)
+	<-w.quit
+	fmt.Printf("witness run finished, the account tree root is %x\n", w.accountTree.Root())
+}
+
+// ExecuteBatchUpdateUser replaces the account leaf of the update and moves
+// the assets of the account in the cex assets.
+func (w *Witness) ExecuteBatchUpdateUser(update *AccountUpdate, op *utils.UpdateUserOperation) {
+	op.BeforeAccountTreeRoot = w.accountTree.Root()
+	op.AccountIndex = update.AccountIndex
+	op.IsOldEmpty = update.Old == nil
+	op.IsNewEmpty = update.New == nil
+	accountProof, err := w.accountTree.GetProof(uint64(update.AccountIndex))
+	if err != nil {
+		panic(err.Error())
+	}
+	copy(op.AccountProof[:], accountProof[:])
+	poseidonHasher := poseidon.NewPoseidon()
+	if update.Old != nil {
+		oldLeaf, err := w.accountTree.Get(uint64(update.AccountIndex), nil)
+		if err != nil {
+			panic(err.Error())
+		}
+		if !bytes.Equal(oldLeaf, utils.AccountInfoToHash(update.Old, &poseidonHasher)) {
+			panic(fmt.Sprintf("the previous account %d is inconsistent with the account tree", update.AccountIndex))
+		}
+		op.AccountIdHash = update.Old.AccountId
+		op.OldTotalEquity = update.Old.TotalEquity
+		op.OldTotalDebt = update.Old.TotalDebt
+		op.OldTotalCollateral = update.Old.TotalCollateral
+		op.OldAssets = update.Old.Assets
+		for p := 0; p < len(update.Old.Assets); p++ {
+			utils.SubAccountAsset(w.cexAssets, &update.Old.Assets[p])
+		}
+	}
+	accountHash := utils.NilAccountHash
+	if update.New != nil {
+		op.AccountIdHash = update.New.AccountId
+		op.Assets = update.New.Assets
+		for p := 0; p < len(update.New.Assets); p++ {
+			utils.AddAccountAsset(w.cexAssets, &update.New.Assets[p])
+		}
+		accountHash = utils.AccountInfoToHash(update.New, &poseidonHasher)
+	}
+	err = w.accountTree.Set(uint64(update.AccountIndex), accountHash)
+	if err != nil {
+		panic(err.Error())
+	}
+	op.AfterAccountTreeRoot = w.accountTree.Root()
+}
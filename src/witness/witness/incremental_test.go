@@ -0,0 +1,94 @@
+package witness
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+)
+
+func testCexAssets(btcPrice uint64) []utils.CexAssetInfo {
+	var ratios [utils.TierCount]utils.TierRatio
+	for i := range ratios {
+		ratios[i] = utils.TierRatio{BoundaryValue: new(big.Int).Lsh(big.NewInt(1), 128), Ratio: 100, PrecomputedValue: new(big.Int)}
+	}
+	return []utils.CexAssetInfo{
+		{Symbol: "btc", Index: 0, BasePrice: btcPrice, LoanRatios: ratios, MarginRatios: ratios, PortfolioMarginRatios: ratios},
+		{Symbol: "usdt", Index: 1, BasePrice: 1, LoanRatios: ratios, MarginRatios: ratios, PortfolioMarginRatios: ratios},
+	}
+}
+
+func TestComputeAccountUpdates(t *testing.T) {
+	k := utils.AssetCountsTiers[0]
+	accountId := []byte{1}
+	prevAccount := &utils.AccountInfo{
+		AccountIndex: 3,
+		AccountId:    accountId,
+		Assets:       []utils.AccountAsset{{Index: 0, Equity: 10, Loan: 10}, {Index: 1, Debt: 5}},
+	}
+	utils.RecomputeAccountTotals(prevAccount, testCexAssets(1))
+	prevAccounts := map[string]*utils.AccountInfo{hex.EncodeToString(accountId): prevAccount}
+	// the debt is only covered by the collateral at the btc price 2
+	newAccounts := func() map[int][]utils.AccountInfo {
+		return map[int][]utils.AccountInfo{k: {{
+			AccountId: accountId,
+			Assets:    []utils.AccountAsset{{Index: 0, Equity: 10, Loan: 10}, {Index: 1, Debt: 15}},
+		}}}
+	}
+
+	updates, nextAccountIndex, err := ComputeAccountUpdates(newAccounts(), prevAccounts, testCexAssets(2), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nextAccountIndex != 4 || len(updates[k]) != 1 || updates[k][0].AccountIndex != 3 || updates[k][0].Old != prevAccount {
+		t.Fatalf("unexpected updates %v %d", updates, nextAccountIndex)
+	}
+	if updates[k][0].New.TotalCollateral.Int64() != 20 || updates[k][0].New.TotalDebt.Int64() != 15 {
+		t.Fatalf("unexpected totals %v", updates[k][0].New)
+	}
+
+	if _, _, err = ComputeAccountUpdates(newAccounts(), prevAccounts, testCexAssets(1), 4); err == nil {
+		t.Fatal("expect an error for the account whose debt is bigger than collateral")
+	}
+}
+
+func TestIncrementalCexAssets(t *testing.T) {
+	prevCexAssets := testCexAssets(1)
+	prevCexAssets[0].TotalEquity = 10
+	prevCexAssets[0].LoanCollateral = 10
+	prevCexAssets[1].TotalDebt = 5
+	cexAssets := testCexAssets(1)
+	// the order of the assets doesn't matter
+	cexAssets[0], cexAssets[1] = cexAssets[1], cexAssets[0]
+
+	startCexAssets, err := utils.IncrementalCexAssets(prevCexAssets, cexAssets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if startCexAssets[0].Symbol != "btc" || startCexAssets[0].TotalEquity != 10 || startCexAssets[0].LoanCollateral != 10 ||
+		startCexAssets[1].TotalDebt != 5 {
+		t.Fatalf("unexpected cex assets %v", startCexAssets)
+	}
+
+	// the unchanged accounts are not proved by the new prices and tier ratios
+	cexAssets = testCexAssets(2)
+	if _, err = utils.IncrementalCexAssets(prevCexAssets, cexAssets); err == nil {
+		t.Fatal("expect an error for a different price")
+	}
+	cexAssets = testCexAssets(1)
+	cexAssets[0].MarginRatios[1].Ratio = 90
+	if _, err = utils.IncrementalCexAssets(prevCexAssets, cexAssets); err == nil {
+		t.Fatal("expect an error for a different tier ratio")
+	}
+	cexAssets = testCexAssets(1)
+	cexAssets[1].PortfolioMarginRatios[0].BoundaryValue = big.NewInt(1000)
+	if _, err = utils.IncrementalCexAssets(prevCexAssets, cexAssets); err == nil {
+		t.Fatal("expect an error for a different tier boundary")
+	}
+	cexAssets = testCexAssets(1)
+	cexAssets[0].Symbol = "eth"
+	if _, err = utils.IncrementalCexAssets(prevCexAssets, cexAssets); err == nil {
+		t.Fatal("expect an error for a different cex asset")
+	}
+}
@@ -5,8 +5,8 @@ import (
 	"encoding/base64"
 	"encoding/gob"
 	"fmt"
+	"io"
 	"runtime"
-	"sort"
 	"sync/atomic"
 	"time"
 
@@ -23,52 +23,59 @@ type Witness struct {
 	accountTree              bsmt.SparseMerkleTree
 	totalOpsNumber           uint32
 	witnessModel             WitnessModel
-	ops                      map[int][]utils.AccountInfo
+	accounts                 utils.AccountSource
 	cexAssets                []utils.CexAssetInfo
+	assetRegistryHash        string
 	db                       *utils.DB
 	ch                       chan BatchWitness
 	quit                     chan int
-	accountHashChan          map[int][]chan []byte
 	currentBatchNumber       int64
 	batchNumberMappingKeys   []int
 	batchNumberMappingValues []int
 }
 
 func NewWitness(accountTree bsmt.SparseMerkleTree, totalOpsNumber uint32,
-	ops map[int][]utils.AccountInfo, cexAssets []utils.CexAssetInfo,
+	accounts utils.AccountSource, cexAssets []utils.CexAssetInfo,
 	config *config.Config) *Witness {
-	db, err := utils.NewDB(config.MysqlDataSource)
+	db, err := utils.NewDBWithDriver(config.DbDriver, config.MysqlDataSource)
 	if err != nil {
 		panic(err.Error())
 	}
 
+	assetRegistry, err := utils.LoadAssetRegistryFromDir(config.UserDataFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	fmt.Println("asset registry hash is ", assetRegistry.Hash)
+
+	w := NewWitnessWithModel(accountTree, totalOpsNumber, accounts, cexAssets, assetRegistry.Hash, NewWitnessModel(db, config.DbSuffix))
+	w.db = db
+	return w
+}
+
+// NewWitnessWithModel writes the batch witness to witnessModel, RunIncremental
+// isn't supported by the returned witness. assetRegistryHash is recorded in
+// every batch witness.
+func NewWitnessWithModel(accountTree bsmt.SparseMerkleTree, totalOpsNumber uint32,
+	accounts utils.AccountSource, cexAssets []utils.CexAssetInfo,
+	assetRegistryHash string, witnessModel WitnessModel) *Witness {
 	return &Witness{
 		accountTree:        accountTree,
 		totalOpsNumber:     totalOpsNumber,
-		witnessModel:       NewWitnessModel(db, config.DbSuffix),
-		ops:                ops,
+		witnessModel:       witnessModel,
+		accounts:           accounts,
 		cexAssets:          cexAssets,
+		assetRegistryHash:  assetRegistryHash,
 		ch:                 make(chan BatchWitness, 100),
 		quit:               make(chan int, 1),
 		currentBatchNumber: 0,
-		accountHashChan:    make(map[int][]chan []byte),
 	}
 }
 
 func (w *Witness) Run() {
 	// create table first
 	w.witnessModel.CreateBatchWitnessTable()
-	var latestWitness *BatchWitness
-	var err error
-	for {
-		latestWitness, err = w.witnessModel.GetLatestBatchWitness()
-		if err == utils.DbErrQueryInterrupted || err == utils.DbErrQueryTimeout {
-			fmt.Println("get latest witness timeout, retry...:", err.Error())
-			time.Sleep(1 * time.Second)
-			continue
-		}
-		break
-	}
+	latestWitness, err := getLatestBatchWitness(w.witnessModel)
 	var height int64
 	if err == utils.DbErrNotFound {
 		height = -1
@@ -104,120 +111,76 @@ func (w *Witness) Run() {
 		fmt.Println("normal starting...")
 	}
 
-	w.PaddingAccounts()
-
 	poseidonHasher := poseidon.NewPoseidon()
 	go w.WriteBatchWitnessToDB()
-	for k := range w.ops {
-		w.accountHashChan[k] = make([]chan []byte, utils.BatchCreateUserOpsCountsTiers[k])
-		for p := 0; p < utils.BatchCreateUserOpsCountsTiers[k]; p++ {
-			w.accountHashChan[k][p] = make(chan []byte, 1)
-		}
-	}
+	// the accounts of the next batch are read and hashed while the current
+	// batch is executed, only a few batches are in memory
+	batches := make(chan accountBatch, 1)
+	go w.readAccountBatches(int(height), batches)
 
-	cpuCores := runtime.NumCPU()
-	workersNum := 1
-	if cpuCores > 2 {
-		workersNum = cpuCores - 2
-	}
-
-	userOpsPerBatch := 0
-	startBatchNum := 0
-	recoveredBatchNum := int(height)
-	for p, k := range w.batchNumberMappingKeys {
-		var wg sync.WaitGroup
-		endBatchNum := w.batchNumberMappingValues[p]
-		userOpsPerBatch = utils.BatchCreateUserOpsCountsTiers[k]
-		averageCount := userOpsPerBatch/workersNum + 1
-		for i := 0; i < workersNum; i++ {
-			wg.Add(1)
-			go func(index int) {
-				defer wg.Done()
-				for j := startBatchNum; j < endBatchNum; j++ {
-					if j <= recoveredBatchNum {
-						continue
-					}
-					if index*averageCount >= userOpsPerBatch {
-						break
-					}
-					lowAccountIndex := index*averageCount + (j-startBatchNum)*userOpsPerBatch
-					highAccountIndex := averageCount + lowAccountIndex
-					if highAccountIndex > (j-startBatchNum+1)*userOpsPerBatch {
-						highAccountIndex = (j - startBatchNum + 1) * userOpsPerBatch
-					}
-					currentAccountIndex := (j - startBatchNum) * userOpsPerBatch
-					// fmt.Printf("worker num: %d, lowAccountInde: %d, highAccountIndex: %d, current: %d\n", index, lowAccountIndex, highAccountIndex, currentAccountIndex)
-					w.ComputeAccountHash(k, uint32(lowAccountIndex), uint32(highAccountIndex), uint32(currentAccountIndex))
-				}
-			}(i)
+	for i := int(height) + 1; i < batchNumber; i++ {
+		batch := <-batches
+		batchCreateUserWit := &utils.BatchCreateUserWitness{
+			BeforeAccountTreeRoot: w.accountTree.Root(),
+			AssetRegistryHash:     w.assetRegistryHash,
+			BeforeCexAssets:       make([]utils.CexAssetInfo, utils.AssetCounts),
+			CreateUserOps:         make([]utils.CreateUserOperation, len(batch.accounts)),
 		}
-		for i := startBatchNum; i < endBatchNum; i++ {
-			if i <= recoveredBatchNum {
-				continue
-			}
-			batchCreateUserWit := &utils.BatchCreateUserWitness{
-				BeforeAccountTreeRoot: w.accountTree.Root(),
-				BeforeCexAssets:       make([]utils.CexAssetInfo, utils.AssetCounts),
-				CreateUserOps:         make([]utils.CreateUserOperation, userOpsPerBatch),
-			}
 
-			copy(batchCreateUserWit.BeforeCexAssets[:], w.cexAssets[:])
-			for j := 0; j < len(w.cexAssets); j++ {
-				commitments := utils.ConvertAssetInfoToBytes(w.cexAssets[j])
-				for p := 0; p < len(commitments); p++ {
-					poseidonHasher.Write(commitments[p])
-				}
+		copy(batchCreateUserWit.BeforeCexAssets[:], w.cexAssets[:])
+		for j := 0; j < len(w.cexAssets); j++ {
+			commitments := utils.ConvertAssetInfoToBytes(w.cexAssets[j])
+			for p := 0; p < len(commitments); p++ {
+				poseidonHasher.Write(commitments[p])
 			}
-			batchCreateUserWit.BeforeCEXAssetsCommitment = poseidonHasher.Sum(nil)
-			poseidonHasher.Reset()
+		}
+		batchCreateUserWit.BeforeCEXAssetsCommitment = poseidonHasher.Sum(nil)
+		poseidonHasher.Reset()
 
-			relativeBatchNum := i - startBatchNum
-			for j := relativeBatchNum * userOpsPerBatch; j < (relativeBatchNum+1)*userOpsPerBatch; j++ {
-				w.ExecuteBatchCreateUser(k, uint32(j), uint32(relativeBatchNum*userOpsPerBatch), batchCreateUserWit)
-			}
-			for j := 0; j < len(w.cexAssets); j++ {
-				commitments := utils.ConvertAssetInfoToBytes(w.cexAssets[j])
-				for p := 0; p < len(commitments); p++ {
-					poseidonHasher.Write(commitments[p])
-				}
+		for j := range batch.accounts {
+			w.ExecuteBatchCreateUser(&batch.accounts[j], batch.hashes[j], &batchCreateUserWit.CreateUserOps[j])
+		}
+		for j := 0; j < len(w.cexAssets); j++ {
+			commitments := utils.ConvertAssetInfoToBytes(w.cexAssets[j])
+			for p := 0; p < len(commitments); p++ {
+				poseidonHasher.Write(commitments[p])
 			}
-			batchCreateUserWit.AfterCEXAssetsCommitment = poseidonHasher.Sum(nil)
-			poseidonHasher.Reset()
-			batchCreateUserWit.AfterAccountTreeRoot = w.accountTree.Root()
+		}
+		batchCreateUserWit.AfterCEXAssetsCommitment = poseidonHasher.Sum(nil)
+		poseidonHasher.Reset()
+		batchCreateUserWit.AfterAccountTreeRoot = w.accountTree.Root()
 
-			// compute batch commitment
-			batchCreateUserWit.BatchCommitment = poseidon.PoseidonBytes(batchCreateUserWit.BeforeAccountTreeRoot,
-				batchCreateUserWit.AfterAccountTreeRoot,
-				batchCreateUserWit.BeforeCEXAssetsCommitment,
-				batchCreateUserWit.AfterCEXAssetsCommitment)
-			// bz, err := json.Marshal(batchCreateUserWit)
-			var serializeBuf bytes.Buffer
-			enc := gob.NewEncoder(&serializeBuf)
-			err := enc.Encode(batchCreateUserWit)
-			if err != nil {
-				panic(err.Error())
-			}
-			// startTime := time.Now()
-			buf := serializeBuf.Bytes()
-			compressedBuf := s2.Encode(nil, buf)
-			// endTime := time.Now()
-			// fmt.Println("compress time is ", endTime.Sub(startTime), " len of compressed buf is ", len(buf), len(compressedBuf))
-			witness := BatchWitness{
-				Height:      int64(i),
-				WitnessData: base64.StdEncoding.EncodeToString(compressedBuf),
-				Status:      StatusPublished,
-			}
-			accPrunedVersion := bsmt.Version(atomic.LoadInt64(&w.currentBatchNumber) + 1)
-			ver, err := w.accountTree.Commit(&accPrunedVersion)
-			if err != nil {
-				fmt.Println("ver is ", ver)
-				panic(err.Error())
-			}
-			// fmt.Printf("ver is %d account tree root is %x\n", ver, w.accountTree.Root())
-			w.ch <- witness
+		// compute batch commitment
+		batchCreateUserWit.BatchCommitment = poseidon.PoseidonBytes(batchCreateUserWit.BeforeAccountTreeRoot,
+			batchCreateUserWit.AfterAccountTreeRoot,
+			batchCreateUserWit.BeforeCEXAssetsCommitment,
+			batchCreateUserWit.AfterCEXAssetsCommitment)
+		// bz, err := json.Marshal(batchCreateUserWit)
+		var serializeBuf bytes.Buffer
+		enc := gob.NewEncoder(&serializeBuf)
+		err := enc.Encode(batchCreateUserWit)
+		if err != nil {
+			panic(err.Error())
 		}
-		wg.Wait()
-		startBatchNum = endBatchNum
+		// startTime := time.Now()
+		buf := serializeBuf.Bytes()
+		compressedBuf := s2.Encode(nil, buf)
+		// endTime := time.Now()
+		// fmt.Println("compress time is ", endTime.Sub(startTime), " len of compressed buf is ", len(buf), len(compressedBuf))
+		witness := BatchWitness{
+			Height:      int64(i),
+			WitnessData: base64.StdEncoding.EncodeToString(compressedBuf),
+			Status:      StatusPublished,
+			AssetsCount: int64(batch.assetsCount),
+		}
+		accPrunedVersion := bsmt.Version(atomic.LoadInt64(&w.currentBatchNumber) + 1)
+		ver, err := w.accountTree.Commit(&accPrunedVersion)
+		if err != nil {
+			fmt.Println("ver is ", ver)
+			panic(err.Error())
+		}
+		// fmt.Printf("ver is %d account tree root is %x\n", ver, w.accountTree.Root())
+		w.ch <- witness
 	}
 
 	close(w.ch)
@@ -226,6 +189,19 @@ func (w *Witness) Run() {
 	fmt.Printf("witness run finished, the account tree root is %x\n", w.accountTree.Root())
 }
 
+func getLatestBatchWitness(witnessModel WitnessModel) (*BatchWitness, error) {
+	for {
+		latestWitness, err := witnessModel.GetLatestBatchWitness()
+		if err == utils.DbErrQueryInterrupted || err == utils.DbErrQueryTimeout {
+			fmt.Println("get latest witness timeout, retry...:", err.Error())
+			utils.ServiceErrors.WithLabelValues("witness", "db").Inc()
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		return latestWitness, err
+	}
+}
+
 func (w *Witness) GetCexAssets(wit *BatchWitness) []utils.CexAssetInfo {
 	witness := utils.DecodeBatchWitness(wit.WitnessData)
 	if witness == nil {
@@ -245,6 +221,8 @@ func (w *Witness) WriteBatchWitnessToDB() {
 			panic("create batch witness failed " + err.Error())
 		}
 		atomic.StoreInt64(&w.currentBatchNumber, witness.Height)
+		utils.WitnessBatchesGenerated.Inc()
+		utils.WitnessLatestHeight.Set(float64(witness.Height))
 		if witness.Height%100 == 0 {
 			fmt.Println("save batch ", witness.Height, " to db")
 		}
@@ -252,22 +230,86 @@ func (w *Witness) WriteBatchWitnessToDB() {
 	w.quit <- 0
 }
 
-func (w *Witness) ComputeAccountHash(key int, accountIndex uint32, highAccountIndex uint32, currentIndex uint32) {
-	poseidonHasher := poseidon.NewPoseidon()
-	for i := accountIndex; i < highAccountIndex; i++ {
-		w.accountHashChan[key][i-currentIndex] <- utils.AccountInfoToHash(&w.ops[key][i], &poseidonHasher)
+// accountBatch is the accounts of a batch and their leaf hashes
+type accountBatch struct {
+	assetsCount int
+	accounts    []utils.AccountInfo
+	hashes      [][]byte
+}
+
+// readAccountBatches reads the accounts of every tier batch by batch, the last
+// batch of a tier is filled by the padding accounts. The batches up to
+// recoveredBatchNum are skipped.
+func (w *Witness) readAccountBatches(recoveredBatchNum int, batches chan<- accountBatch) {
+	paddingStartIndex := int(w.totalOpsNumber)
+	startBatchNum := 0
+	for p, k := range w.batchNumberMappingKeys {
+		endBatchNum := w.batchNumberMappingValues[p]
+		userOpsPerBatch := utils.BatchCreateUserOpsCountsTiers[k]
+		it, err := w.accounts.Iterator(k)
+		if err != nil {
+			panic(err.Error())
+		}
+		for i := startBatchNum; i < endBatchNum; i++ {
+			accounts := make([]utils.AccountInfo, userOpsPerBatch)
+			for j := range accounts {
+				accounts[j], err = it.Next()
+				if err == io.EOF {
+					accounts[j] = utils.NewPaddingAccount(k, paddingStartIndex)
+					paddingStartIndex += 1
+				} else if err != nil {
+					panic(err.Error())
+				}
+			}
+			if i <= recoveredBatchNum {
+				continue
+			}
+			batches <- accountBatch{
+				assetsCount: k,
+				accounts:    accounts,
+				hashes:      computeAccountHashes(accounts),
+			}
+		}
+		it.Close()
+		startBatchNum = endBatchNum
+	}
+	close(batches)
+}
+
+func computeAccountHashes(accounts []utils.AccountInfo) [][]byte {
+	cpuCores := runtime.NumCPU()
+	workersNum := 1
+	if cpuCores > 2 {
+		workersNum = cpuCores - 2
+	}
+	hashes := make([][]byte, len(accounts))
+	averageCount := len(accounts)/workersNum + 1
+	var wg sync.WaitGroup
+	for low := 0; low < len(accounts); low += averageCount {
+		high := low + averageCount
+		if high > len(accounts) {
+			high = len(accounts)
+		}
+		wg.Add(1)
+		go func(low int, high int) {
+			defer wg.Done()
+			poseidonHasher := poseidon.NewPoseidon()
+			for i := low; i < high; i++ {
+				hashes[i] = utils.AccountInfoToHash(&accounts[i], &poseidonHasher)
+			}
+		}(low, high)
 	}
+	wg.Wait()
+	return hashes
 }
 
-func (w *Witness) ExecuteBatchCreateUser(assetKey int, accountIndex uint32, currentAccountIndex uint32, batchCreateUserWit *utils.BatchCreateUserWitness) {
-	index := accountIndex - currentAccountIndex
-	account := w.ops[assetKey][accountIndex]
-	batchCreateUserWit.CreateUserOps[index].BeforeAccountTreeRoot = w.accountTree.Root()
+func (w *Witness) ExecuteBatchCreateUser(account *utils.AccountInfo, accountHash []byte, createUserOp *utils.CreateUserOperation) {
+	createUserOp.BeforeAccountTreeRoot = w.accountTree.Root()
 	accountProof, err := w.accountTree.GetProof(uint64(account.AccountIndex))
 	if err != nil {
 		panic(err.Error())
 	}
-	copy(batchCreateUserWit.CreateUserOps[index].AccountProof[:], accountProof[:])
+	copy(createUserOp.AccountProof[:], accountProof[:])
 	for p := 0; p < len(account.Assets); p++ {
 		// update cexAssetInfo
 		w.cexAssets[account.Assets[p].Index].TotalEquity = utils.SafeAdd(w.cexAssets[account.Assets[p].Index].TotalEquity, account.Assets[p].Equity)
@@ -277,43 +319,57 @@ func (w *Witness) ExecuteBatchCreateUser(assetKey int, accountIndex uint32, curr
 		w.cexAssets[account.Assets[p].Index].PortfolioMarginCollateral = utils.SafeAdd(w.cexAssets[account.Assets[p].Index].PortfolioMarginCollateral, account.Assets[p].PortfolioMargin)
 	}
 	// update account tree
-	accountHash := <-w.accountHashChan[assetKey][index]
 	err = w.accountTree.Set(uint64(account.AccountIndex), accountHash)
 	// fmt.Printf("account index %d, hash: %x\n", account.AccountIndex, accountHash)
 	if err != nil {
 		panic(err.Error())
 	}
-	batchCreateUserWit.CreateUserOps[index].AfterAccountTreeRoot = w.accountTree.Root()
-	batchCreateUserWit.CreateUserOps[index].AccountIndex = account.AccountIndex
-	batchCreateUserWit.CreateUserOps[index].AccountIdHash = account.AccountId
-	batchCreateUserWit.CreateUserOps[index].Assets = account.Assets
+	createUserOp.AfterAccountTreeRoot = w.accountTree.Root()
+	createUserOp.AccountIndex = account.AccountIndex
+	createUserOp.AccountIdHash = account.AccountId
+	createUserOp.Assets = account.Assets
 }
 
 func (w *Witness) GetBatchNumber() int {
 	b := 0
-	keys := make([]int, 0)
-	for k := range w.ops {
-		keys = append(keys, k)
-	}
-	sort.Ints(keys)
+	counts := w.accounts.Counts()
+	keys := utils.SortedTiers(w.accounts)
 	w.batchNumberMappingKeys = keys
 	w.batchNumberMappingValues = make([]int, len(keys))
 	for i, k := range keys {
 		opsPerBatch := utils.BatchCreateUserOpsCountsTiers[k]
-		b += (len(w.ops[k]) + opsPerBatch - 1) / opsPerBatch
+		b += (counts[k] + opsPerBatch - 1) / opsPerBatch
 		w.batchNumberMappingValues[i] = b
 	}
 	return b
 }
 
-func (w *Witness) PaddingAccounts() {
-	keys := make([]int, 0)
-	for k := range w.ops {
-		keys = append(keys, k)
+// RunService parses the user data of the config and generates the batch
+// witness of all accounts, the witness of the incremental snapshot is
+// generated when PrevDbSuffix is set.
+func RunService(witnessConfig *config.Config) {
+	utils.StartMetricsServer(witnessConfig.MetricsAddr)
+	accounts, cexAssetsInfo, err := utils.ParseUserDataSource(witnessConfig.UserDataFile, witnessConfig.SpillDir)
+	if err != nil {
+		panic(err.Error())
 	}
-	sort.Ints(keys)
-	paddingStartIndex := int(w.totalOpsNumber)
-	for _, k := range keys {
-		paddingStartIndex, w.ops[k] = utils.PaddingAccounts(w.ops[k], k, paddingStartIndex)
+	defer accounts.Close()
+	accountTree, err := utils.NewAccountTree(witnessConfig.TreeDB.Driver, witnessConfig.TreeDB.Option.Addr)
+	if err != nil {
+		panic(err.Error())
+	}
+	fmt.Println("account tree init height is ", accountTree.LatestVersion())
+	fmt.Printf("account tree root is %x\n", accountTree.Root())
+	totalAccountNum := 0
+	for k, v := range accounts.Counts() {
+		totalAccountNum += v
+		fmt.Println("the asset counts of user is ", k, "total ops number is ", v)
+	}
+	witnessService := NewWitness(accountTree, uint32(totalAccountNum), accounts, cexAssetsInfo, witnessConfig)
+	if witnessConfig.PrevDbSuffix != "" {
+		witnessService.RunIncremental(witnessConfig.PrevDbSuffix)
+	} else {
+		witnessService.Run()
 	}
+	fmt.Println("witness service run finished...")
 }
@@ -0,0 +1,230 @@
+package witness
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+)
+
+type embeddedWitnessModel struct {
+	table string
+	db    *utils.EmbeddedDB
+}
+
+// NewEmbeddedWitnessModel returns the witness table of the local pipeline
+// mode, it has the same semantics as the mysql one.
+func NewEmbeddedWitnessModel(db *utils.EmbeddedDB, suffix string) WitnessModel {
+	return &embeddedWitnessModel{
+		table: TableNamePrefix + suffix,
+		db:    db,
+	}
+}
+
+func (m *embeddedWitnessModel) CreateBatchWitnessTable() error {
+	return nil
+}
+
+func (m *embeddedWitnessModel) UpgradeBatchWitnessTable() error {
+	return nil
+}
+
+func (m *embeddedWitnessModel) DropBatchWitnessTable() error {
+	return m.db.Drop(m.table)
+}
+
+func (m *embeddedWitnessModel) GetLatestBatchWitnessHeight() (height int64, err error) {
+	witness, err := m.GetLatestBatchWitness()
+	if err != nil {
+		return 0, err
+	}
+	return witness.Height, nil
+}
+
+func (m *embeddedWitnessModel) GetBatchWitnessByHeight(height int64) (witness *BatchWitness, err error) {
+	witness = &BatchWitness{}
+	err = m.db.Get(m.table, utils.EmbeddedUint64Key(uint64(height)), witness)
+	if err != nil {
+		return nil, err
+	}
+	return witness, nil
+}
+
+func (m *embeddedWitnessModel) UpdateBatchWitnessStatus(witness *BatchWitness, status int64) error {
+	m.db.Lock()
+	defer m.db.Unlock()
+	row, err := m.GetBatchWitnessByHeight(witness.Height)
+	if err != nil {
+		return err
+	}
+	return m.putWithStatus(row, status)
+}
+
+func (m *embeddedWitnessModel) putWithStatus(witness *BatchWitness, status int64) error {
+	witness.Status = status
+	witness.UpdatedAt = time.Now()
+	return m.db.Put(m.table, utils.EmbeddedUint64Key(uint64(witness.Height)), witness)
+}
+
+func (m *embeddedWitnessModel) GetLatestBatchWitness() (witness *BatchWitness, err error) {
+	witness = &BatchWitness{}
+	err = m.db.Last(m.table, witness)
+	if err != nil {
+		return nil, err
+	}
+	return witness, nil
+}
+
+// scanByStatus calls fn with the witnesses of the status in ascending order
+// of height until fn returns false.
+func (m *embeddedWitnessModel) scanByStatus(status int64, fn func(witness *BatchWitness) bool) error {
+	return m.db.Scan(m.table, nil, func(value []byte) (bool, error) {
+		witness := &BatchWitness{}
+		err := json.Unmarshal(value, witness)
+		if err != nil {
+			return false, err
+		}
+		if witness.Status != status {
+			return true, nil
+		}
+		return fn(witness), nil
+	})
+}
+
+func (m *embeddedWitnessModel) GetLatestBatchWitnessByStatus(status int64) (witness *BatchWitness, err error) {
+	err = m.scanByStatus(status, func(w *BatchWitness) bool {
+		witness = w
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if witness == nil {
+		return nil, utils.DbErrNotFound
+	}
+	return witness, nil
+}
+
+func (m *embeddedWitnessModel) GetAllBatchHeightsByStatus(status int64, limit int, offset int) (witnessHeights []int64, err error) {
+	err = m.scanByStatus(status, func(w *BatchWitness) bool {
+		if offset > 0 {
+			offset--
+			return true
+		}
+		witnessHeights = append(witnessHeights, w.Height)
+		return len(witnessHeights) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(witnessHeights) == 0 {
+		return nil, utils.DbErrNotFound
+	}
+	return witnessHeights, nil
+}
+
+func (m *embeddedWitnessModel) GetAllBatchTasksByStatus(status int64, limit int, offset int) (tasks []BatchTask, err error) {
+	err = m.scanByStatus(status, func(w *BatchWitness) bool {
+		if offset > 0 {
+			offset--
+			return true
+		}
+		tasks = append(tasks, BatchTask{Height: w.Height, AssetsCount: w.AssetsCount})
+		return len(tasks) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(tasks) == 0 {
+		return nil, utils.DbErrNotFound
+	}
+	return tasks, nil
+}
+
+func (m *embeddedWitnessModel) GetAndUpdateBatchesWitnessByStatus(beforeStatus, afterStatus int64, count int32) (witnesses [](*BatchWitness), err error) {
+	m.db.Lock()
+	defer m.db.Unlock()
+	err = m.scanByStatus(beforeStatus, func(w *BatchWitness) bool {
+		witnesses = append(witnesses, w)
+		return len(witnesses) < int(count)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(witnesses) == 0 {
+		return nil, utils.DbErrNotFound
+	}
+	for _, w := range witnesses {
+		err = m.putWithStatus(w, afterStatus)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return witnesses, nil
+}
+
+func (m *embeddedWitnessModel) GetAndUpdateBatchesWitnessByHeight(height int, beforeStatus, afterStatus int64) (witnesses [](*BatchWitness), err error) {
+	m.db.Lock()
+	defer m.db.Unlock()
+	witness, err := m.GetBatchWitnessByHeight(int64(height))
+	if err != nil {
+		return nil, err
+	}
+	if witness.Status != beforeStatus {
+		return nil, utils.DbErrNotFound
+	}
+	err = m.putWithStatus(witness, afterStatus)
+	if err != nil {
+		return nil, err
+	}
+	return []*BatchWitness{witness}, nil
+}
+
+// CreateBatchWitness returns DbErrDuplicateKey if a height exists, like the
+// unique height of the mysql table, and then no witness is written.
+func (m *embeddedWitnessModel) CreateBatchWitness(witness []BatchWitness) error {
+	m.db.Lock()
+	defer m.db.Unlock()
+	var batch utils.EmbeddedBatch
+	now := time.Now()
+	heights := make(map[int64]bool, len(witness))
+	for _, w := range witness {
+		exists, err := m.db.Has(m.table, utils.EmbeddedUint64Key(uint64(w.Height)))
+		if err != nil {
+			return err
+		}
+		if exists || heights[w.Height] {
+			return fmt.Errorf("witness height %d: %w", w.Height, utils.DbErrDuplicateKey)
+		}
+		heights[w.Height] = true
+		w.ID = uint64(w.Height) + 1
+		w.CreatedAt = now
+		w.UpdatedAt = now
+		err = batch.Put(m.table, utils.EmbeddedUint64Key(uint64(w.Height)), &w)
+		if err != nil {
+			return err
+		}
+	}
+	return m.db.Write(&batch)
+}
+
+func (m *embeddedWitnessModel) GetRowCounts() (counts []int64, err error) {
+	counts = make([]int64, 4)
+	err = m.db.Scan(m.table, nil, func(value []byte) (bool, error) {
+		witness := &BatchWitness{}
+		err := json.Unmarshal(value, witness)
+		if err != nil {
+			return false, err
+		}
+		counts[0]++
+		if witness.Status >= StatusPublished && witness.Status <= StatusFinished {
+			counts[1+witness.Status]++
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
@@ -0,0 +1,93 @@
+package witness
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+)
+
+func TestEmbeddedWitnessModel(t *testing.T) {
+	db, err := utils.NewEmbeddedDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	witnessModel := NewEmbeddedWitnessModel(db, "test")
+	// another snapshot shares the store
+	otherModel := NewEmbeddedWitnessModel(db, "test1")
+	if _, err = witnessModel.GetLatestBatchWitness(); err != utils.DbErrNotFound {
+		t.Fatalf("unexpected error %v", err)
+	}
+	witnesses := make([]BatchWitness, 300)
+	for i := range witnesses {
+		witnesses[i] = BatchWitness{Height: int64(i), WitnessData: "data", Status: StatusPublished, AssetsCount: 50}
+		if i >= 200 {
+			witnesses[i].AssetsCount = 500
+		}
+	}
+	if err = witnessModel.CreateBatchWitness(witnesses); err != nil {
+		t.Fatal(err)
+	}
+	if err = otherModel.CreateBatchWitness(witnesses[:1]); err != nil {
+		t.Fatal(err)
+	}
+	// the existing witness isn't overwritten
+	duplicate := []BatchWitness{{Height: 300, WitnessData: "data"}, {Height: 299, WitnessData: "other"}}
+	if err = witnessModel.CreateBatchWitness(duplicate); !errors.Is(err, utils.DbErrDuplicateKey) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if w, err := witnessModel.GetBatchWitnessByHeight(299); err != nil || w.WitnessData != "data" {
+		t.Fatalf("unexpected witness %v %v", w, err)
+	}
+	if _, err = witnessModel.GetBatchWitnessByHeight(300); err != utils.DbErrNotFound {
+		t.Fatalf("unexpected error %v", err)
+	}
+	height, err := witnessModel.GetLatestBatchWitnessHeight()
+	if err != nil || height != 299 {
+		t.Fatalf("unexpected latest height %d %v", height, err)
+	}
+
+	received, err := witnessModel.GetAndUpdateBatchesWitnessByHeight(256, StatusPublished, StatusReceived)
+	if err != nil || len(received) != 1 || received[0].Height != 256 {
+		t.Fatalf("unexpected witnesses %v %v", received, err)
+	}
+	// the witness can't be received twice
+	if _, err = witnessModel.GetAndUpdateBatchesWitnessByHeight(256, StatusPublished, StatusReceived); err != utils.DbErrNotFound {
+		t.Fatalf("unexpected error %v", err)
+	}
+	rerun, err := witnessModel.GetLatestBatchWitnessByStatus(StatusReceived)
+	if err != nil || rerun.Height != 256 {
+		t.Fatalf("unexpected witness %v %v", rerun, err)
+	}
+	if err = witnessModel.UpdateBatchWitnessStatus(rerun, StatusFinished); err != nil {
+		t.Fatal(err)
+	}
+
+	received, err = witnessModel.GetAndUpdateBatchesWitnessByStatus(StatusPublished, StatusReceived, 10)
+	if err != nil || len(received) != 10 || received[0].Height != 0 || received[9].Height != 9 {
+		t.Fatalf("unexpected witnesses %v", err)
+	}
+	heights, err := witnessModel.GetAllBatchHeightsByStatus(StatusPublished, 100, 240)
+	if err != nil || len(heights) != 49 || heights[0] != 250 || heights[6] != 257 {
+		t.Fatalf("unexpected heights %v %v", heights, err)
+	}
+	tasks, err := witnessModel.GetAllBatchTasksByStatus(StatusPublished, 100, 240)
+	if err != nil || len(tasks) != 49 || tasks[0] != (BatchTask{Height: 250, AssetsCount: 500}) || tasks[48] != (BatchTask{Height: 299, AssetsCount: 500}) {
+		t.Fatalf("unexpected tasks %v %v", tasks, err)
+	}
+	counts, err := witnessModel.GetRowCounts()
+	if err != nil || counts[0] != 300 || counts[1] != 289 || counts[2] != 10 || counts[3] != 1 {
+		t.Fatalf("unexpected counts %v %v", counts, err)
+	}
+
+	if err = witnessModel.DropBatchWitnessTable(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = witnessModel.GetBatchWitnessByHeight(0); err != utils.DbErrNotFound {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err = otherModel.GetBatchWitnessByHeight(0); err != nil {
+		t.Fatal(err)
+	}
+}
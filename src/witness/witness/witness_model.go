@@ -21,6 +21,9 @@ const (
 type (
 	WitnessModel interface {
 		CreateBatchWitnessTable() error
+		// UpgradeBatchWitnessTable adds the columns of the newer versions to
+		// the table created by an older version
+		UpgradeBatchWitnessTable() error
 		DropBatchWitnessTable() error
 		GetLatestBatchWitnessHeight() (height int64, err error)
 		GetBatchWitnessByHeight(height int64) (witness *BatchWitness, err error)
@@ -28,6 +31,7 @@ type (
 		GetLatestBatchWitness() (witness *BatchWitness, err error)
 		GetLatestBatchWitnessByStatus(status int64) (witness *BatchWitness, err error)
 		GetAllBatchHeightsByStatus(status int64, limit int, offset int) (witnessHeights []int64, err error)
+		GetAllBatchTasksByStatus(status int64, limit int, offset int) (tasks []BatchTask, err error)
 		GetAndUpdateBatchesWitnessByStatus(beforeStatus, afterStatus int64, count int32) (witness [](*BatchWitness), err error)
 		GetAndUpdateBatchesWitnessByHeight(height int, beforeStatus, afterStatus int64) (witness [](*BatchWitness), err error)
 		CreateBatchWitness(witness []BatchWitness) error
@@ -47,6 +51,16 @@ type (
 		Height      int64
 		WitnessData string
 		Status      int64
+		// AssetsCount is the assets count tier of the batch, it is 0 for
+		// the witness generated before the tiers are recorded
+		AssetsCount int64
+	}
+
+	// BatchTask is the height and the assets count tier of a batch, the
+	// task queue of the tier is chosen without decoding the witness.
+	BatchTask struct {
+		Height      int64
+		AssetsCount int64
 	}
 )
 
@@ -62,6 +76,13 @@ func (m *defaultWitnessModel) TableName() string {
 }
 
 func (m *defaultWitnessModel) CreateBatchWitnessTable() error {
+	if m.db.Driver() != utils.DbDriverMysql {
+		err := m.createPortableBatchWitnessTable()
+		if err != nil {
+			return err
+		}
+		return m.UpgradeBatchWitnessTable()
+	}
 	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
 		id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
@@ -70,9 +91,40 @@ func (m *defaultWitnessModel) CreateBatchWitnessTable() error {
 		height BIGINT NOT NULL UNIQUE,
 		witness_data LONGTEXT NOT NULL,
 		status BIGINT NOT NULL,
+		assets_count BIGINT NOT NULL DEFAULT 0,
 		INDEX idx_status (status)
 	)`, m.table)
 	_, err := m.db.Exec(query)
+	if err != nil {
+		return err
+	}
+	return m.UpgradeBatchWitnessTable()
+}
+
+// UpgradeBatchWitnessTable adds assets_count to the table of the versions
+// before the assets count tiers, its batches are of the tier 0.
+func (m *defaultWitnessModel) UpgradeBatchWitnessTable() error {
+	return m.db.AddColumn(m.table, "assets_count", "BIGINT NOT NULL DEFAULT 0")
+}
+
+// createPortableBatchWitnessTable creates the table of postgres and sqlite
+func (m *defaultWitnessModel) createPortableBatchWitnessTable() error {
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id %s,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		deleted_at TIMESTAMP NULL DEFAULT NULL,
+		height BIGINT NOT NULL UNIQUE,
+		witness_data TEXT NOT NULL,
+		status BIGINT NOT NULL,
+		assets_count BIGINT NOT NULL DEFAULT 0
+	)`, m.table, m.db.AutoIncrementPrimaryKey())
+	_, err := m.db.Exec(query)
+	if err != nil {
+		return err
+	}
+	query = fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_status ON %s (status)", m.table, m.table)
+	_, err = m.db.Exec(query)
 	return err
 }
 
@@ -91,7 +143,7 @@ func (m *defaultWitnessModel) GetLatestBatchWitnessHeight() (batchNumber int64,
 		return 0, utils.DbErrNotFound
 	}
 	if err != nil {
-		return 0, utils.ConvertMysqlErrToDbErr(err)
+		return 0, utils.ConvertSqlErrToDbErr(err)
 	}
 	return height, nil
 }
@@ -105,7 +157,7 @@ func (m *defaultWitnessModel) GetLatestBatchWitness() (witness *BatchWitness, er
 		return nil, utils.DbErrNotFound
 	}
 	if err != nil {
-		return nil, utils.ConvertMysqlErrToDbErr(err)
+		return nil, utils.ConvertSqlErrToDbErr(err)
 	}
 
 	return m.GetBatchWitnessByHeight(height)
@@ -120,7 +172,7 @@ func (m *defaultWitnessModel) GetLatestBatchWitnessByStatus(status int64) (witne
 		return nil, utils.DbErrNotFound
 	}
 	if err != nil {
-		return nil, utils.ConvertMysqlErrToDbErr(err)
+		return nil, utils.ConvertSqlErrToDbErr(err)
 	}
 	return witness, nil
 }
@@ -142,7 +194,7 @@ func (m *defaultWitnessModel) GetAndUpdateBatchesWitnessByStatus(beforeStatus, a
 	query := fmt.Sprintf("SELECT id, created_at, updated_at, deleted_at, height, witness_data, status FROM %s WHERE status = ? AND deleted_at IS NULL ORDER BY height ASC LIMIT ? FOR UPDATE", m.table)
 	rows, err := tx.Query(query, beforeStatus, count)
 	if err != nil {
-		return nil, utils.ConvertMysqlErrToDbErr(err)
+		return nil, utils.ConvertSqlErrToDbErr(err)
 	}
 	defer rows.Close()
 
@@ -160,7 +212,7 @@ func (m *defaultWitnessModel) GetAndUpdateBatchesWitnessByStatus(beforeStatus, a
 	}
 
 	// Update status for each witness
-	updateQuery := fmt.Sprintf("UPDATE %s SET status = ?, updated_at = NOW() WHERE height = ?", m.table)
+	updateQuery := fmt.Sprintf("UPDATE %s SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE height = ?", m.table)
 	for _, w := range witnesses {
 		_, err = tx.Exec(updateQuery, afterStatus, w.Height)
 		if err != nil {
@@ -185,10 +237,10 @@ func (m *defaultWitnessModel) GetAndUpdateBatchesWitnessByHeight(height int, bef
 	}()
 
 	// Select witnesses with FOR UPDATE lock
-	query := fmt.Sprintf("SELECT id, created_at, updated_at, deleted_at, height, witness_data, status FROM %s WHERE height = ? AND status = ? AND deleted_at IS NULL ORDER BY height ASC", m.table)
+	query := fmt.Sprintf("SELECT id, created_at, updated_at, deleted_at, height, witness_data, status FROM %s WHERE height = ? AND status = ? AND deleted_at IS NULL ORDER BY height ASC FOR UPDATE", m.table)
 	rows, err := tx.Query(query, height, beforeStatus)
 	if err != nil {
-		return nil, utils.ConvertMysqlErrToDbErr(err)
+		return nil, utils.ConvertSqlErrToDbErr(err)
 	}
 	defer rows.Close()
 
@@ -206,7 +258,7 @@ func (m *defaultWitnessModel) GetAndUpdateBatchesWitnessByHeight(height int, bef
 	}
 
 	// Update status for each witness
-	updateQuery := fmt.Sprintf("UPDATE %s SET status = ?, updated_at = NOW() WHERE height = ?", m.table)
+	updateQuery := fmt.Sprintf("UPDATE %s SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE height = ?", m.table)
 	for _, w := range witnesses {
 		_, err = tx.Exec(updateQuery, afterStatus, w.Height)
 		if err != nil {
@@ -226,7 +278,7 @@ func (m *defaultWitnessModel) GetBatchWitnessByHeight(height int64) (witness *Ba
 		return nil, utils.DbErrNotFound
 	}
 	if err != nil {
-		return nil, utils.ConvertMysqlErrToDbErr(err)
+		return nil, utils.ConvertSqlErrToDbErr(err)
 	}
 	return witness, nil
 }
@@ -236,9 +288,9 @@ func (m *defaultWitnessModel) CreateBatchWitness(witness []BatchWitness) error {
 		return nil
 	}
 
-	query := fmt.Sprintf("INSERT INTO %s (height, witness_data, status, created_at, updated_at) VALUES (?, ?, ?, NOW(), NOW())", m.table)
+	query := fmt.Sprintf("INSERT INTO %s (height, witness_data, status, assets_count, created_at, updated_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)", m.table)
 	for _, w := range witness {
-		_, err := m.db.Exec(query, w.Height, w.WitnessData, w.Status)
+		_, err := m.db.Exec(query, w.Height, w.WitnessData, w.Status, w.AssetsCount)
 		if err != nil {
 			return err
 		}
@@ -250,7 +302,7 @@ func (m *defaultWitnessModel) GetAllBatchHeightsByStatus(status int64, limit int
 	query := fmt.Sprintf("SELECT height FROM %s WHERE status = ? AND deleted_at IS NULL ORDER BY height ASC LIMIT ? OFFSET ?", m.table)
 	rows, err := m.db.QueryWithTimeout(query, status, limit, offset)
 	if err != nil {
-		return nil, utils.ConvertMysqlErrToDbErr(err)
+		return nil, utils.ConvertSqlErrToDbErr(err)
 	}
 	defer rows.Close()
 
@@ -269,8 +321,31 @@ func (m *defaultWitnessModel) GetAllBatchHeightsByStatus(status int64, limit int
 	return witnessHeights, nil
 }
 
+func (m *defaultWitnessModel) GetAllBatchTasksByStatus(status int64, limit int, offset int) (tasks []BatchTask, err error) {
+	query := fmt.Sprintf("SELECT height, assets_count FROM %s WHERE status = ? AND deleted_at IS NULL ORDER BY height ASC LIMIT ? OFFSET ?", m.table)
+	rows, err := m.db.QueryWithTimeout(query, status, limit, offset)
+	if err != nil {
+		return nil, utils.ConvertSqlErrToDbErr(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var task BatchTask
+		err = rows.Scan(&task.Height, &task.AssetsCount)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+
+	if len(tasks) == 0 {
+		return nil, utils.DbErrNotFound
+	}
+	return tasks, nil
+}
+
 func (m *defaultWitnessModel) UpdateBatchWitnessStatus(witness *BatchWitness, status int64) error {
-	query := fmt.Sprintf("UPDATE %s SET status = ?, updated_at = NOW() WHERE height = ?", m.table)
+	query := fmt.Sprintf("UPDATE %s SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE height = ?", m.table)
 	_, err := m.db.Exec(query, status, witness.Height)
 	return err
 }
@@ -281,7 +356,7 @@ func (m *defaultWitnessModel) GetRowCounts() (counts []int64, err error) {
 	row := m.db.QueryRowWithTimeout(query)
 	err = row.Scan(&count)
 	if err != nil {
-		return nil, utils.ConvertMysqlErrToDbErr(err)
+		return nil, utils.ConvertSqlErrToDbErr(err)
 	}
 	counts = append(counts, count)
 
@@ -290,7 +365,7 @@ func (m *defaultWitnessModel) GetRowCounts() (counts []int64, err error) {
 	row = m.db.QueryRowWithTimeout(query, StatusPublished)
 	err = row.Scan(&publishedCount)
 	if err != nil {
-		return nil, utils.ConvertMysqlErrToDbErr(err)
+		return nil, utils.ConvertSqlErrToDbErr(err)
 	}
 	counts = append(counts, publishedCount)
 
@@ -298,7 +373,7 @@ func (m *defaultWitnessModel) GetRowCounts() (counts []int64, err error) {
 	row = m.db.QueryRowWithTimeout(query, StatusReceived)
 	err = row.Scan(&pendingCount)
 	if err != nil {
-		return nil, utils.ConvertMysqlErrToDbErr(err)
+		return nil, utils.ConvertSqlErrToDbErr(err)
 	}
 	counts = append(counts, pendingCount)
 
@@ -306,7 +381,7 @@ func (m *defaultWitnessModel) GetRowCounts() (counts []int64, err error) {
 	row = m.db.QueryRowWithTimeout(query, StatusFinished)
 	err = row.Scan(&finishedCount)
 	if err != nil {
-		return nil, utils.ConvertMysqlErrToDbErr(err)
+		return nil, utils.ConvertSqlErrToDbErr(err)
 	}
 	counts = append(counts, finishedCount)
 
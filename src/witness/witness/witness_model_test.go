@@ -0,0 +1,144 @@
+package witness
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+)
+
+func TestSqliteWitnessModel(t *testing.T) {
+	if !utils.SqliteEnabled() {
+		t.Skip("the sqlite driver is built with -tags sqlite")
+	}
+	db, err := utils.NewDBWithDriver(utils.DbDriverSqlite, filepath.Join(t.TempDir(), "zkpos.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	witnessModel := NewWitnessModel(db, "test")
+	if _, err = witnessModel.GetLatestBatchWitness(); err != utils.DbErrTableNotFound {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err = witnessModel.CreateBatchWitnessTable(); err != nil {
+		t.Fatal(err)
+	}
+	// the table can be created again when the service restarts
+	if err = witnessModel.CreateBatchWitnessTable(); err != nil {
+		t.Fatal(err)
+	}
+	witnesses := make([]BatchWitness, 100)
+	for i := range witnesses {
+		witnesses[i] = BatchWitness{Height: int64(i), WitnessData: "data", Status: StatusPublished, AssetsCount: 50}
+	}
+	witnesses[99].AssetsCount = 500
+	if err = witnessModel.CreateBatchWitness(witnesses); err != nil {
+		t.Fatal(err)
+	}
+	tasks, err := witnessModel.GetAllBatchTasksByStatus(StatusPublished, 10, 95)
+	if err != nil || len(tasks) != 5 || tasks[0] != (BatchTask{Height: 95, AssetsCount: 50}) || tasks[4] != (BatchTask{Height: 99, AssetsCount: 500}) {
+		t.Fatalf("unexpected tasks %v %v", tasks, err)
+	}
+
+	received, err := witnessModel.GetAndUpdateBatchesWitnessByHeight(50, StatusPublished, StatusReceived)
+	if err != nil || len(received) != 1 || received[0].Height != 50 {
+		t.Fatalf("unexpected witnesses %v %v", received, err)
+	}
+	// the witness can't be received twice
+	if _, err = witnessModel.GetAndUpdateBatchesWitnessByHeight(50, StatusPublished, StatusReceived); err != utils.DbErrNotFound {
+		t.Fatalf("unexpected error %v", err)
+	}
+	rerun, err := witnessModel.GetLatestBatchWitnessByStatus(StatusReceived)
+	if err != nil || rerun.Height != 50 {
+		t.Fatalf("unexpected witness %v %v", rerun, err)
+	}
+	if err = witnessModel.UpdateBatchWitnessStatus(rerun, StatusFinished); err != nil {
+		t.Fatal(err)
+	}
+
+	// concurrent provers never receive the same witness
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	seen := make(map[int64]bool)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				ws, err := witnessModel.GetAndUpdateBatchesWitnessByStatus(StatusPublished, StatusReceived, 3)
+				if err == utils.DbErrNotFound {
+					return
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				for _, w := range ws {
+					if seen[w.Height] {
+						t.Errorf("witness %d is received twice", w.Height)
+					}
+					seen[w.Height] = true
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(seen) != 99 {
+		t.Fatalf("unexpected received witnesses %d", len(seen))
+	}
+	counts, err := witnessModel.GetRowCounts()
+	if err != nil || counts[0] != 100 || counts[1] != 0 || counts[2] != 99 || counts[3] != 1 {
+		t.Fatalf("unexpected counts %v %v", counts, err)
+	}
+}
+
+func TestUpgradeBatchWitnessTable(t *testing.T) {
+	if !utils.SqliteEnabled() {
+		t.Skip("the sqlite driver is built with -tags sqlite")
+	}
+	db, err := utils.NewDBWithDriver(utils.DbDriverSqlite, filepath.Join(t.TempDir(), "zkpos.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// the witness table of the versions before the assets count tiers
+	_, err = db.Exec(fmt.Sprintf(`CREATE TABLE witness0 (
+		id %s,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		deleted_at TIMESTAMP NULL DEFAULT NULL,
+		height BIGINT NOT NULL UNIQUE,
+		witness_data TEXT NOT NULL,
+		status BIGINT NOT NULL
+	)`, db.AutoIncrementPrimaryKey()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("INSERT INTO witness0 (height, witness_data, status) VALUES (0, 'data', 0)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	witnessModel := NewWitnessModel(db, "0")
+	// dbtool push and the orchestrator upgrade the table without creating it
+	if err = witnessModel.UpgradeBatchWitnessTable(); err != nil {
+		t.Fatal(err)
+	}
+	// the column is added once when the witness service restarts
+	for i := 0; i < 2; i++ {
+		if err = witnessModel.CreateBatchWitnessTable(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = witnessModel.CreateBatchWitness([]BatchWitness{{Height: 1, WitnessData: "data", Status: StatusPublished, AssetsCount: 50}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks, err := witnessModel.GetAllBatchTasksByStatus(StatusPublished, 10, 0)
+	if err != nil || len(tasks) != 2 || tasks[0] != (BatchTask{Height: 0, AssetsCount: 0}) || tasks[1] != (BatchTask{Height: 1, AssetsCount: 50}) {
+		t.Fatalf("unexpected tasks %v %v", tasks, err)
+	}
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"os"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/src/zkpor/zkpor"
+)
+
+func main() {
+	zkpor.Zkpor.Main(os.Args[1:])
+}
@@ -0,0 +1,543 @@
+package zkpor
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/binance/zkmerkle-proof-of-solvency/circuit"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/aggregator/aggregator"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/attestation"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/config"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/dbtool/dbtool"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/keygen/keygen"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/local/local"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/orchestrator"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/proofapi/proofapi"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/prover/prover"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/userproof/userproof"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/utils"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/verifier/verifier"
+	"github.com/binance/zkmerkle-proof-of-solvency/src/witness/witness"
+)
+
+type command struct {
+	name        string
+	description string
+	run         func(fs *flag.FlagSet, args []string)
+}
+
+var commands = []command{
+	{"keygen batch", "generate the keys of the batch circuits of every tier", keygenBatch},
+	{"keygen aggregation", "generate the keys of the aggregation circuits from the groth16 batch keys", keygenAggregation},
+	{"keygen user-inclusion", "generate the keys of the zero-knowledge user inclusion circuit", keygenUserInclusion},
+	{"keygen solidity", "export the solidity verifiers of the batch keys", keygenSolidity},
+	{"ceremony phase1-init", "write the initial phase 1 of the groth16 setup ceremony", ceremonyPhase1Init},
+	{"ceremony phase1-contribute", "add a contribution to a phase 1 file", ceremonyPhase1Contribute},
+	{"ceremony phase1-verify", "verify the initial phase 1 file and its contributions in order", ceremonyPhase1Verify},
+	{"ceremony init", "write the initial phase 2 of a groth16 constraint system generated by keygen", ceremonyInit},
+	{"ceremony contribute", "add a contribution to a phase 2 file", ceremonyContribute},
+	{"ceremony verify", "verify the initial phase 2 file of a constraint system and its contributions in order", ceremonyVerify},
+	{"ceremony extract", "write the groth16 keys of the last phase 2 contribution", ceremonyExtract},
+	{"attest keygen", "generate the ed25519 key pair which signs the attestations", attestKeygen},
+	{"attest sign", "verify an audit bundle and sign the attestation of the snapshot", attestSign},
+	{"validate", "validate the user files and cex_assets_info.csv of UserDataFile", validate},
+	{"witness", "generate the batch witness of the user data", runWitness},
+	{"prove", "generate the batch proofs of the published witness", prove},
+	{"aggregate", "aggregate the batch proofs into the root proof", aggregate},
+	{"userproof", "generate the merkle proofs of all users", runUserProof},
+	{"userproof root", "compute the account tree root of the user data in memory", userProofRoot},
+	{"userproof serve", "serve the user proofs over a read-only http api", userProofServe},
+	{"userproof zk", "generate the zero-knowledge inclusion proof of an account", userProofZk},
+	{"verify batch", "verify the batch proofs of ProofTable", verifyBatch},
+	{"verify bundle", "verify the batch proofs of an audit bundle", verifyBundle},
+	{"verify aggregated", "verify the root proof of AggregatedProofTable", verifyAggregated},
+	{"verify attestation", "check the signature of an attestation and that it commits to a bundle", verifyAttestation},
+	{"verify user", "verify the merkle proof of a user config", verifyUser},
+	{"verify zk-user", "verify the zero-knowledge inclusion proof of a zk user config", verifyZkUser},
+	{"verify hash", "hash two base64 encoded merkle nodes", verifyHash},
+	{"db status", "print the witness and proof counts", dbStatus},
+	{"db delete", "drop the tables of DbSuffix and delete the account tree", dbDelete},
+	{"db cex-assets", "print the cex assets of the latest witness", dbCexAssets},
+	{"db witness", "print the witness data of a batch", dbWitness},
+	{"db account", "print the user config of an account", dbAccount},
+	{"db export-calldata", "export the solidity verifier calldata of all batch proofs", dbExportCalldata},
+	{"db export-bundle", "export the audit bundle of the proofs, verifying keys and final cex assets", dbExportBundle},
+	{"queue push", "push the published witness back to the task queue of the provers", queuePush},
+	{"local", "run the whole pipeline in one process with an embedded db", runLocal},
+	{"run", "run the witness, prover, userproof and verifier stages of the pipeline until the batch proofs are verified", runPipeline},
+}
+
+// Program is a binary which runs the commands of Groups. zkpor has all
+// commands, the service binaries under src are the programs of the commands
+// of their service, so the flags of a command are the same in all binaries.
+type Program struct {
+	Name string
+	// Groups are the name prefixes of the commands of the program, the
+	// prefix "" matches all commands
+	Groups []string
+	// DefaultConfig is the default of -config, -config is required when it
+	// is empty
+	DefaultConfig string
+}
+
+// Zkpor is the program of all commands.
+var Zkpor = &Program{Name: "zkpor", Groups: []string{""}}
+
+// defaultConfig is the DefaultConfig of the running program
+var defaultConfig string
+
+// commandName returns the name of the command in the program, which is the
+// command name without the group.
+func commandName(group string, c *command) string {
+	return strings.TrimSpace(strings.TrimPrefix(c.name, group))
+}
+
+func (p *Program) usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [command] [flags]\n", p.Name)
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, group := range p.Groups {
+		for i := range commands {
+			if c := &commands[i]; strings.HasPrefix(c.name, group) {
+				fmt.Fprintf(os.Stderr, "  %-34s %s\n", strings.TrimSpace(p.Name+" "+commandName(group, c)), c.description)
+			}
+		}
+	}
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintf(os.Stderr, "run %s [command] -h for the flags of the command\n", p.Name)
+}
+
+// Main runs the command named by the first words of args with the rest of
+// args as its flags.
+func (p *Program) Main(args []string) {
+	defaultConfig = p.DefaultConfig
+	// the two words commands are matched first, a group which is a command
+	// is matched without words
+	for _, n := range []int{2, 1, 0} {
+		if len(args) < n {
+			continue
+		}
+		for _, group := range p.Groups {
+			name := strings.TrimSpace(group + " " + strings.Join(args[:n], " "))
+			for i := range commands {
+				if c := &commands[i]; c.name == name {
+					fs := flag.NewFlagSet(strings.TrimSpace(p.Name+" "+commandName(group, c)), flag.ExitOnError)
+					c.run(fs, args[n:])
+					return
+				}
+			}
+		}
+	}
+	p.usage()
+	os.Exit(2)
+}
+
+// parseConfig registers the -config flag, and -remote_password_config when the
+// command connects to the db, then parses the arguments and loads the config.
+func parseConfig(fs *flag.FlagSet, args []string, db bool) *config.Config {
+	configFile := fs.String("config", defaultConfig, "the config file, the relative paths in it are resolved against its directory")
+	var remotePasswdConfig *string
+	if db {
+		remotePasswdConfig = fs.String("remote_password_config", "", "fetch password from aws secretsmanager")
+	}
+	fs.Parse(args)
+	if *configFile == "" {
+		fmt.Fprintln(fs.Output(), "-config is required")
+		fs.Usage()
+		os.Exit(2)
+	}
+	c, err := config.Load(*configFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	loadCircuitParams(c.CircuitParams)
+	if db && *remotePasswdConfig != "" {
+		err = c.SetMysqlSource(*remotePasswdConfig)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	return c
+}
+
+// keygenFlags registers the flags shared by the keygen commands.
+func keygenFlags(fs *flag.FlagSet) (dir *string, provingSystem *string, circuitParams *string) {
+	dir = fs.String("dir", ".", "the directory of the keys")
+	provingSystem = fs.String("proving_system", circuit.ProvingSystemGroth16, "proving system of the keys: groth16 or plonk")
+	circuitParams = circuitParamsFlag(fs)
+	return dir, provingSystem, circuitParams
+}
+
+// skipKeyManifestFlag registers -skip_key_manifest, the returned function
+// applies it after the flags are parsed.
+func skipKeyManifestFlag(fs *flag.FlagSet) func() {
+	skip := fs.Bool("skip_key_manifest", false, "accept the keys whose directory has no keys_manifest.json, the keys are not checked")
+	return func() {
+		if *skip {
+			utils.SkipKeyManifest()
+		}
+	}
+}
+
+func circuitParamsFlag(fs *flag.FlagSet) *string {
+	return fs.String("circuit_params", "", "the circuit params file, the built-in params are used when it is empty")
+}
+
+func loadCircuitParams(name string) {
+	err := utils.LoadCircuitParams(name)
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+func normalizeProvingSystem(provingSystem string) string {
+	p, err := circuit.NormalizeProvingSystem(provingSystem)
+	if err != nil {
+		panic(err.Error())
+	}
+	return p
+}
+
+func keygenBatch(fs *flag.FlagSet, args []string) {
+	dir, provingSystem, circuitParams := keygenFlags(fs)
+	kzgSrsFile := fs.String("kzg_srs", "", "canonical bn254 kzg srs file used by plonk setup")
+	unsafeKzgSrs := fs.Bool("unsafe_kzg_srs", false, "generate an insecure kzg srs for plonk setup, only for testing")
+	incremental := fs.Bool("incremental", false, "generate the keys of the batch update user circuits used by incremental snapshots")
+	fs.Parse(args)
+	loadCircuitParams(*circuitParams)
+	keygen.StartPeriodicGC()
+	keygen.GenerateBatchKeys(*dir, normalizeProvingSystem(*provingSystem), *incremental, *kzgSrsFile, *unsafeKzgSrs)
+}
+
+func keygenAggregation(fs *flag.FlagSet, args []string) {
+	dir := fs.String("dir", ".", "the directory of the keys")
+	batchCount := fs.Int("batch_count", 8, "number of proofs aggregated by one aggregation proof")
+	levels := fs.Int("levels", 3, "number of aggregation levels of every assets count tier")
+	circuitParams := circuitParamsFlag(fs)
+	fs.Parse(args)
+	loadCircuitParams(*circuitParams)
+	keygen.StartPeriodicGC()
+	keygen.GenerateAggregationKeys(*dir, *batchCount, *levels)
+}
+
+func keygenUserInclusion(fs *flag.FlagSet, args []string) {
+	dir, provingSystem, circuitParams := keygenFlags(fs)
+	kzgSrsFile := fs.String("kzg_srs", "", "canonical bn254 kzg srs file used by plonk setup")
+	unsafeKzgSrs := fs.Bool("unsafe_kzg_srs", false, "generate an insecure kzg srs for plonk setup, only for testing")
+	fs.Parse(args)
+	loadCircuitParams(*circuitParams)
+	keygen.GenerateUserInclusionKeys(*dir, normalizeProvingSystem(*provingSystem), *kzgSrsFile, *unsafeKzgSrs)
+}
+
+func keygenSolidity(fs *flag.FlagSet, args []string) {
+	dir, provingSystem, circuitParams := keygenFlags(fs)
+	incremental := fs.Bool("incremental", false, "export the verifiers of the batch update user circuits")
+	fs.Parse(args)
+	loadCircuitParams(*circuitParams)
+	keygen.ExportSolidityVerifiers(*dir, normalizeProvingSystem(*provingSystem), *incremental)
+}
+
+func ceremonyPhase1Init(fs *flag.FlagSet, args []string) {
+	power := fs.Int("power", 0, "the circuits of at most 2^power constraints use the phase 1")
+	output := fs.String("output", "phase1_0", "the initial phase 1 file")
+	fs.Parse(args)
+	keygen.CeremonyPhase1Init(*power, *output)
+}
+
+func ceremonyPhase1Contribute(fs *flag.FlagSet, args []string) {
+	input := fs.String("input", "", "the phase 1 file of the previous participant")
+	output := fs.String("output", "", "the phase 1 file of the contribution")
+	fs.Parse(args)
+	keygen.CeremonyPhase1Contribute(*input, *output)
+}
+
+func ceremonyPhase1Verify(fs *flag.FlagSet, args []string) {
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: zkpor ceremony phase1-verify <initial phase 1 file> <contribution files in order>...")
+	}
+	fs.Parse(args)
+	err := keygen.CeremonyPhase1Verify(fs.Args())
+	if err != nil {
+		fmt.Println("phase 1 verification failed:", err.Error())
+		os.Exit(1)
+	}
+	fmt.Println("phase 1 verification passed")
+}
+
+func ceremonyInit(fs *flag.FlagSet, args []string) {
+	r1cs := fs.String("r1cs", "", "the groth16 constraint system generated by keygen, like zkpor50_700.r1cs")
+	phase1 := fs.String("phase1", "", "the last verified phase 1 contribution")
+	output := fs.String("output", "phase2_0", "the initial phase 2 file")
+	evaluations := fs.String("evaluations", "evaluations", "the evaluations file needed by the extraction of the keys")
+	fs.Parse(args)
+	keygen.CeremonyInit(*r1cs, *phase1, *output, *evaluations)
+}
+
+func ceremonyContribute(fs *flag.FlagSet, args []string) {
+	input := fs.String("input", "", "the phase 2 file of the previous participant")
+	output := fs.String("output", "", "the phase 2 file of the contribution")
+	fs.Parse(args)
+	keygen.CeremonyContribute(*input, *output)
+}
+
+func ceremonyVerify(fs *flag.FlagSet, args []string) {
+	r1cs := fs.String("r1cs", "", "the groth16 constraint system of the phase 2")
+	phase1 := fs.String("phase1", "", "the last phase 1 contribution the phase 2 is initialized from")
+	evaluations := fs.String("evaluations", "evaluations", "the evaluations file written by ceremony init")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: zkpor ceremony verify [flags] <initial phase 2 file> <contribution files in order>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	err := keygen.CeremonyVerify(*r1cs, *phase1, *evaluations, fs.Args())
+	if err != nil {
+		fmt.Println("phase 2 verification failed:", err.Error())
+		os.Exit(1)
+	}
+	fmt.Println("phase 2 verification passed")
+}
+
+func ceremonyExtract(fs *flag.FlagSet, args []string) {
+	r1cs := fs.String("r1cs", "", "the groth16 constraint system of the phase 2")
+	evaluations := fs.String("evaluations", "evaluations", "the evaluations file written by ceremony init")
+	input := fs.String("input", "", "the last verified phase 2 contribution")
+	dir := fs.String("dir", ".", "the directory of the keys")
+	circuitParams := circuitParamsFlag(fs)
+	fs.Parse(args)
+	loadCircuitParams(*circuitParams)
+	keygen.StartPeriodicGC()
+	keygen.CeremonyExtract(*r1cs, *evaluations, *input, *dir)
+}
+
+func attestKeygen(fs *flag.FlagSet, args []string) {
+	privateKey := fs.String("private_key", "attestation.key", "the private key file")
+	publicKey := fs.String("public_key", "attestation.pub", "the public key file")
+	fs.Parse(args)
+	err := attestation.GenerateKey(*privateKey, *publicKey)
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+func attestSign(fs *flag.FlagSet, args []string) {
+	bundleName := fs.String("bundle", "bundle", "the bundle directory, or the gzipped tar ending with .tar.gz")
+	privateKey := fs.String("private_key", "attestation.key", "the private key file")
+	snapshotTime := fs.String("snapshot_time", "", "the time of the user data snapshot in RFC 3339, e.g. 2026-01-02T00:00:00Z")
+	output := fs.String("output", "attestation.json", "the attestation file")
+	skipKeyManifest := skipKeyManifestFlag(fs)
+	fs.Parse(args)
+	skipKeyManifest()
+	t, err := time.Parse(time.RFC3339, *snapshotTime)
+	if err != nil {
+		fmt.Fprintln(fs.Output(), "-snapshot_time is required in RFC 3339")
+		fs.Usage()
+		os.Exit(2)
+	}
+	if !verifier.AttestBundle(*bundleName, *privateKey, t, *output) {
+		os.Exit(1)
+	}
+}
+
+func validate(fs *flag.FlagSet, args []string) {
+	reportFile := fs.String("report", "validation_report.json", "the report file, the violations are written as csv and the asset summaries to <name>_assets.csv when it ends with .csv")
+	maxViolations := fs.Int("max_violations", 100000, "the maximum number of violations written to the report, the rest are only counted")
+	c := parseConfig(fs, args, false)
+	report, err := utils.ValidateUserDataSet(c.UserDataFile, *maxViolations)
+	if err != nil {
+		panic(err.Error())
+	}
+	err = report.Write(*reportFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	report.PrintSummary()
+	fmt.Println("the report is written to", *reportFile)
+	if report.ViolationsCount() > 0 {
+		fmt.Println("the user data is invalid")
+		os.Exit(1)
+	}
+	fmt.Println("the user data is valid")
+}
+
+func runWitness(fs *flag.FlagSet, args []string) {
+	witness.RunService(parseConfig(fs, args, true).WitnessConfig())
+}
+
+func prove(fs *flag.FlagSet, args []string) {
+	rerun := fs.Bool("rerun", false, "prove the witness received by the provers which are gone")
+	tier := fs.Int("tier", 0, "pin the prover to the task queue of the assets count tier, the tasks of all tiers are proved when it is 0")
+	skipKeyManifest := skipKeyManifestFlag(fs)
+	proverConfig := parseConfig(fs, args, true).ProverConfig()
+	skipKeyManifest()
+	if *tier != 0 {
+		proverConfig.TaskTiers = []int{*tier}
+	}
+	prover.CheckConfig(proverConfig)
+	utils.StartMetricsServer(proverConfig.MetricsAddr)
+	prover.NewProver(proverConfig).Run(*rerun)
+}
+
+func aggregate(fs *flag.FlagSet, args []string) {
+	aggregatorConfig := parseConfig(fs, args, true).AggregatorConfig()
+	aggregator.CheckConfig(aggregatorConfig)
+	aggregator.NewAggregator(aggregatorConfig).Run()
+}
+
+func runUserProof(fs *flag.FlagSet, args []string) {
+	userproof.Run(parseConfig(fs, args, true).UserProofConfig())
+}
+
+func userProofRoot(fs *flag.FlagSet, args []string) {
+	userproof.ComputeAccountRootHash(parseConfig(fs, args, false).UserProofConfig())
+}
+
+func userProofServe(fs *flag.FlagSet, args []string) {
+	proofapi.Run(parseConfig(fs, args, true).ProofApiConfig())
+}
+
+func userProofZk(fs *flag.FlagSet, args []string) {
+	accountId := fs.String("account_id", "", "the account id hash of the user")
+	output := fs.String("output", "zk_user_config.json", "the zk user config file")
+	c := parseConfig(fs, args, true)
+	if *accountId == "" {
+		panic("-account_id is required")
+	}
+	userproof.GenerateZkUserProof(c.UserProofConfig(), *accountId, *output)
+}
+
+func verifyBatch(fs *flag.FlagSet, args []string) {
+	skipKeyManifest := skipKeyManifestFlag(fs)
+	verifierConfig := parseConfig(fs, args, false).VerifierConfig()
+	skipKeyManifest()
+	verifier.PrepareConfig(verifierConfig)
+	if !verifier.VerifyBatchProofs(verifierConfig) {
+		os.Exit(1)
+	}
+}
+
+func verifyBundle(fs *flag.FlagSet, args []string) {
+	name := fs.String("bundle", "bundle", "the bundle directory, or the gzipped tar ending with .tar.gz")
+	skipKeyManifest := skipKeyManifestFlag(fs)
+	fs.Parse(args)
+	skipKeyManifest()
+	if !verifier.VerifyBundle(*name) {
+		os.Exit(1)
+	}
+}
+
+func verifyAggregated(fs *flag.FlagSet, args []string) {
+	skipKeyManifest := skipKeyManifestFlag(fs)
+	verifierConfig := parseConfig(fs, args, false).VerifierConfig()
+	skipKeyManifest()
+	verifier.PrepareConfig(verifierConfig)
+	if verifierConfig.PrevAccountTreeRoot != "" {
+		panic("the proofs of the incremental snapshot can't be aggregated")
+	}
+	verifier.VerifyAggregatedProof(verifierConfig)
+}
+
+func verifyAttestation(fs *flag.FlagSet, args []string) {
+	attestationFile := fs.String("attestation", "attestation.json", "the attestation file")
+	publicKey := fs.String("public_key", "attestation.pub", "the public key file published by the exchange")
+	bundleName := fs.String("bundle", "", "optional, the bundle which the attestation must commit to")
+	skipKeyManifest := skipKeyManifestFlag(fs)
+	fs.Parse(args)
+	skipKeyManifest()
+	if !verifier.VerifyAttestation(*attestationFile, *publicKey, *bundleName) {
+		os.Exit(1)
+	}
+}
+
+func verifyUser(fs *flag.FlagSet, args []string) {
+	userConfig := fs.String("user_config", "user_config.json", "the user config file")
+	assetRegistryFile := fs.String("asset_registry", "", "asset registry file published with the snapshot, it is checked against the AssetRegistryHash of the user config")
+	bundleName := fs.String("bundle", "", "optional, the published bundle which the root and the AssetRegistryHash of the user config must match")
+	fs.Parse(args)
+	verifier.VerifyUserProof(*userConfig, *assetRegistryFile, *bundleName)
+}
+
+func verifyZkUser(fs *flag.FlagSet, args []string) {
+	zkUserConfig := fs.String("zk_user_config", "zk_user_config.json", "the zk user config file")
+	zkUserVk := fs.String("vk", "zkpor_user_inclusion.vk", "verifying key of the user inclusion circuit")
+	skipKeyManifest := skipKeyManifestFlag(fs)
+	fs.Parse(args)
+	skipKeyManifest()
+	verifier.VerifyZkUserProof(*zkUserConfig, *zkUserVk)
+}
+
+func verifyHash(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		panic("invalid hash command, it needs two arguments")
+	}
+	verifier.Hash(fs.Arg(0), fs.Arg(1))
+}
+
+func dbStatus(fs *flag.FlagSet, args []string) {
+	dbtool.CheckProverStatus(parseConfig(fs, args, true).DbToolConfig())
+}
+
+func dbDelete(fs *flag.FlagSet, args []string) {
+	treeOnly := fs.Bool("tree_only", false, "only delete the account tree")
+	dbtoolConfig := parseConfig(fs, args, true).DbToolConfig()
+	if !*treeOnly {
+		dbtool.DropTables(dbtoolConfig)
+	}
+	dbtool.FlushTreeDB(dbtoolConfig)
+}
+
+func dbCexAssets(fs *flag.FlagSet, args []string) {
+	dbtool.QueryCexAssets(parseConfig(fs, args, true).DbToolConfig())
+}
+
+func dbWitness(fs *flag.FlagSet, args []string) {
+	height := fs.Int64("height", 0, "the batch height")
+	dbtool.QueryWitnessData(parseConfig(fs, args, true).DbToolConfig(), *height)
+}
+
+func dbAccount(fs *flag.FlagSet, args []string) {
+	index := fs.Int("index", 0, "the account index")
+	dbtool.QueryAccountData(parseConfig(fs, args, true).DbToolConfig(), *index)
+}
+
+func dbExportCalldata(fs *flag.FlagSet, args []string) {
+	output := fs.String("output", "calldata.json", "the calldata file")
+	dbtool.ExportCalldata(parseConfig(fs, args, true).DbToolConfig(), *output)
+}
+
+func dbExportBundle(fs *flag.FlagSet, args []string) {
+	output := fs.String("output", "bundle", "the bundle directory, it is written as a gzipped tar when it ends with .tar.gz")
+	dbtool.ExportBundle(parseConfig(fs, args, true).DbToolConfig(), *output)
+}
+
+func queuePush(fs *flag.FlagSet, args []string) {
+	dbtool.PushTasksToRedis(parseConfig(fs, args, true).DbToolConfig())
+}
+
+func runLocal(fs *flag.FlagSet, args []string) {
+	skipKeyManifest := skipKeyManifestFlag(fs)
+	localConfig := parseConfig(fs, args, false).LocalConfig()
+	skipKeyManifest()
+	local.Run(localConfig)
+}
+
+func runPipeline(fs *flag.FlagSet, args []string) {
+	pollInterval := fs.Duration("poll_interval", 30*time.Second, "the interval of checking the witness counts while waiting for the provers")
+	stallTimeout := fs.Duration("stall_timeout", 8*time.Minute, "prove the batches left in process when no proof is generated and no prover holds a lease within this time")
+	maxRecoveries := fs.Int("max_recoveries", 3, "give up after proving the batches left in process this many times")
+	skipKeyManifest := skipKeyManifestFlag(fs)
+	c := parseConfig(fs, args, true)
+	skipKeyManifest()
+	o := orchestrator.NewOrchestrator(c)
+	o.PollInterval = *pollInterval
+	o.StallTimeout = *stallTimeout
+	o.MaxRecoveries = *maxRecoveries
+	summary := o.Run()
+	summary.Print()
+	if summary.Err != nil {
+		os.Exit(1)
+	}
+}